@@ -9,7 +9,12 @@ import (
 
 	"github.com/urfave/cli/v2"
 
+	"github.com/dennis-tra/pcp/internal/format"
 	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/pkg/dht"
+	"github.com/dennis-tra/pcp/pkg/diag"
+	"github.com/dennis-tra/pcp/pkg/mdns"
+	"github.com/dennis-tra/pcp/pkg/node"
 	"github.com/dennis-tra/pcp/pkg/receive"
 	"github.com/dennis-tra/pcp/pkg/send"
 )
@@ -23,9 +28,23 @@ var (
 	ShortCommit = "5f3759df" // quake
 )
 
+// newByteSize returns a flag value with the given default size.
+func newByteSize(size int64) *format.ByteSize {
+	b := format.ByteSize(size)
+	return &b
+}
+
 func main() {
 	// ShortCommit version tag
 	verTag := fmt.Sprintf("v%s+%s", RawVersion, ShortCommit)
+	node.BuildVersion = verTag
+
+	cli.VersionPrinter = func(c *cli.Context) {
+		fmt.Printf("pcp version %s\n", c.App.Version)
+		fmt.Printf("protocol version: %s\n", node.ProtocolVersion)
+		fmt.Printf("transfer protocol: %s\n", node.ProtocolTransfer)
+		fmt.Printf("discovery scheme version: %s\n", node.DiscoveryVersion)
+	}
 
 	app := &cli.App{
 		Name: "pcp",
@@ -41,30 +60,210 @@ func main() {
 		Commands: []*cli.Command{
 			receive.Command,
 			send.Command,
+			diag.Command,
+		},
+		Action: func(c *cli.Context) error {
+			if c.Bool("list-interfaces") {
+				return mdns.PrintInterfaces(os.Stdout)
+			}
+			return cli.ShowAppHelp(c)
 		},
 		Before: func(c *cli.Context) error {
-			if c.Bool("debug") {
+			if c.Bool("insecure-skip-pake") && !c.Bool("i-understand-the-risks") {
+				return fmt.Errorf("--insecure-skip-pake disables peer authentication, confirm it with --i-understand-the-risks")
+			}
+			if c.Bool("debug") && c.Bool("quiet") {
+				return fmt.Errorf("the debug and quiet flags are mutually exclusive")
+			} else if c.Bool("debug") {
 				log.SetLevel(log.DebugLevel)
+			} else if c.Bool("quiet") {
+				log.SetLevel(log.WarningLevel)
+			}
+
+			if c.String("log-file") != "" {
+				lvl, err := log.ParseLevel(c.String("log-level"))
+				if err != nil {
+					return err
+				}
+				if err = log.OpenFile(c.String("log-file"), lvl); err != nil {
+					return fmt.Errorf("could not open log file: %w", err)
+				}
 			}
 			return nil
 		},
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
-				Name:  "debug",
-				Usage: "enables debug log output",
+				Name:    "debug",
+				Usage:   "enables debug log output",
+				EnvVars: []string{"PCP_DEBUG"},
+			},
+			&cli.BoolFlag{
+				Name:    "quiet",
+				Aliases: []string{"q"},
+				Usage:   "only print warnings and errors",
+				EnvVars: []string{"PCP_QUIET"},
+			},
+			&cli.BoolFlag{
+				Name:    "summary",
+				Usage:   "show a summary when the session ends and keep it on screen for a moment or until a key is pressed (only on a terminal)",
+				EnvVars: []string{"PCP_SUMMARY"},
+			},
+			&cli.StringFlag{
+				Name:    "log-file",
+				Usage:   "append log messages as logfmt lines to this file independent of the terminal output",
+				EnvVars: []string{"PCP_LOG_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "log-level",
+				Usage:   "minimum level of messages written to --log-file (debug, info, warning, error)",
+				EnvVars: []string{"PCP_LOG_LEVEL"},
+				Value:   "debug",
+			},
+			&cli.BoolFlag{
+				Name:    "dht",
+				Usage:   "Only advertise via the DHT",
+				EnvVars: []string{"PCP_DHT"},
+			},
+			&cli.BoolFlag{
+				Name:    "mdns",
+				Usage:   "Only advertise via multicast DNS",
+				EnvVars: []string{"PCP_MDNS"},
+			},
+			&cli.IntFlag{
+				Name:    "dht-bootstrap-rounds",
+				Usage:   "how often to try connecting to enough DHT bootstrap peers - only the failed ones are retried",
+				EnvVars: []string{"PCP_DHT_BOOTSTRAP_ROUNDS"},
+				Value:   dht.BootstrapRounds,
+			},
+			&cli.DurationFlag{
+				Name:    "dht-bootstrap-backoff",
+				Usage:   "jittered waiting time before retrying DHT bootstrap peers, doubled for each further round",
+				EnvVars: []string{"PCP_DHT_BOOTSTRAP_BACKOFF"},
+				Value:   dht.BootstrapBackoff,
+			},
+			&cli.StringFlag{
+				Name:    "namespace",
+				Usage:   "isolates discovery from other pcp deployments - must be identical on both ends",
+				EnvVars: []string{"PCP_NAMESPACE"},
+			},
+			&cli.StringFlag{
+				Name:    "mdns-service-tag",
+				Usage:   "replaces the pcp prefix of the mDNS service name - must be identical on both ends",
+				EnvVars: []string{"PCP_MDNS_SERVICE_TAG"},
+			},
+			&cli.DurationFlag{
+				Name:    "mdns-interval",
+				Usage:   "how often mDNS queries and announcements are sent - shorter finds peers faster but causes more multicast traffic (min 100ms, 0 uses the defaults)",
+				EnvVars: []string{"PCP_MDNS_INTERVAL"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "mdns-interface",
+				Usage:   "only use mDNS on this network interface, can be given multiple times, e.g. wlan0 (see --list-interfaces)",
+				EnvVars: []string{"PCP_MDNS_INTERFACE"},
+			},
+			&cli.BoolFlag{
+				Name:    "list-interfaces",
+				Usage:   "print the network interfaces that can be passed to --mdns-interface, then exit",
+				EnvVars: []string{"PCP_LIST_INTERFACES"},
+			},
+			&cli.StringFlag{
+				Name:    "identity",
+				Usage:   "path to a private key file to keep the peer ID across runs (created if missing)",
+				EnvVars: []string{"PCP_IDENTITY"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "listen",
+				Usage:   "multi address the node should listen on, can be given multiple times, e.g. /ip4/0.0.0.0/tcp/4001",
+				EnvVars: []string{"PCP_LISTEN"},
+			},
+			&cli.StringFlag{
+				Name:    "metrics-addr",
+				Usage:   "expose Prometheus metrics on the given address, e.g. localhost:9090 (disabled if empty)",
+				EnvVars: []string{"PCP_METRICS_ADDR"},
+			},
+			&cli.GenericFlag{
+				Name:    "chunk-size",
+				Usage:   "size of the buffer the transferred data is copied with, e.g. 64KiB or 1MiB (4KiB - 16MiB)",
+				EnvVars: []string{"PCP_CHUNK_SIZE"},
+				Value:   newByteSize(node.DefaultChunkSize),
+			},
+			&cli.GenericFlag{
+				Name:    "max-memory",
+				Usage:   "bound the transfer buffers, e.g. 1MiB - concurrent transfers wait for memory instead of allocating more (0 means unlimited)",
+				EnvVars: []string{"PCP_MAX_MEMORY"},
+				Value:   newByteSize(0),
+			},
+			&cli.StringFlag{
+				Name:    "channel-file",
+				Usage:   "pins the discovery channel in this file so that restarts within an hour reuse it - can be shared between sender and receiver",
+				EnvVars: []string{"PCP_CHANNEL_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "bucket-time",
+				Usage:   "derive the discovery channel from the time slot of this RFC3339 time instead of the clock - for deterministic tests",
+				EnvVars: []string{"PCP_BUCKET_TIME"},
+				Hidden:  true,
+			},
+			&cli.StringFlag{
+				Name:    "rendezvous",
+				Usage:   "also meet at this libp2p rendezvous server, e.g. /ip4/1.2.3.4/tcp/4001/p2p/Qm... - both peers must use the same server",
+				EnvVars: []string{"PCP_RENDEZVOUS"},
+			},
+			&cli.BoolFlag{
+				Name:    "only-direct",
+				Usage:   "never transfer over a relayed connection - fails if no direct connection to the peer can be established",
+				EnvVars: []string{"PCP_ONLY_DIRECT"},
+			},
+			&cli.BoolFlag{
+				Name:    "lan-only",
+				Usage:   "only connect to peers via private addresses of the local network - relayed and public connections are refused",
+				EnvVars: []string{"PCP_LAN_ONLY"},
 			},
 			&cli.BoolFlag{
-				Name:  "dht",
-				Usage: "Only advertise via the DHT",
+				Name:    "upnp",
+				Usage:   "ask the router to forward the listen ports via UPnP or NAT-PMP for direct connections - removed on exit",
+				EnvVars: []string{"PCP_UPNP"},
+			},
+			&cli.StringFlag{
+				Name:    "user-agent",
+				Usage:   "user agent announced to other libp2p peers (default: pcp/<version>)",
+				EnvVars: []string{"PCP_USER_AGENT"},
+			},
+			&cli.BoolFlag{
+				Name:    "no-checksum",
+				Usage:   "don't calculate and print the SHA-256 checksum of the transferred data - the sender hashes it while sending, the receiver needs an extra pass over it",
+				EnvVars: []string{"PCP_NO_CHECKSUM"},
 			},
 			&cli.BoolFlag{
-				Name:  "mdns",
-				Usage: "Only advertise via multicast DNS",
+				Name:    "show-channel",
+				Usage:   "print the discovery identifiers derived from the words - they must be identical on both ends",
+				EnvVars: []string{"PCP_SHOW_CHANNEL"},
 			},
 			&cli.BoolFlag{
-				Name:   "homebrew",
-				Usage:  "if set transfers a hard coded file with a hard coded word sequence",
-				Hidden: true,
+				Name:    "insecure-skip-pake",
+				Usage:   "INSECURE: skip the peer authentication in fully trusted networks - must be set on both ends",
+				EnvVars: []string{"PCP_INSECURE_SKIP_PAKE"},
+			},
+			&cli.BoolFlag{
+				Name:    "i-understand-the-risks",
+				Usage:   "confirm that --insecure-skip-pake lets anyone on the network pretend to be your peer",
+				EnvVars: []string{"PCP_I_UNDERSTAND_THE_RISKS"},
+			},
+			&cli.StringFlag{
+				Name:    "config",
+				Usage:   "read the settings from this file instead of the default location - it must exist",
+				EnvVars: []string{"PCP_CONFIG"},
+			},
+			&cli.BoolFlag{
+				Name:    "print-config",
+				Usage:   "print the effective value of every flag and where it came from, then exit",
+				EnvVars: []string{"PCP_PRINT_CONFIG"},
+			},
+			&cli.BoolFlag{
+				Name:    "homebrew",
+				Usage:   "if set transfers a hard coded file with a hard coded word sequence",
+				EnvVars: []string{"PCP_HOMEBREW"},
+				Hidden:  true,
 			},
 		},
 	}
@@ -82,7 +281,11 @@ func main() {
 
 	err := app.RunContext(ctx, os.Args)
 	if err != nil {
-		log.Infof("error: %v\n", err)
+		log.Errorf("error: %v\n", err)
+	}
+	log.CloseFile()
+
+	if err != nil {
 		os.Exit(1)
 	}
 }
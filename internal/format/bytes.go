@@ -0,0 +1,61 @@
+package format
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteUnits maps the supported unit suffixes to their multiplier.
+var byteUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"KIB": 1 << 10,
+	"MB":  1e6,
+	"MIB": 1 << 20,
+	"GB":  1e9,
+	"GIB": 1 << 30,
+}
+
+// ParseBytes parses a human readable size like 64KiB or 1MB.
+// Decimal (KB) and binary (KiB) units are supported.
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i == -1 {
+		i = len(s)
+	}
+
+	num, unit := s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	multiplier, found := byteUnits[unit]
+	if !found {
+		return 0, fmt.Errorf("unknown size unit %q", s[i:])
+	}
+
+	value, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
+
+// ByteSize is a number of bytes that can be used as a command line
+// flag value. It accepts human readable sizes, see ParseBytes.
+type ByteSize int64
+
+// Set parses the given human readable size.
+func (b *ByteSize) Set(s string) error {
+	size, err := ParseBytes(s)
+	if err != nil {
+		return err
+	}
+	*b = ByteSize(size)
+	return nil
+}
+
+// String returns the size in bytes.
+func (b *ByteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
@@ -59,6 +59,25 @@ func Speed(bytesPerS int64) string {
 	return fmt.Sprintf("%s/s", Bytes(bytesPerS))
 }
 
+// BytesPerSecond returns the average throughput of transferring the given
+// bytes in the given time. It returns zero if no time has passed.
+func BytesPerSecond(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) / d.Seconds()
+}
+
+// TransferSummary describes how many bytes were transferred in which time,
+// e.g. "1GB in 45s (27MB/s)". The rate is omitted if no time has passed.
+func TransferSummary(bytes int64, d time.Duration) string {
+	rate := BytesPerSecond(bytes, d)
+	if rate == 0 {
+		return fmt.Sprintf("%s in %s", Bytes(bytes), d.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%s in %s (%s)", Bytes(bytes), d.Round(time.Millisecond), Speed(int64(rate)))
+}
+
 // TransferStatus takes the terminal width `twidth` and builds a string occupying the whole width indicating the
 // current transfer status.
 func TransferStatus(fn string, iteration int, twidth int, p float64, eta time.Duration, bytesPerS int64) string {
@@ -124,3 +124,40 @@ func TestFormatTransferStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "512", want: 512},
+		{in: "512B", want: 512},
+		{in: "64KiB", want: 64 << 10},
+		{in: "64kb", want: 64000},
+		{in: "1.5 MiB", want: 3 << 19},
+		{in: "1GB", want: 1e9},
+		{in: "1XB", wantErr: true},
+		{in: "MiB", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseBytes(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTransferSummary(t *testing.T) {
+	assert.Equal(t, "1GB in 45s (26MB/s)", TransferSummary(1_200_000_000, 45*time.Second))
+	assert.Equal(t, "12B in 0s", TransferSummary(12, 0))
+	assert.Equal(t, 0.0, BytesPerSecond(12, 0))
+	assert.Equal(t, 100.0, BytesPerSecond(200, 2*time.Second))
+}
@@ -0,0 +1,103 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	fileLk    sync.Mutex
+	file      io.WriteCloser
+	fileLevel Level
+)
+
+// String returns the name of the level as it's used in the log file.
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarningLevel:
+		return "warning"
+	case ErrorLevel:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", l)
+	}
+}
+
+// ParseLevel returns the level with the given name.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warning", "warn":
+		return WarningLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q (debug, info, warning, error)", name)
+	}
+}
+
+// OpenFile appends all messages of at least the given level to the file
+// at the given path. It is independent of the terminal output, so the
+// file can contain debug messages while the terminal stays clean.
+func OpenFile(path string, l Level) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	SetFile(f, l)
+	return nil
+}
+
+// SetFile writes all messages of at least the given level to the given
+// writer. A previously set file is closed. This is used for tests.
+func SetFile(w io.WriteCloser, l Level) {
+	fileLk.Lock()
+	defer fileLk.Unlock()
+	if file != nil {
+		file.Close()
+	}
+	file = w
+	fileLevel = l
+}
+
+// CloseFile stops writing messages to the log file.
+func CloseFile() error {
+	fileLk.Lock()
+	defer fileLk.Unlock()
+	if file == nil {
+		return nil
+	}
+	err := file.Close()
+	file = nil
+	return err
+}
+
+// writeFile writes the message as a single logfmt line to the log file.
+// Carriage returns and surrounding whitespace that are only meant for
+// the terminal are removed.
+func writeFile(l Level, msg string) {
+	fileLk.Lock()
+	defer fileLk.Unlock()
+
+	if file == nil || l < fileLevel {
+		return
+	}
+
+	msg = strings.TrimSpace(strings.ReplaceAll(msg, "\r", ""))
+	if msg == "" {
+		return
+	}
+
+	fmt.Fprintf(file, "time=%s level=%s msg=%q\n", time.Now().Format(time.RFC3339Nano), l, msg)
+}
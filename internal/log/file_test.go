@@ -0,0 +1,54 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nopCloser struct{ *bytes.Buffer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestParseLevel(t *testing.T) {
+	for _, l := range []Level{DebugLevel, InfoLevel, WarningLevel, ErrorLevel} {
+		parsed, err := ParseLevel(l.String())
+		require.NoError(t, err)
+		assert.Equal(t, l, parsed)
+	}
+
+	parsed, err := ParseLevel("WARN")
+	require.NoError(t, err)
+	assert.Equal(t, WarningLevel, parsed)
+
+	_, err = ParseLevel("trace")
+	assert.Error(t, err)
+}
+
+func TestSetFile(t *testing.T) {
+	out := Out
+	Out = ioutil.Discard
+	defer func() { Out = out }()
+
+	prev := level
+	SetLevel(ErrorLevel)
+	defer SetLevel(prev)
+
+	buf := nopCloser{&bytes.Buffer{}}
+	SetFile(buf, InfoLevel)
+	defer CloseFile()
+
+	Debugln("hidden")
+	Infoln("some", "info")
+	Infor("\rauthenticating...")
+	Warningf("%d warnings\n", 2)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 3)
+	assert.Contains(t, string(lines[0]), `level=info msg="some info"`)
+	assert.Contains(t, string(lines[1]), `level=info msg="authenticating..."`)
+	assert.Contains(t, string(lines[2]), `level=warning msg="2 warnings"`)
+}
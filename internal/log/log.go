@@ -51,23 +51,27 @@ func printTimestamp() {
 	fmt.Printf("[%s] ", time.Now().Format(time.RFC3339))
 }
 
-func Info(a ...interface{}) {
-	if level > InfoLevel {
+// write sends the message to the log file and prints it
+// if the given level is enabled on the terminal.
+func write(l Level, msg string) {
+	writeFile(l, msg)
+	if level > l {
 		return
 	}
 	printTimestamp()
-	fmt.Fprint(Out, a...)
+	fmt.Fprint(Out, msg)
+}
+
+func Info(a ...interface{}) {
+	write(InfoLevel, fmt.Sprint(a...))
 }
 
 func Infoln(a ...interface{}) {
-	if level > InfoLevel {
-		return
-	}
-	printTimestamp()
-	fmt.Fprintln(Out, a...)
+	write(InfoLevel, fmt.Sprintln(a...))
 }
 
 func Infor(format string, a ...interface{}) {
+	writeFile(InfoLevel, fmt.Sprintf(format, a...))
 	if level > InfoLevel {
 		return
 	}
@@ -83,72 +87,46 @@ func Infor(format string, a ...interface{}) {
 }
 
 func Infof(format string, a ...interface{}) {
-	if level > InfoLevel {
-		return
-	}
-	printTimestamp()
-	fmt.Fprintf(Out, format, a...)
+	write(InfoLevel, fmt.Sprintf(format, a...))
 }
 
 func Debug(a ...interface{}) {
-	if level > DebugLevel {
-		return
-	}
-	printTimestamp()
-	fmt.Fprint(Out, a...)
+	write(DebugLevel, fmt.Sprint(a...))
 }
 
 func Debugln(a ...interface{}) {
-	if level > DebugLevel {
-		return
-	}
-	printTimestamp()
-	fmt.Fprintln(Out, a...)
+	write(DebugLevel, fmt.Sprintln(a...))
 }
 
 func Debugf(format string, a ...interface{}) {
-	if level > DebugLevel {
-		return
-	}
-	printTimestamp()
-	fmt.Fprintf(Out, format, a...)
+	write(DebugLevel, fmt.Sprintf(format, a...))
 }
 
 func Warning(a ...interface{}) {
-	if level > WarningLevel {
-		return
-	}
-	printTimestamp()
-	fmt.Fprint(Out, a...)
+	write(WarningLevel, fmt.Sprint(a...))
 }
 
 func Warningln(a ...interface{}) {
-	if level > WarningLevel {
-		return
-	}
-	printTimestamp()
-	fmt.Fprintln(Out, a...)
+	write(WarningLevel, fmt.Sprintln(a...))
 }
 
 func Warningf(format string, a ...interface{}) {
-	if level > WarningLevel {
-		return
-	}
-	printTimestamp()
-	fmt.Fprintf(Out, format, a...)
+	write(WarningLevel, fmt.Sprintf(format, a...))
 }
 
 func Error(a ...interface{}) {
-	printTimestamp()
-	fmt.Fprint(Out, a...)
+	write(ErrorLevel, fmt.Sprint(a...))
 }
 
 func Errorln(a ...interface{}) {
-	printTimestamp()
-	fmt.Fprintln(Out, a...)
+	write(ErrorLevel, fmt.Sprintln(a...))
 }
 
 func Errorf(format string, a ...interface{}) {
-	printTimestamp()
-	fmt.Fprintf(Out, format, a...)
+	write(ErrorLevel, fmt.Sprintf(format, a...))
+}
+
+// Enabled returns true if messages of the given level are printed to the terminal.
+func Enabled(l Level) bool {
+	return level <= l
 }
@@ -0,0 +1,15 @@
+package log
+
+import (
+	progress "github.com/schollz/progressbar/v3"
+)
+
+// NewProgressBar returns a progress bar for a transfer of max bytes.
+// If the current log level suppresses info messages the returned
+// progress bar is invisible.
+func NewProgressBar(max int64, description string) *progress.ProgressBar {
+	if level > InfoLevel {
+		return progress.NewOptions64(max, progress.OptionSetVisibility(false))
+	}
+	return progress.DefaultBytes(max, description)
+}
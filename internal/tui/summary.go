@@ -0,0 +1,123 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/dennis-tra/pcp/internal/format"
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// DefaultHold is the time the summary stays on screen if no key is pressed.
+const DefaultHold = 10 * time.Second
+
+// Summary describes the outcome of a send or receive session.
+// Empty fields are left out.
+type Summary struct {
+	Outcome    string
+	Peer       string
+	File       string
+	Size       int64
+	Duration   time.Duration
+	Connection string
+	Checksum   string
+}
+
+// rows returns the labels and values of the fields that are set.
+func (s *Summary) rows() [][2]string {
+	rows := [][2]string{{"Outcome", s.Outcome}}
+	if s.Peer != "" {
+		rows = append(rows, [2]string{"Peer", s.Peer})
+	}
+	if s.File != "" {
+		rows = append(rows, [2]string{"File", s.File})
+	}
+	if s.Size > 0 {
+		rows = append(rows, [2]string{"Size", format.Bytes(s.Size)})
+	}
+	if s.Duration > 0 {
+		rows = append(rows, [2]string{"Duration", s.Duration.Round(time.Millisecond).String()})
+	}
+	if s.Connection != "" {
+		rows = append(rows, [2]string{"Connection", s.Connection})
+	}
+	if s.Checksum != "" {
+		rows = append(rows, [2]string{"SHA-256", s.Checksum})
+	}
+	return rows
+}
+
+// Render draws the summary as a box of aligned rows.
+func (s *Summary) Render() string {
+	rows := s.rows()
+
+	labelWidth, width := 0, 0
+	for _, row := range rows {
+		if n := len(row[0]); n > labelWidth {
+			labelWidth = n
+		}
+	}
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		lines[i] = fmt.Sprintf("%-*s  %s", labelWidth+1, row[0]+":", row[1])
+		if n := utf8.RuneCountInString(lines[i]); n > width {
+			width = n
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("┌" + strings.Repeat("─", width+2) + "┐\n")
+	for _, line := range lines {
+		pad := width - utf8.RuneCountInString(line)
+		b.WriteString("│ " + line + strings.Repeat(" ", pad) + " │\n")
+	}
+	b.WriteString("└" + strings.Repeat("─", width+2) + "┘\n")
+	return b.String()
+}
+
+// Interactive returns true if both stdin and stdout are terminals,
+// i.e. a user is watching and can press a key.
+func Interactive() bool {
+	return terminal.IsTerminal(int(os.Stdout.Fd())) && terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// Show prints the summary and keeps it on screen for the given time
+// or until a key is pressed. Nothing is shown if the summary is nil,
+// info messages are suppressed or the session isn't interactive,
+// so that automated runs aren't held up.
+func Show(s *Summary, hold time.Duration) {
+	if s == nil || !log.Enabled(log.InfoLevel) || !Interactive() {
+		return
+	}
+
+	fmt.Fprint(log.Out, "\n"+s.Render())
+	if hold <= 0 {
+		return
+	}
+	fmt.Fprintf(log.Out, "Press any key to exit (closing in %s)", hold)
+	defer fmt.Fprintln(log.Out)
+
+	// Read single key presses instead of whole lines.
+	fd := int(os.Stdin.Fd())
+	if state, err := terminal.MakeRaw(fd); err == nil {
+		defer terminal.Restore(fd, state)
+	}
+
+	key := make(chan struct{})
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := os.Stdin.Read(buf); err == nil {
+			close(key)
+		}
+	}()
+
+	select {
+	case <-key:
+	case <-time.After(hold):
+	}
+}
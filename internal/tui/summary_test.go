@@ -0,0 +1,54 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummary_Render(t *testing.T) {
+	s := &Summary{
+		Outcome:    "received",
+		Peer:       "QmPeer",
+		File:       "file.txt",
+		Size:       2048,
+		Duration:   1500 * time.Millisecond,
+		Connection: "direct",
+	}
+
+	lines := strings.Split(strings.TrimSuffix(s.Render(), "\n"), "\n")
+	assert.Len(t, lines, 8)
+	assert.Equal(t, "│ Outcome:     received │", lines[1])
+	assert.Contains(t, lines[4], "2KB")
+	assert.Contains(t, lines[5], "1.5s")
+	assert.Contains(t, lines[6], "direct")
+
+	// All lines have the same width.
+	for _, line := range lines {
+		assert.Equal(t, len([]rune(lines[0])), len([]rune(line)), line)
+	}
+}
+
+func TestSummary_Render_emptyFields(t *testing.T) {
+	s := &Summary{Outcome: "failed: no sender found"}
+	lines := strings.Split(strings.TrimSuffix(s.Render(), "\n"), "\n")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "│ Outcome:  failed: no sender found │", lines[1])
+}
+
+func TestSummary_Render_checksum(t *testing.T) {
+	s := &Summary{Outcome: "sent", Checksum: "abc123"}
+	lines := strings.Split(strings.TrimSuffix(s.Render(), "\n"), "\n")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, "│ SHA-256:  abc123 │", lines[2])
+}
+
+func TestShow_nonInteractive(t *testing.T) {
+	// Must not block if nobody could press a key.
+	Show(nil, time.Hour)
+	if !Interactive() {
+		Show(&Summary{Outcome: "received"}, time.Hour)
+	}
+}
@@ -45,8 +45,10 @@ func (c *Config) Save() error {
 	return nil
 }
 
-func LoadConfig() (*Config, error) {
-	settings, err := LoadSettings()
+// LoadConfig loads the settings from the given file or, if
+// the path is empty, from the default location.
+func LoadConfig(path string) (*Config, error) {
+	settings, err := LoadSettingsFrom(path)
 	if err != nil {
 		return nil, err
 	}
@@ -58,11 +60,19 @@ func LoadConfig() (*Config, error) {
 	return c, nil
 }
 
-func FillContext(c *cli.Context) (*cli.Context, error) {
-	conf, err := LoadConfig()
+// FillContext loads the configuration from the given file, applies its
+// flag values to the context and stores it in the context. If the path
+// is empty the file at the default location is used if it exists.
+func FillContext(c *cli.Context, path string) (*cli.Context, error) {
+	conf, err := LoadConfig(path)
 	if err != nil {
 		return c, err
 	}
+
+	if err = conf.Settings.Apply(c); err != nil {
+		return c, err
+	}
+
 	c.Context = context.WithValue(c.Context, ContextKey, conf)
 	return c, nil
 }
@@ -0,0 +1,48 @@
+package config_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/dennis-tra/pcp/pkg/config"
+	"github.com/dennis-tra/pcp/pkg/diag"
+	"github.com/dennis-tra/pcp/pkg/receive"
+	"github.com/dennis-tra/pcp/pkg/send"
+)
+
+func TestCommands_haveDerivedEnvVars(t *testing.T) {
+	for _, cmd := range []*cli.Command{send.Command, receive.Command, diag.Command} {
+		for _, f := range cmd.Flags {
+			expected := config.FlagEnvVar(f)
+			if cmd == diag.Command {
+				// The names of the diag flags are too generic to share them.
+				expected = strings.Replace(expected, "PCP_", "PCP_DIAG_", 1)
+			}
+
+			envVars := reflect.ValueOf(f).Elem().FieldByName("EnvVars").Interface()
+			assert.Equal(t, []string{expected}, envVars, "flag %s of %s", f.Names()[0], cmd.Name)
+		}
+	}
+}
+
+// The word count of the sender must not apply to received codes.
+func TestCommands_wordCountIsPerCommand(t *testing.T) {
+	names := map[string][]string{}
+	for _, cmd := range []*cli.Command{send.Command, receive.Command} {
+		for _, f := range cmd.Flags {
+			if f.Names()[0] == "w" {
+				names[cmd.Name] = f.Names()
+			}
+		}
+	}
+
+	require.Len(t, names, 2)
+	for _, name := range names["send"] {
+		assert.NotContains(t, names["receive"][1:], name)
+	}
+}
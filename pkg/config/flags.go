@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// EnvVar returns the environment variable that corresponds to the
+// flag with the given name, e.g. word-count becomes PCP_WORD_COUNT.
+func EnvVar(name string) string {
+	return strings.ToUpper(Prefix + "_" + strings.ReplaceAll(name, "-", "_"))
+}
+
+// FlagEnvVar returns the environment variable of the given flag. It is
+// derived from the first name that is longer than a single character.
+func FlagEnvVar(f cli.Flag) string {
+	names := f.Names()
+	for _, name := range names {
+		if len(name) > 1 {
+			return EnvVar(name)
+		}
+	}
+	return EnvVar(names[0])
+}
+
+// Apply sets the flags of the settings file that were neither given on
+// the command line nor through their environment variable. This gives
+// the precedence: flag > environment variable > settings file > default.
+func (s *Settings) Apply(c *cli.Context) error {
+	for name, value := range s.Flags {
+		fc := flagContext(c, name)
+		if fc == nil && definedByApp(c.App, name) {
+			// The settings file is shared by all commands.
+			log.Debugf("Ignoring flag %q of another command in settings file %s\n", name, s.Path)
+			continue
+		} else if fc == nil {
+			return fmt.Errorf("unknown flag %q in settings file %s", name, s.Path)
+		}
+
+		if c.IsSet(name) {
+			continue
+		}
+
+		values, err := flagValues(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for flag %q in settings file %s: %w", name, s.Path, err)
+		}
+
+		for _, v := range values {
+			if err := fc.Set(name, v); err != nil {
+				return fmt.Errorf("invalid value for flag %q in settings file %s: %w", name, s.Path, err)
+			}
+		}
+
+		if s.applied == nil {
+			s.applied = map[string]bool{}
+		}
+		s.applied[name] = true
+	}
+	return nil
+}
+
+// flagContext returns the context of the command that defines the flag
+// with the given name. That is either a subcommand or the app itself.
+// The context of the app has an unnamed command.
+func flagContext(c *cli.Context, name string) *cli.Context {
+	for _, lc := range c.Lineage() {
+		var flags []cli.Flag
+		if lc.Command != nil && lc.Command.Name != "" {
+			flags = lc.Command.Flags
+		} else if lc.App != nil {
+			flags = lc.App.Flags
+		}
+
+		if definesFlag(flags, name) {
+			return lc
+		}
+	}
+	return nil
+}
+
+// definedByApp returns true if the app or any of its
+// (sub)commands defines a flag with the given name.
+func definedByApp(app *cli.App, name string) bool {
+	if app == nil {
+		return false
+	}
+	return definesFlag(app.Flags, name) || commandsDefineFlag(app.Commands, name)
+}
+
+func commandsDefineFlag(cmds []*cli.Command, name string) bool {
+	for _, cmd := range cmds {
+		if definesFlag(cmd.Flags, name) || commandsDefineFlag(cmd.Subcommands, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func definesFlag(flags []cli.Flag, name string) bool {
+	for _, f := range flags {
+		for _, n := range f.Names() {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flagValues converts a decoded JSON value to its command line
+// representation. Lists are returned element-wise, so that
+// slice flags can be set multiple times.
+func flagValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case []interface{}:
+		var values []string
+		for _, elem := range v {
+			elemValues, err := flagValues(elem)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, elemValues...)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T", value)
+	}
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestEnvVar(t *testing.T) {
+	assert.Equal(t, "PCP_WORD_COUNT", EnvVar("word-count"))
+	assert.Equal(t, "PCP_NAMESPACE", EnvVar("namespace"))
+	assert.Equal(t, "PCP_WORD_COUNT", FlagEnvVar(&cli.IntFlag{Name: "w", Aliases: []string{"word-count"}}))
+	assert.Equal(t, "PCP_F", FlagEnvVar(&cli.BoolFlag{Name: "f"}))
+}
+
+type testValues struct {
+	namespace string
+	wordCount int
+	timeout   time.Duration
+	subnets   []string
+}
+
+// runApp runs an app with a global and a subcommand flags and applies the given settings.
+func runApp(t *testing.T, settings *Settings, args ...string) testValues {
+	var values testValues
+	app := &cli.App{
+		Name: "pcp",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "namespace", EnvVars: []string{EnvVar("namespace")}, Value: "default"},
+		},
+		Commands: []*cli.Command{
+			{
+				Name: "send",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "exclude", EnvVars: []string{EnvVar("exclude")}},
+				},
+			},
+			{
+				Name: "receive",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "w", Aliases: []string{"word-count"}, EnvVars: []string{EnvVar("word-count")}, Value: 4},
+					&cli.DurationFlag{Name: "transfer-timeout", EnvVars: []string{EnvVar("transfer-timeout")}},
+					&cli.StringSliceFlag{Name: "allow-subnet", EnvVars: []string{EnvVar("allow-subnet")}},
+				},
+				Action: func(c *cli.Context) error {
+					if err := settings.Apply(c); err != nil {
+						return err
+					}
+					values = testValues{
+						namespace: c.String("namespace"),
+						wordCount: c.Int("w"),
+						timeout:   c.Duration("transfer-timeout"),
+						subnets:   c.StringSlice("allow-subnet"),
+					}
+					return nil
+				},
+			},
+		},
+	}
+	require.NoError(t, app.Run(append([]string{"pcp"}, args...)))
+	return values
+}
+
+func setEnv(t *testing.T, key string, value string) {
+	require.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() { os.Unsetenv(key) })
+}
+
+func TestSettings_Apply_precedence(t *testing.T) {
+	settings := &Settings{
+		Flags: map[string]interface{}{
+			"namespace":        "file",
+			"w":                float64(5),
+			"transfer-timeout": "10s",
+			"allow-subnet":     []interface{}{"10.0.0.0/8", "192.168.0.0/16"},
+		},
+	}
+
+	t.Run("default", func(t *testing.T) {
+		values := runApp(t, &Settings{}, "receive")
+		assert.Equal(t, testValues{namespace: "default", wordCount: 4}, values)
+	})
+
+	t.Run("settings file over default", func(t *testing.T) {
+		values := runApp(t, settings, "receive")
+		assert.Equal(t, "file", values.namespace)
+		assert.Equal(t, 5, values.wordCount)
+		assert.Equal(t, 10*time.Second, values.timeout)
+		assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, values.subnets)
+	})
+
+	t.Run("env over settings file", func(t *testing.T) {
+		setEnv(t, "PCP_NAMESPACE", "env")
+		setEnv(t, "PCP_WORD_COUNT", "6")
+		values := runApp(t, settings, "receive")
+		assert.Equal(t, "env", values.namespace)
+		assert.Equal(t, 6, values.wordCount)
+		assert.Equal(t, 10*time.Second, values.timeout)
+	})
+
+	t.Run("flag over env", func(t *testing.T) {
+		setEnv(t, "PCP_NAMESPACE", "env")
+		setEnv(t, "PCP_WORD_COUNT", "6")
+		values := runApp(t, settings, "--namespace", "flag", "receive", "--word-count", "7", "--transfer-timeout", "1m")
+		assert.Equal(t, "flag", values.namespace)
+		assert.Equal(t, 7, values.wordCount)
+		assert.Equal(t, time.Minute, values.timeout)
+	})
+}
+
+func TestSettings_Apply_unknownFlag(t *testing.T) {
+	settings := &Settings{Flags: map[string]interface{}{"unknown": "value"}}
+	app := &cli.App{
+		Name:   "pcp",
+		Action: settings.Apply,
+	}
+	assert.Error(t, app.Run([]string{"pcp"}))
+}
+
+func TestSettings_Apply_flagOfOtherCommand(t *testing.T) {
+	settings := &Settings{
+		Flags: map[string]interface{}{
+			"w":       float64(5),
+			"exclude": []interface{}{"*.log"},
+		},
+	}
+
+	values := runApp(t, settings, "receive")
+	assert.Equal(t, 5, values.wordCount)
+}
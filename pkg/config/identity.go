@@ -0,0 +1,42 @@
+package config
+
+import (
+	"crypto/rand"
+	"os"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/pkg/errors"
+)
+
+// LoadIdentity reads the private key at the given path. If there
+// is no file at that path a new Ed25519 key is generated and
+// persisted with restrictive access permissions, so that the
+// peer ID stays the same across runs.
+func LoadIdentity(path string) (crypto.PrivKey, error) {
+	data, err := appIoutil.ReadFile(path)
+	if err == nil {
+		key, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed parsing identity key")
+		}
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = appIoutil.WriteFile(path, data, 0o600); err != nil {
+		return nil, errors.Wrap(err, "failed saving identity key")
+	}
+
+	return key, nil
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennis-tra/pcp/internal/mock"
+)
+
+func TestLoadIdentity_returnsErrorOfReadFile(t *testing.T) {
+	ctrl := setup(t)
+	defer teardown(t, ctrl)
+
+	mioutil := mock.NewMockIoutiler(ctrl)
+	appIoutil = mioutil
+
+	expectedErr := fmt.Errorf("some error")
+	mioutil.
+		EXPECT().
+		ReadFile(gomock.Eq("path")).
+		Return(nil, expectedErr)
+
+	key, err := LoadIdentity("path")
+	assert.Nil(t, key)
+	assert.Equal(t, expectedErr, err)
+}
+
+func TestLoadIdentity_returnsErrorOnUnparsableData(t *testing.T) {
+	ctrl := setup(t)
+	defer teardown(t, ctrl)
+
+	mioutil := mock.NewMockIoutiler(ctrl)
+	appIoutil = mioutil
+
+	mioutil.
+		EXPECT().
+		ReadFile(gomock.Eq("path")).
+		Return([]byte("unparsable"), nil)
+
+	key, err := LoadIdentity("path")
+	assert.Nil(t, key)
+	assert.Error(t, err)
+}
+
+func TestLoadIdentity_generatesAndPersistsKey(t *testing.T) {
+	ctrl := setup(t)
+	defer teardown(t, ctrl)
+
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "identity")
+
+	key1, err := LoadIdentity(path)
+	require.NoError(t, err)
+	assert.Equal(t, crypto.Ed25519, int(key1.Type()))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	key2, err := LoadIdentity(path)
+	require.NoError(t, err)
+	assert.True(t, key1.Equals(key2))
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Sources of the effective value of a flag in the order of precedence.
+const (
+	SourceFlag     = "command line"
+	SourceEnv      = "environment"
+	SourceSettings = "settings file"
+	SourceDefault  = "default"
+)
+
+// Print writes the effective value of every flag of the command and of
+// the app together with where the value came from. It helps to find out
+// why a setting doesn't take effect. The context must have been filled
+// with FillContext.
+func Print(w io.Writer, c *cli.Context) error {
+	conf, err := FromContext(c.Context)
+	if err != nil {
+		return err
+	}
+
+	state := "not found"
+	if conf.Settings.Exists {
+		state = "loaded"
+	}
+	fmt.Fprintf(w, "Settings file: %s (%s)\n\n", conf.Settings.Path, state)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, lc := range c.Lineage() {
+		var flags []cli.Flag
+		if lc.Command != nil && lc.Command.Name != "" {
+			flags = lc.Command.Flags
+		} else if lc.App != nil {
+			flags = lc.App.Flags
+		}
+
+		for _, f := range flags {
+			name := f.Names()[0]
+			if name == "help" || name == "version" {
+				continue
+			}
+			fmt.Fprintf(tw, "--%s\t%s\t%s\n", name, flagValue(lc, f), flagSource(lc, f, conf.Settings))
+		}
+	}
+	return tw.Flush()
+}
+
+// flagValue returns the effective value of the given flag.
+func flagValue(c *cli.Context, f cli.Flag) string {
+	name := f.Names()[0]
+
+	var value string
+	switch f.(type) {
+	case *cli.StringSliceFlag:
+		value = strings.Join(c.StringSlice(name), ",")
+	case *cli.GenericFlag:
+		if g := c.Generic(name); g != nil {
+			value = fmt.Sprint(g)
+		}
+	default:
+		value = fmt.Sprint(c.Value(name))
+	}
+
+	if value == "" {
+		return `""`
+	}
+	return value
+}
+
+// flagSource returns where the effective value of the given flag came from.
+func flagSource(c *cli.Context, f cli.Flag, s *Settings) string {
+	for _, name := range f.Names() {
+		if s.applied[name] {
+			return SourceSettings
+		}
+	}
+
+	for _, set := range c.LocalFlagNames() {
+		for _, name := range f.Names() {
+			if set == name {
+				return SourceFlag
+			}
+		}
+	}
+
+	if _, found := os.LookupEnv(FlagEnvVar(f)); found {
+		return SourceEnv
+	}
+
+	return SourceDefault
+}
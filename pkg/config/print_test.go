@@ -0,0 +1,58 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestPrint(t *testing.T) {
+	setEnv(t, "PCP_NAMESPACE", "env")
+
+	settings := &Settings{Path: "settings.json", Exists: true, Flags: map[string]interface{}{"w": float64(5)}}
+
+	var out bytes.Buffer
+	app := &cli.App{
+		Name: "pcp",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "namespace", EnvVars: []string{EnvVar("namespace")}},
+			&cli.StringFlag{Name: "identity", EnvVars: []string{EnvVar("identity")}},
+		},
+		Commands: []*cli.Command{
+			{
+				Name: "receive",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "w", Aliases: []string{"word-count"}, EnvVars: []string{EnvVar("word-count")}, Value: 4},
+					&cli.BoolFlag{Name: "force", EnvVars: []string{EnvVar("force")}},
+				},
+				Action: func(c *cli.Context) error {
+					if err := settings.Apply(c); err != nil {
+						return err
+					}
+					c.Context = context.WithValue(c.Context, ContextKey, &Config{Settings: settings})
+					return Print(&out, c)
+				},
+			},
+		},
+	}
+	require.NoError(t, app.Run([]string{"pcp", "receive", "--force"}))
+
+	lines := strings.Split(out.String(), "\n")
+	assert.Equal(t, "Settings file: settings.json (loaded)", lines[0])
+
+	fields := map[string][]string{}
+	for _, line := range lines[2:] {
+		if f := strings.Fields(line); len(f) > 0 {
+			fields[f[0]] = []string{f[1], strings.Join(f[2:], " ")}
+		}
+	}
+	assert.Equal(t, []string{"5", SourceSettings}, fields["--w"])
+	assert.Equal(t, []string{"true", SourceFlag}, fields["--force"])
+	assert.Equal(t, []string{"env", SourceEnv}, fields["--namespace"])
+	assert.Equal(t, []string{`""`, SourceDefault}, fields["--identity"])
+}
@@ -2,18 +2,39 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 )
 
 type Settings struct {
 	Path   string `json:"-"`
 	Exists bool   `json:"-"`
+
+	// Flags holds default values for command line flags keyed by
+	// their name. They are overridden by the command line and
+	// environment variables.
+	Flags map[string]interface{} `json:"flags,omitempty"`
+
+	// applied holds the names of the flags whose value
+	// was taken from the settings file by Apply.
+	applied map[string]bool
 }
 
+// LoadSettings loads the settings file from the default location.
 func LoadSettings() (*Settings, error) {
-	path, err := appXdg.ConfigFile(settingsFile)
-	if err != nil {
-		return nil, err
+	return LoadSettingsFrom("")
+}
+
+// LoadSettingsFrom loads the settings from the given file. If the path
+// is empty the file at the default location is loaded, which doesn't
+// need to exist. An explicitly given file must exist.
+func LoadSettingsFrom(path string) (*Settings, error) {
+	explicit := path != ""
+	if !explicit {
+		var err error
+		if path, err = appXdg.ConfigFile(settingsFile); err != nil {
+			return nil, err
+		}
 	}
 
 	settings := &Settings{Path: path}
@@ -24,6 +45,8 @@ func LoadSettings() (*Settings, error) {
 			return nil, err
 		}
 		settings.Exists = true
+	} else if os.IsNotExist(err) && explicit {
+		return nil, fmt.Errorf("config file %s does not exist", path)
 	} else if !os.IsNotExist(err) {
 		return nil, err
 	}
@@ -146,3 +146,46 @@ func TestLoadSettings_happyPath(t *testing.T) {
 	assert.True(t, settings.Exists)
 	assert.Equal(t, "path", settings.Path)
 }
+
+func TestLoadSettingsFrom_explicitPath(t *testing.T) {
+	ctrl := setup(t)
+	defer teardown(t, ctrl)
+
+	mioutil := mock.NewMockIoutiler(ctrl)
+	mxdg := mock.NewMockXdger(ctrl)
+
+	appIoutil = mioutil
+	appXdg = mxdg
+
+	// The default location isn't consulted.
+	mxdg.EXPECT().ConfigFile(gomock.Any()).Times(0)
+
+	mioutil.
+		EXPECT().
+		ReadFile(gomock.Eq("custom.json")).
+		Return([]byte(`{"flags":{"namespace":"custom"}}`), nil)
+
+	settings, err := LoadSettingsFrom("custom.json")
+	require.NoError(t, err)
+	assert.True(t, settings.Exists)
+	assert.Equal(t, "custom.json", settings.Path)
+	assert.Equal(t, "custom", settings.Flags["namespace"])
+}
+
+func TestLoadSettingsFrom_explicitPathDoesNotExist(t *testing.T) {
+	ctrl := setup(t)
+	defer teardown(t, ctrl)
+
+	mioutil := mock.NewMockIoutiler(ctrl)
+	appIoutil = mioutil
+
+	mioutil.
+		EXPECT().
+		ReadFile(gomock.Eq("missing.json")).
+		Return(nil, os.ErrNotExist)
+
+	settings, err := LoadSettingsFrom("missing.json")
+	assert.Nil(t, settings)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing.json")
+}
@@ -92,7 +92,8 @@ func NewStreamDecrypter(key []byte, iv []byte, src io.Reader) (*StreamDecrypter,
 	}, nil
 }
 
-// Read reads bytes from the underlying reader and then decrypts them.
+// Read reads bytes from the underlying reader and then decrypts them. An
+// empty read, e.g. of an empty write to a pipe, is no end of stream.
 func (s *StreamDecrypter) Read(p []byte) (int, error) {
 	n, readErr := s.src.Read(p)
 	if n > 0 {
@@ -101,9 +102,8 @@ func (s *StreamDecrypter) Read(p []byte) (int, error) {
 			return n, err
 		}
 		s.stream.XORKeyStream(p[:n], p[:n])
-		return n, readErr
 	}
-	return 0, io.EOF
+	return n, readErr
 }
 
 // Authenticate verifies that the hash of the stream is correct. This should only be called after processing is finished
@@ -2,6 +2,7 @@ package crypt
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
 	"testing"
 
@@ -32,3 +33,28 @@ func TestStreamEncrypterDecrypter(t *testing.T) {
 
 	assert.Nil(t, sd.Authenticate(se.Hash()))
 }
+
+func TestStreamDecrypter_emptyRead(t *testing.T) {
+	key, err := DeriveKey([]byte("password"), []byte("salt"))
+	assert.Nil(t, err)
+
+	pr, pw := io.Pipe()
+	se, err := NewStreamEncrypter(key, pw)
+	assert.Nil(t, err)
+
+	// An empty write arrives as an empty read,
+	// which must not end the stream.
+	go func() {
+		_, _ = se.Write([]byte{})
+		_, _ = se.Write([]byte("some text"))
+		pw.Close()
+	}()
+
+	sd, err := NewStreamDecrypter(key, se.InitializationVector(), pr)
+	assert.Nil(t, err)
+
+	decrypted, err := ioutil.ReadAll(sd)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("some text"), decrypted)
+	assert.Nil(t, sd.Authenticate(se.Hash()))
+}
@@ -2,9 +2,13 @@ package dht
 
 import (
 	"context"
+	"sync"
 	"time"
 
+	"github.com/libp2p/go-libp2p-core/event"
 	"github.com/libp2p/go-libp2p-core/host"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 
 	"github.com/dennis-tra/pcp/internal/log"
 	"github.com/dennis-tra/pcp/internal/wrap"
@@ -18,16 +22,25 @@ var (
 	// IP address. This can take time until e.g. the identify
 	// protocol has determined one for us.
 	pubAddrInter = 50 * time.Millisecond
+
+	// addrDebounce is the time our public addresses must be stable
+	// after a change before the DHT entry is renewed.
+	addrDebounce = 5 * time.Second
 )
 
 // Advertiser is responsible for writing and renewing the DHT entry.
 type Advertiser struct {
 	*protocol
+
+	// cancelProvide aborts the running provide operation, so that
+	// the next one picks up our changed addresses right away.
+	provideLk     sync.Mutex
+	cancelProvide context.CancelFunc
 }
 
 // NewAdvertiser creates a new Advertiser.
 func NewAdvertiser(h host.Host, dht wrap.IpfsDHT) *Advertiser {
-	return &Advertiser{newProtocol(h, dht)}
+	return &Advertiser{protocol: newProtocol(h, dht)}
 }
 
 // Advertise establishes a connection to a set of bootstrap peers
@@ -44,16 +57,20 @@ func NewAdvertiser(h host.Host, dht wrap.IpfsDHT) *Advertiser {
 // it rolls over to the next time slot. Than pcp just advertises the new time slot
 // as well. It can still be found with the old one.
 func (a *Advertiser) Advertise(chanID int) error {
-	if err := a.Bootstrap(); err != nil {
+	// Start the service first, so that a shutdown
+	// aborts retrying to connect to bootstrap peers.
+	if err := a.ServiceStarted(); err != nil {
 		return err
 	}
+	defer a.ServiceStopped()
 
-	if err := a.ServiceStarted(); err != nil {
+	a.setStage(StageBootstrapping)
+	if err := a.Bootstrap(); err != nil {
 		return err
 	}
-	defer a.ServiceStopped()
 
 	log.Debugln("DHT - Waiting for public IP...")
+	a.setStage(StageWaitingForPublicAddrs)
 	for {
 		// Only advertise in the DHT if we have a public addr.
 		if !a.HasPublicAddr() {
@@ -68,10 +85,31 @@ func (a *Advertiser) Advertise(chanID int) error {
 		break
 	}
 
+	a.setStage(StageProviding)
+
+	// Renew the DHT entry if our public addresses change, e.g.
+	// after a network switch. Otherwise peers dial dead addresses.
+	sub, err := a.EventBus().Subscribe(new(event.EvtLocalAddressesUpdated))
+	if err != nil {
+		log.Warningln("DHT - Can't watch for address changes:", err)
+	} else {
+		defer sub.Close()
+		go a.watchAddrs(sub.Out(), a.Addrs)
+	}
+
 	for {
-		err := a.provide(a.ServiceContext(), a.DiscoveryID(chanID))
-		if err == context.Canceled {
+		ctx, cancel := context.WithCancel(a.ServiceContext())
+		a.provideLk.Lock()
+		a.cancelProvide = cancel
+		a.provideLk.Unlock()
+
+		err := a.provide(ctx, a.DiscoveryID(chanID))
+		cancel()
+
+		if a.ServiceContext().Err() != nil {
 			break
+		} else if err == context.Canceled {
+			log.Debugln("DHT - Renewing the DHT entry with our new addresses")
 		} else if err != nil && err != context.DeadlineExceeded {
 			log.Warningf("Error providing: %s\n", err)
 		}
@@ -80,6 +118,41 @@ func (a *Advertiser) Advertise(chanID int) error {
 	return nil
 }
 
+// watchAddrs restarts the running provide operation when our public
+// addresses have changed. Rapid updates are debounced, so that we only
+// renew the DHT entry once the addresses have settled.
+func (a *Advertiser) watchAddrs(events <-chan interface{}, addrs func() []ma.Multiaddr) {
+	last := publicAddrs(addrs())
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-a.SigShutdown():
+			return
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+			debounce = time.After(addrDebounce)
+		case <-debounce:
+			debounce = nil
+
+			current := publicAddrs(addrs())
+			if sameAddrs(last, current) {
+				continue
+			}
+			last = current
+
+			log.Infoln("DHT - Public addresses changed, re-advertising")
+			a.provideLk.Lock()
+			if a.cancelProvide != nil {
+				a.cancelProvide()
+			}
+			a.provideLk.Unlock()
+		}
+	}
+}
+
 // HasPublicAddr returns true if there is at least one public
 // address associated with the current node - aka we got at
 // least three confirmations from peers through the identify
@@ -93,6 +166,67 @@ func (a *Advertiser) HasPublicAddr() bool {
 	return false
 }
 
+// publicAddrs returns the public addresses of the given ones.
+func publicAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	var public []ma.Multiaddr
+	for _, addr := range addrs {
+		if manet.IsPublicAddr(addr) {
+			public = append(public, addr)
+		}
+	}
+	return public
+}
+
+// sameAddrs returns true if both slices contain the same set of addresses.
+func sameAddrs(a []ma.Multiaddr, b []ma.Multiaddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	set := map[string]struct{}{}
+	for _, addr := range a {
+		set[string(addr.Bytes())] = struct{}{}
+	}
+
+	for _, addr := range b {
+		if _, found := set[string(addr.Bytes())]; !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SetNamespace sets the namespace that is mixed into the discovery identifier.
+func (a *Advertiser) SetNamespace(namespace string) *Advertiser {
+	a.namespace = namespace
+	return a
+}
+
+// SetTimeSlot pins the time slot the discovery identifier is derived
+// from. The zero time derives it from the current time again.
+func (a *Advertiser) SetTimeSlot(slot time.Time) *Advertiser {
+	a.timeSlot = slot
+	return a
+}
+
+// SetBootstrapRetries configures how often we try to connect to enough
+// bootstrap peers and the initial jittered waiting time in between.
+// Zero rounds keep BootstrapRounds.
+func (a *Advertiser) SetBootstrapRetries(rounds int, backoff time.Duration) *Advertiser {
+	if rounds > 0 {
+		a.bootstrapRounds = rounds
+	}
+	a.bootstrapBackoff = backoff
+	return a
+}
+
+// SetStageHandler registers a function that is called whenever the stage changes.
+func (a *Advertiser) SetStageHandler(handler func(Stage)) *Advertiser {
+	a.onStage = handler
+	return a
+}
+
 // Shutdown stops the advertise mechanics.
 func (a *Advertiser) Shutdown() {
 	a.Service.Shutdown()
@@ -157,11 +157,10 @@ func TestAdvertiser_Advertise_listensOnShutdownIfNoPublicAddr(t *testing.T) {
 }
 
 func TestAdvertiser_Advertise_propagatesServiceAlreadyStarted(t *testing.T) {
-	ctrl, local, net, teardown := setup(t)
+	ctrl, local, _, teardown := setup(t)
 	defer teardown(t)
 
-	mockDefaultBootstrapPeers(t, ctrl, net, local)
-
+	// The service is started before bootstrapping, so it fails right away.
 	a := NewAdvertiser(local, mock.NewMockIpfsDHT(ctrl))
 
 	err := a.ServiceStarted()
@@ -248,3 +247,62 @@ func TestAdvertiser_Advertise_mutatesDiscoveryIdentifier(t *testing.T) {
 
 	assert.NotEqual(t, cids[0], cids[1])
 }
+
+func TestAdvertiser_watchAddrs(t *testing.T) {
+	_, local, _, teardown := setup(t)
+	defer teardown(t)
+
+	addrDebounce = 10 * time.Millisecond
+
+	a := NewAdvertiser(local, nil)
+	require.NoError(t, a.ServiceStarted())
+
+	cancelled := make(chan struct{}, 10)
+	a.cancelProvide = func() { cancelled <- struct{}{} }
+
+	var lk sync.Mutex
+	addrs := []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}
+	getAddrs := func() []ma.Multiaddr {
+		lk.Lock()
+		defer lk.Unlock()
+		return addrs
+	}
+
+	events := make(chan interface{})
+	go a.watchAddrs(events, getAddrs)
+
+	// Unchanged public addresses don't renew the entry.
+	events <- struct{}{}
+	lk.Lock()
+	addrs = append(addrs, ma.StringCast("/ip4/192.168.0.2/tcp/4001"))
+	lk.Unlock()
+	events <- struct{}{}
+	select {
+	case <-cancelled:
+		t.Fatal("provide should not be restarted")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Rapid updates are debounced into a single renewal.
+	lk.Lock()
+	addrs = []ma.Multiaddr{ma.StringCast("/ip4/5.6.7.8/tcp/4001")}
+	lk.Unlock()
+	events <- struct{}{}
+	events <- struct{}{}
+	events <- struct{}{}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("provide should be restarted")
+	}
+
+	select {
+	case <-cancelled:
+		t.Fatal("provide should only be restarted once")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	go a.Shutdown()
+	a.ServiceStopped()
+}
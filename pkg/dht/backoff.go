@@ -0,0 +1,51 @@
+package dht
+
+import (
+	"math/rand"
+	"time"
+)
+
+var (
+	// RetryBackoffInitial is the default waiting time before the first
+	// repeated provider lookup. Zero disables the backoff.
+	RetryBackoffInitial = time.Second
+
+	// RetryBackoffMax is the default upper bound of the waiting time
+	// between two provider lookups.
+	RetryBackoffMax = time.Minute
+)
+
+// backoff calculates exponentially increasing waiting times with
+// jitter between repeated provider lookups.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+// Next returns the time to wait before the next lookup. The waiting time
+// doubles with each call until the maximum is reached. Half of it is
+// randomized, so that multiple discoverers don't hit the DHT in lockstep.
+func (b *backoff) Next() time.Duration {
+	if b.initial <= 0 {
+		return 0
+	}
+
+	if b.current == 0 {
+		b.current = b.initial
+	} else {
+		b.current *= 2
+	}
+
+	if b.max > 0 && b.current > b.max {
+		b.current = b.max
+	}
+
+	half := b.current / 2
+	return half + time.Duration(rand.Int63n(int64(b.current-half)+1))
+}
+
+// Reset starts over with the initial waiting time.
+func (b *backoff) Reset() {
+	b.current = 0
+}
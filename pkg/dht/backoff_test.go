@@ -0,0 +1,26 @@
+package dht
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff_Next(t *testing.T) {
+	b := backoff{initial: time.Second, max: 4 * time.Second}
+
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		wait := b.Next()
+		assert.GreaterOrEqual(t, int64(wait), int64(expected/2))
+		assert.LessOrEqual(t, int64(wait), int64(expected))
+	}
+
+	b.Reset()
+	assert.LessOrEqual(t, int64(b.Next()), int64(time.Second))
+}
+
+func TestBackoff_Next_disabled(t *testing.T) {
+	b := backoff{max: time.Minute}
+	assert.Equal(t, time.Duration(0), b.Next())
+}
@@ -2,6 +2,7 @@ package dht
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p-core/host"
@@ -11,17 +12,49 @@ import (
 
 	"github.com/dennis-tra/pcp/internal/log"
 	"github.com/dennis-tra/pcp/internal/wrap"
+	"github.com/dennis-tra/pcp/pkg/discovery"
+	"github.com/dennis-tra/pcp/pkg/metrics"
 )
 
+// DefaultProviderLimit is the default number of providers a single
+// lookup returns before it ends.
+const DefaultProviderLimit = 100
+
 // Discoverer is responsible for reading the DHT for an
 // entry with the channel ID given below.
 type Discoverer struct {
 	*protocol
+
+	// backoff determines the waiting time between repeated lookups.
+	backoff backoff
+
+	// providerLimit is the number of providers after which a lookup
+	// ends early. Zero means the lookup runs until it times out.
+	providerLimit int
+
+	// unreachable holds the peers that were found without a public address.
+	unreachable sync.Map
+
+	// lookups bounds the provider lookups that run concurrently
+	// with other discoverers. It's nil if there is no limit.
+	lookups *LookupLimiter
+
+	// retries counts the lookups without a result.
+	retries discovery.Retries
+
+	// onNoProvider is called once if no provider at all was found
+	// within noProviderGrace after the first lookup has started.
+	noProviderGrace time.Duration
+	onNoProvider    func()
 }
 
 // NewDiscoverer creates a new Discoverer.
 func NewDiscoverer(h host.Host, dht wrap.IpfsDHT) *Discoverer {
-	return &Discoverer{newProtocol(h, dht)}
+	return &Discoverer{
+		protocol:      newProtocol(h, dht),
+		backoff:       backoff{initial: RetryBackoffInitial, max: RetryBackoffMax},
+		providerLimit: DefaultProviderLimit,
+	}
 }
 
 // Discover establishes a connection to a set of bootstrap peers
@@ -32,10 +65,17 @@ func (d *Discoverer) Discover(chanID int, handler func(info peer.AddrInfo)) erro
 	}
 	defer d.ServiceStopped()
 
+	d.setStage(StageBootstrapping)
 	if err := d.Bootstrap(); err != nil {
 		return err
 	}
 
+	// The DHT is reachable from here on. Not finding a provider
+	// usually means that the sender hasn't started yet.
+	lookupStart := time.Now()
+	hinted := false
+	seen := false
+
 	for {
 		did := d.DiscoveryID(chanID)
 		log.Debugln("DHT - Discovering", did)
@@ -44,25 +84,75 @@ func (d *Discoverer) Discover(chanID int, handler func(info peer.AddrInfo)) erro
 			return err
 		}
 
+		// Wait until the discoverers of other time slots leave room for our lookup.
+		if d.lookups != nil {
+			d.setStage(StageWaitingForLookup)
+		}
+		if err = d.lookups.acquire(d.ServiceContext(), d.offset == 0); err != nil {
+			return nil
+		}
+
 		// Find new provider with a timeout, so the discovery ID is renewed if necessary.
+		d.setStage(StageLookup)
+		start := time.Now()
+		found := false
 		ctx, cancel := context.WithTimeout(d.ServiceContext(), provideTimeout)
-		for pi := range d.dht.FindProvidersAsync(ctx, cID, 100) {
+		for pi := range d.dht.FindProvidersAsync(ctx, cID, d.providerLimit) {
 			log.Debugln("DHT - Found peer ", pi.ID)
+			if pi.ID != d.ID() {
+				seen = true
+			}
 			pi.Addrs = onlyPublic(pi.Addrs)
 			if isRoutable(pi) {
+				metrics.PeersFound.WithLabelValues("dht").Inc()
+				found = true
 				go handler(pi)
+			} else if pi.ID != d.ID() {
+				d.markUnreachable(pi.ID)
 			}
 		}
+		metrics.DiscoveryRoundDuration.WithLabelValues("dht").Observe(time.Since(start).Seconds())
 		log.Debugln("DHT - Discovering", did, " done.")
 
 		// cannot defer cancel in this for loop
 		cancel()
+		d.lookups.release()
 
 		select {
 		case <-d.SigShutdown():
 			return nil
 		default:
 		}
+
+		if d.retries.Exhausted(found) {
+			d.setStage(StageGaveUp)
+			return discovery.ErrRetriesExhausted{Mechanism: d.Mechanism(), Rounds: d.retries.Max}
+		}
+
+		// Only a hint, the lookups go on as the sender may still show up.
+		if !seen && !hinted && d.onNoProvider != nil && time.Since(lookupStart) >= d.noProviderGrace {
+			hinted = true
+			d.onNoProvider()
+		}
+
+		// Don't hammer the DHT with lookups. Start over
+		// with short waiting times if we found a provider.
+		if found {
+			d.backoff.Reset()
+		}
+		if wait := d.backoff.Next(); wait > 0 {
+			log.Debugln("DHT - Waiting", wait, "before next lookup")
+			if seen {
+				d.setStage(StageRetrying)
+			} else {
+				d.setStage(StageNoProvider)
+			}
+			select {
+			case <-d.SigShutdown():
+				return nil
+			case <-time.After(wait):
+			}
+		}
 	}
 }
 
@@ -71,6 +161,95 @@ func (d *Discoverer) SetOffset(offset time.Duration) *Discoverer {
 	return d
 }
 
+func (d *Discoverer) SetNamespace(namespace string) *Discoverer {
+	d.namespace = namespace
+	return d
+}
+
+// SetTimeSlot pins the time slot the discovery identifier is derived
+// from. The zero time derives it from the current time again.
+func (d *Discoverer) SetTimeSlot(slot time.Time) *Discoverer {
+	d.timeSlot = slot
+	return d
+}
+
+// SetBackoff configures the exponential backoff between repeated
+// provider lookups. An initial waiting time of zero disables it.
+func (d *Discoverer) SetBackoff(initial time.Duration, max time.Duration) *Discoverer {
+	d.backoff = backoff{initial: initial, max: max}
+	return d
+}
+
+// SetProviderLimit ends each lookup after the given number of providers
+// was found. The next lookup starts as usual if none of them is our
+// peer. Zero removes the limit.
+func (d *Discoverer) SetProviderLimit(limit int) *Discoverer {
+	d.providerLimit = limit
+	return d
+}
+
+// SetLookupLimiter shares the given limiter of concurrent provider
+// lookups with other discoverers. Nil removes the limit.
+func (d *Discoverer) SetLookupLimiter(l *LookupLimiter) *Discoverer {
+	d.lookups = l
+	return d
+}
+
+// SetBootstrapRetries configures how often we try to connect to enough
+// bootstrap peers and the initial jittered waiting time in between.
+// Zero rounds keep BootstrapRounds.
+func (d *Discoverer) SetBootstrapRetries(rounds int, backoff time.Duration) *Discoverer {
+	if rounds > 0 {
+		d.bootstrapRounds = rounds
+	}
+	d.bootstrapBackoff = backoff
+	return d
+}
+
+// SetMaxRetries makes Discover give up after the given number of lookups
+// without a result. Zero retries forever.
+func (d *Discoverer) SetMaxRetries(max int) *Discoverer {
+	d.retries = discovery.Retries{Max: max}
+	return d
+}
+
+// SetNoProviderHandler registers a function that is called once if the
+// lookups haven't found any provider within the given grace period. The
+// discoverer keeps looking afterwards. A nil handler disables it.
+func (d *Discoverer) SetNoProviderHandler(grace time.Duration, handler func()) *Discoverer {
+	d.noProviderGrace = grace
+	d.onNoProvider = handler
+	return d
+}
+
+// SetStageHandler registers a function that is called whenever the stage changes.
+func (d *Discoverer) SetStageHandler(handler func(Stage)) *Discoverer {
+	d.onStage = handler
+	return d
+}
+
+// Unreachable returns the number of distinct peers that
+// were found but didn't advertise any public address.
+func (d *Discoverer) Unreachable() int {
+	count := 0
+	d.unreachable.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// markUnreachable records that the given peer was found without a
+// public address. The same peer is usually found in every lookup,
+// so it's only counted once.
+func (d *Discoverer) markUnreachable(peerID peer.ID) {
+	if _, found := d.unreachable.LoadOrStore(peerID, struct{}{}); found {
+		return
+	}
+	log.Debugln("DHT - Found peer", peerID, "but it has no public addresses")
+	metrics.PeersUnreachable.WithLabelValues("dht").Inc()
+}
+
 func (d *Discoverer) Shutdown() {
 	d.Service.Shutdown()
 }
@@ -3,6 +3,7 @@ package dht
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/dennis-tra/pcp/internal/mock"
+	"github.com/dennis-tra/pcp/pkg/discovery"
 )
 
 func TestDiscoverer_Discover_happyPath(t *testing.T) {
@@ -72,6 +74,47 @@ func TestDiscoverer_Discover_happyPath(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDiscoverer_Discover_countsUnreachable(t *testing.T) {
+	ctrl, local, net, teardown := setup(t)
+	defer teardown(t)
+
+	mockDefaultBootstrapPeers(t, ctrl, net, local)
+
+	dht := mock.NewMockIpfsDHT(ctrl)
+	d := NewDiscoverer(local, dht)
+
+	piChan := make(chan peer.AddrInfo)
+	dht.EXPECT().
+		FindProvidersAsync(gomock.Any(), gomock.Any(), 100).
+		DoAndReturn(func(ctx context.Context, cID cid.Cid, count int) <-chan peer.AddrInfo {
+			go func() {
+				<-ctx.Done()
+				close(piChan)
+			}()
+			return piChan
+		})
+
+	unreachable, err := net.GenPeer()
+	require.NoError(t, err)
+
+	provider, err := net.GenPeer()
+	require.NoError(t, err)
+
+	go func() {
+		// The same peer without addresses is found twice.
+		piChan <- peer.AddrInfo{ID: unreachable.ID()}
+		piChan <- peer.AddrInfo{ID: unreachable.ID()}
+		piChan <- peer.AddrInfo{ID: provider.ID(), Addrs: provider.Addrs()}
+	}()
+
+	err = d.Discover(333, func(pi peer.AddrInfo) {
+		assert.Equal(t, provider.ID(), pi.ID)
+		d.Shutdown()
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, d.Unreachable())
+}
+
 func TestDiscoverer_Discover_reschedulesFindProvider(t *testing.T) {
 	ctrl, local, net, teardown := setup(t)
 	defer teardown(t)
@@ -102,6 +145,32 @@ func TestDiscoverer_Discover_reschedulesFindProvider(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDiscoverer_Discover_providerLimit(t *testing.T) {
+	ctrl, local, net, teardown := setup(t)
+	defer teardown(t)
+
+	mockDefaultBootstrapPeers(t, ctrl, net, local)
+
+	dht := mock.NewMockIpfsDHT(ctrl)
+	d := NewDiscoverer(local, dht).SetProviderLimit(3).SetBackoff(0, 0)
+
+	// The lookup is repeated after the limit was reached.
+	var calls int32
+	dht.EXPECT().
+		FindProvidersAsync(gomock.Any(), gomock.Any(), 3).
+		DoAndReturn(func(ctx context.Context, cID cid.Cid, count int) <-chan peer.AddrInfo {
+			piChan := make(chan peer.AddrInfo)
+			go close(piChan)
+			if atomic.AddInt32(&calls, 1) == 2 {
+				go d.Shutdown()
+			}
+			return piChan
+		}).MinTimes(2)
+
+	err := d.Discover(333, nil)
+	assert.NoError(t, err)
+}
+
 func TestDiscoverer_Discover_callsFindProviderWithMutatingDiscoveryIDs(t *testing.T) {
 	ctrl, local, net, teardown := setup(t)
 	defer teardown(t)
@@ -197,3 +266,71 @@ func TestDiscoverer_SetOffset(t *testing.T) {
 	id2 := d.DiscoveryID(333)
 	assert.NotEqual(t, id1, id2)
 }
+
+func TestDiscoverer_Discover_maxRetries(t *testing.T) {
+	ctrl, local, net, teardown := setup(t)
+	defer teardown(t)
+
+	mockDefaultBootstrapPeers(t, ctrl, net, local)
+
+	dht := mock.NewMockIpfsDHT(ctrl)
+	d := NewDiscoverer(local, dht).SetMaxRetries(3)
+
+	var stages []Stage
+	d.SetStageHandler(func(s Stage) { stages = append(stages, s) })
+
+	dht.EXPECT().
+		FindProvidersAsync(gomock.Any(), gomock.Any(), 100).
+		DoAndReturn(func(ctx context.Context, cID cid.Cid, count int) <-chan peer.AddrInfo {
+			piChan := make(chan peer.AddrInfo)
+			go close(piChan)
+			return piChan
+		}).Times(3)
+
+	err := d.Discover(333, nil)
+	assert.Equal(t, discovery.ErrRetriesExhausted{Mechanism: "DHT", Rounds: 3}, err)
+	assert.Equal(t, StageGaveUp, stages[len(stages)-1])
+}
+
+func TestDiscoverer_Discover_noProviderHint(t *testing.T) {
+	ctrl, local, net, teardown := setup(t)
+	defer teardown(t)
+
+	mockDefaultBootstrapPeers(t, ctrl, net, local)
+
+	dht := mock.NewMockIpfsDHT(ctrl)
+	d := NewDiscoverer(local, dht).SetBackoff(time.Millisecond, time.Millisecond)
+
+	var hints int32
+	d.SetNoProviderHandler(0, func() { atomic.AddInt32(&hints, 1) })
+
+	var stagesLk sync.Mutex
+	var stages []Stage
+	d.SetStageHandler(func(s Stage) {
+		stagesLk.Lock()
+		defer stagesLk.Unlock()
+		stages = append(stages, s)
+	})
+
+	// The lookups go on after the hint until we stop them.
+	var calls int32
+	dht.EXPECT().
+		FindProvidersAsync(gomock.Any(), gomock.Any(), 100).
+		DoAndReturn(func(ctx context.Context, cID cid.Cid, count int) <-chan peer.AddrInfo {
+			piChan := make(chan peer.AddrInfo)
+			go close(piChan)
+			if atomic.AddInt32(&calls, 1) == 3 {
+				go d.Shutdown()
+			}
+			return piChan
+		}).MinTimes(3)
+
+	err := d.Discover(333, nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&hints))
+
+	stagesLk.Lock()
+	defer stagesLk.Unlock()
+	assert.Contains(t, stages, StageNoProvider)
+	assert.NotContains(t, stages, StageRetrying)
+}
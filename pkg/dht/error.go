@@ -2,16 +2,25 @@ package dht
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/dennis-tra/pcp/internal/log"
 )
 
+// ErrConnThresholdNotReached is returned if we couldn't connect to
+// ConnThreshold bootstrap peers after all attempts. BootstrapErrs holds
+// the errors of the last attempt.
 type ErrConnThresholdNotReached struct {
 	BootstrapErrs []error
+
+	// Connected is the number of bootstrap peers we
+	// could connect to out of Total.
+	Connected int
+	Total     int
 }
 
 func (e ErrConnThresholdNotReached) Error() string {
-	return "could not establish enough connections to bootstrap peers"
+	return fmt.Sprintf("could not establish enough connections to bootstrap peers (%d of %d, need %d)", e.Connected, e.Total, ConnThreshold)
 }
 
 func (e ErrConnThresholdNotReached) Log() {
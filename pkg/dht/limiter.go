@@ -0,0 +1,84 @@
+package dht
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultConcurrentLookups is the default number of provider lookups
+// that the discoverers of the receiving node run at the same time.
+const DefaultConcurrentLookups = 2
+
+// LookupLimiter bounds the number of concurrent provider lookups of
+// several discoverers that look in different time slots. The primary
+// discoverer without an offset never waits, so that the lookup in the
+// current time slot isn't starved by the others. Its lookups still
+// count towards the limit. A nil limiter is unlimited.
+type LookupLimiter struct {
+	lk      sync.Mutex
+	limit   int
+	running int
+
+	// released is closed and replaced whenever a lookup has
+	// finished to wake up the discoverers that wait for it.
+	released chan struct{}
+}
+
+// NewLookupLimiter returns a limiter for the given number of concurrent
+// lookups. It returns nil, i.e. no limit, if the limit is zero.
+func NewLookupLimiter(limit int) *LookupLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &LookupLimiter{limit: limit, released: make(chan struct{})}
+}
+
+// acquire blocks until another lookup may start or the context is done.
+// Primary lookups start right away.
+func (l *LookupLimiter) acquire(ctx context.Context, primary bool) error {
+	if l == nil {
+		return nil
+	}
+
+	for {
+		l.lk.Lock()
+		if primary || l.running < l.limit {
+			l.running++
+			l.lk.Unlock()
+			return nil
+		}
+		released := l.released
+		l.lk.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+// release marks a lookup as finished.
+func (l *LookupLimiter) release() {
+	if l == nil {
+		return
+	}
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	l.running--
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// Running returns the number of lookups that are currently running.
+func (l *LookupLimiter) Running() int {
+	if l == nil {
+		return 0
+	}
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	return l.running
+}
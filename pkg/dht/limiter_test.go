@@ -0,0 +1,80 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennis-tra/pcp/internal/mock"
+)
+
+func TestLookupLimiter_unlimited(t *testing.T) {
+	l := NewLookupLimiter(0)
+	assert.Nil(t, l)
+	assert.NoError(t, l.acquire(context.Background(), false))
+	l.release()
+	assert.Zero(t, l.Running())
+}
+
+func TestLookupLimiter_primaryNeverWaits(t *testing.T) {
+	ctx := context.Background()
+	l := NewLookupLimiter(1)
+
+	require.NoError(t, l.acquire(ctx, false))
+	require.NoError(t, l.acquire(ctx, true))
+	assert.Equal(t, 2, l.Running())
+
+	// The primary lookup counts towards the limit.
+	l.release()
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, l.acquire(timeout, false))
+}
+
+func TestLookupLimiter_waits(t *testing.T) {
+	ctx := context.Background()
+	l := NewLookupLimiter(1)
+	require.NoError(t, l.acquire(ctx, false))
+
+	acquired := make(chan error)
+	go func() { acquired <- l.acquire(ctx, false) }()
+
+	select {
+	case <-acquired:
+		t.Fatal("lookup didn't wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	l.release()
+	assert.NoError(t, <-acquired)
+	assert.Equal(t, 1, l.Running())
+}
+
+func TestDiscoverer_Discover_waitsForLookup(t *testing.T) {
+	ctrl, local, net, teardown := setup(t)
+	defer teardown(t)
+
+	mockDefaultBootstrapPeers(t, ctrl, net, local)
+
+	// The offset discoverer must not look up providers while the limit is
+	// exhausted, so FindProvidersAsync isn't expected to be called.
+	dht := mock.NewMockIpfsDHT(ctrl)
+	l := NewLookupLimiter(1)
+	require.NoError(t, l.acquire(context.Background(), true))
+
+	var stages []Stage
+	d := NewDiscoverer(local, dht).SetOffset(-TruncateDuration).SetLookupLimiter(l).
+		SetStageHandler(func(s Stage) { stages = append(stages, s) })
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		d.Shutdown()
+	}()
+
+	assert.NoError(t, d.Discover(333, nil))
+	assert.Equal(t, StageWaitingForLookup, stages[len(stages)-1])
+	assert.Equal(t, 1, l.Running())
+}
@@ -1,6 +1,7 @@
 package dht
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -10,10 +11,21 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	mh "github.com/multiformats/go-multihash"
 
+	"github.com/dennis-tra/pcp/internal/log"
 	"github.com/dennis-tra/pcp/internal/wrap"
+	"github.com/dennis-tra/pcp/pkg/discovery"
 	"github.com/dennis-tra/pcp/pkg/service"
 )
 
+// Both the discoverer and advertiser can be used generically.
+var (
+	_ discovery.Discoverer = (*Discoverer)(nil)
+	_ discovery.Advertiser = (*Advertiser)(nil)
+
+	_ discovery.ChannelDescriber = (*Discoverer)(nil)
+	_ discovery.ChannelDescriber = (*Advertiser)(nil)
+)
+
 // These wrapped top level functions are here for testing purposes.
 var (
 	wrapDHT   wrap.DHTer   = wrap.DHT{}
@@ -25,6 +37,14 @@ var (
 	// ConnThreshold represents the minimum number of bootstrap peers we need a connection to.
 	ConnThreshold = 3
 
+	// BootstrapRounds is the default number of attempts to connect to
+	// ConnThreshold bootstrap peers. Only the failed peers are retried.
+	BootstrapRounds = 3
+
+	// BootstrapBackoff is the default waiting time before the second
+	// attempt. It doubles with each further attempt and is jittered.
+	BootstrapBackoff = time.Second
+
 	// TruncateDuration represents the time slot to which the current time is truncated.
 	TruncateDuration = 5 * time.Minute
 
@@ -33,6 +53,20 @@ var (
 	bootstrap = map[peer.ID]*sync.Once{} // may need locking in theory?
 )
 
+// Stage describes what the discoverer or advertiser is currently doing.
+type Stage string
+
+const (
+	StageBootstrapping         Stage = "bootstrapping"
+	StageWaitingForPublicAddrs Stage = "waiting for public addresses"
+	StageWaitingForLookup      Stage = "waiting for other lookups"
+	StageLookup                Stage = "looking up providers"
+	StageProviding             Stage = "providing"
+	StageRetrying              Stage = "waiting before retrying"
+	StageNoProvider            Stage = "searching (no provider yet)"
+	StageGaveUp                Stage = "gave up"
+)
+
 // protocol encapsulates the logic for discovering peers
 // through providing it in the IPFS DHT.
 type protocol struct {
@@ -44,11 +78,33 @@ type protocol struct {
 	dht wrap.IpfsDHT
 
 	offset time.Duration
+
+	// timeSlot pins the time slot instead of deriving it from the
+	// current time. The discovery identifier doesn't rotate then.
+	timeSlot time.Time
+
+	// namespace isolates pcp deployments from each other by
+	// being mixed into the discovery identifier.
+	namespace string
+
+	// onStage is called whenever the stage changes.
+	onStage func(Stage)
+
+	// bootstrapRounds and bootstrapBackoff configure the
+	// retries of failed bootstrap connections.
+	bootstrapRounds  int
+	bootstrapBackoff time.Duration
 }
 
 func newProtocol(h host.Host, dht wrap.IpfsDHT) *protocol {
 	bootstrap[h.ID()] = &sync.Once{}
-	return &protocol{Host: h, dht: dht, Service: service.New("DHT")}
+	return &protocol{
+		Host:             h,
+		dht:              dht,
+		Service:          service.New("DHT"),
+		bootstrapRounds:  BootstrapRounds,
+		bootstrapBackoff: BootstrapBackoff,
+	}
 }
 
 // Bootstrap connects to a set of bootstrap nodes to connect
@@ -58,58 +114,127 @@ func (p *protocol) Bootstrap() (err error) {
 	// bootstrap twice. Here we're limiting it to only one call.
 	once := bootstrap[p.ID()]
 	once.Do(func() {
-		peers := wrapDHT.GetDefaultBootstrapPeerAddrInfos()
-		peerCount := len(peers)
-		if peerCount == 0 {
-			err = fmt.Errorf("no bootstrap peers configured")
-			return
+		err = p.bootstrap()
+	})
+	return
+}
+
+// bootstrap connects to the default bootstrap peers. If we couldn't
+// establish enough connections it retries the failed peers up to
+// bootstrapRounds times in total with a jittered backoff in between.
+func (p *protocol) bootstrap() error {
+	peers := wrapDHT.GetDefaultBootstrapPeerAddrInfos()
+	peerCount := len(peers)
+	if peerCount == 0 {
+		return fmt.Errorf("no bootstrap peers configured")
+	}
+
+	b := backoff{initial: p.bootstrapBackoff, max: RetryBackoffMax}
+	pending := peers
+	var errs []error
+	for round := 1; ; round++ {
+		pending, errs = p.connectBootstrapPeers(pending)
+
+		connected := peerCount - len(pending)
+		if connected >= ConnThreshold {
+			return nil
 		}
 
-		// Asynchronously connect to all bootstrap peers and send
-		// potential errors to a channel. This channel is used
-		// to capture the errors and check if we have established
-		// enough connections. An error group (errgroup) cannot
-		// be used here as it exits as soon as an error is thrown
-		// in one of the Go-Routines.
-		var wg sync.WaitGroup
-		errChan := make(chan error, peerCount)
-		for _, bp := range peers {
-			wg.Add(1)
-			go func(pi peer.AddrInfo) {
-				defer wg.Done()
-				errChan <- p.Connect(p.ServiceContext(), pi)
-			}(bp)
+		if round >= p.bootstrapRounds {
+			break
 		}
 
-		// Close error channel after all connection attempts are done
-		// to signal the for-loop below to stop.
-		go func() {
-			wg.Wait()
-			close(errChan)
-		}()
-
-		// Reading the error channel and collect errors.
-		errs := ErrConnThresholdNotReached{BootstrapErrs: []error{}}
-		for {
-			err, ok := <-errChan
-			if !ok {
-				// channel was closed.
-				break
-			} else if err != nil {
-				errs.BootstrapErrs = append(errs.BootstrapErrs, err)
-			}
+		wait := b.Next()
+		log.Debugf("DHT - Connected to %d of %d bootstrap peers, retrying in %s\n", connected, peerCount, wait)
+		select {
+		case <-p.ServiceContext().Done():
+			errs = append(errs, p.ServiceContext().Err())
+			return ErrConnThresholdNotReached{BootstrapErrs: errs, Connected: connected, Total: peerCount}
+		case <-time.After(wait):
 		}
+	}
+
+	return ErrConnThresholdNotReached{BootstrapErrs: errs, Connected: peerCount - len(pending), Total: peerCount}
+}
 
-		// If we could not establish enough connections return an error
-		if peerCount-len(errs.BootstrapErrs) < ConnThreshold {
-			err = errs
+// connectBootstrapPeers connects to the given peers in parallel. It
+// returns the peers we couldn't connect to together with the errors.
+func (p *protocol) connectBootstrapPeers(peers []peer.AddrInfo) ([]peer.AddrInfo, []error) {
+	return connectPeers(p.ServiceContext(), p.Host, peers)
+}
+
+// connectPeers connects the given host to the given peers in parallel. It
+// returns the peers we couldn't connect to together with the errors.
+func connectPeers(ctx context.Context, h host.Host, peers []peer.AddrInfo) ([]peer.AddrInfo, []error) {
+	// Asynchronously connect to all bootstrap peers and send
+	// potential errors to a channel. This channel is used
+	// to capture the errors and check if we have established
+	// enough connections. An error group (errgroup) cannot
+	// be used here as it exits as soon as an error is thrown
+	// in one of the Go-Routines.
+	type result struct {
+		pi  peer.AddrInfo
+		err error
+	}
+
+	var wg sync.WaitGroup
+	resultChan := make(chan result, len(peers))
+	for _, bp := range peers {
+		wg.Add(1)
+		go func(pi peer.AddrInfo) {
+			defer wg.Done()
+			resultChan <- result{pi: pi, err: h.Connect(ctx, pi)}
+		}(bp)
+	}
+
+	// Close result channel after all connection attempts are done
+	// to signal the for-loop below to stop.
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	// Reading the result channel and collect the failed peers.
+	var failed []peer.AddrInfo
+	errs := []error{}
+	for r := range resultChan {
+		if r.err != nil {
+			failed = append(failed, r.pi)
+			errs = append(errs, r.err)
 		}
-	})
-	return
+	}
+
+	return failed, errs
+}
+
+// CheckBootstrap connects the given host once to the default bootstrap
+// peers without retrying. It returns how many of them were reachable out
+// of how many are configured together with the errors of the failed ones.
+// It doesn't bootstrap the DHT and is meant for diagnosing connectivity.
+func CheckBootstrap(ctx context.Context, h host.Host) (int, int, []error) {
+	peers := wrapDHT.GetDefaultBootstrapPeerAddrInfos()
+	failed, errs := connectPeers(ctx, h, peers)
+	return len(peers) - len(failed), len(peers), errs
+}
+
+// Mechanism returns the name of the discovery mechanism.
+func (p *protocol) Mechanism() string {
+	return "DHT"
+}
+
+// setStage reports the given stage to the stage handler if there is one.
+func (p *protocol) setStage(stage Stage) {
+	log.Debugln("DHT - Stage", stage)
+	if p.onStage != nil {
+		p.onStage(stage)
+	}
 }
 
 // TimeSlotStart returns the time when the current time slot started.f
 func (p *protocol) TimeSlotStart() time.Time {
+	if !p.timeSlot.IsZero() {
+		return p.timeSlot.Add(p.offset)
+	}
 	return p.refTime().Truncate(TruncateDuration)
 }
 
@@ -122,7 +247,21 @@ func (p *protocol) refTime() time.Time {
 // via mDNS and the DHT. See chanID above for more information.
 // Using UnixNano for testing.
 func (p *protocol) DiscoveryID(chanID int) string {
-	return fmt.Sprintf("/pcp/%d/%d", p.TimeSlotStart().UnixNano(), chanID)
+	if p.namespace == "" {
+		return fmt.Sprintf("/pcp/%d/%d", p.TimeSlotStart().UnixNano(), chanID)
+	}
+	return fmt.Sprintf("/pcp/%s/%d/%d", p.namespace, p.TimeSlotStart().UnixNano(), chanID)
+}
+
+// DescribeChannel returns the discovery ID and the content ID that is
+// provided in or looked up from the DHT for the given channel.
+func (p *protocol) DescribeChannel(chanID int) string {
+	did := p.DiscoveryID(chanID)
+	cID, err := strToCid(did)
+	if err != nil {
+		return did
+	}
+	return fmt.Sprintf("%s (content ID %s)", did, cID)
 }
 
 // strToCid hashes the given string (SHA256) and produces a CID from that hash.
@@ -32,6 +32,13 @@ func setup(t *testing.T) (*gomock.Controller, host.Host, mocknet.Mocknet, func(t
 	tmpTruncateDuration := TruncateDuration
 	tmpPubAddrInter := pubAddrInter
 	tmpProvideTimeout := provideTimeout
+	tmpAddrDebounce := addrDebounce
+	tmpRetryBackoffInitial := RetryBackoffInitial
+	tmpBootstrapBackoff := BootstrapBackoff
+
+	// Retry immediately to keep the tests fast.
+	RetryBackoffInitial = 0
+	BootstrapBackoff = 0
 
 	local, err := net.GenPeer()
 	require.NoError(t, err)
@@ -42,6 +49,9 @@ func setup(t *testing.T) (*gomock.Controller, host.Host, mocknet.Mocknet, func(t
 		TruncateDuration = tmpTruncateDuration
 		pubAddrInter = tmpPubAddrInter
 		provideTimeout = tmpProvideTimeout
+		addrDebounce = tmpAddrDebounce
+		RetryBackoffInitial = tmpRetryBackoffInitial
+		BootstrapBackoff = tmpBootstrapBackoff
 
 		wrapDHT = wrap.DHT{}
 		wrapmanet = wrap.Manet{}
@@ -213,3 +223,158 @@ func TestProtocol_DiscoveryIdentifier_returnsCorrect(t *testing.T) {
 	unixNow := now.Truncate(TruncateDuration).UnixNano()
 	assert.Equal(t, "/pcp/"+strconv.Itoa(int(unixNow))+"/333", id)
 }
+
+func TestProtocol_DiscoveryIdentifier_withNamespace(t *testing.T) {
+	ctrl, local, _, teardown := setup(t)
+	defer teardown(t)
+
+	now := time.Now()
+
+	m := mock.NewMockTimer(ctrl)
+	m.EXPECT().Now().Return(now)
+	wraptime = m
+
+	p := newProtocol(local, nil)
+	p.namespace = "acme"
+	id := p.DiscoveryID(333)
+
+	unixNow := now.Truncate(TruncateDuration).UnixNano()
+	assert.Equal(t, "/pcp/acme/"+strconv.Itoa(int(unixNow))+"/333", id)
+}
+
+func TestProtocol_DiscoveryIdentifier_pinnedTimeSlot(t *testing.T) {
+	_, local, _, teardown := setup(t)
+	defer teardown(t)
+
+	slot := time.Date(2021, 3, 4, 10, 5, 0, 0, time.UTC)
+
+	p := newProtocol(local, nil)
+	p.timeSlot = slot
+	assert.Equal(t, "/pcp/"+strconv.Itoa(int(slot.UnixNano()))+"/333", p.DiscoveryID(333))
+
+	p.offset = -TruncateDuration
+	assert.Equal(t, "/pcp/"+strconv.Itoa(int(slot.Add(-TruncateDuration).UnixNano()))+"/333", p.DiscoveryID(333))
+}
+
+func TestProtocol_DescribeChannel(t *testing.T) {
+	_, local, _, teardown := setup(t)
+	defer teardown(t)
+
+	p := newProtocol(local, nil)
+	p.timeSlot = time.Date(2021, 3, 4, 10, 5, 0, 0, time.UTC)
+
+	did := p.DiscoveryID(333)
+	cID, err := strToCid(did)
+	require.NoError(t, err)
+	assert.Equal(t, did+" (content ID "+cID.String()+")", p.DescribeChannel(333))
+}
+
+func TestProtocol_Bootstrap_reportsFinalCounts(t *testing.T) {
+	ctrl, local, net, teardown := setup(t)
+	defer teardown(t)
+
+	peers := genPeers(t, net, local, ConnThreshold)
+	mockGetDefaultBootstrapPeerAddrInfos(ctrl, peers)
+
+	err := net.UnlinkPeers(local.ID(), peers[0].ID)
+	require.NoError(t, err)
+
+	err = newProtocol(local, nil).Bootstrap()
+	require.Error(t, err)
+
+	errs, ok := err.(ErrConnThresholdNotReached)
+	require.True(t, ok)
+	assert.Equal(t, ConnThreshold-1, errs.Connected)
+	assert.Equal(t, ConnThreshold, errs.Total)
+
+	// Only the failed peer is retried in each round.
+	assert.Len(t, errs.BootstrapErrs, 1)
+}
+
+func TestProtocol_Bootstrap_retriesFailedPeers(t *testing.T) {
+	ctrl, local, net, teardown := setup(t)
+	defer teardown(t)
+
+	peers := genPeers(t, net, local, ConnThreshold)
+	mockGetDefaultBootstrapPeerAddrInfos(ctrl, peers)
+
+	err := net.UnlinkPeers(local.ID(), peers[0].ID)
+	require.NoError(t, err)
+
+	// The peer becomes reachable while we're waiting for the second round.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, err := net.LinkPeers(local.ID(), peers[0].ID)
+		assert.NoError(t, err)
+	}()
+
+	p := newProtocol(local, nil)
+	p.bootstrapBackoff = 200 * time.Millisecond
+	require.NoError(t, p.Bootstrap())
+	assert.Len(t, net.Net(local.ID()).Peers(), ConnThreshold)
+}
+
+func TestProtocol_Bootstrap_singleRound(t *testing.T) {
+	ctrl, local, net, teardown := setup(t)
+	defer teardown(t)
+
+	peers := genPeers(t, net, local, ConnThreshold)
+	mockGetDefaultBootstrapPeerAddrInfos(ctrl, peers)
+
+	err := net.UnlinkPeers(local.ID(), peers[0].ID)
+	require.NoError(t, err)
+
+	// Nothing must wait if there is no further round.
+	p := newProtocol(local, nil)
+	p.bootstrapRounds = 1
+	p.bootstrapBackoff = time.Hour
+	assert.Error(t, p.Bootstrap())
+}
+
+func TestProtocol_Bootstrap_shutdownAbortsRetries(t *testing.T) {
+	ctrl, local, net, teardown := setup(t)
+	defer teardown(t)
+
+	peers := genPeers(t, net, local, ConnThreshold)
+	mockGetDefaultBootstrapPeerAddrInfos(ctrl, peers)
+
+	err := net.UnlinkPeers(local.ID(), peers[0].ID)
+	require.NoError(t, err)
+
+	p := newProtocol(local, nil)
+	p.bootstrapBackoff = time.Hour
+	require.NoError(t, p.ServiceStarted())
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		p.Shutdown()
+	}()
+
+	start := time.Now()
+	err = p.Bootstrap()
+	p.ServiceStopped()
+
+	require.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+
+	errs, ok := err.(ErrConnThresholdNotReached)
+	require.True(t, ok)
+	assert.Contains(t, errs.BootstrapErrs, context.Canceled)
+}
+
+func TestCheckBootstrap_countsReachablePeers(t *testing.T) {
+	ctrl, local, net, teardown := setup(t)
+	defer teardown(t)
+
+	peers := genPeers(t, net, local, ConnThreshold)
+	mockGetDefaultBootstrapPeerAddrInfos(ctrl, peers)
+
+	err := net.UnlinkPeers(local.ID(), peers[0].ID)
+	require.NoError(t, err)
+
+	connected, total, errs := CheckBootstrap(context.Background(), local)
+	assert.Equal(t, ConnThreshold-1, connected)
+	assert.Equal(t, ConnThreshold, total)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), peers[0].ID.String())
+}
@@ -0,0 +1,98 @@
+package diag
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/pkg/config"
+)
+
+// Command contains the diag sub-command configuration.
+var Command = &cli.Command{
+	Name:   "diag",
+	Usage:  "collect a diagnostics bundle to attach to bug reports",
+	Action: Action,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:    "timeout",
+			Usage:   "upper bound for running all checks - AutoNAT needs about 20s to determine the reachability",
+			EnvVars: []string{"PCP_DIAG_TIMEOUT"},
+			Value:   30 * time.Second,
+		},
+		&cli.BoolFlag{
+			Name:    "json",
+			Usage:   "write the bundle as JSON instead of text",
+			EnvVars: []string{"PCP_DIAG_JSON"},
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "write the bundle to this file instead of stdout",
+			EnvVars: []string{"PCP_DIAG_OUTPUT"},
+		},
+		&cli.BoolFlag{
+			Name:    "no-redact",
+			Usage:   "keep the peer ID and public IP addresses of this machine in the bundle",
+			EnvVars: []string{"PCP_DIAG_NO_REDACT"},
+		},
+	},
+	Description: `The diag command collects everything that helps to debug connectivity
+problems into a single bundle: the pcp and Go versions, the operating
+system, the addresses of this machine, the reachability determined by
+AutoNAT, whether enough DHT bootstrap peers are reachable and whether
+multicast DNS works on each network interface.
+
+All checks run in parallel and the command finishes after --timeout at
+the latest. Checks that didn't finish are noted in the bundle.
+
+The peer ID and public IP addresses of this machine are masked unless
+--no-redact is given. Private addresses are kept as they are needed to
+debug the discovery in the local network.`,
+}
+
+// Action contains the logic for the diag subcommand of the pcp program.
+func Action(c *cli.Context) error {
+	c, err := config.FillContext(c, c.String("config"))
+	if err != nil {
+		return errors.Wrap(err, "failed loading configuration")
+	}
+
+	log.Infof("Collecting diagnostics for up to %s...\n", c.Duration("timeout"))
+	report, err := Collect(c.Context, Options{
+		ListenAddrs: c.StringSlice("listen"),
+		Timeout:     c.Duration("timeout"),
+		Redact:      !c.Bool("no-redact"),
+	})
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if c.String("output") != "" {
+		f, err := os.Create(c.String("output"))
+		if err != nil {
+			return errors.Wrap(err, "failed creating output file")
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if c.Bool("json") {
+		err = report.WriteJSON(w)
+	} else {
+		err = report.WriteText(w)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.String("output") != "" {
+		log.Infoln("Wrote diagnostics to", c.String("output"))
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package diag
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/network"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/pkg/dht"
+	"github.com/dennis-tra/pcp/pkg/mdns"
+	"github.com/dennis-tra/pcp/pkg/node"
+)
+
+// MulticastTimeout is how long the multicast DNS probe waits
+// for an answer on each network interface.
+var MulticastTimeout = time.Second
+
+// Options configures the collection of the diagnostics.
+type Options struct {
+	// ListenAddrs are the multi addresses the host listens
+	// on. The libp2p defaults are used if it's empty.
+	ListenAddrs []string
+
+	// Timeout bounds the time all checks may take together.
+	Timeout time.Duration
+
+	// Redact masks the peer ID and public addresses of this machine.
+	Redact bool
+}
+
+// Collect starts an ephemeral host and runs the checks in parallel until
+// all of them have finished or the timeout has passed. Checks that didn't
+// finish in time are noted in the report instead of failing it.
+func Collect(ctx context.Context, o Options) (*Report, error) {
+	if o.Timeout <= 0 {
+		return nil, fmt.Errorf("timeout must be positive")
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+
+	r := &Report{
+		Version:   node.BuildVersion,
+		Protocol:  node.ProtocolVersion,
+		Discovery: node.DiscoveryVersion,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Addrs:     []string{},
+		Multicast: []MulticastReport{},
+		Bootstrap: BootstrapReport{Threshold: dht.ConnThreshold},
+	}
+
+	// The multicast probe doesn't depend on the host.
+	type multicastResult struct {
+		checks []mdns.MulticastCheck
+		err    error
+	}
+	multicastChan := make(chan multicastResult, 1)
+	go func() {
+		checks, err := mdns.CheckMulticast(MulticastTimeout)
+		multicastChan <- multicastResult{checks: checks, err: err}
+	}()
+
+	opts := []libp2p.Option{libp2p.UserAgent(node.DefaultUserAgent())}
+	for _, addr := range o.ListenAddrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid listen address %q", addr)
+		}
+		opts = append(opts, libp2p.ListenAddrs(maddr))
+	}
+
+	h, err := libp2p.New(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start host")
+	}
+	defer h.Close()
+
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Close()
+
+	// AutoNAT needs connections to other peers to determine
+	// the reachability, which the bootstrap peers provide.
+	var errs []error
+	r.Bootstrap.Connected, r.Bootstrap.Total, errs = dht.CheckBootstrap(ctx, h)
+	for _, err := range errs {
+		r.Bootstrap.Errors = append(r.Bootstrap.Errors, err.Error())
+	}
+
+	reachability := network.ReachabilityUnknown
+	multicastDone := false
+wait:
+	for reachability == network.ReachabilityUnknown || !multicastDone {
+		select {
+		case evt := <-sub.Out():
+			reachability = evt.(event.EvtLocalReachabilityChanged).Reachability
+		case res := <-multicastChan:
+			multicastDone = true
+			if res.err != nil {
+				r.Notes = append(r.Notes, fmt.Sprintf("listing the network interfaces failed: %s", res.err))
+			}
+			for _, c := range res.checks {
+				m := MulticastReport{Interface: c.Interface, Usable: c.Usable, OK: c.Usable && c.Err == nil}
+				if c.Err != nil {
+					m.Error = c.Err.Error()
+				}
+				r.Multicast = append(r.Multicast, m)
+			}
+		case <-ctx.Done():
+			if reachability == network.ReachabilityUnknown {
+				r.Notes = append(r.Notes, "AutoNAT didn't determine the reachability within the timeout")
+			}
+			if !multicastDone {
+				r.Notes = append(r.Notes, "the multicast DNS probe didn't finish within the timeout")
+			}
+			break wait
+		}
+	}
+	r.Reachability = reachabilityString(reachability)
+
+	for _, addr := range h.Addrs() {
+		r.Addrs = append(r.Addrs, addr.String())
+	}
+
+	if o.Redact {
+		r.redact(h.ID())
+	}
+	r.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	return r, nil
+}
@@ -0,0 +1,184 @@
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Report is the diagnostics bundle that is attached to bug reports.
+type Report struct {
+	Version   string `json:"version"`
+	Protocol  string `json:"protocol"`
+	Discovery string `json:"discovery"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	Duration  string `json:"duration"`
+
+	// Reachability is the result of AutoNAT. It's unknown if
+	// it hasn't determined it within the timeout.
+	Reachability string `json:"reachability"`
+
+	// Addrs are the addresses the host listens on
+	// together with the ones other peers observed.
+	Addrs []string `json:"addrs"`
+
+	Bootstrap BootstrapReport   `json:"bootstrap"`
+	Multicast []MulticastReport `json:"multicast"`
+
+	// Notes explain checks that didn't finish.
+	Notes []string `json:"notes,omitempty"`
+
+	// Redacted is true if the peer ID and public
+	// addresses of this machine were masked.
+	Redacted bool `json:"redacted"`
+}
+
+// BootstrapReport holds how many of the default DHT bootstrap
+// peers were reachable and the errors of the others.
+type BootstrapReport struct {
+	Connected int      `json:"connected"`
+	Total     int      `json:"total"`
+	Threshold int      `json:"threshold"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// MulticastReport holds whether multicast DNS works on a network interface.
+// Skipped interfaces are down or don't support multicast.
+type MulticastReport struct {
+	Interface string `json:"interface"`
+	Usable    bool   `json:"usable"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+}
+
+// reachabilityString returns the reachability as written to the report.
+func reachabilityString(r network.Reachability) string {
+	switch r {
+	case network.ReachabilityPublic:
+		return "public"
+	case network.ReachabilityPrivate:
+		return "private"
+	default:
+		return "unknown"
+	}
+}
+
+// redact masks the given peer ID and the public IP addresses that appear
+// in the addresses of the report everywhere in it. Private addresses are
+// kept because they don't identify the machine outside its network but
+// are needed to debug local discovery. The addresses of the bootstrap
+// peers in the errors are public knowledge and are kept as well.
+func (r *Report) redact(self peer.ID) {
+	replacements := map[string]string{}
+	if self != "" {
+		replacements[self.String()] = "<peer-id>"
+	}
+
+	for _, addr := range r.Addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil || manet.IsPrivateAddr(maddr) {
+			continue
+		}
+		ip, err := manet.ToIP(maddr)
+		if err != nil {
+			continue
+		}
+		if ip.To4() != nil {
+			replacements[ip.String()] = "<public-ip4>"
+		} else {
+			replacements[ip.String()] = "<public-ip6>"
+		}
+	}
+
+	// Replace longer strings first so that an address
+	// isn't partially replaced by one of its prefixes.
+	olds := make([]string, 0, len(replacements))
+	for old := range replacements {
+		olds = append(olds, old)
+	}
+	sort.Slice(olds, func(i, j int) bool { return len(olds[i]) > len(olds[j]) })
+
+	pairs := make([]string, 0, 2*len(olds))
+	for _, old := range olds {
+		pairs = append(pairs, old, replacements[old])
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	for i, addr := range r.Addrs {
+		r.Addrs[i] = replacer.Replace(addr)
+	}
+	for i, err := range r.Bootstrap.Errors {
+		r.Bootstrap.Errors[i] = replacer.Replace(err)
+	}
+	for i, m := range r.Multicast {
+		r.Multicast[i].Error = replacer.Replace(m.Error)
+	}
+	r.Redacted = true
+}
+
+// WriteJSON writes the report as indented JSON to w.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText writes the report in a human readable form to w.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "pcp version:\t%s\n", r.Version)
+	fmt.Fprintf(tw, "protocol version:\t%s\n", r.Protocol)
+	fmt.Fprintf(tw, "discovery scheme version:\t%s\n", r.Discovery)
+	fmt.Fprintf(tw, "go version:\t%s\n", r.GoVersion)
+	fmt.Fprintf(tw, "platform:\t%s/%s\n", r.OS, r.Arch)
+	fmt.Fprintf(tw, "duration:\t%s\n", r.Duration)
+	fmt.Fprintf(tw, "redacted:\t%t\n", r.Redacted)
+	fmt.Fprintf(tw, "reachability:\t%s\n", r.Reachability)
+	fmt.Fprintf(tw, "bootstrap peers:\t%d of %d reachable (need %d)\n", r.Bootstrap.Connected, r.Bootstrap.Total, r.Bootstrap.Threshold)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, err := range r.Bootstrap.Errors {
+		fmt.Fprintf(w, "\t%s\n", err)
+	}
+
+	fmt.Fprintln(w, "\naddresses:")
+	for _, addr := range r.Addrs {
+		fmt.Fprintf(w, "\t%s\n", addr)
+	}
+
+	fmt.Fprintln(w, "\nmulticast DNS:")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, m := range r.Multicast {
+		switch {
+		case !m.Usable:
+			fmt.Fprintf(tw, "\t%s\tskipped (down or no multicast)\n", m.Interface)
+		case m.OK:
+			fmt.Fprintf(tw, "\t%s\tok\n", m.Interface)
+		default:
+			fmt.Fprintf(tw, "\t%s\tfailed: %s\n", m.Interface, m.Error)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(r.Notes) > 0 {
+		fmt.Fprintln(w, "\nnotes:")
+		for _, note := range r.Notes {
+			fmt.Fprintf(w, "\t%s\n", note)
+		}
+	}
+	return nil
+}
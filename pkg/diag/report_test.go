@@ -0,0 +1,101 @@
+package diag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPeerID = "QmSoLnSGccFuZQJzRadHn95W2CrSFmZuTdDWP8HXaHca9z"
+
+func testReport() *Report {
+	return &Report{
+		Version:      "v0.4.0+5f3759df",
+		Protocol:     "1",
+		Discovery:    "1",
+		GoVersion:    "go1.15",
+		OS:           "linux",
+		Arch:         "amd64",
+		Duration:     "21s",
+		Reachability: "private",
+		Addrs: []string{
+			"/ip4/127.0.0.1/tcp/4001",
+			"/ip4/192.168.1.10/tcp/4001",
+			"/ip4/203.0.113.7/tcp/4001",
+			"/ip4/203.0.113.70/tcp/4001",
+			"/ip6/2001:db8::1/tcp/4001",
+		},
+		Bootstrap: BootstrapReport{
+			Connected: 2,
+			Total:     3,
+			Threshold: 3,
+			Errors:    []string{"failed to dial QmBootstrap from " + testPeerID + " at 203.0.113.7: timeout"},
+		},
+		Multicast: []MulticastReport{
+			{Interface: "lo"},
+			{Interface: "wlan0", Usable: true, OK: true},
+			{Interface: "docker0", Usable: true, Error: "no answer within 1s"},
+		},
+	}
+}
+
+func TestReport_redact(t *testing.T) {
+	r := testReport()
+
+	self, err := peer.Decode(testPeerID)
+	require.NoError(t, err)
+	r.redact(self)
+
+	assert.True(t, r.Redacted)
+	assert.Equal(t, []string{
+		"/ip4/127.0.0.1/tcp/4001",
+		"/ip4/192.168.1.10/tcp/4001",
+		"/ip4/<public-ip4>/tcp/4001",
+		"/ip4/<public-ip4>/tcp/4001",
+		"/ip6/<public-ip6>/tcp/4001",
+	}, r.Addrs)
+	assert.Equal(t, []string{"failed to dial QmBootstrap from <peer-id> at <public-ip4>: timeout"}, r.Bootstrap.Errors)
+	assert.Equal(t, "no answer within 1s", r.Multicast[2].Error)
+}
+
+func TestReport_WriteText(t *testing.T) {
+	r := testReport()
+	r.Notes = []string{"AutoNAT didn't determine the reachability within the timeout"}
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteText(&buf))
+
+	out := buf.String()
+	assert.Contains(t, out, "platform:                  linux/amd64")
+	assert.Contains(t, out, "bootstrap peers:           2 of 3 reachable (need 3)")
+	assert.Contains(t, out, "\t/ip4/192.168.1.10/tcp/4001\n")
+	assert.Contains(t, out, "lo       skipped (down or no multicast)")
+	assert.Contains(t, out, "wlan0    ok")
+	assert.Contains(t, out, "docker0  failed: no answer within 1s")
+	assert.Contains(t, out, "notes:\n\tAutoNAT didn't determine the reachability within the timeout\n")
+}
+
+func TestReport_WriteJSON(t *testing.T) {
+	r := testReport()
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteJSON(&buf))
+
+	var decoded Report
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, *r, decoded)
+}
+
+func TestCollect_rejectsNonPositiveTimeout(t *testing.T) {
+	_, err := Collect(context.Background(), Options{Timeout: 0})
+	assert.EqualError(t, err, "timeout must be positive")
+
+	_, err = Collect(context.Background(), Options{Timeout: -time.Second})
+	assert.Error(t, err)
+}
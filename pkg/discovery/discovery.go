@@ -0,0 +1,84 @@
+// Package discovery defines the interfaces of the mechanisms that are
+// used to find a peer on a channel, e.g. mDNS and the DHT. New
+// mechanisms can be added by implementing them.
+package discovery
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Discoverer looks for peers that advertise the given channel.
+type Discoverer interface {
+	// Discover blocks until Shutdown is called or the discoverer gives
+	// up and calls the handler for each peer that was found.
+	Discover(chanID int, handler func(info peer.AddrInfo)) error
+
+	// Shutdown stops the discovery and waits until Discover has returned.
+	Shutdown()
+
+	// Mechanism returns a human readable name of the discovery mechanism.
+	Mechanism() string
+}
+
+// UnreachableCounter is optionally implemented by a Discoverer that keeps
+// track of the peers it found but dropped because they didn't advertise
+// any address we could dial. It tells "found nobody" apart from "found
+// a peer but couldn't reach it".
+type UnreachableCounter interface {
+	// Unreachable returns the number of distinct unreachable peers.
+	Unreachable() int
+}
+
+// Advertiser announces the given channel, so that peers can find us.
+type Advertiser interface {
+	// Advertise blocks until Shutdown is called or the advertiser gives up.
+	Advertise(chanID int) error
+
+	// Shutdown stops the advertisement and waits until Advertise has returned.
+	Shutdown()
+
+	// Mechanism returns a human readable name of the discovery mechanism.
+	Mechanism() string
+}
+
+// ChannelDescriber is optionally implemented by a Discoverer or Advertiser
+// to reveal the identifiers it derives from a channel. Two peers only find
+// each other if these are identical on both ends, so they help to debug
+// peers that can't see each other.
+type ChannelDescriber interface {
+	// DescribeChannel returns the identifiers derived from the given channel.
+	DescribeChannel(chanID int) string
+}
+
+// ErrRetriesExhausted is returned by a Discoverer that gave up
+// because it didn't find a peer in the maximum number of rounds.
+type ErrRetriesExhausted struct {
+	Mechanism string
+	Rounds    int
+}
+
+func (e ErrRetriesExhausted) Error() string {
+	return fmt.Sprintf("%s - no peer found in %d rounds, giving up", e.Mechanism, e.Rounds)
+}
+
+// Retries counts the rounds of a Discoverer in which it didn't find a
+// peer. The zero value never gives up.
+type Retries struct {
+	// Max is the number of unsuccessful rounds after which
+	// the discoverer gives up. Zero means it never does.
+	Max int
+
+	failed int
+}
+
+// Exhausted registers the outcome of a round and returns true if the
+// maximum number of unsuccessful rounds was reached.
+func (r *Retries) Exhausted(found bool) bool {
+	if found || r.Max <= 0 {
+		return false
+	}
+	r.failed++
+	return r.failed >= r.Max
+}
@@ -0,0 +1,20 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetries_Exhausted(t *testing.T) {
+	r := Retries{Max: 2}
+	assert.False(t, r.Exhausted(false))
+	assert.False(t, r.Exhausted(true))
+	assert.True(t, r.Exhausted(false))
+
+	// The zero value never gives up.
+	r = Retries{}
+	for i := 0; i < 10; i++ {
+		assert.False(t, r.Exhausted(false))
+	}
+}
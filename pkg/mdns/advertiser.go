@@ -2,6 +2,7 @@ package mdns
 
 import (
 	"context"
+	"io"
 
 	"github.com/dennis-tra/pcp/internal/log"
 
@@ -25,11 +26,12 @@ func (a *Advertiser) Advertise(chanID int) error {
 	}
 	defer a.ServiceStopped()
 
+	a.setStage(StageAdvertising)
 	for {
-		did := a.DiscoveryID(chanID)
+		did := a.ServiceName(chanID)
 		log.Debugln("mDNS - Advertising ", did)
 		ctx, cancel := context.WithTimeout(a.ServiceContext(), Timeout)
-		mdns, err := wrapdiscovery.NewMdnsService(ctx, a, a.interval, did)
+		mdns, err := a.newService(ctx, did)
 		if err != nil {
 			cancel()
 			return err
@@ -54,6 +56,21 @@ func (a *Advertiser) Advertise(chanID int) error {
 	}
 }
 
+// newService starts announcing the given service. If the advertiser is
+// restricted to interfaces, it only responds on them with their addresses.
+func (a *Advertiser) newService(ctx context.Context, service string) (io.Closer, error) {
+	if len(a.ifaces) == 0 {
+		return wrapdiscovery.NewMdnsService(ctx, a, a.interval, service)
+	}
+	return newInterfaceServers(a, service, a.ifaces)
+}
+
+// SetStageHandler registers a function that is called whenever the stage changes.
+func (a *Advertiser) SetStageHandler(handler func(Stage)) *Advertiser {
+	a.onStage = handler
+	return a
+}
+
 func (a *Advertiser) Shutdown() {
 	a.Service.Shutdown()
 }
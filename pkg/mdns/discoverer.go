@@ -2,6 +2,7 @@ package mdns
 
 import (
 	"net"
+	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p-core/host"
@@ -12,14 +13,38 @@ import (
 	"github.com/whyrusleeping/mdns"
 
 	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/pkg/discovery"
+	"github.com/dennis-tra/pcp/pkg/metrics"
 )
 
 type Discoverer struct {
 	*protocol
+
+	// keepLocalAddrs disables the preference of LAN addresses over
+	// loopback and link-local ones.
+	keepLocalAddrs bool
+
+	// keepPublicAddrs disables the filter that drops public addresses.
+	// Some LANs (e.g. campus networks) use public address ranges.
+	keepPublicAddrs bool
+
+	// zoneWarned holds the peers we have warned about that they
+	// only advertised IPv6 link-local addresses.
+	zoneWarned sync.Map
+
+	// unreachable holds the peers that were found without
+	// an address that passed the filters.
+	unreachable sync.Map
+
+	// seen suppresses repeated responses of the same peer.
+	seen *seenCache
+
+	// retries counts the queries without a result.
+	retries discovery.Retries
 }
 
 func NewDiscoverer(h host.Host) *Discoverer {
-	return &Discoverer{newProtocol(h)}
+	return &Discoverer{protocol: newProtocol(h), seen: newSeenCache(DefaultDedupTTL)}
 }
 
 func (d *Discoverer) Discover(chanID int, handler func(info peer.AddrInfo)) error {
@@ -28,39 +53,122 @@ func (d *Discoverer) Discover(chanID int, handler func(info peer.AddrInfo)) erro
 	}
 	defer d.ServiceStopped()
 
+	d.setStage(StageQuerying)
 	for {
 		entriesCh := make(chan *mdns.ServiceEntry, 16)
-		go d.drainEntriesChan(entriesCh, handler)
+		foundCh := make(chan bool, 1)
+		go func() { foundCh <- d.drainEntriesChan(entriesCh, handler) }()
 
-		did := d.DiscoveryID(chanID)
+		did := d.ServiceName(chanID)
 		log.Debugln("mDNS - Discovering", did)
-		qp := &mdns.QueryParam{
-			Domain:  "local",
-			Entries: entriesCh,
-			Service: did,
-			Timeout: time.Second * 5,
-		}
 
-		err := mdns.Query(qp)
+		start := time.Now()
+		d.query(did, entriesCh)
+		metrics.DiscoveryRoundDuration.WithLabelValues("mdns").Observe(time.Since(start).Seconds())
 		log.Debugln("mDNS - Discovering", did, " done.")
-		if err != nil {
-			log.Warningln("mDNS - query error", err)
-		}
 		close(entriesCh)
+		found := <-foundCh
 
 		select {
 		case <-d.SigShutdown():
 			return nil
 		default:
 		}
+
+		if d.retries.Exhausted(found) {
+			d.setStage(StageGaveUp)
+			return discovery.ErrRetriesExhausted{Mechanism: d.Mechanism(), Rounds: d.retries.Max}
+		}
+	}
+}
+
+// query sends a query for the given service and passes the responses to
+// the given channel. If the discoverer is restricted to interfaces,
+// the query is sent on each of them concurrently.
+func (d *Discoverer) query(service string, entries chan<- *mdns.ServiceEntry) {
+	if len(d.ifaces) == 0 {
+		d.queryInterface(service, entries, nil)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, iface := range d.ifaces {
+		wg.Add(1)
+		go func(iface net.Interface) {
+			defer wg.Done()
+			d.queryInterface(service, entries, &iface)
+		}(iface.Interface)
 	}
+	wg.Wait()
+}
+
+// queryInterface sends a query on the given interface or the
+// system default multicast interface if it's nil.
+func (d *Discoverer) queryInterface(service string, entries chan<- *mdns.ServiceEntry, iface *net.Interface) {
+	qp := &mdns.QueryParam{
+		Domain:    "local",
+		Entries:   entries,
+		Service:   service,
+		Timeout:   d.queryTimeout,
+		Interface: iface,
+	}
+
+	start := time.Now()
+	if err := mdns.Query(qp); err != nil {
+		log.Warningln("mDNS - query error", err)
+
+		// Don't flood the log if the query fails right away, e.g.
+		// because the interface doesn't support IPv6 multicast.
+		select {
+		case <-d.SigShutdown():
+		case <-time.After(d.queryTimeout - time.Since(start)):
+		}
+	}
+}
+
+// SetKeepLocalAddrs configures whether loopback and link-local
+// addresses are kept even if LAN addresses are available.
+func (d *Discoverer) SetKeepLocalAddrs(keep bool) *Discoverer {
+	d.keepLocalAddrs = keep
+	return d
+}
+
+// SetKeepPublicAddrs configures whether public addresses of
+// discovered peers are kept instead of being filtered out.
+func (d *Discoverer) SetKeepPublicAddrs(keep bool) *Discoverer {
+	d.keepPublicAddrs = keep
+	return d
+}
+
+// SetDedupTTL configures the time during which repeated responses of a
+// peer with unchanged addresses are dropped. Responses with changed
+// addresses are always passed on. Zero passes on every response.
+func (d *Discoverer) SetDedupTTL(ttl time.Duration) *Discoverer {
+	d.seen = newSeenCache(ttl)
+	return d
+}
+
+// SetMaxRetries makes Discover give up after the given number of queries
+// without a result. Zero retries forever.
+func (d *Discoverer) SetMaxRetries(max int) *Discoverer {
+	d.retries = discovery.Retries{Max: max}
+	return d
+}
+
+// SetStageHandler registers a function that is called whenever the stage changes.
+func (d *Discoverer) SetStageHandler(handler func(Stage)) *Discoverer {
+	d.onStage = handler
+	return d
 }
 
 func (d *Discoverer) Shutdown() {
 	d.Service.Shutdown()
 }
 
-func (d *Discoverer) drainEntriesChan(entries chan *mdns.ServiceEntry, handler func(info peer.AddrInfo)) {
+// drainEntriesChan passes the peers of the given entries to the handler
+// until the channel is closed. It returns true if it passed on any.
+func (d *Discoverer) drainEntriesChan(entries chan *mdns.ServiceEntry, handler func(info peer.AddrInfo)) bool {
+	found := false
 	for entry := range entries {
 
 		pi, err := parseServiceEntry(entry)
@@ -68,47 +176,127 @@ func (d *Discoverer) drainEntriesChan(entries chan *mdns.ServiceEntry, handler f
 			continue
 		}
 
-		log.Debugln("mDNS - Found peer", pi.ID)
-
 		if pi.ID == d.ID() {
 			continue
 		}
 
-		pi.Addrs = onlyPrivate(pi.Addrs)
+		// Drop the addresses of networks we're not supposed to use.
+		if len(d.ifaces) > 0 {
+			pi.Addrs = inInterfaces(pi.Addrs, d.ifaces)
+		}
+
+		if d.keepPublicAddrs {
+			if public := countPublic(pi.Addrs); public > 0 {
+				log.Infof("mDNS - Public address filter bypassed, kept %d public address(es) of %s\n", public, pi.ID)
+			}
+		} else {
+			pi.Addrs = onlyPrivate(pi.Addrs)
+		}
+		var dropped int
+		pi.Addrs, dropped = withoutLinkLocalIPv6(pi.Addrs)
+		if !d.keepLocalAddrs {
+			pi.Addrs = preferLAN(pi.Addrs)
+		}
 		if !isRoutable(pi) {
+			d.markUnreachable(pi.ID)
+			if dropped > 0 && d.warnZone(pi.ID) {
+				log.Warningln("mDNS - Found peer", pi.ID, "but it only advertised IPv6 link-local addresses, which can't be dialed. Connect both peers to a network with IPv4 or routable IPv6 addresses.")
+			}
+			continue
+		}
+
+		if !d.seen.fresh(pi, time.Now()) {
 			continue
 		}
+		log.Debugln("mDNS - Found peer", pi.ID)
 
+		metrics.PeersFound.WithLabelValues("mdns").Inc()
+		found = true
 		go handler(pi)
 	}
+	return found
 }
 
+// parseServiceEntry extracts the peer ID and all addresses from the
+// given mDNS entry. An entry carries at most one IPv4 and one IPv6
+// address.
 func parseServiceEntry(entry *mdns.ServiceEntry) (peer.AddrInfo, error) {
 	p, err := peer.Decode(entry.Info)
 	if err != nil {
 		return peer.AddrInfo{}, errors.Wrap(err, "error parsing peer ID from mdns entry")
 	}
 
-	var addr net.IP
-	if entry.AddrV4 != nil {
-		addr = entry.AddrV4
-	} else if entry.AddrV6 != nil {
-		addr = entry.AddrV6
-	} else {
-		return peer.AddrInfo{}, errors.Wrap(err, "error parsing multiaddr from mdns entry: no IP address found")
+	var maddrs []ma.Multiaddr
+	for _, ip := range []net.IP{entry.AddrV4, entry.AddrV6} {
+		if ip == nil {
+			continue
+		}
+
+		maddr, err := manet.FromNetAddr(&net.TCPAddr{IP: ip, Port: entry.Port})
+		if err != nil {
+			return peer.AddrInfo{}, errors.Wrap(err, "error parsing multiaddr from mdns entry")
+		}
+		maddrs = append(maddrs, maddr)
 	}
 
-	maddr, err := manet.FromNetAddr(&net.TCPAddr{IP: addr, Port: entry.Port})
-	if err != nil {
-		return peer.AddrInfo{}, errors.Wrap(err, "error parsing multiaddr from mdns entry")
+	if len(maddrs) == 0 {
+		return peer.AddrInfo{}, errors.New("error parsing multiaddr from mdns entry: no IP address found")
 	}
 
 	return peer.AddrInfo{
 		ID:    p,
-		Addrs: []ma.Multiaddr{maddr},
+		Addrs: maddrs,
 	}, nil
 }
 
+// Unreachable returns the number of distinct peers that were
+// found but didn't advertise an address that passed the filters.
+func (d *Discoverer) Unreachable() int {
+	count := 0
+	d.unreachable.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// markUnreachable records that the given peer was found without a usable
+// address. Peers answer every query, so each one is only counted once.
+func (d *Discoverer) markUnreachable(peerID peer.ID) {
+	if _, found := d.unreachable.LoadOrStore(peerID, struct{}{}); found {
+		return
+	}
+	log.Debugln("mDNS - Found peer", peerID, "but it has no usable addresses")
+	metrics.PeersUnreachable.WithLabelValues("mdns").Inc()
+}
+
+// warnZone returns true if we haven't warned about the given peer yet.
+func (d *Discoverer) warnZone(peerID peer.ID) bool {
+	_, warned := d.zoneWarned.LoadOrStore(peerID, struct{}{})
+	return !warned
+}
+
+// withoutLinkLocalIPv6 removes IPv6 link-local addresses and returns the
+// number of removed ones. Such addresses are only dialable together with
+// the zone (the network interface, e.g. fe80::1%eth0). mDNS entries don't
+// tell on which interface they were received, so the zone is unknown.
+// Even with a zone the TCP transport doesn't dial /ip6zone addresses.
+// IPv4 link-local addresses don't need a zone and are kept.
+func withoutLinkLocalIPv6(addrs []ma.Multiaddr) ([]ma.Multiaddr, int) {
+	var kept []ma.Multiaddr
+	dropped := 0
+	for _, addr := range addrs {
+		ip, err := manet.ToIP(addr)
+		if err == nil && ip.To4() == nil && ip.IsLinkLocalUnicast() {
+			log.Debugf("\tIPv6 link-local without zone - %s\n", addr.String())
+			dropped++
+			continue
+		}
+		kept = append(kept, addr)
+	}
+	return kept, dropped
+}
+
 func isRoutable(pi peer.AddrInfo) bool {
 	return len(pi.Addrs) > 0
 }
@@ -126,3 +314,44 @@ func onlyPrivate(addrs []ma.Multiaddr) []ma.Multiaddr {
 	}
 	return routable
 }
+
+// countPublic returns the number of public addresses.
+func countPublic(addrs []ma.Multiaddr) int {
+	count := 0
+	for _, addr := range addrs {
+		if manet.IsPublicAddr(addr) {
+			count++
+		}
+	}
+	return count
+}
+
+// preferLAN drops loopback and link-local addresses as dialing them
+// is a waste of time for a peer on another machine. They are only
+// kept if there are no other addresses. In that case link-local
+// addresses are preferred over loopback ones.
+func preferLAN(addrs []ma.Multiaddr) []ma.Multiaddr {
+	var lan, linkLocal, loopback []ma.Multiaddr
+	for _, addr := range addrs {
+		ip, err := manet.ToIP(addr)
+		switch {
+		case err != nil:
+			lan = append(lan, addr)
+		case ip.IsLoopback():
+			loopback = append(loopback, addr)
+			log.Debugf("\tloopback - %s\n", addr.String())
+		case ip.IsLinkLocalUnicast():
+			linkLocal = append(linkLocal, addr)
+			log.Debugf("\tlink-local - %s\n", addr.String())
+		default:
+			lan = append(lan, addr)
+		}
+	}
+
+	if len(lan) > 0 {
+		return lan
+	} else if len(linkLocal) > 0 {
+		return linkLocal
+	}
+	return loopback
+}
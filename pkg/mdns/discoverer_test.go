@@ -0,0 +1,127 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/whyrusleeping/mdns"
+)
+
+func toMaddrs(addrs ...string) []ma.Multiaddr {
+	maddrs := make([]ma.Multiaddr, len(addrs))
+	for i, addr := range addrs {
+		maddrs[i] = ma.StringCast(addr)
+	}
+	return maddrs
+}
+
+func Test_preferLAN(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrs    []ma.Multiaddr
+		expected []ma.Multiaddr
+	}{
+		{
+			name:     "mixed",
+			addrs:    toMaddrs("/ip4/127.0.0.1/tcp/4001", "/ip4/169.254.1.1/tcp/4001", "/ip4/192.168.0.2/tcp/4001", "/ip6/fe80::1/tcp/4001", "/ip6/::1/tcp/4001", "/ip6/fd00::2/tcp/4001"),
+			expected: toMaddrs("/ip4/192.168.0.2/tcp/4001", "/ip6/fd00::2/tcp/4001"),
+		},
+		{
+			name:     "link-local before loopback",
+			addrs:    toMaddrs("/ip4/127.0.0.1/tcp/4001", "/ip4/169.254.1.1/tcp/4001", "/ip6/fe80::1/tcp/4001"),
+			expected: toMaddrs("/ip4/169.254.1.1/tcp/4001", "/ip6/fe80::1/tcp/4001"),
+		},
+		{
+			name:     "only loopback",
+			addrs:    toMaddrs("/ip4/127.0.0.1/tcp/4001", "/ip6/::1/tcp/4001"),
+			expected: toMaddrs("/ip4/127.0.0.1/tcp/4001", "/ip6/::1/tcp/4001"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, preferLAN(tt.addrs))
+		})
+	}
+}
+
+func Test_onlyPrivate_keepsLocalAddrs(t *testing.T) {
+	addrs := toMaddrs("/ip4/127.0.0.1/tcp/4001", "/ip4/169.254.1.1/tcp/4001", "/ip4/192.168.0.2/tcp/4001", "/ip4/1.2.3.4/tcp/4001")
+	assert.Equal(t, addrs[:3], onlyPrivate(addrs))
+}
+
+func Test_countPublic(t *testing.T) {
+	addrs := toMaddrs("/ip4/127.0.0.1/tcp/4001", "/ip4/192.168.0.2/tcp/4001", "/ip4/1.2.3.4/tcp/4001", "/ip4/130.83.1.1/tcp/4001")
+	assert.Equal(t, 2, countPublic(addrs))
+	assert.Equal(t, 0, countPublic(nil))
+}
+
+func Test_withoutLinkLocalIPv6(t *testing.T) {
+	addrs := toMaddrs("/ip4/169.254.1.1/tcp/4001", "/ip6/fe80::1/tcp/4001", "/ip6/fd00::2/tcp/4001", "/ip4/192.168.0.2/tcp/4001")
+	kept, dropped := withoutLinkLocalIPv6(addrs)
+	assert.Equal(t, toMaddrs("/ip4/169.254.1.1/tcp/4001", "/ip6/fd00::2/tcp/4001", "/ip4/192.168.0.2/tcp/4001"), kept)
+	assert.Equal(t, 1, dropped)
+
+	kept, dropped = withoutLinkLocalIPv6(toMaddrs("/ip6/fe80::1/tcp/4001"))
+	assert.Empty(t, kept)
+	assert.Equal(t, 1, dropped)
+}
+
+func Test_parseServiceEntry(t *testing.T) {
+	pid, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	entry := &mdns.ServiceEntry{
+		Info:   pid.Pretty(),
+		AddrV4: net.ParseIP("192.168.0.2"),
+		AddrV6: net.ParseIP("fd00::2"),
+		Port:   4001,
+	}
+
+	pi, err := parseServiceEntry(entry)
+	require.NoError(t, err)
+	assert.Equal(t, pid, pi.ID)
+	assert.Equal(t, toMaddrs("/ip4/192.168.0.2/tcp/4001", "/ip6/fd00::2/tcp/4001"), pi.Addrs)
+
+	// IPv6-only peers are discovered as well.
+	entry.AddrV4 = nil
+	pi, err = parseServiceEntry(entry)
+	require.NoError(t, err)
+	assert.Equal(t, toMaddrs("/ip6/fd00::2/tcp/4001"), pi.Addrs)
+
+	entry.AddrV6 = nil
+	_, err = parseServiceEntry(entry)
+	assert.Error(t, err)
+}
+
+func TestDiscoverer_warnZone(t *testing.T) {
+	d := &Discoverer{}
+	assert.True(t, d.warnZone("peer"))
+	assert.False(t, d.warnZone("peer"))
+	assert.True(t, d.warnZone("other"))
+}
+
+func TestDiscoverer_drainEntriesChan_unreachable(t *testing.T) {
+	_, local, teardown := setup(t)
+	defer teardown(t)
+
+	pid, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	// The public address is filtered, so no address is left.
+	entries := make(chan *mdns.ServiceEntry, 2)
+	for i := 0; i < 2; i++ {
+		entries <- &mdns.ServiceEntry{Info: pid.Pretty(), AddrV4: net.ParseIP("8.8.8.8"), Port: 4001}
+	}
+	close(entries)
+
+	d := NewDiscoverer(local)
+	d.drainEntriesChan(entries, func(pi peer.AddrInfo) {
+		t.Error("handler called for unreachable peer")
+	})
+	assert.Equal(t, 1, d.Unreachable())
+}
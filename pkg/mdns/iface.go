@@ -0,0 +1,217 @@
+package mdns
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/whyrusleeping/mdns"
+)
+
+// These wrapped functions of the net package are here for testing purposes.
+var (
+	netInterfaces  = net.Interfaces
+	interfaceAddrs = func(iface net.Interface) ([]net.Addr, error) { return iface.Addrs() }
+)
+
+// Interface is a network interface that mDNS can be restricted to.
+type Interface struct {
+	net.Interface
+
+	// Nets are the networks the interface is connected to.
+	Nets []*net.IPNet
+}
+
+// usable returns true if the interface is up and supports multicast.
+func (i Interface) usable() bool {
+	return i.Flags&net.FlagUp != 0 && i.Flags&net.FlagMulticast != 0
+}
+
+// contains returns true if the given IP address belongs
+// to one of the networks of the interface.
+func (i Interface) contains(ip net.IP) bool {
+	for _, n := range i.Nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// interfaces returns all network interfaces of this machine.
+func interfaces() ([]Interface, error) {
+	nifaces, err := netInterfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	ifaces := make([]Interface, 0, len(nifaces))
+	for _, niface := range nifaces {
+		addrs, err := interfaceAddrs(niface)
+		if err != nil {
+			return nil, err
+		}
+
+		iface := Interface{Interface: niface}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				iface.Nets = append(iface.Nets, ipNet)
+			}
+		}
+		ifaces = append(ifaces, iface)
+	}
+
+	return ifaces, nil
+}
+
+// LookupInterfaces returns the network interfaces with the given names.
+// It returns an error if one of them doesn't exist, is down or doesn't
+// support multicast.
+func LookupInterfaces(names []string) ([]Interface, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	all, err := interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]Interface{}
+	for _, iface := range all {
+		byName[iface.Name] = iface
+	}
+
+	var ifaces []Interface
+	for _, name := range names {
+		iface, found := byName[name]
+		if !found {
+			return nil, fmt.Errorf("network interface %s does not exist, see --list-interfaces", name)
+		} else if !iface.usable() {
+			return nil, fmt.Errorf("network interface %s is down or doesn't support multicast", name)
+		}
+		ifaces = append(ifaces, iface)
+	}
+
+	return ifaces, nil
+}
+
+// PrintInterfaces writes the network interfaces of this machine together
+// with their addresses to w and whether mDNS can use them.
+func PrintInterfaces(w io.Writer) error {
+	ifaces, err := interfaces()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INTERFACE\tMDNS\tADDRESSES")
+	for _, iface := range ifaces {
+		usable := "no"
+		if iface.usable() {
+			usable = "yes"
+		}
+
+		var addrs []string
+		for _, n := range iface.Nets {
+			addrs = append(addrs, n.String())
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", iface.Name, usable, strings.Join(addrs, ", "))
+	}
+	return tw.Flush()
+}
+
+// inInterfaces keeps the addresses that belong to the networks of the
+// given interfaces. Addresses without an IP, e.g. DNS ones, are dropped.
+func inInterfaces(addrs []ma.Multiaddr, ifaces []Interface) []ma.Multiaddr {
+	kept := []ma.Multiaddr{}
+	for _, addr := range addrs {
+		ip, err := manet.ToIP(addr)
+		if err != nil {
+			continue
+		}
+
+		for _, iface := range ifaces {
+			if iface.contains(ip) {
+				kept = append(kept, addr)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// interfaceServers answers mDNS queries on a fixed set of interfaces.
+// Unlike the libp2p mDNS service it doesn't query for other peers.
+type interfaceServers []*mdns.Server
+
+// newInterfaceServers starts a responder for the given service on each of
+// the given interfaces. It only announces the listen addresses of the
+// host that belong to the network of the respective interface.
+func newInterfaceServers(h host.Host, service string, ifaces []Interface) (interfaceServers, error) {
+	laddrs, err := h.Network().InterfaceListenAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	myid := h.ID().Pretty()
+
+	var servers interfaceServers
+	for _, iface := range ifaces {
+		port := 0
+		var ips []net.IP
+		for _, laddr := range laddrs {
+			na, err := manet.ToNetAddr(laddr)
+			if err != nil {
+				continue
+			}
+
+			tcp, ok := na.(*net.TCPAddr)
+			if !ok || !iface.contains(tcp.IP) {
+				continue
+			}
+
+			if port == 0 {
+				port = tcp.Port
+			}
+			ips = append(ips, tcp.IP)
+		}
+
+		if len(ips) == 0 {
+			_ = servers.Close()
+			return nil, fmt.Errorf("not listening on any address of network interface %s", iface.Name)
+		}
+
+		zone, err := mdns.NewMDNSService(myid, service, "", "", port, ips, []string{myid})
+		if err != nil {
+			_ = servers.Close()
+			return nil, err
+		}
+
+		iface := iface
+		server, err := mdns.NewServer(&mdns.Config{Zone: zone, Iface: &iface.Interface})
+		if err != nil {
+			_ = servers.Close()
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+
+	return servers, nil
+}
+
+// Close stops all responders.
+func (s interfaceServers) Close() error {
+	var err error
+	for _, server := range s {
+		if serr := server.Shutdown(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
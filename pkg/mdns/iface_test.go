@@ -0,0 +1,92 @@
+package mdns
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mockInterfaces(t *testing.T) {
+	tmpNetInterfaces, tmpInterfaceAddrs := netInterfaces, interfaceAddrs
+	t.Cleanup(func() {
+		netInterfaces, interfaceAddrs = tmpNetInterfaces, tmpInterfaceAddrs
+	})
+
+	netInterfaces = func() ([]net.Interface, error) {
+		return []net.Interface{
+			{Index: 1, Name: "lo", Flags: net.FlagUp | net.FlagLoopback},
+			{Index: 2, Name: "wlan0", Flags: net.FlagUp | net.FlagMulticast},
+			{Index: 3, Name: "docker0", Flags: net.FlagMulticast},
+		}, nil
+	}
+
+	interfaceAddrs = func(iface net.Interface) ([]net.Addr, error) {
+		cidrs := map[string]string{"lo": "127.0.0.1/8", "wlan0": "192.168.1.10/24", "docker0": "172.17.0.1/16"}
+		_, ipNet, err := net.ParseCIDR(cidrs[iface.Name])
+		require.NoError(t, err)
+		return []net.Addr{ipNet}, nil
+	}
+}
+
+func TestLookupInterfaces(t *testing.T) {
+	mockInterfaces(t)
+
+	ifaces, err := LookupInterfaces(nil)
+	require.NoError(t, err)
+	assert.Nil(t, ifaces)
+
+	ifaces, err = LookupInterfaces([]string{"wlan0"})
+	require.NoError(t, err)
+	require.Len(t, ifaces, 1)
+	assert.Equal(t, "wlan0", ifaces[0].Name)
+	assert.True(t, ifaces[0].contains(net.ParseIP("192.168.1.20")))
+	assert.False(t, ifaces[0].contains(net.ParseIP("172.17.0.2")))
+
+	_, err = LookupInterfaces([]string{"wlan0", "eth7"})
+	assert.EqualError(t, err, "network interface eth7 does not exist, see --list-interfaces")
+
+	_, err = LookupInterfaces([]string{"docker0"})
+	assert.Error(t, err)
+
+	_, err = LookupInterfaces([]string{"lo"})
+	assert.Error(t, err)
+}
+
+func TestPrintInterfaces(t *testing.T) {
+	mockInterfaces(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, PrintInterfaces(&buf))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 4)
+	assert.Equal(t, []string{"wlan0", "yes", "192.168.1.0/24"}, fields(lines[2]))
+	assert.Equal(t, []string{"docker0", "no", "172.17.0.0/16"}, fields(lines[3]))
+}
+
+func fields(line []byte) []string {
+	var f []string
+	for _, field := range bytes.Fields(line) {
+		f = append(f, string(field))
+	}
+	return f
+}
+
+func Test_inInterfaces(t *testing.T) {
+	mockInterfaces(t)
+
+	ifaces, err := LookupInterfaces([]string{"wlan0"})
+	require.NoError(t, err)
+
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/ip4/192.168.1.20/tcp/4001"),
+		ma.StringCast("/ip4/172.17.0.2/tcp/4001"),
+		ma.StringCast("/ip4/10.8.0.2/tcp/4001"),
+		ma.StringCast("/dns4/example.com/tcp/4001"),
+	}
+	assert.Equal(t, addrs[:1], inInterfaces(addrs, ifaces))
+}
@@ -0,0 +1,100 @@
+package mdns
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/whyrusleeping/mdns"
+)
+
+// probeService is the mDNS service that is announced and queried to
+// check whether multicast works. It doesn't collide with pcp peers.
+const probeService = "_pcp-probe._udp"
+
+// MulticastCheck is the result of probing multicast DNS on a network interface.
+type MulticastCheck struct {
+	Interface string
+
+	// Usable is false if the interface is down or doesn't support
+	// multicast. The interface isn't probed then.
+	Usable bool
+
+	// Err is nil if the probe could be answered over the interface.
+	Err error
+}
+
+// probeInterface is here for testing purposes.
+var probeInterface = probe
+
+// CheckMulticast probes each usable network interface of this machine by
+// answering mDNS queries for a random instance and looking it up over the
+// same interface. The interfaces are probed one after another so that the
+// answer can't arrive over another one, each for at most the given timeout.
+func CheckMulticast(timeout time.Duration) ([]MulticastCheck, error) {
+	ifaces, err := interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	checks := make([]MulticastCheck, len(ifaces))
+	for i, iface := range ifaces {
+		checks[i] = MulticastCheck{Interface: iface.Name, Usable: iface.usable()}
+		if checks[i].Usable {
+			checks[i].Err = probeInterface(iface, timeout)
+		}
+	}
+	return checks, nil
+}
+
+// probe announces a random instance of the probe service on the given
+// interface and returns nil if a query over the interface finds it.
+func probe(iface Interface, timeout time.Duration) error {
+	var ips []net.IP
+	for _, n := range iface.Nets {
+		ips = append(ips, n.IP)
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("no IP address")
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	instance := hex.EncodeToString(buf)
+
+	zone, err := mdns.NewMDNSService(instance, probeService, "", "", 1, ips, []string{instance})
+	if err != nil {
+		return err
+	}
+
+	server, err := mdns.NewServer(&mdns.Config{Zone: zone, Iface: &iface.Interface})
+	if err != nil {
+		return err
+	}
+	defer server.Shutdown()
+
+	entries := make(chan *mdns.ServiceEntry, 16)
+	err = mdns.Query(&mdns.QueryParam{
+		Service:   probeService,
+		Domain:    "local",
+		Timeout:   timeout,
+		Interface: &iface.Interface,
+		Entries:   entries,
+	})
+	close(entries)
+	if err != nil {
+		return err
+	}
+
+	for entry := range entries {
+		if strings.HasPrefix(entry.Name, instance+".") {
+			return nil
+		}
+	}
+	return fmt.Errorf("no answer within %s", timeout)
+}
@@ -0,0 +1,39 @@
+package mdns
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckMulticast_probesUsableInterfaces(t *testing.T) {
+	mockInterfaces(t)
+
+	tmpProbeInterface := probeInterface
+	t.Cleanup(func() { probeInterface = tmpProbeInterface })
+
+	var probed []string
+	probeInterface = func(iface Interface, timeout time.Duration) error {
+		probed = append(probed, iface.Name)
+		assert.Equal(t, time.Second, timeout)
+		return fmt.Errorf("no answer")
+	}
+
+	checks, err := CheckMulticast(time.Second)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"wlan0"}, probed)
+	assert.Equal(t, []MulticastCheck{
+		{Interface: "lo"},
+		{Interface: "wlan0", Usable: true, Err: fmt.Errorf("no answer")},
+		{Interface: "docker0"},
+	}, checks)
+}
+
+func TestProbe_noIPAddress(t *testing.T) {
+	err := probe(Interface{}, time.Second)
+	assert.EqualError(t, err, "no IP address")
+}
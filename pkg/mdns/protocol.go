@@ -2,14 +2,27 @@ package mdns
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/libp2p/go-libp2p-core/host"
 
 	"github.com/dennis-tra/pcp/internal/wrap"
+	"github.com/dennis-tra/pcp/pkg/discovery"
 	"github.com/dennis-tra/pcp/pkg/service"
 )
 
+// Both the discoverer and advertiser can be used generically.
+var (
+	_ discovery.Discoverer = (*Discoverer)(nil)
+	_ discovery.Advertiser = (*Advertiser)(nil)
+
+	_ discovery.ChannelDescriber = (*Discoverer)(nil)
+	_ discovery.ChannelDescriber = (*Advertiser)(nil)
+)
+
 // These wrapped top level functions are here for testing purposes.
 var (
 	wraptime      wrap.Timer      = wrap.Time{}
@@ -28,6 +41,23 @@ var (
 	// TruncateDuration represents the time slot to which
 	// the current time is truncated.
 	TruncateDuration = 5 * time.Minute
+
+	// QueryTimeout is the time a query waits for responses
+	// before the discoverer sends the next one.
+	QueryTimeout = 5 * time.Second
+
+	// MinInterval is the smallest interval that can be configured.
+	// Shorter intervals would flood the network with multicast traffic.
+	MinInterval = 100 * time.Millisecond
+)
+
+// Stage describes what the discoverer or advertiser is currently doing.
+type Stage string
+
+const (
+	StageQuerying    Stage = "querying"
+	StageAdvertising Stage = "advertising"
+	StageGaveUp      Stage = "gave up"
 )
 
 // protocol encapsulates the logic for discovering peers
@@ -35,21 +65,61 @@ var (
 type protocol struct {
 	host.Host
 	*service.Service
-	interval time.Duration
+
+	// interval is the frequency with which the advertiser announces
+	// the service and queryTimeout the frequency with which the
+	// discoverer queries for it.
+	interval     time.Duration
+	queryTimeout time.Duration
 
 	offset time.Duration
+
+	// timeSlot pins the time slot instead of deriving it from the
+	// current time. The discovery identifier doesn't rotate then.
+	timeSlot time.Time
+
+	// namespace isolates pcp deployments from each other by
+	// being mixed into the discovery identifier.
+	namespace string
+
+	// serviceTag replaces the pcp prefix of the DNS-SD service
+	// string. The derived default is used if it's empty.
+	serviceTag string
+
+	// ifaces restricts mDNS to these network interfaces.
+	// All interfaces are used if it's empty.
+	ifaces []Interface
+
+	// onStage is called whenever the stage changes.
+	onStage func(Stage)
 }
 
 func newProtocol(h host.Host) *protocol {
 	return &protocol{
-		Host:     h,
-		interval: Interval,
-		Service:  service.New("mDNS"),
+		Host:         h,
+		interval:     Interval,
+		queryTimeout: QueryTimeout,
+		Service:      service.New("mDNS"),
+	}
+}
+
+// Mechanism returns the name of the discovery mechanism.
+func (p *protocol) Mechanism() string {
+	return "mDNS"
+}
+
+// setStage reports the given stage to the stage handler if there is one.
+func (p *protocol) setStage(stage Stage) {
+	if p.onStage != nil {
+		p.onStage(stage)
 	}
 }
 
 // TimeSlotStart returns the time when the current time slot started.f
 func (p *protocol) TimeSlotStart() time.Time {
+	if !p.timeSlot.IsZero() {
+		return p.timeSlot.Add(p.offset)
+	}
 	return p.refTime().Truncate(TruncateDuration)
 }
 
@@ -63,9 +133,128 @@ func (d *Discoverer) SetOffset(offset time.Duration) *Discoverer {
 	return d
 }
 
+func (d *Discoverer) SetNamespace(namespace string) *Discoverer {
+	d.namespace = namespace
+	return d
+}
+
+func (a *Advertiser) SetNamespace(namespace string) *Advertiser {
+	a.namespace = namespace
+	return a
+}
+
+// SetTimeSlot pins the time slot the discovery identifier is derived
+// from. The zero time derives it from the current time again.
+func (d *Discoverer) SetTimeSlot(slot time.Time) *Discoverer {
+	d.timeSlot = slot
+	return d
+}
+
+// SetTimeSlot pins the time slot the discovery identifier is derived
+// from. The zero time derives it from the current time again.
+func (a *Advertiser) SetTimeSlot(slot time.Time) *Advertiser {
+	a.timeSlot = slot
+	return a
+}
+
+// SetInterval configures how often the discoverer queries for peers. Shorter
+// intervals find peers faster at the cost of more multicast traffic.
+// Zero keeps the default.
+func (d *Discoverer) SetInterval(interval time.Duration) *Discoverer {
+	if interval > 0 {
+		d.queryTimeout = interval
+	}
+	return d
+}
+
+// SetInterval configures how often the advertiser announces the service.
+// Zero keeps the default.
+func (a *Advertiser) SetInterval(interval time.Duration) *Advertiser {
+	if interval > 0 {
+		a.interval = interval
+	}
+	return a
+}
+
+// ValidateInterval checks that the given query and announce interval
+// is not too short. Zero is valid and selects the defaults.
+func ValidateInterval(interval time.Duration) error {
+	if interval != 0 && interval < MinInterval {
+		return fmt.Errorf("mDNS interval must not be shorter than %s", MinInterval)
+	}
+	return nil
+}
+
+func (d *Discoverer) SetServiceTag(tag string) *Discoverer {
+	d.serviceTag = tag
+	return d
+}
+
+func (a *Advertiser) SetServiceTag(tag string) *Advertiser {
+	a.serviceTag = tag
+	return a
+}
+
+// SetInterfaces restricts the queries and the addresses of the
+// found peers to the given interfaces. Nil uses all of them.
+func (d *Discoverer) SetInterfaces(ifaces []Interface) *Discoverer {
+	d.ifaces = ifaces
+	return d
+}
+
+// SetInterfaces restricts the announcements and the advertised
+// addresses to the given interfaces. Nil uses all of them.
+func (a *Advertiser) SetInterfaces(ifaces []Interface) *Advertiser {
+	a.ifaces = ifaces
+	return a
+}
+
+// serviceTagRegex matches DNS-SD service names according to RFC 6335
+// section 5.1: letters, digits and non-consecutive hyphens that neither
+// begin nor end the name. The length is checked separately.
+var serviceTagRegex = regexp.MustCompile(`^[a-zA-Z0-9]+(-[a-zA-Z0-9]+)*$`)
+
+// ValidateServiceTag checks whether the given tag is a legal
+// DNS-SD service name. An empty tag is valid and means that
+// the derived default is used.
+func ValidateServiceTag(tag string) error {
+	if tag == "" {
+		return nil
+	}
+
+	if len(tag) > 15 {
+		return fmt.Errorf("mDNS service tag must not be longer than 15 characters")
+	}
+
+	if !serviceTagRegex.MatchString(tag) || strings.IndexFunc(tag, unicode.IsLetter) == -1 {
+		return fmt.Errorf("mDNS service tag must contain a letter and only letters, digits and non-consecutive inner dashes")
+	}
+
+	return nil
+}
+
+// ServiceName returns the DNS-SD service string that is used to advertise
+// and discover peers. It equals the DiscoveryID unless a service tag is set,
+// which then replaces the pcp prefix.
+func (p *protocol) ServiceName(chanID int) string {
+	did := p.DiscoveryID(chanID)
+	if p.serviceTag == "" {
+		return did
+	}
+	return "/" + p.serviceTag + strings.TrimPrefix(did, "/pcp")
+}
+
+// DescribeChannel returns the DNS-SD service string for the given channel.
+func (p *protocol) DescribeChannel(chanID int) string {
+	return p.ServiceName(chanID)
+}
+
 // DiscoveryID returns the string, that we use to advertise
 // via mDNS and the DHT. See chanID above for more information.
 // Using UnixNano for testing.
 func (p *protocol) DiscoveryID(chanID int) string {
-	return fmt.Sprintf("/pcp/%d/%d", p.TimeSlotStart().UnixNano(), chanID)
+	if p.namespace == "" {
+		return fmt.Sprintf("/pcp/%d/%d", p.TimeSlotStart().UnixNano(), chanID)
+	}
+	return fmt.Sprintf("/pcp/%s/%d/%d", p.namespace, p.TimeSlotStart().UnixNano(), chanID)
 }
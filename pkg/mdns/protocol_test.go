@@ -0,0 +1,77 @@
+package mdns
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dennis-tra/pcp/internal/mock"
+)
+
+func TestProtocol_ServiceName(t *testing.T) {
+	ctrl, local, teardown := setup(t)
+	defer teardown(t)
+
+	now := time.Now()
+
+	m := mock.NewMockTimer(ctrl)
+	m.EXPECT().Now().Return(now).Times(2)
+	wraptime = m
+
+	p := newProtocol(local)
+	unixNow := strconv.Itoa(int(now.Truncate(TruncateDuration).UnixNano()))
+	assert.Equal(t, "/pcp/"+unixNow+"/333", p.ServiceName(333))
+
+	p.serviceTag = "myapp"
+	assert.Equal(t, "/myapp/"+unixNow+"/333", p.ServiceName(333))
+}
+
+func TestValidateServiceTag(t *testing.T) {
+	tests := []struct {
+		tag   string
+		valid bool
+	}{
+		{"", true},
+		{"pcp", true},
+		{"my-app2", true},
+		{"123", false},
+		{"-app", false},
+		{"app-", false},
+		{"my--app", false},
+		{"my_app", false},
+		{"averyveryverylongname", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			err := ValidateServiceTag(tt.tag)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateInterval(t *testing.T) {
+	assert.NoError(t, ValidateInterval(0))
+	assert.NoError(t, ValidateInterval(MinInterval))
+	assert.NoError(t, ValidateInterval(10*time.Second))
+	assert.Error(t, ValidateInterval(MinInterval-1))
+	assert.Error(t, ValidateInterval(-time.Second))
+}
+
+func TestSetInterval(t *testing.T) {
+	_, local, teardown := setup(t)
+	defer teardown(t)
+
+	d := NewDiscoverer(local)
+	assert.Equal(t, QueryTimeout, d.SetInterval(0).queryTimeout)
+	assert.Equal(t, 2*time.Second, d.SetInterval(2*time.Second).queryTimeout)
+
+	a := NewAdvertiser(local)
+	assert.Equal(t, Interval, a.SetInterval(0).interval)
+	assert.Equal(t, 2*time.Second, a.SetInterval(2*time.Second).interval)
+}
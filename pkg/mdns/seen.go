@@ -0,0 +1,70 @@
+package mdns
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// DefaultDedupTTL is the time during which repeated mDNS responses of
+// a peer with unchanged addresses are not passed on again.
+const DefaultDedupTTL = 30 * time.Second
+
+// seenCache remembers which peers were recently passed on to the
+// handler with which addresses. A chatty peer answers every query,
+// which would otherwise lead to the same peer being handled over and
+// over again.
+type seenCache struct {
+	lk    sync.Mutex
+	ttl   time.Duration
+	peers map[peer.ID]seenPeer
+}
+
+type seenPeer struct {
+	addrs string
+	at    time.Time
+}
+
+func newSeenCache(ttl time.Duration) *seenCache {
+	return &seenCache{ttl: ttl, peers: map[peer.ID]seenPeer{}}
+}
+
+// fresh returns true if the peer should be passed on, i.e. if it wasn't
+// seen within the TTL or its addresses have changed since. It records
+// the peer as seen at the given time. A TTL of zero passes on every peer.
+func (c *seenCache) fresh(pi peer.AddrInfo, now time.Time) bool {
+	if c.ttl <= 0 {
+		return true
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	for id, s := range c.peers {
+		if now.Sub(s.at) >= c.ttl {
+			delete(c.peers, id)
+		}
+	}
+
+	addrs := addrsKey(pi)
+	if s, found := c.peers[pi.ID]; found && s.addrs == addrs {
+		return false
+	}
+	c.peers[pi.ID] = seenPeer{addrs: addrs, at: now}
+
+	return true
+}
+
+// addrsKey returns a representation of the addresses of the
+// given peer that is independent of their order.
+func addrsKey(pi peer.AddrInfo) string {
+	addrs := make([]string, len(pi.Addrs))
+	for i, addr := range pi.Addrs {
+		addrs[i] = addr.String()
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+}
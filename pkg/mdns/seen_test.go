@@ -0,0 +1,68 @@
+package mdns
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/whyrusleeping/mdns"
+)
+
+func Test_seenCache_fresh(t *testing.T) {
+	c := newSeenCache(time.Minute)
+	now := time.Now()
+
+	pi := peer.AddrInfo{ID: "peer", Addrs: toMaddrs("/ip4/192.168.1.2/tcp/4001", "/ip6/fd00::2/tcp/4001")}
+	assert.True(t, c.fresh(pi, now))
+	assert.False(t, c.fresh(pi, now.Add(time.Second)))
+
+	// The order of the addresses doesn't matter.
+	reordered := peer.AddrInfo{ID: "peer", Addrs: []ma.Multiaddr{pi.Addrs[1], pi.Addrs[0]}}
+	assert.False(t, c.fresh(reordered, now.Add(2*time.Second)))
+
+	// Changed addresses are passed on right away.
+	changed := peer.AddrInfo{ID: "peer", Addrs: toMaddrs("/ip4/192.168.1.3/tcp/4001")}
+	assert.True(t, c.fresh(changed, now.Add(3*time.Second)))
+
+	// Other peers are independent.
+	assert.True(t, c.fresh(peer.AddrInfo{ID: "other", Addrs: pi.Addrs}, now.Add(4*time.Second)))
+
+	// The peer is passed on again after the TTL.
+	assert.True(t, c.fresh(changed, now.Add(3*time.Second+time.Minute)))
+}
+
+func Test_seenCache_disabled(t *testing.T) {
+	c := newSeenCache(0)
+	pi := peer.AddrInfo{ID: "peer", Addrs: toMaddrs("/ip4/192.168.1.2/tcp/4001")}
+	assert.True(t, c.fresh(pi, time.Now()))
+	assert.True(t, c.fresh(pi, time.Now()))
+}
+
+func TestDiscoverer_drainEntriesChan_dedup(t *testing.T) {
+	_, local, teardown := setup(t)
+	defer teardown(t)
+
+	pid, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	entries := make(chan *mdns.ServiceEntry, 3)
+	for i := 0; i < 3; i++ {
+		entries <- &mdns.ServiceEntry{Info: pid.Pretty(), AddrV4: net.ParseIP("192.168.1.2"), Port: 4001}
+	}
+	close(entries)
+
+	var calls int32
+	NewDiscoverer(local).drainEntriesChan(entries, func(pi peer.AddrInfo) {
+		atomic.AddInt32(&calls, 1)
+	})
+
+	// The handler is called asynchronously.
+	time.Sleep(50 * time.Millisecond)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/pkg/service"
+)
+
+const namespace = "pcp"
+
+var (
+	// PeersFound counts the peers that were handed to the
+	// peer handler, partitioned by the discoverer that found them.
+	PeersFound = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "peers_found_total",
+		Help:      "Number of peers found per discoverer.",
+	}, []string{"discoverer"})
+
+	// PeersUnreachable counts the peers that were found but dropped
+	// because they didn't advertise any address we could dial.
+	PeersUnreachable = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "peers_unreachable_total",
+		Help:      "Number of peers found without a dialable address per discoverer.",
+	}, []string{"discoverer"})
+
+	// ConnectionAttempts counts the connection attempts to discovered peers.
+	ConnectionAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "connection_attempts_total",
+		Help:      "Number of connection attempts to discovered peers.",
+	})
+
+	// ConnectionFailures counts the failed connection attempts to discovered peers.
+	ConnectionFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "connection_failures_total",
+		Help:      "Number of failed connection attempts to discovered peers.",
+	})
+
+	// KeyExchanges counts the password authenticated key
+	// exchanges partitioned by their result.
+	KeyExchanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "key_exchanges_total",
+		Help:      "Number of password authenticated key exchanges by result.",
+	}, []string{"result"})
+
+	// BytesTransferred counts the payload bytes that were sent or received.
+	BytesTransferred = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transferred_bytes_total",
+		Help:      "Number of payload bytes sent or received.",
+	}, []string{"direction"})
+
+	// StageDuration observes how long the node stayed in a particular state.
+	StageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stage_duration_seconds",
+		Help:      "Time spent in a particular node state.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600},
+	}, []string{"stage"})
+
+	// DiscoveryRoundDuration observes how long a single
+	// advertise or discovery round of a discoverer took.
+	DiscoveryRoundDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "discovery_round_duration_seconds",
+		Help:      "Duration of a single advertise or discovery round.",
+		Buckets:   []float64{0.5, 1, 5, 15, 30, 60, 120},
+	}, []string{"discoverer"})
+)
+
+// Result labels for the KeyExchanges counter.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Direction labels for the BytesTransferred counter.
+const (
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+)
+
+// registry holds all pcp collectors. A dedicated registry is used
+// so that we don't expose the collectors of our dependencies.
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		PeersFound,
+		PeersUnreachable,
+		ConnectionAttempts,
+		ConnectionFailures,
+		KeyExchanges,
+		BytesTransferred,
+		StageDuration,
+		DiscoveryRoundDuration,
+	)
+}
+
+// Server exposes the collected metrics via HTTP in the
+// Prometheus exposition format.
+type Server struct {
+	*service.Service
+	srv *http.Server
+	lis net.Listener
+}
+
+// NewServer initializes a metrics server that listens on the given address.
+// If the address does not contain a host, the server binds to localhost.
+func NewServer(addr string) (*Server, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if host == "" {
+		host = "localhost"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	return &Server{
+		Service: service.New("metrics"),
+		srv: &http.Server{
+			Addr:    net.JoinHostPort(host, port),
+			Handler: mux,
+		},
+	}, nil
+}
+
+// Listen binds the listener of the HTTP server, so that an address that
+// can't be used is reported right away. Serve must be called afterwards.
+func (s *Server) Listen() error {
+	lis, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	if err = s.ServiceStarted(); err != nil {
+		lis.Close()
+		return err
+	}
+	s.lis = lis
+	log.Debugln("Serving metrics on", lis.Addr())
+
+	return nil
+}
+
+// Serve serves the metrics on the listener that Listen has bound
+// and blocks until the server is shut down.
+func (s *Server) Serve() error {
+	defer s.ServiceStopped()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.srv.Serve(s.lis)
+	}()
+
+	select {
+	case <-s.SigShutdown():
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return s.srv.Shutdown(ctx)
+	case err := <-errChan:
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	}
+}
+
+// ListenAndServe starts the HTTP server and blocks until it is shut down.
+func (s *Server) ListenAndServe() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Shutdown gracefully stops the HTTP server.
+func (s *Server) Shutdown() {
+	s.Service.Shutdown()
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewServer_defaultsToLocalhost(t *testing.T) {
+	s, err := NewServer(":9090")
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:9090", s.srv.Addr)
+}
+
+func TestNewServer_keepsExplicitHost(t *testing.T) {
+	s, err := NewServer("0.0.0.0:9090")
+	require.NoError(t, err)
+	assert.Equal(t, "0.0.0.0:9090", s.srv.Addr)
+}
+
+func TestNewServer_errorOnInvalidAddr(t *testing.T) {
+	_, err := NewServer("9090")
+	assert.Error(t, err)
+}
+
+func TestServer_exposesMetrics(t *testing.T) {
+	s, err := NewServer(":9090")
+	require.NoError(t, err)
+
+	PeersFound.WithLabelValues("dht").Inc()
+
+	rec := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `pcp_peers_found_total{discoverer="dht"} 1`)
+}
+
+func TestServer_Listen_addrInUse(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+
+	s, err := NewServer(lis.Addr().String())
+	require.NoError(t, err)
+	assert.Error(t, s.Listen())
+}
+
+func TestServer_Shutdown_afterListen(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, s.Listen())
+	go s.Serve()
+
+	// The server is started once Listen returns, so
+	// shutting it down right away doesn't miss it.
+	done := make(chan struct{})
+	go func() {
+		s.Shutdown()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown didn't stop the server")
+	}
+
+	_, err = net.Dial("tcp", s.lis.Addr().String())
+	assert.Error(t, err, "still serving")
+}
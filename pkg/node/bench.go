@@ -0,0 +1,121 @@
+package node
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/dennis-tra/pcp/internal/format"
+)
+
+// BenchmarkName is the name under which generated benchmark data is sent.
+const BenchmarkName = "pcp-benchmark"
+
+// benchmarkSeed seeds the generator, so that every benchmark sends the same bytes.
+const benchmarkSeed = 1
+
+// RateInterval is the interval in which the throughput is sampled.
+var RateInterval = time.Second
+
+// BenchmarkReader returns a reader that generates size deterministic
+// pseudo-random bytes. The data doesn't compress, so it measures the
+// network like a real transfer, but it doesn't touch the disk.
+func BenchmarkReader(size int64) io.Reader {
+	block := make([]byte, DefaultChunkSize)
+	rand.New(rand.NewSource(benchmarkSeed)).Read(block)
+	return io.LimitReader(&repeatReader{block: block}, size)
+}
+
+// repeatReader endlessly repeats the given block.
+type repeatReader struct {
+	block []byte
+	off   int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.block[r.off:])
+		r.off = (r.off + c) % len(r.block)
+		n += c
+	}
+	return n, nil
+}
+
+// RateMeter is a writer that counts the bytes written to it
+// and samples the throughput once per RateInterval.
+type RateMeter struct {
+	now func() time.Time
+
+	start   time.Time
+	last    time.Time
+	total   int64
+	sampled int64
+	samples int
+
+	min float64
+	max float64
+}
+
+// NewRateMeter returns a rate meter that starts measuring right away.
+func NewRateMeter() *RateMeter {
+	m := &RateMeter{now: time.Now}
+	m.start = m.now()
+	m.last = m.start
+	return m
+}
+
+func (m *RateMeter) Write(p []byte) (int, error) {
+	m.total += int64(len(p))
+
+	now := m.now()
+	elapsed := now.Sub(m.last)
+	if elapsed < RateInterval {
+		return len(p), nil
+	}
+
+	rate := format.BytesPerSecond(m.total-m.sampled, elapsed)
+	if m.samples == 0 || rate < m.min {
+		m.min = rate
+	}
+	if rate > m.max {
+		m.max = rate
+	}
+	m.last = now
+	m.sampled = m.total
+	m.samples++
+
+	return len(p), nil
+}
+
+// Summary returns the measured rates until now.
+func (m *RateMeter) Summary() RateSummary {
+	s := RateSummary{
+		Bytes:    m.total,
+		Duration: m.now().Sub(m.start),
+		Min:      m.min,
+		Max:      m.max,
+	}
+	s.Avg = format.BytesPerSecond(s.Bytes, s.Duration)
+
+	// The transfer was too short to take a single sample.
+	if m.samples == 0 {
+		s.Min, s.Max = s.Avg, s.Avg
+	}
+	return s
+}
+
+// RateSummary holds the throughput of a transfer in bytes per second.
+type RateSummary struct {
+	Bytes    int64
+	Duration time.Duration
+	Min      float64
+	Avg      float64
+	Max      float64
+}
+
+func (s RateSummary) String() string {
+	return fmt.Sprintf("%s in %s - min %s, avg %s, max %s", format.Bytes(s.Bytes), s.Duration.Round(time.Millisecond),
+		format.Speed(int64(s.Min)), format.Speed(int64(s.Avg)), format.Speed(int64(s.Max)))
+}
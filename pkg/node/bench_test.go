@@ -0,0 +1,88 @@
+package node
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBenchmarkReader(t *testing.T) {
+	a, err := ioutil.ReadAll(BenchmarkReader(100_000))
+	require.NoError(t, err)
+	assert.Len(t, a, 100_000)
+
+	b, err := ioutil.ReadAll(BenchmarkReader(100_000))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(a, b), "generated data differs")
+}
+
+func TestRateMeter(t *testing.T) {
+	now := time.Now()
+	m := &RateMeter{now: func() time.Time { return now }, start: now, last: now}
+
+	write := func(n int, after time.Duration) {
+		now = now.Add(after)
+		_, err := m.Write(make([]byte, n))
+		require.NoError(t, err)
+	}
+
+	write(100, RateInterval)
+	write(300, RateInterval)
+	write(200, RateInterval)
+
+	s := m.Summary()
+	assert.EqualValues(t, 600, s.Bytes)
+	assert.Equal(t, 3*RateInterval, s.Duration)
+	assert.InDelta(t, 100/RateInterval.Seconds(), s.Min, 0.001)
+	assert.InDelta(t, 200/RateInterval.Seconds(), s.Avg, 0.001)
+	assert.InDelta(t, 300/RateInterval.Seconds(), s.Max, 0.001)
+}
+
+func TestRateMeter_noSample(t *testing.T) {
+	now := time.Now()
+	m := &RateMeter{now: func() time.Time { return now }, start: now, last: now}
+
+	_, err := m.Write(make([]byte, 50))
+	require.NoError(t, err)
+	now = now.Add(RateInterval / 2)
+
+	s := m.Summary()
+	assert.Equal(t, s.Avg, s.Min)
+	assert.Equal(t, s.Avg, s.Max)
+}
+
+func TestTransferProtocol_TransferBenchmark(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	authNodes(t, node1, node2)
+
+	var received int64
+	done := make(chan struct{})
+	node2.RegisterTransferHandler(&TestTransferHandler{
+		handler: func(hdr *tar.Header, r io.Reader) {
+			assert.Equal(t, BenchmarkName, hdr.Name)
+			received, _ = io.Copy(ioutil.Discard, r)
+		},
+		done: func() { close(done) },
+	})
+
+	require.NoError(t, net.LinkAll())
+
+	summary, err := node1.TransferBenchmark(ctx, node2.ID(), 1<<20)
+	require.NoError(t, err)
+	<-done
+
+	assert.EqualValues(t, 1<<20, received)
+	assert.EqualValues(t, 1<<20, summary.Bytes)
+}
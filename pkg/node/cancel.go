@@ -0,0 +1,62 @@
+package node
+
+import (
+	"context"
+	"io"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// pattern: /protocol-name/request-or-response-message/version
+const ProtocolCancel = "/pcp/cancel/0.1.0"
+
+// RegisterCancelHandler registers a function that is called if an
+// authenticated peer tells us that it has cancelled the transfer on
+// purpose. This lets us distinguish it from a broken connection.
+func (n *Node) RegisterCancelHandler(handler func(peer.ID)) {
+	log.Debugln("Registering cancel handler")
+	n.SetStreamHandler(ProtocolCancel, func(s network.Stream) {
+		defer s.Close()
+
+		peerID := s.Conn().RemotePeer()
+		if !n.IsAuthenticated(peerID) {
+			log.Debugln("Received cancellation from unauthenticated peer:", peerID)
+			s.Reset()
+			return
+		}
+
+		handler(peerID)
+	})
+}
+
+// UnregisterCancelHandler removes the cancel handler.
+func (n *Node) UnregisterCancelHandler() {
+	log.Debugln("Unregistering cancel handler")
+	n.RemoveStreamHandler(ProtocolCancel)
+}
+
+// SendCancel tells the given peer that we have cancelled the transfer. It
+// waits until the peer has handled the cancellation, so that it knows
+// the reason before we reset the transfer stream.
+func (n *Node) SendCancel(ctx context.Context, peerID peer.ID) error {
+	s, err := n.NewStream(ctx, peerID, ProtocolCancel)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	defer resetOnDone(ctx, s)()
+
+	if err = s.CloseWrite(); err != nil {
+		return err
+	}
+
+	// The peer closes the stream after it has handled the cancellation.
+	if _, err = s.Read(make([]byte, 1)); err != io.EOF {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package node
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNode_SendCancel(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	authNodes(t, node1, node2)
+	require.NoError(t, net.LinkAll())
+
+	var cancelled int32
+	node1.RegisterCancelHandler(func(peerID peer.ID) {
+		assert.Equal(t, node2.ID(), peerID)
+		atomic.StoreInt32(&cancelled, 1)
+	})
+	defer node1.UnregisterCancelHandler()
+
+	require.NoError(t, node2.SendCancel(ctx, node1.ID()))
+
+	// The handler has run before SendCancel returns.
+	assert.EqualValues(t, 1, atomic.LoadInt32(&cancelled))
+}
+
+func TestNode_SendCancel_unauthenticated(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	require.NoError(t, net.LinkAll())
+
+	node1.RegisterCancelHandler(func(peerID peer.ID) {
+		t.Error("handler must not be called for unauthenticated peers")
+	})
+	defer node1.UnregisterCancelHandler()
+
+	assert.Error(t, node2.SendCancel(ctx, node1.ID()))
+}
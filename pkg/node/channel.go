@@ -0,0 +1,104 @@
+package node
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/pkg/dht"
+	"github.com/dennis-tra/pcp/pkg/discovery"
+)
+
+// ChannelGrace is the time a time slot that was saved to a channel
+// file is reused. Afterwards the current time slot is saved instead.
+var ChannelGrace = time.Hour
+
+// channel is the content of a channel file. It pins the time slot the
+// discovery identifier is derived from, so that restarts and peers that
+// share the file use the same identifier regardless of the current time.
+type channel struct {
+	ChanID    int       `json:"chan_id"`
+	Namespace string    `json:"namespace,omitempty"`
+	TimeSlot  time.Time `json:"time_slot"`
+}
+
+// TimeSlotFromFile returns the time slot saved in the channel file at the
+// given path. If the file doesn't exist, belongs to another channel or
+// its time slot is older than ChannelGrace the current time slot is
+// saved to the file and returned.
+func TimeSlotFromFile(path string, chanID int, namespace string) (time.Time, error) {
+	return timeSlotFromFile(path, chanID, namespace, time.Now())
+}
+
+func timeSlotFromFile(path string, chanID int, namespace string, now time.Time) (time.Time, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return time.Time{}, errors.Wrap(err, "error reading channel file")
+	}
+
+	if err == nil {
+		var ch channel
+		if err := json.Unmarshal(data, &ch); err != nil {
+			return time.Time{}, errors.Wrap(err, "error parsing channel file")
+		}
+
+		switch {
+		case ch.ChanID != chanID || ch.Namespace != namespace:
+			log.Infoln("Channel file belongs to another channel, starting a new one")
+		case now.Sub(ch.TimeSlot) > ChannelGrace:
+			log.Infoln("Channel file has expired, starting a new one")
+		default:
+			log.Infoln("Reusing channel from", ch.TimeSlot.Local().Format(time.Kitchen))
+			return ch.TimeSlot, nil
+		}
+	}
+
+	ch := channel{
+		ChanID:    chanID,
+		Namespace: namespace,
+		TimeSlot:  now.Truncate(dht.TruncateDuration).UTC(),
+	}
+
+	data, err = json.MarshalIndent(ch, "", "  ")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if err = ioutil.WriteFile(path, data, 0o644); err != nil {
+		return time.Time{}, errors.Wrap(err, "error writing channel file")
+	}
+
+	return ch.TimeSlot, nil
+}
+
+// parseBucketTime returns the start of the time slot of the given RFC3339
+// time. Peers that are given times in the same slot derive the same
+// discovery identifiers regardless of their clocks.
+func parseBucketTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "invalid bucket time")
+	}
+	return t.Truncate(dht.TruncateDuration).UTC(), nil
+}
+
+// ReportChannel logs the identifiers the given discoverer or advertiser
+// derives from our channel under the given label. Peers only find each
+// other if they are identical on both ends. They are logged at info level
+// with --show-channel and at debug level otherwise.
+func (n *Node) ReportChannel(label string, x interface{}) {
+	cd, ok := x.(discovery.ChannelDescriber)
+	if !ok {
+		return
+	}
+
+	if n.showChannel {
+		log.Infof("Channel %s: %s\n", label, cd.DescribeChannel(n.ChanID))
+	} else {
+		log.Debugf("Channel %s: %s\n", label, cd.DescribeChannel(n.ChanID))
+	}
+}
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_timeSlotFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "channel.json")
+	now := time.Date(2021, 3, 4, 10, 7, 30, 0, time.UTC)
+
+	slot, err := timeSlotFromFile(path, 1, "", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 3, 4, 10, 5, 0, 0, time.UTC), slot)
+
+	// A restart in a later time slot reuses the saved one.
+	reused, err := timeSlotFromFile(path, 1, "", now.Add(20*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, slot.Equal(reused))
+
+	// Another channel starts over.
+	other, err := timeSlotFromFile(path, 2, "", now.Add(20*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 3, 4, 10, 25, 0, 0, time.UTC), other)
+
+	// An expired time slot is replaced.
+	expired, err := timeSlotFromFile(path, 2, "", now.Add(ChannelGrace+time.Hour))
+	require.NoError(t, err)
+	assert.True(t, expired.After(other))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("garbage"), 0o644))
+	_, err = timeSlotFromFile(path, 2, "", now)
+	assert.Error(t, err)
+}
+
+func Test_parseBucketTime(t *testing.T) {
+	// Both times are in the same time slot, one given in another zone.
+	slot, err := parseBucketTime("2021-03-04T10:07:30Z")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 3, 4, 10, 5, 0, 0, time.UTC), slot)
+
+	other, err := parseBucketTime("2021-03-04T11:09:59+01:00")
+	require.NoError(t, err)
+	assert.Equal(t, slot, other)
+
+	_, err = parseBucketTime("2021-03-04 10:07")
+	assert.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Checksum returns the hex encoded SHA-256 checksum that users can compare
+// out of band. For a single file it's the hash of its content, just like
+// sha256sum prints it. For a directory it's calculated over the names
+// relative to the directory and the contents of all entries in walk order,
+// so that it doesn't depend on the name the directory was saved under.
+// Entries that match the given Exclude are left out.
+func Checksum(path string, exclude *Exclude) (string, error) {
+	base, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if !base.IsDir() {
+		sum, err := FileHash(path)
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(sum), nil
+	}
+
+	h := sha256.New()
+	err = exclude.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == path {
+			return err
+		}
+
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		WriteContentName(h, rel)
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "error calculating checksum")
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ChecksumEnabled returns true if the checksum of the transferred
+// data should be calculated and printed.
+func (n *Node) ChecksumEnabled() bool {
+	return !n.noChecksum
+}
@@ -0,0 +1,56 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksum_file(t *testing.T) {
+	path := relTestDir("transfer_file/file")
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	sum, err := Checksum(path, nil)
+	require.NoError(t, err)
+	expected := sha256.Sum256(content)
+	assert.Equal(t, hex.EncodeToString(expected[:]), sum)
+}
+
+func TestChecksum_dir(t *testing.T) {
+	write := func(root string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "sub"), 0o755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0o644))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte("b"), 0o644))
+	}
+
+	// The checksum doesn't depend on the name of the directory.
+	dir1 := filepath.Join(t.TempDir(), "dir")
+	dir2 := filepath.Join(t.TempDir(), "dir_1")
+	write(dir1)
+	write(dir2)
+
+	sum1, err := Checksum(dir1, nil)
+	require.NoError(t, err)
+	sum2, err := Checksum(dir2, nil)
+	require.NoError(t, err)
+	assert.Equal(t, sum1, sum2)
+
+	// But on the content and the excluded entries.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir2, "c.log"), []byte("c"), 0o644))
+	sum2, err = Checksum(dir2, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, sum1, sum2)
+
+	exclude, err := NewExclude([]string{"*.log"}, false, false)
+	require.NoError(t, err)
+	sum2, err = Checksum(dir2, exclude)
+	require.NoError(t, err)
+	assert.Equal(t, sum1, sum2)
+}
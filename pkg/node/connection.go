@@ -0,0 +1,267 @@
+package node
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// isRelayed returns true if the given remote address
+// is reached through a circuit relay.
+func isRelayed(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+// ConnectionType returns a human readable description
+// whether the given connection is direct or relayed.
+func ConnectionType(conn network.Conn) string {
+	if isRelayed(conn.RemoteMultiaddr()) {
+		return "relayed"
+	}
+	return "direct"
+}
+
+// PeerConnectionType returns whether we're connected to the given peer
+// directly or through a relay. It's empty if there is no connection.
+func (n *Node) PeerConnectionType(peerID peer.ID) string {
+	conns := n.Network().ConnsToPeer(peerID)
+	if len(conns) == 0 {
+		return ""
+	}
+	return ConnectionType(conns[0])
+}
+
+// reportConnection tells the user whether the transfer uses a direct or
+// a relayed connection. Relayed connections are usually a lot slower.
+func reportConnection(conn network.Conn) {
+	if isRelayed(conn.RemoteMultiaddr()) {
+		log.Infoln("Transferring via relayed connection - this may be slow:", conn.RemoteMultiaddr())
+		return
+	}
+	log.Infoln("Transferring via direct connection:", conn.RemoteMultiaddr())
+}
+
+// DirectConnTimeout is the time we wait for a direct connection
+// to a peer that we're only connected to through a relay.
+const DirectConnTimeout = 30 * time.Second
+
+// ErrNoDirectConnection is returned in --only-direct mode if we
+// couldn't establish a direct connection to our peer.
+var ErrNoDirectConnection = errors.New("could not establish a direct connection")
+
+// ErrRelayedConnection is returned in --only-direct mode if a transfer
+// would go through a relayed connection.
+var ErrRelayedConnection = errors.New("refusing to transfer over a relayed connection")
+
+// DirectAddrs returns the given addresses without the ones
+// that are reached through a circuit relay.
+func DirectAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	direct := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if !isRelayed(addr) {
+			direct = append(direct, addr)
+		}
+	}
+	return direct
+}
+
+// ErrNotLAN is returned in --lan-only mode if the connection to
+// a peer doesn't use a private address of the local network.
+var ErrNotLAN = errors.New("refusing a connection outside the local network")
+
+// lanNets are the private networks after RFC 1918 and their IPv6
+// counterpart, the unique local addresses after RFC 4193. Other
+// ranges that manet considers private, like the shared address
+// space of carrier-grade NAT (100.64.0.0/10), span more than the
+// local network.
+var lanNets = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// isLANIP returns true if the given IP is a loopback,
+// link-local or private address of the local network.
+func isLANIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return true
+	}
+	for _, n := range lanNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLANAddr returns true if the given address is a private address of
+// the local network that is reached without a relay. Relay addresses
+// can start with a private address of the relay, so they're
+// checked first.
+func isLANAddr(addr ma.Multiaddr) bool {
+	if isRelayed(addr) {
+		return false
+	}
+
+	ip, err := manet.ToIP(addr)
+	return err == nil && isLANIP(ip)
+}
+
+// LANAddrs returns the given addresses without the ones
+// that are outside the local network or relayed.
+func LANAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	lan := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if isLANAddr(addr) {
+			lan = append(lan, addr)
+		}
+	}
+	return lan
+}
+
+// checkLANConn returns an error that tells why the given connection
+// doesn't stay in the local network. It's nil if it does.
+func checkLANConn(conn network.Conn) error {
+	addr := conn.RemoteMultiaddr()
+	if isRelayed(addr) {
+		return errors.Wrapf(ErrNotLAN, "%s is relayed", addr)
+	}
+	if !isLANAddr(addr) {
+		return errors.Wrapf(ErrNotLAN, "%s is not a private address", addr)
+	}
+	return nil
+}
+
+// LANOnly returns true if we must only connect to peers in the local network.
+func (n *Node) LANOnly() bool {
+	return n.lanOnly
+}
+
+// EnsureLANConn makes sure that all our connections to the given peer use
+// private addresses of the local network. The other connections are
+// closed. It returns why the peer is refused if no connection is left.
+func (n *Node) EnsureLANConn(peerID peer.ID) error {
+	var refused error
+	for _, conn := range n.Network().ConnsToPeer(peerID) {
+		err := checkLANConn(conn)
+		if err == nil {
+			continue
+		}
+		refused = err
+		log.Debugln("Closing connection outside the local network:", err)
+		if err = conn.Close(); err != nil {
+			log.Debugln("error closing connection:", conn.RemoteMultiaddr(), err)
+		}
+	}
+
+	if len(n.Network().ConnsToPeer(peerID)) > 0 {
+		return nil
+	} else if refused != nil {
+		return refused
+	}
+	return errors.Wrap(ErrNotLAN, "not connected to the peer")
+}
+
+// OnlyDirect returns true if transfers must not use relayed connections.
+func (n *Node) OnlyDirect() bool {
+	return n.onlyDirect
+}
+
+// HasDirectConn returns true if at least one of
+// our connections to the given peer is direct.
+func (n *Node) HasDirectConn(peerID peer.ID) bool {
+	for _, conn := range n.Network().ConnsToPeer(peerID) {
+		if !isRelayed(conn.RemoteMultiaddr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// PingTimeout is the time we wait for the round trip
+// time measurement to a peer.
+const PingTimeout = 5 * time.Second
+
+// RTT measures the round trip time to the given peer with a single ping.
+func (n *Node) RTT(ctx context.Context, peerID peer.ID) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, PingTimeout)
+	defer cancel()
+
+	select {
+	case res, ok := <-ping.Ping(ctx, n, peerID):
+		if !ok {
+			return 0, ctx.Err()
+		}
+		return res.RTT, res.Error
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// closeRelayedConns closes all relayed connections to the given
+// peer, so that new streams are opened on direct connections.
+func (n *Node) closeRelayedConns(peerID peer.ID) {
+	for _, conn := range n.Network().ConnsToPeer(peerID) {
+		if !isRelayed(conn.RemoteMultiaddr()) {
+			continue
+		}
+		if err := conn.Close(); err != nil {
+			log.Debugln("error closing relayed connection:", conn.RemoteMultiaddr(), err)
+		}
+	}
+}
+
+// EnsureDirectConn makes sure that we're connected to the given peer
+// directly and not through a relay. If we're only connected through a
+// relay, the direct addresses of the peer are dialed. This version of
+// libp2p can't punch holes through NATs, so a peer without a reachable
+// direct address results in ErrNoDirectConnection.
+func (n *Node) EnsureDirectConn(ctx context.Context, peerID peer.ID) error {
+	if n.HasDirectConn(peerID) {
+		n.closeRelayedConns(peerID)
+		return nil
+	}
+
+	log.Infof("Waiting for a direct connection to %s (--only-direct)...\n", peerID)
+
+	addrs := DirectAddrs(n.Peerstore().Addrs(peerID))
+	if len(addrs) == 0 {
+		return errors.Wrap(ErrNoDirectConnection, "the peer is only reachable through a relay")
+	}
+
+	// Close the relayed connections, otherwise Connect
+	// returns right away without dialing.
+	n.closeRelayedConns(peerID)
+
+	ctx, cancel := context.WithTimeout(ctx, DirectConnTimeout)
+	defer cancel()
+
+	if err := n.Connect(ctx, peer.AddrInfo{ID: peerID, Addrs: addrs}); err != nil {
+		return errors.Wrapf(ErrNoDirectConnection, "dialing the direct addresses of the peer failed: %s", err)
+	}
+
+	if !n.HasDirectConn(peerID) {
+		return ErrNoDirectConnection
+	}
+	log.Infoln("Established a direct connection to", peerID)
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_isRelayed(t *testing.T) {
+	assert.False(t, isRelayed(ma.StringCast("/ip4/192.168.0.1/tcp/4001")))
+	assert.True(t, isRelayed(ma.StringCast("/ip4/1.2.3.4/tcp/4001/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb/p2p-circuit")))
+}
+
+func TestDirectAddrs(t *testing.T) {
+	direct := ma.StringCast("/ip4/192.168.0.1/tcp/4001")
+	relayed := ma.StringCast("/ip4/1.2.3.4/tcp/4001/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb/p2p-circuit")
+
+	assert.Equal(t, []ma.Multiaddr{direct}, DirectAddrs([]ma.Multiaddr{relayed, direct}))
+	assert.Empty(t, DirectAddrs([]ma.Multiaddr{relayed}))
+}
+
+func TestNode_EnsureDirectConn(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	require.NoError(t, net.LinkAll())
+
+	// We don't know any address of the peer.
+	err := node1.EnsureDirectConn(ctx, node2.ID())
+	assert.True(t, errors.Is(err, ErrNoDirectConnection))
+
+	_, err = net.ConnectPeers(node1.ID(), node2.ID())
+	require.NoError(t, err)
+	assert.NoError(t, node1.EnsureDirectConn(ctx, node2.ID()))
+}
+
+func TestLANAddrs(t *testing.T) {
+	private := ma.StringCast("/ip4/192.168.0.1/tcp/4001")
+	public := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	// The relay itself has a private address, but the peer behind it may be anywhere.
+	relayed := ma.StringCast("/ip4/192.168.0.2/tcp/4001/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb/p2p-circuit")
+
+	assert.Equal(t, []ma.Multiaddr{private}, LANAddrs([]ma.Multiaddr{public, relayed, private}))
+	assert.Empty(t, LANAddrs([]ma.Multiaddr{public, relayed}))
+}
+
+func Test_isLANAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "/ip4/10.1.2.3/tcp/4001", want: true},
+		{addr: "/ip4/172.16.0.1/tcp/4001", want: true},
+		{addr: "/ip4/192.168.0.1/udp/4001/quic", want: true},
+		{addr: "/ip4/127.0.0.1/tcp/4001", want: true},
+		{addr: "/ip4/169.254.1.1/tcp/4001", want: true},
+		{addr: "/ip6/fe80::1/tcp/4001", want: true},
+		{addr: "/ip6/fd00::1/tcp/4001", want: true},
+		{addr: "/ip6/::1/tcp/4001", want: true},
+		// Carrier-grade NAT is shared with other customers of the provider.
+		{addr: "/ip4/100.64.0.1/tcp/4001", want: false},
+		{addr: "/ip4/100.127.255.254/tcp/4001", want: false},
+		{addr: "/ip4/172.32.0.1/tcp/4001", want: false},
+		{addr: "/ip4/1.2.3.4/tcp/4001", want: false},
+		{addr: "/ip6/2001:db8::1/tcp/4001", want: false},
+		{addr: "/dns4/example.com/tcp/4001", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			assert.Equal(t, tt.want, isLANAddr(ma.StringCast(tt.addr)))
+		})
+	}
+}
+
+func TestNode_EnsureLANConn(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	require.NoError(t, net.LinkAll())
+
+	// We're not connected at all.
+	err := node1.EnsureLANConn(node2.ID())
+	assert.True(t, errors.Is(err, ErrNotLAN))
+
+	// The mock network uses public addresses.
+	_, err = net.ConnectPeers(node1.ID(), node2.ID())
+	require.NoError(t, err)
+	err = node1.EnsureLANConn(node2.ID())
+	assert.True(t, errors.Is(err, ErrNotLAN))
+	assert.Contains(t, err.Error(), "not a private address")
+	assert.Empty(t, node1.Network().ConnsToPeer(node2.ID()), "connection wasn't closed")
+}
+
+func TestTransferProtocol_lanOnly(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	authNodes(t, node1, node2)
+	require.NoError(t, net.LinkAll())
+
+	node1.lanOnly = true
+	err := node1.Transfer(ctx, node2.ID(), relTestDir("transfer_file/file"))
+	assert.True(t, errors.Is(err, ErrNotLAN))
+}
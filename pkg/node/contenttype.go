@@ -0,0 +1,95 @@
+package node
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// sniffLen is the number of bytes the content type is sniffed from.
+const sniffLen = 512
+
+// preferredExtensions holds the extension of common content types. The
+// system's MIME database often lists several ones, e.g. .asc for
+// text/plain, of which the first isn't the most common.
+var preferredExtensions = map[string]string{
+	"text/plain":         ".txt",
+	"text/html":          ".html",
+	"text/csv":           ".csv",
+	"text/xml":           ".xml",
+	"application/json":   ".json",
+	"application/pdf":    ".pdf",
+	"application/zip":    ".zip",
+	"application/x-gzip": ".gz",
+	"application/gzip":   ".gz",
+	"application/x-tar":  ".tar",
+	"image/png":          ".png",
+	"image/jpeg":         ".jpg",
+	"image/gif":          ".gif",
+	"image/webp":         ".webp",
+	"audio/mpeg":         ".mp3",
+	"video/mp4":          ".mp4",
+	"video/webm":         ".webm",
+}
+
+// DetectContentType returns the MIME type of a file with the given name
+// and the given first bytes. The extension of the name is preferred over
+// sniffing the content. It returns an empty string if the type is
+// unknown.
+func DetectContentType(name string, head []byte) string {
+	ct := mime.TypeByExtension(filepath.Ext(name))
+	if ct == "" && len(head) > 0 {
+		ct = http.DetectContentType(head)
+	}
+	return mediaType(ct)
+}
+
+// FileContentType returns the MIME type of the file at the given path.
+func FileContentType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return DetectContentType(path, head[:n]), nil
+}
+
+// mediaType strips the parameters from the given content type and
+// returns an empty string for the generic binary type, which tells
+// nothing about the content.
+func mediaType(ct string) string {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil || mt == "application/octet-stream" {
+		return ""
+	}
+	return mt
+}
+
+// ExtensionByType returns the usual file name extension including the
+// dot for the given MIME type. It returns an empty string if the type
+// is unknown.
+func ExtensionByType(ct string) string {
+	mt := mediaType(ct)
+	if mt == "" {
+		return ""
+	}
+
+	if ext, found := preferredExtensions[mt]; found {
+		return ext
+	}
+
+	exts, err := mime.ExtensionsByType(mt)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
@@ -0,0 +1,43 @@
+package node
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectContentType(t *testing.T) {
+	assert.Equal(t, "application/pdf", DetectContentType("report.pdf", nil))
+	assert.Equal(t, "text/plain", DetectContentType("notes", []byte("just some text")))
+	assert.Equal(t, "image/png", DetectContentType("image", []byte("\x89PNG\x0D\x0A\x1A\x0A")))
+	assert.Equal(t, "", DetectContentType("data", []byte{0x00, 0x01, 0x02}))
+	assert.Equal(t, "", DetectContentType("data", nil))
+}
+
+func TestFileContentType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "stdin")
+	require.NoError(t, ioutil.WriteFile(path, []byte("<html><body>hi</body></html>"), 0o644))
+
+	ct, err := FileContentType(path)
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", ct)
+
+	_, err = FileContentType(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestExtensionByType(t *testing.T) {
+	assert.Equal(t, ".txt", ExtensionByType("text/plain; charset=utf-8"))
+	assert.Equal(t, ".jpg", ExtensionByType("image/jpeg"))
+	assert.Equal(t, "", ExtensionByType("application/octet-stream"))
+	assert.Equal(t, "", ExtensionByType("application/x-unknown-pcp"))
+	assert.Equal(t, "", ExtensionByType(""))
+}
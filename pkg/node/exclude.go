@@ -0,0 +1,243 @@
+package node
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Exclude decides which entries of a directory are left out when it's
+// sent. A nil Exclude doesn't exclude anything.
+type Exclude struct {
+	// globs are matched against the slash separated path relative to
+	// the sent directory and against the base name of each entry.
+	globs []string
+
+	// rules are .gitignore style patterns. The last matching rule wins.
+	rules []ignoreRule
+
+	// noHidden excludes all entries whose name starts with a dot.
+	noHidden bool
+}
+
+// ignoreRule is a single line of a .gitignore file.
+type ignoreRule struct {
+	re       *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// NewExclude returns an Exclude for the given patterns. They are standard
+// glob patterns unless gitignore is set, in which case they are matched
+// like the lines of a .gitignore file.
+func NewExclude(patterns []string, noHidden bool, gitignore bool) (*Exclude, error) {
+	e := &Exclude{noHidden: noHidden}
+	for _, pattern := range patterns {
+		if gitignore {
+			if err := e.addRule(pattern); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Match only fails for malformed patterns.
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q", pattern)
+		}
+		e.globs = append(e.globs, pattern)
+	}
+
+	return e, nil
+}
+
+// AddGitignore adds the rules of the .gitignore file at the given path.
+// A missing file is ignored.
+func (e *Exclude) AddGitignore(file string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err = e.addRule(scanner.Text()); err != nil {
+			return errors.Wrapf(err, "error reading %s", file)
+		}
+	}
+	return scanner.Err()
+}
+
+// addRule parses a single .gitignore line. Empty lines and comments are skipped.
+func (e *Exclude) addRule(line string) error {
+	line = strings.TrimRight(line, " ")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	r := ignoreRule{}
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// Patterns with an inner slash are relative to the root.
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile("^" + ignoreRegexp(line) + "$")
+	if err != nil {
+		return fmt.Errorf("invalid exclude pattern %q", line)
+	}
+	r.re = re
+
+	e.rules = append(e.rules, r)
+	return nil
+}
+
+// ignoreRegexp translates the wildcards of a .gitignore pattern to
+// a regular expression. A double star matches any number of
+// directories, single stars and question marks don't match slashes.
+func ignoreRegexp(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**"):
+			b.WriteString("(/.*)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// Match returns true if the entry at the given slash separated path
+// relative to the sent directory is excluded.
+func (e *Exclude) Match(rel string, isDir bool) bool {
+	if e == nil || rel == "." || rel == "" {
+		return false
+	}
+
+	name := path.Base(rel)
+	if e.noHidden && strings.HasPrefix(name, ".") {
+		return true
+	}
+
+	for _, glob := range e.globs {
+		if ok, _ := path.Match(glob, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+
+	excluded := false
+	for _, r := range e.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+
+		target := name
+		if r.anchored {
+			target = rel
+		}
+
+		if r.re.MatchString(target) {
+			excluded = !r.negate
+		}
+	}
+	return excluded
+}
+
+// Walk is like filepath.Walk but skips the excluded entries below root.
+// The content of excluded directories isn't visited.
+func (e *Exclude) Walk(root string, fn filepath.WalkFunc) error {
+	return e.walk(root, fn, nil)
+}
+
+// Count returns the number of entries below root that are excluded.
+// An excluded directory counts as a single entry.
+func (e *Exclude) Count(root string) (int, error) {
+	count := 0
+	err := e.walk(root, func(string, os.FileInfo, error) error { return nil }, func() { count++ })
+	return count, err
+}
+
+// walk calls fn for each entry below root that's not excluded and
+// onExclude for each one that is.
+func (e *Exclude) walk(root string, fn filepath.WalkFunc, onExclude func()) error {
+	if e == nil {
+		return filepath.Walk(root, fn)
+	}
+
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == root {
+			return fn(p, info, err)
+		}
+
+		rel, rerr := filepath.Rel(root, p)
+		if rerr != nil {
+			return rerr
+		}
+
+		if !e.Match(filepath.ToSlash(rel), info.IsDir()) {
+			return fn(p, info, err)
+		}
+
+		if onExclude != nil {
+			onExclude()
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
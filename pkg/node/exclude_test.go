@@ -0,0 +1,83 @@
+package node
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExclude_Match(t *testing.T) {
+	tests := []struct {
+		name      string
+		patterns  []string
+		noHidden  bool
+		gitignore bool
+		rel       string
+		isDir     bool
+		want      bool
+	}{
+		{name: "nil", rel: "a.txt", want: false},
+		{name: "glob name", patterns: []string{"*.log"}, rel: "sub/a.log", want: true},
+		{name: "glob path", patterns: []string{"sub/*"}, rel: "sub/a.txt", want: true},
+		{name: "glob no match", patterns: []string{"*.log"}, rel: "a.txt", want: false},
+		{name: "hidden", noHidden: true, rel: "sub/.env", want: true},
+		{name: "hidden dir", noHidden: true, rel: ".git", isDir: true, want: true},
+		{name: "gitignore name", patterns: []string{"*.o"}, gitignore: true, rel: "a/b/c.o", want: true},
+		{name: "gitignore negate", patterns: []string{"*.o", "!keep.o"}, gitignore: true, rel: "a/keep.o", want: false},
+		{name: "gitignore anchored", patterns: []string{"/build"}, gitignore: true, rel: "sub/build", want: false},
+		{name: "gitignore anchored root", patterns: []string{"/build"}, gitignore: true, rel: "build", want: true},
+		{name: "gitignore dir only file", patterns: []string{"out/"}, gitignore: true, rel: "out", want: false},
+		{name: "gitignore dir only dir", patterns: []string{"out/"}, gitignore: true, rel: "out", isDir: true, want: true},
+		{name: "gitignore double star", patterns: []string{"docs/**/*.tmp"}, gitignore: true, rel: "docs/a/b/c.tmp", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e *Exclude
+			if len(tt.patterns) > 0 || tt.noHidden {
+				var err error
+				e, err = NewExclude(tt.patterns, tt.noHidden, tt.gitignore)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, e.Match(tt.rel, tt.isDir))
+		})
+	}
+}
+
+func TestNewExclude_invalid(t *testing.T) {
+	_, err := NewExclude([]string{"[a"}, false, false)
+	assert.Error(t, err)
+}
+
+func TestExclude_Walk(t *testing.T) {
+	root, err := ioutil.TempDir("", "pcp-exclude")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "node_modules", "pkg"), 0o755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "node_modules", "pkg", "index.js"), nil, 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "main.js"), nil, 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "debug.log"), nil, 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, ".gitignore"), []byte("# deps\nnode_modules/\n*.log\n"), 0o644))
+
+	e, err := NewExclude(nil, false, true)
+	require.NoError(t, err)
+	require.NoError(t, e.AddGitignore(filepath.Join(root, ".gitignore")))
+	require.NoError(t, e.AddGitignore(filepath.Join(root, "missing")))
+
+	var visited []string
+	err = e.Walk(root, func(path string, info os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(root, path)
+		visited = append(visited, filepath.ToSlash(rel))
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{".", ".gitignore", "main.js"}, visited)
+
+	count, err := e.Count(root)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
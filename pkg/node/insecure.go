@@ -0,0 +1,87 @@
+package node
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/pkg/crypt"
+)
+
+// pattern: /protocol-name/request-or-response-message/version
+//
+// ProtocolInsecure replaces the password authenticated key exchange
+// if both peers skip it. As the PAKE protocol is not registered in
+// that mode, a peer that doesn't skip it fails the authentication.
+const ProtocolInsecure = "/pcp/insecure/0.1.0"
+
+// enableInsecureSkip replaces the password authenticated key exchange by
+// a key that is derived from the words alone. Any peer that connects is
+// considered authenticated. The words still protect the transferred
+// data but anyone on the network can pretend to be our peer.
+func (p *PakeProtocol) enableInsecureSkip(words []string) error {
+	key, err := crypt.DeriveKey([]byte(strings.Join(words, "")), []byte(ProtocolInsecure))
+	if err != nil {
+		return err
+	}
+	p.insecureKey = key
+	return nil
+}
+
+// printInsecureWarning makes sure the user can't miss that
+// the peer is not authenticated.
+func printInsecureWarning() {
+	log.Warningln(strings.Repeat("!", 64))
+	log.Warningln("!! --insecure-skip-pake is set. Peers are NOT authenticated.")
+	log.Warningln("!! Anyone on the network can pretend to be your peer.")
+	log.Warningln("!! Only use this in networks you fully trust.")
+	log.Warningln(strings.Repeat("!", 64))
+}
+
+// onInsecureExchange considers the remote peer authenticated without
+// any key exchange and notifies the key exchange handler.
+func (p *PakeProtocol) onInsecureExchange(s network.Stream) {
+	peerID := s.Conn().RemotePeer()
+	log.Debugln("Skipping key exchange with peer", peerID)
+	p.AddAuthenticatedPeer(peerID, p.insecureKey)
+	p.reportKeyExchange(peerID, KeyExchangeAuthenticated)
+
+	// Closing the stream tells our peer that we're done.
+	if err := s.Close(); err != nil {
+		log.Warningln("error closing insecure exchange", err)
+	}
+
+	// The handler may unregister itself, which needs the write lock.
+	p.lk.RLock()
+	keh := p.keh
+	p.lk.RUnlock()
+	if keh != nil {
+		go keh.HandleSuccessfulKeyExchange(peerID)
+	}
+}
+
+// startInsecureExchange tells the given peer that we skip the key exchange
+// and waits for its confirmation. This fails if the peer doesn't skip it.
+func (p *PakeProtocol) startInsecureExchange(ctx context.Context, peerID peer.ID) ([]byte, error) {
+	s, err := p.node.NewStream(ctx, peerID, ProtocolInsecure)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	defer resetOnDone(ctx, s)()
+
+	if err = s.CloseWrite(); err != nil {
+		return nil, err
+	}
+
+	if _, err = s.Read(make([]byte, 1)); err != io.EOF {
+		return nil, err
+	}
+
+	p.AddAuthenticatedPeer(peerID, p.insecureKey)
+	return p.insecureKey, nil
+}
@@ -0,0 +1,121 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type keyExchangeHandler chan peer.ID
+
+func (k keyExchangeHandler) HandleSuccessfulKeyExchange(peerID peer.ID) {
+	k <- peerID
+}
+
+func TestPakeProtocol_insecureSkip(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	require.NoError(t, net.LinkAll())
+
+	words := []string{"one", "two", "three"}
+
+	var err error
+	node1.PakeProtocol, err = NewPakeProtocol(node1, words)
+	require.NoError(t, err)
+	require.NoError(t, node1.enableInsecureSkip(words))
+
+	node2.PakeProtocol, err = NewPakeProtocol(node2, words)
+	require.NoError(t, err)
+	require.NoError(t, node2.enableInsecureSkip(words))
+
+	keh := make(keyExchangeHandler, 1)
+	node2.RegisterKeyExchangeHandler(keh)
+
+	key, err := node1.StartKeyExchange(ctx, node2.ID())
+	require.NoError(t, err)
+	assert.Equal(t, node1.ID(), <-keh)
+
+	assert.True(t, node1.IsAuthenticated(node2.ID()))
+	assert.True(t, node2.IsAuthenticated(node1.ID()))
+	sessionKey, found := node2.GetSessionKey(node1.ID())
+	assert.True(t, found)
+	assert.Equal(t, key, sessionKey)
+}
+
+func TestPakeProtocol_insecureSkip_requiredOnBothEnds(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	require.NoError(t, net.LinkAll())
+
+	words := []string{"one", "two", "three"}
+
+	var err error
+	node1.PakeProtocol, err = NewPakeProtocol(node1, words)
+	require.NoError(t, err)
+	require.NoError(t, node1.enableInsecureSkip(words))
+
+	node2.PakeProtocol, err = NewPakeProtocol(node2, words)
+	require.NoError(t, err)
+	node2.RegisterKeyExchangeHandler(make(keyExchangeHandler, 1))
+
+	_, err = node1.StartKeyExchange(ctx, node2.ID())
+	assert.Error(t, err)
+	assert.False(t, node1.IsAuthenticated(node2.ID()))
+}
+
+// unregisteringHandler unregisters itself from the key exchange
+// like the sender does once a receiver has authenticated.
+type unregisteringHandler struct {
+	p    *PakeProtocol
+	done chan peer.ID
+}
+
+func (u *unregisteringHandler) HandleSuccessfulKeyExchange(peerID peer.ID) {
+	u.p.UnregisterKeyExchangeHandler()
+	u.done <- peerID
+}
+
+func TestPakeProtocol_insecureSkip_handlerUnregistersItself(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	require.NoError(t, net.LinkAll())
+
+	words := []string{"one", "two", "three"}
+
+	var err error
+	node1.PakeProtocol, err = NewPakeProtocol(node1, words)
+	require.NoError(t, err)
+	require.NoError(t, node1.enableInsecureSkip(words))
+
+	node2.PakeProtocol, err = NewPakeProtocol(node2, words)
+	require.NoError(t, err)
+	require.NoError(t, node2.enableInsecureSkip(words))
+
+	keh := &unregisteringHandler{p: node2.PakeProtocol, done: make(chan peer.ID, 1)}
+	node2.RegisterKeyExchangeHandler(keh)
+
+	_, err = node1.StartKeyExchange(ctx, node2.ID())
+	require.NoError(t, err)
+
+	select {
+	case peerID := <-keh.done:
+		assert.Equal(t, node1.ID(), peerID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler could not unregister itself")
+	}
+	assert.False(t, node2.accepting())
+}
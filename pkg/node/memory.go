@@ -0,0 +1,119 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dennis-tra/pcp/internal/format"
+)
+
+// MemoryBudget bounds the sum of the buffers of concurrent transfers.
+// A transfer acquires the memory for its buffers before it starts and
+// waits until other transfers have released enough of it. This applies
+// backpressure instead of allocating more buffers. A nil budget is
+// unlimited.
+type MemoryBudget struct {
+	lk    sync.Mutex
+	limit int64
+	used  int64
+
+	// released is closed and replaced whenever memory is released
+	// to wake up the transfers that are waiting for it.
+	released chan struct{}
+}
+
+// NewMemoryBudget returns a budget of the given number of bytes.
+// It returns nil, i.e. an unlimited budget, if the limit is zero.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &MemoryBudget{limit: limit, released: make(chan struct{})}
+}
+
+// Acquire blocks until n bytes are available or the context is done.
+// It fails right away if n exceeds the whole budget.
+func (b *MemoryBudget) Acquire(ctx context.Context, n int64) error {
+	if b == nil {
+		return nil
+	}
+
+	if n > b.limit {
+		return fmt.Errorf("transfer needs %s of buffers but the memory limit is %s", format.Bytes(n), format.Bytes(b.limit))
+	}
+
+	for {
+		b.lk.Lock()
+		if b.used+n <= b.limit {
+			b.used += n
+			b.lk.Unlock()
+			return nil
+		}
+		released := b.released
+		b.lk.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+// Release returns n previously acquired bytes to the budget.
+func (b *MemoryBudget) Release(n int64) {
+	if b == nil {
+		return
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	b.used -= n
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// Used returns the number of bytes that are currently acquired.
+func (b *MemoryBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	return b.used
+}
+
+// ValidateMaxMemory checks that the memory limit can hold the buffers
+// of at least one transfer with the given chunk size. Zero is valid
+// and disables the limit.
+func ValidateMaxMemory(limit int64, chunkSize int) error {
+	if limit < 0 {
+		return fmt.Errorf("memory limit must not be negative")
+	}
+
+	if limit != 0 && limit < sendBufferSize(chunkSize) {
+		return fmt.Errorf("memory limit must be at least %s to hold the buffers of one transfer with the chunk size", format.Bytes(sendBufferSize(chunkSize)))
+	}
+	return nil
+}
+
+// sendBufferSize is the memory a sending transfer needs: the chunk buffer
+// the data is read into and the buffer it's encrypted into.
+func sendBufferSize(chunkSize int) int64 {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return 2 * int64(chunkSize)
+}
+
+// receiveBufferSize is the memory a receiving transfer needs. The
+// data is decrypted in place, so only the chunk buffer is needed.
+func receiveBufferSize(chunkSize int) int64 {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return int64(chunkSize)
+}
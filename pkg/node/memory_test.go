@@ -0,0 +1,99 @@
+package node
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBudget_unlimited(t *testing.T) {
+	b := NewMemoryBudget(0)
+	assert.Nil(t, b)
+	assert.NoError(t, b.Acquire(context.Background(), 1<<40))
+	b.Release(1 << 40)
+	assert.Zero(t, b.Used())
+}
+
+func TestMemoryBudget_tooLarge(t *testing.T) {
+	b := NewMemoryBudget(100)
+	assert.Error(t, b.Acquire(context.Background(), 101))
+}
+
+func TestMemoryBudget_backpressure(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBudget(100)
+
+	var maxUsed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, b.Acquire(ctx, 40))
+			if used := b.Used(); used > atomic.LoadInt64(&maxUsed) {
+				atomic.StoreInt64(&maxUsed, used)
+			}
+			time.Sleep(time.Millisecond)
+			b.Release(40)
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, atomic.LoadInt64(&maxUsed), int64(100))
+	assert.Zero(t, b.Used())
+}
+
+func TestMemoryBudget_cancel(t *testing.T) {
+	b := NewMemoryBudget(100)
+	require.NoError(t, b.Acquire(context.Background(), 100))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, b.Acquire(ctx, 1))
+}
+
+func TestValidateMaxMemory(t *testing.T) {
+	assert.NoError(t, ValidateMaxMemory(0, 0))
+	assert.NoError(t, ValidateMaxMemory(2*DefaultChunkSize, 0))
+	assert.Error(t, ValidateMaxMemory(2*DefaultChunkSize-1, 0))
+	assert.NoError(t, ValidateMaxMemory(2*MinChunkSize, MinChunkSize))
+	assert.Error(t, ValidateMaxMemory(-1, 0))
+}
+
+func TestTransferProtocol_memoryBackpressure(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, done := setupNode(t, net)
+	authNodes(t, node1, node2)
+	require.NoError(t, net.LinkAll())
+
+	// The budget only fits the buffers of a single transfer,
+	// which another transfer holds at the moment.
+	node1.memory = NewMemoryBudget(sendBufferSize(node1.ChunkSize()))
+	require.NoError(t, node1.memory.Acquire(ctx, sendBufferSize(node1.ChunkSize())))
+
+	errCh := make(chan error)
+	go func() {
+		_, err := node1.TransferBenchmark(ctx, node2.ID(), 1<<16)
+		errCh <- err
+	}()
+
+	select {
+	case <-errCh:
+		t.Fatal("transfer didn't wait for memory")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	node1.memory.Release(sendBufferSize(node1.ChunkSize()))
+	require.NoError(t, <-errCh)
+	<-done
+	assert.Zero(t, node1.memory.Used())
+}
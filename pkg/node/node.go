@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"regexp"
 	"sync"
 	"time"
 
@@ -18,17 +19,25 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/routing"
 	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-varint"
 	"github.com/pkg/errors"
-	"github.com/urfave/cli/v2"
 
 	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/pkg/config"
 	"github.com/dennis-tra/pcp/pkg/crypt"
+	"github.com/dennis-tra/pcp/pkg/mdns"
+	"github.com/dennis-tra/pcp/pkg/metrics"
 	p2p "github.com/dennis-tra/pcp/pkg/pb"
+	"github.com/dennis-tra/pcp/pkg/rendezvous"
 	"github.com/dennis-tra/pcp/pkg/service"
 	"github.com/dennis-tra/pcp/pkg/words"
 )
 
+// namespaceRegex restricts the namespace to characters
+// that are valid in mDNS service names.
+var namespaceRegex = regexp.MustCompile(`^[a-zA-Z0-9-]*$`)
+
 // Is set to true during test runs because the
 // generated peers won't have proper keys
 var skipMessageAuth = false
@@ -59,15 +68,62 @@ type Node struct {
 	ChanID int
 	Words  []string
 
+	// Namespace is mixed into the discovery identifier to
+	// isolate independent pcp deployments from each other.
+	Namespace string
+
+	// MDNSServiceTag replaces the pcp prefix of the mDNS service string.
+	MDNSServiceTag string
+
+	// MDNSInterval is the frequency of mDNS queries and announcements.
+	// The defaults of the mdns package are used if it's zero.
+	MDNSInterval time.Duration
+
+	// MDNSInterfaces restricts mDNS to these network interfaces.
+	// All interfaces are used if it's empty.
+	MDNSInterfaces []mdns.Interface
+
+	// DHTBootstrapRounds and DHTBootstrapBackoff configure the
+	// retries of failed connections to DHT bootstrap peers.
+	DHTBootstrapRounds  int
+	DHTBootstrapBackoff time.Duration
+
+	// TimeSlot pins the time slot the discovery identifier is derived
+	// from. It's the zero time if the identifier rotates with time.
+	TimeSlot time.Time
+
+	// Rendezvous is the rendezvous server both peers meet at.
+	// It's nil if no rendezvous server is used.
+	Rendezvous *peer.AddrInfo
+
 	stateLk *sync.RWMutex
 	state   State
+
+	// stateSince holds the time when the current state was entered.
+	stateSince time.Time
+
+	// metrics is the optional server that exposes Prometheus metrics.
+	metrics *metrics.Server
+
+	// onlyDirect refuses transfers over relayed connections.
+	onlyDirect bool
+
+	// lanOnly refuses connections that don't use a private address.
+	lanOnly bool
+
+	// showChannel logs the derived discovery identifiers at info level.
+	showChannel bool
+
+	// noChecksum skips printing the checksum of the transferred data.
+	noChecksum bool
 }
 
 // New creates a new, fully initialized node with the given options.
-func New(c *cli.Context, wrds []string, opts ...libp2p.Option) (*Node, error) {
+func New(ctx context.Context, o Options, opts ...libp2p.Option) (*Node, error) {
 	log.Debugln("Initialising local node...")
 
-	if c.Bool("homebrew") {
+	wrds := o.Words
+	if o.Homebrew {
 		wrds = words.HomebrewList()
 	}
 	ints, err := words.ToInts(wrds)
@@ -75,51 +131,220 @@ func New(c *cli.Context, wrds []string, opts ...libp2p.Option) (*Node, error) {
 		return nil, err
 	}
 
+	if !namespaceRegex.MatchString(o.Namespace) {
+		return nil, fmt.Errorf("namespace must only contain letters, digits and dashes")
+	}
+
+	if err := mdns.ValidateServiceTag(o.MDNSServiceTag); err != nil {
+		return nil, err
+	}
+
+	if err := mdns.ValidateInterval(o.MDNSInterval); err != nil {
+		return nil, err
+	}
+
+	if o.BucketTime != "" && o.ChannelFile != "" {
+		return nil, fmt.Errorf("--bucket-time can't be combined with --channel-file")
+	}
+
+	if o.DHTBootstrapRounds < 0 || o.DHTBootstrapBackoff < 0 {
+		return nil, fmt.Errorf("DHT bootstrap rounds and backoff must not be negative")
+	}
+
+	mdnsIfaces, err := mdns.LookupInterfaces(o.MDNSInterfaces)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateChunkSize(o.ChunkSize); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateMaxMemory(o.MaxMemory, o.ChunkSize); err != nil {
+		return nil, err
+	}
+
+	var rendezvousServer *peer.AddrInfo
+	if o.Rendezvous != "" {
+		if rendezvousServer, err = rendezvous.ParseServer(o.Rendezvous); err != nil {
+			return nil, err
+		}
+	}
+
 	node := &Node{
-		Service: service.New("node"),
-		state:   Idle,
-		stateLk: &sync.RWMutex{},
-		Words:   wrds,
-		ChanID:  ints[0],
+		Service:    service.New("node"),
+		state:      Idle,
+		stateLk:    &sync.RWMutex{},
+		stateSince: time.Now(),
+		Words:      wrds,
+		ChanID:     ints[0],
+		Namespace:  o.Namespace,
+
+		MDNSServiceTag: o.MDNSServiceTag,
+		MDNSInterval:   o.MDNSInterval,
+		MDNSInterfaces: mdnsIfaces,
+		Rendezvous:     rendezvousServer,
+
+		DHTBootstrapRounds:  o.DHTBootstrapRounds,
+		DHTBootstrapBackoff: o.DHTBootstrapBackoff,
+
+		onlyDirect:  o.OnlyDirect,
+		lanOnly:     o.LANOnly,
+		showChannel: o.ShowChannel,
+		noChecksum:  o.NoChecksum,
+	}
+
+	if o.ChannelFile != "" {
+		if node.TimeSlot, err = TimeSlotFromFile(o.ChannelFile, node.ChanID, node.Namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.BucketTime != "" {
+		if node.TimeSlot, err = parseBucketTime(o.BucketTime); err != nil {
+			return nil, err
+		}
 	}
 	node.PushProtocol = NewPushProtocol(node)
 	node.TransferProtocol = NewTransferProtocol(node)
+	node.chunkSize = o.ChunkSize
+	node.memory = NewMemoryBudget(o.MaxMemory)
 	node.PakeProtocol, err = NewPakeProtocol(node, wrds)
 	if err != nil {
 		return nil, err
 	}
 
-	key, pub, err := crypto.GenerateKeyPair(crypto.Secp256k1, 256)
+	if o.InsecureSkipPake {
+		if err = node.enableInsecureSkip(wrds); err != nil {
+			return nil, err
+		}
+		printInsecureWarning()
+	}
+
+	key, err := identity(o.Identity, o.Seed)
 	if err != nil {
 		return nil, err
 	}
 
-	node.pubKey, err = pub.Raw()
+	node.pubKey, err = key.GetPublic().Raw()
 	if err != nil {
 		return nil, err
 	}
 
+	if len(o.ListenAddrs) > 0 {
+		maddrs, err := parseListenAddrs(o.ListenAddrs)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, libp2p.ListenAddrs(maddrs...))
+	}
+
+	userAgent := o.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent()
+	}
+
 	opts = append(opts,
 		libp2p.Identity(key),
+		libp2p.UserAgent(userAgent),
 		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-			node.DHT, err = kaddht.New(c.Context, h)
+			node.DHT, err = kaddht.New(ctx, h)
 			return node.DHT, err
 		}),
 	)
 
-	node.Host, err = libp2p.New(c.Context, opts...)
+	var mapper *portMapper
+	if o.UPnP {
+		mapper = &portMapper{}
+		opts = append(opts, mapper.option())
+	}
+
+	if o.MetricsAddr != "" {
+		if node.metrics, err = metrics.NewServer(o.MetricsAddr); err != nil {
+			return nil, errors.Wrap(err, "invalid metrics address")
+		}
+	}
+
+	node.Host, err = libp2p.New(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
 
+	if node.metrics != nil {
+		if err = node.metrics.Listen(); err != nil {
+			node.Host.Close()
+			return nil, errors.Wrap(err, "could not serve metrics")
+		}
+		go func() {
+			if err := node.metrics.Serve(); err != nil {
+				log.Warningln("error serving metrics:", err)
+			}
+		}()
+	}
+
+	if mapper != nil {
+		go mapper.report(node.SigShutdown())
+	}
+
 	return node, node.ServiceStarted()
 }
 
+// identity returns the private key of the identity file at the given path or
+// generates a new, ephemeral one if no file was given. The words are
+// generated independently of the identity, so a stable peer ID does
+// not lead to predictable words.
+func identity(path string, seed string) (crypto.PrivKey, error) {
+	if path != "" && seed != "" {
+		return nil, fmt.Errorf("a seed can't be combined with an identity file")
+	}
+
+	if path != "" {
+		return config.LoadIdentity(path)
+	}
+
+	if seed != "" {
+		return seededIdentity(seed)
+	}
+
+	key, _, err := crypto.GenerateKeyPair(crypto.Secp256k1, 256)
+	return key, err
+}
+
+// seededIdentity derives the private key from the given seed, so that
+// the peer ID is the same in every run with the same seed. The secp256k1
+// key generation of libp2p ignores the given source of randomness, so
+// the key is built from the seeded bytes directly.
+func seededIdentity(seed string) (crypto.PrivKey, error) {
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(words.NewSeededReader("identity:"+seed), raw); err != nil {
+		return nil, err
+	}
+	return crypto.UnmarshalSecp256k1PrivateKey(raw)
+}
+
+// parseListenAddrs parses the given strings as multi addresses
+// and returns a descriptive error for the first malformed one.
+func parseListenAddrs(addrs []string) ([]ma.Multiaddr, error) {
+	maddrs := make([]ma.Multiaddr, len(addrs))
+	for i, addr := range addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid listen address %q", addr)
+		}
+		maddrs[i] = maddr
+	}
+	return maddrs, nil
+}
+
 func (n *Node) Shutdown() {
 	if err := n.Host.Close(); err != nil {
 		log.Warningln("error closing node", err)
 	}
 
+	if n.metrics != nil {
+		n.metrics.Shutdown()
+	}
+
 	n.ServiceStopped()
 }
 
@@ -127,7 +352,9 @@ func (n *Node) SetState(s State) State {
 	log.Debugln("Setting local node state to", s)
 	n.stateLk.Lock()
 	defer n.stateLk.Unlock()
+	metrics.StageDuration.WithLabelValues(string(n.state)).Observe(time.Since(n.stateSince).Seconds())
 	n.state = s
+	n.stateSince = time.Now()
 	return n.state
 }
 
@@ -157,6 +384,8 @@ func (n *Node) Send(s network.Stream, msg p2p.HeaderMessage) error {
 		NodeId:     peer.Encode(n.Host.ID()),
 		NodePubKey: pub,
 		Timestamp:  time.Now().Unix(),
+
+		ProtocolVersion: ProtocolVersion,
 	}
 	msg.SetHeader(hdr)
 	log.Debugf("Sending message %T to %s with request ID %s\n", msg, s.Conn().RemotePeer().String(), hdr.RequestId)
@@ -293,13 +522,21 @@ func (n *Node) Read(s network.Stream, buf p2p.HeaderMessage) error {
 // WriteBytes writes the given bytes to the destination writer and
 // prefixes it with a uvarint indicating the length of the data.
 func (n *Node) WriteBytes(w io.Writer, data []byte) (int, error) {
-	size := varint.ToUvarint(uint64(len(data)))
-	return w.Write(append(size, data...))
+	return writeBytes(w, data)
 }
 
 // ReadBytes reads an uvarint from the source reader to know how
 // much data is following.
 func (n *Node) ReadBytes(r io.Reader) ([]byte, error) {
+	return readBytes(r)
+}
+
+func writeBytes(w io.Writer, data []byte) (int, error) {
+	size := varint.ToUvarint(uint64(len(data)))
+	return w.Write(append(size, data...))
+}
+
+func readBytes(r io.Reader) ([]byte, error) {
 	br := bufio.NewReader(r) // init byte reader
 	l, err := varint.ReadUvarint(br)
 	if err != nil {
@@ -326,6 +563,20 @@ func (n *Node) ResetOnShutdown(s network.Stream) context.CancelFunc {
 	return func() { close(cancel) }
 }
 
+// resetOnDone resets the given stream if the given context is done
+// before the returned function is called.
+func resetOnDone(ctx context.Context, s network.Stream) context.CancelFunc {
+	cancel := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Reset()
+		case <-cancel:
+		}
+	}()
+	return func() { close(cancel) }
+}
+
 // WaitForEOF waits for an EOF signal on the stream. This indicates that the peer
 // has received all data and won't read from this stream anymore. Alternatively
 // there is a 10 second timeout.
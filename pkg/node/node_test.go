@@ -0,0 +1,35 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseListenAddrs(t *testing.T) {
+	maddrs, err := parseListenAddrs([]string{"/ip4/0.0.0.0/tcp/4001", "/ip6/::/tcp/4001"})
+	require.NoError(t, err)
+	require.Len(t, maddrs, 2)
+	assert.Equal(t, "/ip4/0.0.0.0/tcp/4001", maddrs[0].String())
+	assert.Equal(t, "/ip6/::/tcp/4001", maddrs[1].String())
+
+	_, err = parseListenAddrs([]string{"/ip4/0.0.0.0/tcp/4001", "0.0.0.0:4001"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0.0.0.0:4001")
+}
+
+func Test_identity_seed(t *testing.T) {
+	key1, err := identity("", "seed")
+	require.NoError(t, err)
+	key2, err := identity("", "seed")
+	require.NoError(t, err)
+	assert.True(t, key1.Equals(key2))
+
+	key3, err := identity("", "other seed")
+	require.NoError(t, err)
+	assert.False(t, key1.Equals(key3))
+
+	_, err = identity("identity.key", "seed")
+	assert.Error(t, err)
+}
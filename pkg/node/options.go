@@ -0,0 +1,177 @@
+package node
+
+import (
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/dennis-tra/pcp/internal/format"
+	"github.com/dennis-tra/pcp/pkg/dht"
+)
+
+// Options holds the configuration that is shared by the sending
+// and receiving node. It allows to embed pcp in other programs
+// without going through the command line interface.
+type Options struct {
+	// Words are the words that are used for discovery and authentication.
+	Words []string
+
+	// Homebrew replaces the words with a hard coded list.
+	Homebrew bool
+
+	// Namespace is mixed into the discovery identifier. It must be
+	// identical on both ends and is empty by default.
+	Namespace string
+
+	// MDNSServiceTag replaces the pcp prefix of the mDNS service
+	// string. It must be identical on both ends and is empty by default.
+	MDNSServiceTag string
+
+	// MDNSInterval is the frequency with which mDNS queries and
+	// announcements are sent. The defaults are used if it's zero.
+	MDNSInterval time.Duration
+
+	// MDNSInterfaces are the names of the network interfaces mDNS is
+	// restricted to. All interfaces are used if it's empty.
+	MDNSInterfaces []string
+
+	// Identity is the path to a private key file to keep the peer ID
+	// across runs. A new key is generated for every run if it's empty.
+	Identity string
+
+	// Seed derives the identity deterministically, so that the peer ID
+	// is the same in every run. It must not be combined with Identity
+	// and is only meant for tests and demos.
+	Seed string
+
+	// ListenAddrs are the multi addresses the node should listen on.
+	// The libp2p defaults are used if it's empty.
+	ListenAddrs []string
+
+	// MetricsAddr is the address Prometheus metrics are exposed on.
+	// Metrics are not exposed if it's empty.
+	MetricsAddr string
+
+	// UseDHT and UseMDNS determine the discovery mechanisms.
+	UseDHT  bool
+	UseMDNS bool
+
+	// DHTBootstrapRounds is the number of attempts to connect to enough
+	// DHT bootstrap peers. The default of the dht package is used if
+	// it's zero. DHTBootstrapBackoff is the jittered waiting time before
+	// the second attempt, which doubles for each further one.
+	DHTBootstrapRounds  int
+	DHTBootstrapBackoff time.Duration
+
+	// ChunkSize is the size of the buffer the transferred data is copied
+	// with. DefaultChunkSize is used if it's zero.
+	ChunkSize int
+
+	// MaxMemory bounds the sum of the buffers of concurrent transfers.
+	// Transfers wait for memory instead of allocating more. Zero
+	// means unlimited.
+	MaxMemory int64
+
+	// InsecureSkipPake skips the password authenticated key exchange.
+	// It must be set on both ends. Peers are not authenticated in
+	// this mode, so it must only be used in fully trusted networks.
+	InsecureSkipPake bool
+
+	// ChannelFile is the path to a file that pins the time slot of the
+	// discovery identifier across restarts. Peers that share the file
+	// pair regardless of their clocks.
+	ChannelFile string
+
+	// BucketTime is an RFC3339 time whose time slot the discovery
+	// identifiers are derived from instead of the current one. It
+	// makes pairing deterministic in tests. The clock is used if
+	// it's empty.
+	BucketTime string
+
+	// Rendezvous is the multi address of a libp2p rendezvous server
+	// including its peer ID. Both peers must use the same server.
+	// No rendezvous server is used if it's empty.
+	Rendezvous string
+
+	// OnlyDirect refuses to transfer over relayed connections. The
+	// peers must be able to connect to each other directly.
+	OnlyDirect bool
+
+	// LANOnly refuses connections to peers that don't use a private
+	// address of the local network, including relayed ones.
+	LANOnly bool
+
+	// ShowChannel logs the identifiers every discovery mechanism derives
+	// from the words at startup, so that users can compare them between
+	// peers that don't find each other. They're logged with --debug anyway.
+	ShowChannel bool
+
+	// UPnP asks the gateway to map the listen ports via UPnP or NAT-PMP,
+	// so that peers can connect directly. The mappings are removed on
+	// shutdown. Nothing changes if there is no such gateway.
+	UPnP bool
+
+	// NoChecksum skips calculating and printing the SHA-256 checksum of
+	// the transferred data. The sender hashes the data while it's sent,
+	// but the receiver needs an extra pass over it.
+	NoChecksum bool
+
+	// UserAgent is announced to other peers in the libp2p identify
+	// exchange. DefaultUserAgent is used if it's empty.
+	UserAgent string
+}
+
+// DefaultOptions returns options that use all discovery
+// mechanisms with the given words.
+func DefaultOptions(words []string) Options {
+	return Options{
+		Words:   words,
+		UseDHT:  true,
+		UseMDNS: true,
+
+		DHTBootstrapRounds:  dht.BootstrapRounds,
+		DHTBootstrapBackoff: dht.BootstrapBackoff,
+	}
+}
+
+// OptionsFromContext builds the options from the global command line flags.
+func OptionsFromContext(c *cli.Context, words []string) Options {
+	return Options{
+		Words:          words,
+		Homebrew:       c.Bool("homebrew"),
+		Namespace:      c.String("namespace"),
+		MDNSServiceTag: c.String("mdns-service-tag"),
+		MDNSInterval:   c.Duration("mdns-interval"),
+		MDNSInterfaces: c.StringSlice("mdns-interface"),
+		Identity:       c.String("identity"),
+		ListenAddrs:    c.StringSlice("listen"),
+		MetricsAddr:    c.String("metrics-addr"),
+		UseDHT:         c.Bool("dht") || !c.Bool("mdns"),
+		UseMDNS:        c.Bool("mdns") || !c.Bool("dht"),
+		ChunkSize:      byteSize(c, "chunk-size"),
+		MaxMemory:      int64(byteSize(c, "max-memory")),
+
+		InsecureSkipPake: c.Bool("insecure-skip-pake"),
+		ChannelFile:      c.String("channel-file"),
+		BucketTime:       c.String("bucket-time"),
+		Rendezvous:       c.String("rendezvous"),
+		OnlyDirect:       c.Bool("only-direct"),
+		LANOnly:          c.Bool("lan-only"),
+		ShowChannel:      c.Bool("show-channel"),
+		UPnP:             c.Bool("upnp"),
+		NoChecksum:       c.Bool("no-checksum"),
+		UserAgent:        c.String("user-agent"),
+
+		DHTBootstrapRounds:  c.Int("dht-bootstrap-rounds"),
+		DHTBootstrapBackoff: c.Duration("dht-bootstrap-backoff"),
+	}
+}
+
+// byteSize returns the value of the given human readable size flag.
+// It returns zero if the flag is not defined.
+func byteSize(c *cli.Context, name string) int {
+	if b, ok := c.Generic(name).(*format.ByteSize); ok && b != nil {
+		return int(*b)
+	}
+	return 0
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/dennis-tra/pcp/internal/log"
 	"github.com/dennis-tra/pcp/pkg/crypt"
+	"github.com/dennis-tra/pcp/pkg/metrics"
 )
 
 // pattern: /protocol-name/request-or-response-message/version
@@ -38,6 +39,10 @@ type PakeProtocol struct {
 	// a successful key exchange.
 	lk  sync.RWMutex
 	keh KeyExchangeHandler
+
+	// insecureKey is the session key if the key exchange is
+	// skipped. It's nil if the key exchange is performed.
+	insecureKey []byte
 }
 
 func NewPakeProtocol(node *Node, words []string) (*PakeProtocol, error) {
@@ -90,12 +95,61 @@ type KeyExchangeHandler interface {
 	HandleSuccessfulKeyExchange(peerID peer.ID)
 }
 
+// KeyExchangeState is the progress of a key exchange that a peer
+// has started with us.
+type KeyExchangeState uint8
+
+const (
+	// KeyExchangeConnecting means the peer has opened the key
+	// exchange stream but hasn't sent key information yet.
+	KeyExchangeConnecting KeyExchangeState = iota
+	KeyExchangeAuthenticating
+	KeyExchangeAuthenticated
+	KeyExchangeFailed
+)
+
+func (s KeyExchangeState) String() string {
+	switch s {
+	case KeyExchangeConnecting:
+		return "connecting"
+	case KeyExchangeAuthenticating:
+		return "authenticating"
+	case KeyExchangeAuthenticated:
+		return "authenticated"
+	case KeyExchangeFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// KeyExchangeObserver can optionally be implemented by a KeyExchangeHandler
+// to follow the key exchanges that peers start with us, e.g. to show the
+// user who is trying to connect.
+type KeyExchangeObserver interface {
+	HandleKeyExchangeState(peerID peer.ID, state KeyExchangeState)
+}
+
+// reportKeyExchange passes the state of the key exchange with the given
+// peer to the registered handler if it's a KeyExchangeObserver.
+func (p *PakeProtocol) reportKeyExchange(peerID peer.ID, state KeyExchangeState) {
+	p.lk.RLock()
+	defer p.lk.RUnlock()
+	if o, ok := p.keh.(KeyExchangeObserver); ok {
+		o.HandleKeyExchangeState(peerID, state)
+	}
+}
+
 func (p *PakeProtocol) RegisterKeyExchangeHandler(keh KeyExchangeHandler) {
 	log.Debugln("Registering key exchange handler")
 	p.lk.Lock()
 	defer p.lk.Unlock()
 	p.keh = keh
-	p.node.SetStreamHandler(ProtocolPake, p.onKeyExchange)
+	if p.insecureKey != nil {
+		p.node.SetStreamHandler(ProtocolInsecure, p.onInsecureExchange)
+	} else {
+		p.node.SetStreamHandler(ProtocolPake, p.onKeyExchange)
+	}
 }
 
 func (p *PakeProtocol) UnregisterKeyExchangeHandler() {
@@ -103,13 +157,33 @@ func (p *PakeProtocol) UnregisterKeyExchangeHandler() {
 	p.lk.Lock()
 	defer p.lk.Unlock()
 	p.node.RemoveStreamHandler(ProtocolPake)
+	p.node.RemoveStreamHandler(ProtocolInsecure)
 	p.keh = nil
 }
 
+// accepting returns true if a key exchange handler is registered
+// that authenticated peers are passed to.
+func (p *PakeProtocol) accepting() bool {
+	p.lk.RLock()
+	defer p.lk.RUnlock()
+	return p.keh != nil
+}
+
 func (p *PakeProtocol) onKeyExchange(s network.Stream) {
 	defer s.Close()
 	defer p.node.ResetOnShutdown(s)()
 
+	remotePeer := s.Conn().RemotePeer()
+	p.reportKeyExchange(remotePeer, KeyExchangeConnecting)
+
+	authenticated := false
+	defer func() {
+		observeKeyExchange(authenticated)
+		if !authenticated {
+			p.reportKeyExchange(remotePeer, KeyExchangeFailed)
+		}
+	}()
+
 	log.Infor("Authenticating peer...")
 
 	// pick an elliptic curve
@@ -130,6 +204,8 @@ func (p *PakeProtocol) onKeyExchange(s network.Stream) {
 		return
 	}
 
+	p.reportKeyExchange(remotePeer, KeyExchangeAuthenticating)
+
 	log.Infor("Calculating on key information...")
 	// Use init data from P
 	if err = Q.Update(dat); err != nil {
@@ -180,7 +256,17 @@ func (p *PakeProtocol) onKeyExchange(s network.Stream) {
 		return
 	}
 
+	// We may have stopped accepting peers while the key exchange was
+	// running, e.g. because the transfer to another peer has started.
+	// Reject the peer instead of letting it wait for a transfer.
+	if !p.accepting() {
+		log.Infoln("Rejecting peer as no new peers are accepted anymore:", remotePeer)
+		s.Reset()
+		return
+	}
+
 	p.AddAuthenticatedPeer(s.Conn().RemotePeer(), key)
+	p.node.logAgent(s.Conn().RemotePeer())
 
 	// We're done reading data from P
 	if err = s.CloseRead(); err != nil {
@@ -204,6 +290,8 @@ func (p *PakeProtocol) onKeyExchange(s network.Stream) {
 	}
 
 	log.Infor("Peer connected and authenticated!\n")
+	authenticated = true
+	p.reportKeyExchange(remotePeer, KeyExchangeAuthenticated)
 
 	p.lk.RLock()
 	defer p.lk.RUnlock()
@@ -214,13 +302,23 @@ func (p *PakeProtocol) onKeyExchange(s network.Stream) {
 	go p.keh.HandleSuccessfulKeyExchange(s.Conn().RemotePeer())
 }
 
-func (p *PakeProtocol) StartKeyExchange(ctx context.Context, peerID peer.ID) ([]byte, error) {
+func (p *PakeProtocol) StartKeyExchange(ctx context.Context, peerID peer.ID) (key []byte, err error) {
+	defer func() { observeKeyExchange(err == nil) }()
+
+	if p.insecureKey != nil {
+		return p.startInsecureExchange(ctx, peerID)
+	}
+
 	s, err := p.node.NewStream(ctx, peerID, ProtocolPake)
 	if err != nil {
 		return nil, err
 	}
 	defer s.Close()
 
+	// The stream doesn't observe the context, so we reset it when
+	// the context is done to not wait forever for a silent peer.
+	defer resetOnDone(ctx, s)()
+
 	log.Infor("Authenticating peer...")
 
 	// pick an elliptic curve
@@ -258,7 +356,7 @@ func (p *PakeProtocol) StartKeyExchange(ctx context.Context, peerID peer.ID) ([]
 	}
 
 	// Extract calculated key
-	key, err := P.SessionKey()
+	key, err = P.SessionKey()
 	if err != nil {
 		return nil, err
 	}
@@ -270,6 +368,7 @@ func (p *PakeProtocol) StartKeyExchange(ctx context.Context, peerID peer.ID) ([]
 	}
 
 	p.AddAuthenticatedPeer(s.Conn().RemotePeer(), key)
+	p.node.logAgent(s.Conn().RemotePeer())
 
 	log.Infor("Proofing authenticity to peer...")
 	// Send Q encryption proof
@@ -297,6 +396,15 @@ func (p *PakeProtocol) StartKeyExchange(ctx context.Context, peerID peer.ID) ([]
 	return key, nil
 }
 
+// observeKeyExchange records the outcome of a key exchange.
+func observeKeyExchange(success bool) {
+	if success {
+		metrics.KeyExchanges.WithLabelValues(metrics.ResultSuccess).Inc()
+	} else {
+		metrics.KeyExchanges.WithLabelValues(metrics.ResultFailure).Inc()
+	}
+}
+
 // SendProof takes the public key of our node and encrypts it with
 // the PAKE-derived session key. The recipient can decrypt the key
 // and verify that it matches.
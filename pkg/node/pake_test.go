@@ -0,0 +1,136 @@
+package node
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPakeProtocol_StartKeyExchange_timeout(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	require.NoError(t, net.LinkAll())
+
+	var err error
+	node1.PakeProtocol, err = NewPakeProtocol(node1, []string{"one", "two", "three"})
+	require.NoError(t, err)
+
+	// node2 accepts the stream but never answers.
+	block := make(chan struct{})
+	defer close(block)
+	node2.SetStreamHandler(ProtocolPake, func(s network.Stream) {
+		<-block
+		s.Reset()
+	})
+
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = node1.StartKeyExchange(tctx, node2.ID())
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	assert.Equal(t, context.DeadlineExceeded, tctx.Err())
+}
+
+// observingHandler records the states of the key exchanges
+// and signals when one has finished.
+type observingHandler struct {
+	lk       sync.Mutex
+	states   []KeyExchangeState
+	finished chan struct{}
+}
+
+func (h *observingHandler) HandleSuccessfulKeyExchange(peer.ID) {}
+
+func (h *observingHandler) HandleKeyExchangeState(_ peer.ID, state KeyExchangeState) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	h.states = append(h.states, state)
+	if state == KeyExchangeAuthenticated || state == KeyExchangeFailed {
+		close(h.finished)
+	}
+}
+
+func TestPakeProtocol_onKeyExchange_observer(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	require.NoError(t, net.LinkAll())
+
+	var err error
+	node2.PakeProtocol, err = NewPakeProtocol(node2, []string{"one", "two", "three"})
+	require.NoError(t, err)
+
+	h := &observingHandler{finished: make(chan struct{})}
+	node2.RegisterKeyExchangeHandler(h)
+
+	// The peer sends malformed key information.
+	s, err := node1.NewStream(ctx, node2.ID(), ProtocolPake)
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = node1.WriteBytes(s, []byte("malformed"))
+	require.NoError(t, err)
+	<-h.finished
+
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	assert.Equal(t, []KeyExchangeState{KeyExchangeConnecting, KeyExchangeAuthenticating, KeyExchangeFailed}, h.states)
+}
+
+func TestPakeProtocol_onInsecureExchange_observer(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	require.NoError(t, net.LinkAll())
+
+	words := []string{"one", "two", "three"}
+	for _, n := range []*Node{node1, node2} {
+		var err error
+		n.PakeProtocol, err = NewPakeProtocol(n, words)
+		require.NoError(t, err)
+		require.NoError(t, n.enableInsecureSkip(words))
+	}
+
+	h := &observingHandler{finished: make(chan struct{})}
+	node2.RegisterKeyExchangeHandler(h)
+
+	_, err := node1.StartKeyExchange(ctx, node2.ID())
+	require.NoError(t, err)
+	<-h.finished
+
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	assert.Equal(t, []KeyExchangeState{KeyExchangeAuthenticated}, h.states)
+}
+
+func TestPakeProtocol_accepting(t *testing.T) {
+	net := mocknet.New(context.Background())
+	node, _ := setupNode(t, net)
+
+	var err error
+	node.PakeProtocol, err = NewPakeProtocol(node, []string{"one", "two", "three"})
+	require.NoError(t, err)
+	assert.False(t, node.accepting())
+
+	node.RegisterKeyExchangeHandler(&observingHandler{})
+	assert.True(t, node.accepting())
+
+	// Key exchanges that are still running are rejected once we've stopped accepting peers.
+	node.UnregisterKeyExchangeHandler()
+	assert.False(t, node.accepting())
+}
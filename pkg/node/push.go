@@ -25,6 +25,33 @@ type PushRequestHandler interface {
 	HandlePushRequest(*p2p.PushRequest) (bool, error)
 }
 
+// PushRequestErrorHandler can optionally be implemented by a PushRequestHandler
+// to be notified about push requests that were refused before reaching it.
+type PushRequestErrorHandler interface {
+	HandlePushRequestError(error)
+}
+
+// PushResumeHandler can optionally be implemented by a PushRequestHandler
+// to tell the sender how many bytes of an accepted file it already has
+// from an interrupted transfer together with the hash of these bytes.
+type PushResumeHandler interface {
+	ResumeOffset(*p2p.PushRequest) (int64, []byte)
+}
+
+// PushDirResumeHandler can optionally be implemented by a PushRequestHandler
+// to tell the sender which files of an accepted directory it already has
+// from an interrupted transfer.
+type PushDirResumeHandler interface {
+	ResumeDir(*p2p.PushRequest) *DirResume
+}
+
+// PushManifestOnlyHandler can optionally be implemented by a
+// PushRequestHandler to tell the sender that a rejection only means that
+// the push request was inspected and not that the transfer was refused.
+type PushManifestOnlyHandler interface {
+	ManifestOnly() bool
+}
+
 func NewPushProtocol(node *Node) *PushProtocol {
 	return &PushProtocol{node: node, lk: sync.RWMutex{}}
 }
@@ -64,14 +91,34 @@ func (p *PushProtocol) onPushRequest(s network.Stream) {
 
 	p.lk.RLock()
 	defer p.lk.RUnlock()
-	accept, err := p.prh.HandlePushRequest(req)
+
+	accept := false
+	err := checkVersion(req)
 	if err != nil {
+		// Don't bother the handler and tell peer we won't handle the request.
+		if eh, ok := p.prh.(PushRequestErrorHandler); ok {
+			eh.HandlePushRequestError(err)
+		} else {
+			log.Errorln(err)
+		}
+	} else if accept, err = p.prh.HandlePushRequest(req); err != nil {
 		log.Infoln(err)
 		accept = false
 		// Fall through and tell peer we won't handle the request
 	}
 
-	if err := p.node.Send(s, p2p.NewPushResponse(accept)); err != nil {
+	resp := p2p.NewPushResponse(accept)
+	if rh, ok := p.prh.(PushResumeHandler); ok && accept {
+		resp.Offset, resp.PartialHash = rh.ResumeOffset(req)
+	}
+	if dh, ok := p.prh.(PushDirResumeHandler); ok && accept {
+		dh.ResumeDir(req).apply(resp)
+	}
+	if mh, ok := p.prh.(PushManifestOnlyHandler); ok && !accept {
+		resp.ManifestOnly = mh.ManifestOnly()
+	}
+
+	if err := p.node.Send(s, resp); err != nil {
 		log.Infoln(err)
 		return
 	}
@@ -82,22 +129,37 @@ func (p *PushProtocol) onPushRequest(s network.Stream) {
 	}
 }
 
-func (p *PushProtocol) SendPushRequest(ctx context.Context, peerID peer.ID, filename string, size int64, isDir bool) (bool, error) {
-	s, err := p.node.NewStream(ctx, peerID, ProtocolPushRequest)
+func (p *PushProtocol) SendPushRequest(ctx context.Context, peerID peer.ID, req *p2p.PushRequest) (bool, error) {
+	resp, err := p.RequestPush(ctx, peerID, req)
 	if err != nil {
 		return false, err
 	}
+	return resp.Accept, nil
+}
+
+// RequestPush sends the given push request to the peer and returns its
+// response. Contrary to SendPushRequest the response also carries the
+// offset the transfer should be resumed at.
+func (p *PushProtocol) RequestPush(ctx context.Context, peerID peer.ID, req *p2p.PushRequest) (*p2p.PushResponse, error) {
+	s, err := p.node.NewStream(ctx, peerID, ProtocolPushRequest)
+	if err != nil {
+		return nil, err
+	}
 	defer s.Close()
 
-	log.Debugln("Sending push request", filename, size)
-	if err = p.node.Send(s, p2p.NewPushRequest(filename, size, isDir)); err != nil {
-		return false, err
+	log.Debugln("Sending push request", req.Name, req.Size)
+	if err = p.node.Send(s, req); err != nil {
+		return nil, err
 	}
 
 	resp := &p2p.PushResponse{}
 	if err = p.node.Read(s, resp); err != nil {
-		return false, err
+		return nil, err
 	}
 
-	return resp.Accept, nil
+	if err = checkVersion(resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
 }
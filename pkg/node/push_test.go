@@ -48,7 +48,39 @@ func TestPushProtocol_RegisterPushRequestHandler_happyPath(t *testing.T) {
 
 	node2.RegisterPushRequestHandler(tprh)
 
-	accepted, err := node1.SendPushRequest(ctx, node2.ID(), "filename", 1000, true)
+	accepted, err := node1.SendPushRequest(ctx, node2.ID(), p2p.NewPushRequest("filename", 1000, true))
+	require.NoError(t, err)
+
+	node2.UnregisterPushRequestHandler()
+
+	assert.True(t, accepted)
+}
+
+func TestPushProtocol_RegisterPushRequestHandler_emptyFile(t *testing.T) {
+	skipMessageAuth = true
+
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	authNodes(t, node1, node2)
+
+	err := net.LinkAll()
+	require.NoError(t, err)
+
+	tprh := &TestPushRequestHandler{
+		handler: func(pr *p2p.PushRequest) (bool, error) {
+			assert.Equal(t, "empty", pr.Name)
+			assert.EqualValues(t, 0, pr.Size)
+			assert.False(t, pr.IsDir)
+			return true, nil
+		},
+	}
+
+	node2.RegisterPushRequestHandler(tprh)
+
+	accepted, err := node1.SendPushRequest(ctx, node2.ID(), p2p.NewPushRequest("empty", 0, false))
 	require.NoError(t, err)
 
 	node2.UnregisterPushRequestHandler()
@@ -74,8 +106,37 @@ func TestPushProtocol_RegisterPushRequestHandler_unauthenticated(t *testing.T) {
 
 	node2.RegisterPushRequestHandler(tprh)
 
-	accept, err := node1.SendPushRequest(ctx, node2.ID(), "filename", 1000, true)
+	accept, err := node1.SendPushRequest(ctx, node2.ID(), p2p.NewPushRequest("filename", 1000, true))
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "stream reset")
 	assert.False(t, accept)
 }
+
+type testManifestOnlyHandler struct {
+	TestPushRequestHandler
+}
+
+func (h *testManifestOnlyHandler) ManifestOnly() bool {
+	return true
+}
+
+func TestPushProtocol_RequestPush_manifestOnly(t *testing.T) {
+	skipMessageAuth = true
+
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	authNodes(t, node1, node2)
+	require.NoError(t, net.LinkAll())
+
+	node2.RegisterPushRequestHandler(&testManifestOnlyHandler{
+		TestPushRequestHandler: TestPushRequestHandler{handler: func(*p2p.PushRequest) (bool, error) { return false, nil }},
+	})
+
+	resp, err := node1.RequestPush(ctx, node2.ID(), p2p.NewPushRequest("dir", 100, true))
+	require.NoError(t, err)
+	assert.False(t, resp.Accept)
+	assert.True(t, resp.ManifestOnly)
+}
@@ -0,0 +1,156 @@
+package node
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/internal/log"
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+// FileHash calculates the SHA-256 hash of the content of the given file.
+// The receiver records it for the files of a directory it has received
+// completely, so that they're skipped if the transfer is resumed.
+func FileHash(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, errors.Wrapf(err, "error hashing %s", path)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// PrefixHash calculates the SHA-256 hash of the first n bytes of the given
+// file. It fails if the file is shorter. The sender uses it to check that
+// the beginning of a partially received file is still the same.
+func PrefixHash(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.CopyN(h, f, n); err != nil {
+		return nil, errors.Wrapf(err, "error hashing %s", path)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// The PAX records that mark resumed entries of a directory transfer. An
+// entry with ResumeSkipRecord has no content because the receiver already
+// has the file. An entry with ResumeOffsetRecord only holds the content
+// after the given offset.
+const (
+	ResumeSkipRecord   = "PCP.skip"
+	ResumeOffsetRecord = "PCP.offset"
+)
+
+// DirResume describes which files of a directory the receiver already has
+// from an interrupted transfer. The files are named as in the tar archive.
+type DirResume struct {
+	// Done maps the files the receiver has completely
+	// to the SHA-256 hashes of their content.
+	Done map[string][]byte
+
+	// Partial is the file the transfer was interrupted in. The receiver
+	// has its first Offset bytes, which have the hash PartialHash.
+	Partial     string
+	PartialHash []byte
+	Offset      int64
+}
+
+// DirResumeFromResponse returns the files the receiver already has as
+// announced in the given push response. It returns nil if there are none.
+func DirResumeFromResponse(resp *p2p.PushResponse) *DirResume {
+	if len(resp.DoneFiles) == 0 && resp.PartialFile == "" {
+		return nil
+	}
+
+	r := &DirResume{
+		Done:        map[string][]byte{},
+		Partial:     resp.PartialFile,
+		PartialHash: resp.PartialHash,
+		Offset:      resp.Offset,
+	}
+	for i, name := range resp.DoneFiles {
+		if i < len(resp.DoneHashes) {
+			r.Done[name] = resp.DoneHashes[i]
+		}
+	}
+	return r
+}
+
+// apply adds the files the receiver already has to the given push response.
+func (r *DirResume) apply(resp *p2p.PushResponse) {
+	if r == nil {
+		return
+	}
+
+	for name, hash := range r.Done {
+		resp.DoneFiles = append(resp.DoneFiles, name)
+		resp.DoneHashes = append(resp.DoneHashes, hash)
+	}
+	if r.Partial != "" && r.Offset > 0 {
+		resp.PartialFile = r.Partial
+		resp.PartialHash = r.PartialHash
+		resp.Offset = r.Offset
+	}
+}
+
+// resumeAt returns whether the receiver already has the given file with
+// the same content and skips it, or otherwise the offset its transfer
+// is resumed at. Files that have changed are sent in full.
+func (r *DirResume) resumeAt(name string, path string, size int64) (int64, bool, error) {
+	if expected, found := r.Done[name]; found {
+		hash, err := FileHash(path)
+		if err != nil {
+			return 0, false, err
+		}
+		if bytes.Equal(hash, expected) {
+			return 0, true, nil
+		}
+		log.Infoln(name, "has changed and is sent again")
+		return 0, false, nil
+	}
+
+	if name != r.Partial {
+		return 0, false, nil
+	}
+
+	offset, err := VerifyOffset(path, size, r.Offset, r.PartialHash)
+	return offset, false, err
+}
+
+// VerifyOffset returns the offset the transfer of the file at the given
+// path with the given size is resumed at. The receiver has reported
+// that it already has the first offset bytes, which have the given hash.
+// If our file starts differently, it has changed and zero is returned,
+// so that it's sent in full.
+func VerifyOffset(path string, size int64, offset int64, expected []byte) (int64, error) {
+	if offset <= 0 || offset > size {
+		return 0, nil
+	}
+
+	hash, err := PrefixHash(path, offset)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(hash, expected) {
+		log.Infoln(filepath.Base(path), "has changed and is sent again")
+		return 0, nil
+	}
+	return offset, nil
+}
@@ -0,0 +1,276 @@
+package node
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+	"github.com/dennis-tra/pcp/pkg/service"
+)
+
+type testResumeHandler struct {
+	TestPushRequestHandler
+	offset int64
+	hash   []byte
+}
+
+func (h *testResumeHandler) ResumeOffset(*p2p.PushRequest) (int64, []byte) {
+	return h.offset, h.hash
+}
+
+func TestFileHash(t *testing.T) {
+	path := relTestDir("transfer_file/file")
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	hash, err := FileHash(path)
+	require.NoError(t, err)
+	expected := sha256.Sum256(content)
+	assert.Equal(t, expected[:], hash)
+
+	_, err = FileHash(relTestDir("not-there"))
+	assert.Error(t, err)
+}
+
+func TestVerifyOffset(t *testing.T) {
+	path := relTestDir("transfer_file/file")
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	size := int64(len(content))
+	prefix := sha256.Sum256(content[:3])
+
+	offset, err := VerifyOffset(path, size, 3, prefix[:])
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, offset)
+
+	// The file has changed since the receiver got the first bytes.
+	offset, err = VerifyOffset(path, size, 3, []byte("other"))
+	require.NoError(t, err)
+	assert.Zero(t, offset)
+
+	// Offsets beyond the file are ignored.
+	offset, err = VerifyOffset(path, size, size+1, prefix[:])
+	require.NoError(t, err)
+	assert.Zero(t, offset)
+}
+
+func TestPushProtocol_RequestPush_offset(t *testing.T) {
+	skipMessageAuth = true
+
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	authNodes(t, node1, node2)
+	require.NoError(t, net.LinkAll())
+
+	accept := true
+	node2.RegisterPushRequestHandler(&testResumeHandler{
+		TestPushRequestHandler: TestPushRequestHandler{handler: func(*p2p.PushRequest) (bool, error) { return accept, nil }},
+		offset:                 42,
+		hash:                   []byte("hash"),
+	})
+
+	resp, err := node1.RequestPush(ctx, node2.ID(), p2p.NewPushRequest("file", 100, false))
+	require.NoError(t, err)
+	assert.True(t, resp.Accept)
+	assert.EqualValues(t, 42, resp.Offset)
+	assert.Equal(t, []byte("hash"), resp.PartialHash)
+
+	// A rejected transfer doesn't carry an offset.
+	accept = false
+	resp, err = node1.RequestPush(ctx, node2.ID(), p2p.NewPushRequest("file", 100, false))
+	require.NoError(t, err)
+	assert.False(t, resp.Accept)
+	assert.Zero(t, resp.Offset)
+}
+
+func TestTransferProtocol_TransferFrom(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	path := relTestDir("transfer_file/file")
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Greater(t, len(content), 3)
+
+	node1, _ := setupNode(t, net)
+
+	p, err := net.GenPeer()
+	require.NoError(t, err)
+	node2 := &Node{Service: service.New("node"), Host: p, PakeProtocol: &PakeProtocol{}}
+	node2.TransferProtocol = NewTransferProtocol(node2)
+
+	var received []byte
+	var size int64
+	done := make(chan struct{})
+	node2.RegisterTransferHandler(&TestTransferHandler{
+		handler: func(hdr *tar.Header, r io.Reader) {
+			size = hdr.Size
+			received, err = ioutil.ReadAll(r)
+			require.NoError(t, err)
+		},
+		done: func() { close(done) },
+	})
+
+	authNodes(t, node1, node2)
+	require.NoError(t, net.LinkAll())
+
+	sum := sha256.New()
+	require.NoError(t, node1.TransferFrom(ctx, node2.ID(), path, 3, sum))
+	<-done
+
+	assert.EqualValues(t, len(content)-3, size)
+	assert.Equal(t, content[3:], received)
+
+	// The checksum covers the skipped bytes as well.
+	expected := sha256.Sum256(content)
+	assert.Equal(t, expected[:], sum.Sum(nil))
+}
+
+func TestTransferProtocol_TransferFrom_invalidOffset(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	node2.RegisterTransferHandler(&TestTransferHandler{done: func() {}})
+	authNodes(t, node1, node2)
+	require.NoError(t, net.LinkAll())
+
+	assert.Error(t, node1.TransferFrom(ctx, node2.ID(), relTestDir("transfer_file/file"), 1<<30, nil))
+	assert.Error(t, node1.TransferFrom(ctx, node2.ID(), relTestDir("transfer_file/file"), -1, nil))
+	assert.Error(t, node1.TransferFrom(ctx, node2.ID(), relTestDir("transfer_dir"), 1, nil))
+}
+
+func TestTransferProtocol_TransferResume(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "dir")
+	require.NoError(t, os.Mkdir(base, 0o755))
+	files := map[string]string{
+		"done":    "already received",
+		"changed": "changed on the sender",
+		"partial": "partially received",
+		"new":     "not received yet",
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(base, name), []byte(content), 0o644))
+	}
+
+	hash := func(data string) []byte {
+		h := sha256.Sum256([]byte(data))
+		return h[:]
+	}
+	r := &DirResume{
+		Done: map[string][]byte{
+			filepath.Join("dir", "done"):    hash("already received"),
+			filepath.Join("dir", "changed"): hash("old content"),
+		},
+		Partial:     filepath.Join("dir", "partial"),
+		PartialHash: hash("partially"),
+		Offset:      int64(len("partially")),
+	}
+
+	node1, _ := setupNode(t, net)
+
+	p, err := net.GenPeer()
+	require.NoError(t, err)
+	node2 := &Node{Service: service.New("node"), Host: p, PakeProtocol: &PakeProtocol{}}
+	node2.TransferProtocol = NewTransferProtocol(node2)
+
+	headers := map[string]*tar.Header{}
+	received := map[string]string{}
+	done := make(chan struct{})
+	node2.RegisterTransferHandler(&TestTransferHandler{
+		handler: func(hdr *tar.Header, r io.Reader) {
+			data, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			headers[filepath.Base(hdr.Name)] = hdr
+			received[filepath.Base(hdr.Name)] = string(data)
+		},
+		done: func() { close(done) },
+	})
+
+	authNodes(t, node1, node2)
+	require.NoError(t, net.LinkAll())
+
+	sum := sha256.New()
+	require.NoError(t, node1.TransferResume(ctx, node2.ID(), base, r, sum))
+	<-done
+
+	// The checksum covers the files the receiver already has as well.
+	expected, err := Checksum(base, nil)
+	require.NoError(t, err)
+	assert.Equal(t, expected, hex.EncodeToString(sum.Sum(nil)))
+
+	// The unchanged file is skipped.
+	assert.Contains(t, headers["done"].PAXRecords, ResumeSkipRecord)
+	assert.Zero(t, headers["done"].Size)
+	assert.Empty(t, received["done"])
+
+	// The changed file is sent in full.
+	assert.NotContains(t, headers["changed"].PAXRecords, ResumeSkipRecord)
+	assert.Equal(t, files["changed"], received["changed"])
+
+	// The partial file is resumed after the bytes the receiver has.
+	assert.Equal(t, "9", headers["partial"].PAXRecords[ResumeOffsetRecord])
+	assert.Equal(t, " received", received["partial"])
+
+	assert.Equal(t, files["new"], received["new"])
+}
+
+func TestDirResume_resumeAt_changedPartial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(path, []byte("new content"), 0o644))
+
+	hash := sha256.Sum256([]byte("old"))
+	r := &DirResume{Partial: "file", PartialHash: hash[:], Offset: 3}
+
+	offset, skip, err := r.resumeAt("file", path, 11)
+	require.NoError(t, err)
+	assert.False(t, skip)
+	assert.Zero(t, offset)
+
+	// The receiver can't have more than the whole file.
+	r.Offset = 20
+	offset, _, err = r.resumeAt("file", path, 11)
+	require.NoError(t, err)
+	assert.Zero(t, offset)
+}
+
+func TestDirResume_response(t *testing.T) {
+	r := &DirResume{
+		Done:        map[string][]byte{"dir/a": {1}},
+		Partial:     "dir/b",
+		PartialHash: []byte{2},
+		Offset:      3,
+	}
+
+	resp := p2p.NewPushResponse(true)
+	r.apply(resp)
+	assert.Equal(t, r, DirResumeFromResponse(resp))
+
+	assert.Nil(t, DirResumeFromResponse(p2p.NewPushResponse(true)))
+}
@@ -0,0 +1,165 @@
+package node
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	pb "github.com/libp2p/go-libp2p-core/crypto/pb"
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/pkg/config"
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+// SignatureAlgorithm identifies the only supported push request signature scheme.
+const SignatureAlgorithm = "ed25519"
+
+// ErrInvalidSignature is returned if a push request is not
+// signed by the trusted key.
+var ErrInvalidSignature = errors.New("push request is not signed by the trusted key")
+
+// NewContentHash returns the hash that is calculated over the names
+// and contents of all transferred files.
+func NewContentHash() hash.Hash {
+	return sha256.New()
+}
+
+// WriteContentName adds the name of a tar entry to the given content hash.
+// It must be called before the content of the entry is written to the hash.
+func WriteContentName(h hash.Hash, name string) {
+	h.Write([]byte(filepath.ToSlash(name)))
+	h.Write([]byte{0})
+}
+
+// ContentHash calculates the content hash of the given file or directory
+// in the same order the files are transferred. Entries that match the
+// given Exclude are left out like they are during the transfer.
+func ContentHash(basePath string, exclude *Exclude) ([]byte, error) {
+	base, err := os.Stat(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	h := NewContentHash()
+	err = exclude.Walk(basePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name, err := relPath(basePath, base.IsDir(), path)
+		if err != nil {
+			return err
+		}
+		WriteContentName(h, name)
+
+		if info.IsDir() || info.Size() == 0 {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error hashing content")
+	}
+
+	return h.Sum(nil), nil
+}
+
+// LoadSignKey loads the ed25519 private key from the given
+// file. The key is generated if the file does not exist.
+func LoadSignKey(path string) (crypto.PrivKey, error) {
+	key, err := config.LoadIdentity(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.Type() != pb.KeyType_Ed25519 {
+		return nil, fmt.Errorf("sign key must be an ed25519 key")
+	}
+
+	return key, nil
+}
+
+// ParseVerifyKey parses the hex encoded raw ed25519 public key.
+func ParseVerifyKey(s string) (crypto.PubKey, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "verify key must be hex encoded")
+	}
+
+	key, err := crypto.UnmarshalEd25519PublicKey(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid ed25519 verify key")
+	}
+
+	return key, nil
+}
+
+// FormatVerifyKey returns the hex encoded raw public key
+// that the receiver can pass as the verify key.
+func FormatVerifyKey(key crypto.PrivKey) (string, error) {
+	raw, err := key.GetPublic().Raw()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// signaturePayload returns the data of the push request that is signed.
+func signaturePayload(pr *p2p.PushRequest) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(pr.Name)
+	buf.WriteByte(0)
+	_ = binary.Write(&buf, binary.BigEndian, pr.Size)
+	buf.Write(pr.ContentHash)
+	return buf.Bytes()
+}
+
+// SignPushRequest signs the name, size and given content hash of the push request.
+func SignPushRequest(key crypto.PrivKey, pr *p2p.PushRequest, contentHash []byte) error {
+	pr.ContentHash = contentHash
+	pr.SignatureAlgorithm = SignatureAlgorithm
+
+	sig, err := key.Sign(signaturePayload(pr))
+	if err != nil {
+		return errors.Wrap(err, "error signing push request")
+	}
+	pr.Signature = sig
+
+	return nil
+}
+
+// VerifyPushRequest checks that the push request was signed by the given key.
+func VerifyPushRequest(key crypto.PubKey, pr *p2p.PushRequest) error {
+	if len(pr.Signature) == 0 {
+		return errors.Wrap(ErrInvalidSignature, "request is unsigned")
+	}
+
+	if pr.SignatureAlgorithm != SignatureAlgorithm {
+		return errors.Wrapf(ErrInvalidSignature, "unsupported signature algorithm %q", pr.SignatureAlgorithm)
+	}
+
+	ok, err := key.Verify(signaturePayload(pr), pr.Signature)
+	if err != nil {
+		return errors.Wrap(ErrInvalidSignature, err.Error())
+	} else if !ok {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package node
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+func TestSignPushRequest(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	verifyKey, err := FormatVerifyKey(key)
+	require.NoError(t, err)
+
+	pub, err := ParseVerifyKey(verifyKey)
+	require.NoError(t, err)
+
+	pr := p2p.NewPushRequest("file", 1000, false)
+	require.NoError(t, SignPushRequest(key, pr, []byte("hash")))
+	assert.NoError(t, VerifyPushRequest(pub, pr))
+
+	pr.Size = 1001
+	assert.Error(t, VerifyPushRequest(pub, pr))
+}
+
+func TestVerifyPushRequest_untrusted(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	_, other, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	pr := p2p.NewPushRequest("file", 1000, false)
+	assert.Error(t, VerifyPushRequest(other, pr))
+
+	require.NoError(t, SignPushRequest(key, pr, []byte("hash")))
+	assert.Error(t, VerifyPushRequest(other, pr))
+}
+
+func TestParseVerifyKey_invalid(t *testing.T) {
+	_, err := ParseVerifyKey("not hex")
+	assert.Error(t, err)
+
+	_, err = ParseVerifyKey("abcd")
+	assert.Error(t, err)
+}
+
+func TestContentHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "dir")
+	require.NoError(t, os.Mkdir(base, 0o755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(base, "file"), []byte("content"), 0o644))
+
+	h := sha256.New()
+	WriteContentName(h, "dir")
+	WriteContentName(h, "dir/file")
+	h.Write([]byte("content"))
+
+	hash, err := ContentHash(base, nil)
+	require.NoError(t, err)
+	assert.Equal(t, h.Sum(nil), hash)
+}
@@ -0,0 +1,63 @@
+package node
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// Timeline prints the stages the discovery and advertisement mechanisms
+// go through together with the time each stage took. This lets the
+// user see where we're stuck if it takes long to find the peer.
+type Timeline struct {
+	lk     sync.Mutex
+	start  time.Time
+	stages map[string]timelineStage
+
+	// seen holds the stages that were already printed per mechanism.
+	// Repeated stages (e.g. retries) are only logged in debug mode.
+	seen map[string]struct{}
+}
+
+type timelineStage struct {
+	name  string
+	since time.Time
+}
+
+// NewTimeline starts a new timeline at the current time.
+func NewTimeline() *Timeline {
+	return &Timeline{
+		start:  time.Now(),
+		stages: map[string]timelineStage{},
+		seen:   map[string]struct{}{},
+	}
+}
+
+// Enter records that the given mechanism has entered the given stage.
+func (t *Timeline) Enter(mechanism string, stage string) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	now := time.Now()
+	prev, found := t.stages[mechanism]
+	if found && prev.name == stage {
+		return
+	}
+	t.stages[mechanism] = timelineStage{name: stage, since: now}
+
+	logf := log.Infof
+	key := mechanism + "/" + stage
+	if _, seen := t.seen[key]; seen {
+		logf = log.Debugf
+	}
+	t.seen[key] = struct{}{}
+
+	elapsed := now.Sub(t.start).Round(100 * time.Millisecond)
+	if found {
+		took := now.Sub(prev.since).Round(100 * time.Millisecond)
+		logf("[%6s] %s: %s (%s took %s)\n", elapsed, mechanism, stage, prev.name, took)
+	} else {
+		logf("[%6s] %s: %s\n", elapsed, mechanism, stage)
+	}
+}
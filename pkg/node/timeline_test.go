@@ -0,0 +1,35 @@
+package node
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+func TestTimeline_Enter(t *testing.T) {
+	var buf bytes.Buffer
+	out := log.Out
+	log.Out = &buf
+	defer func() { log.Out = out }()
+
+	tl := NewTimeline()
+	tl.Enter("DHT", "bootstrapping")
+	tl.Enter("DHT", "bootstrapping") // unchanged stages are ignored
+	tl.Enter("DHT", "looking up providers")
+	tl.Enter("mDNS", "querying")
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 3)
+	assert.Contains(t, string(lines[0]), "DHT: bootstrapping")
+	assert.Contains(t, string(lines[1]), "DHT: looking up providers (bootstrapping took")
+	assert.Contains(t, string(lines[2]), "mDNS: querying")
+
+	// Repeated stages are only logged in debug mode.
+	buf.Reset()
+	tl.Enter("DHT", "waiting before retrying")
+	tl.Enter("DHT", "looking up providers")
+	assert.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("\n")))
+}
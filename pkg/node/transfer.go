@@ -4,19 +4,23 @@ import (
 	"archive/tar"
 	"context"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
-	progress "github.com/schollz/progressbar/v3"
 
+	"github.com/dennis-tra/pcp/internal/format"
 	"github.com/dennis-tra/pcp/internal/log"
 	"github.com/dennis-tra/pcp/pkg/crypt"
+	"github.com/dennis-tra/pcp/pkg/metrics"
 )
 
 // pattern: /protocol-name/request-or-response-message/version
@@ -24,18 +28,101 @@ const (
 	ProtocolTransfer = "/pcp/transfer/0.2.0"
 )
 
+// The chunk size determines the buffer that is used to copy the transferred
+// data. The default corresponds to the buffer size of io.Copy.
+const (
+	DefaultChunkSize = 32 << 10
+	MinChunkSize     = 4 << 10
+	MaxChunkSize     = 16 << 20
+)
+
 // TransferProtocol encapsulates data necessary to fulfill its protocol.
 type TransferProtocol struct {
 	node *Node
 	lk   sync.RWMutex
 	th   TransferHandler
+
+	// timeout is the time after which an incoming transfer is
+	// aborted if no bytes have arrived. Zero disables it.
+	timeout time.Duration
+
+	// chunkSize is the size of the buffer the data is copied with.
+	chunkSize int
+
+	// memory bounds the buffers of concurrent transfers. It's nil if
+	// the memory isn't limited.
+	memory *MemoryBudget
+
+	// exclude leaves entries out of sent directories. It's nil if
+	// all entries are sent.
+	exclude *Exclude
+
+	// xattrs sends the extended attributes of
+	// the files along with their content.
+	xattrs bool
 }
 
+// TransferHandler is called for each received file. If HandleFile returns
+// an error, the transfer is aborted and the sender is notified by
+// resetting the stream.
 type TransferHandler interface {
-	HandleFile(*tar.Header, io.Reader)
+	HandleFile(*tar.Header, io.Reader) error
 	Done()
 }
 
+// TransferErrorHandler can optionally be implemented by a TransferHandler
+// to be notified why an incoming transfer was aborted.
+type TransferErrorHandler interface {
+	HandleTransferError(error)
+}
+
+// SetTransferTimeout configures the time after which an incoming transfer
+// is aborted if no bytes have arrived. Zero disables the timeout.
+func (t *TransferProtocol) SetTransferTimeout(timeout time.Duration) {
+	t.timeout = timeout
+}
+
+// SetExclude leaves the entries that match the given Exclude out
+// of sent directories. Nil sends all entries.
+func (t *TransferProtocol) SetExclude(e *Exclude) {
+	t.exclude = e
+}
+
+// SetXattrs configures whether the extended attributes of sent
+// files are added to their tar entries.
+func (t *TransferProtocol) SetXattrs(enabled bool) {
+	t.xattrs = enabled
+}
+
+// ChunkSize returns the size of the buffer the transferred data is copied with.
+func (t *TransferProtocol) ChunkSize() int {
+	if t.chunkSize == 0 {
+		return DefaultChunkSize
+	}
+	return t.chunkSize
+}
+
+// ValidateChunkSize checks that the given chunk size is within sane
+// bounds. Zero is valid and selects the default chunk size.
+func ValidateChunkSize(size int) error {
+	if size != 0 && (size < MinChunkSize || size > MaxChunkSize) {
+		return fmt.Errorf("chunk size must be between %s and %s", format.Bytes(MinChunkSize), format.Bytes(MaxChunkSize))
+	}
+	return nil
+}
+
+// CopyChunks copies from src to dst in chunks of the given size. Contrary
+// to io.CopyBuffer the buffer is used even if src implements io.WriterTo
+// or dst implements io.ReaderFrom. DefaultChunkSize is used if the
+// given chunk size is not positive.
+func CopyChunks(dst io.Writer, src io.Reader, chunkSize int) (int64, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	buf := make([]byte, chunkSize)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, buf)
+}
+
 func (t *TransferProtocol) RegisterTransferHandler(th TransferHandler) {
 	log.Debugln("Registering transfer handler")
 	t.lk.Lock()
@@ -52,6 +139,22 @@ func (t *TransferProtocol) UnregisterTransferHandler() {
 	t.th = nil
 }
 
+// checkConnection refuses relayed connections in --only-direct mode and
+// connections outside the local network in --lan-only mode. Otherwise
+// it tells the user which kind of connection is used.
+func (t *TransferProtocol) checkConnection(conn network.Conn) error {
+	if t.node.onlyDirect && isRelayed(conn.RemoteMultiaddr()) {
+		return ErrRelayedConnection
+	}
+	if t.node.lanOnly {
+		if err := checkLANConn(conn); err != nil {
+			return err
+		}
+	}
+	reportConnection(conn)
+	return nil
+}
+
 // New TransferProtocol initializes a new TransferProtocol object with all
 // fields set to their default values.
 func NewTransferProtocol(node *Node) *TransferProtocol {
@@ -70,28 +173,92 @@ func (t *TransferProtocol) onTransfer(s network.Stream) {
 		s.Reset() // Tell peer to go away
 		return
 	}
+	if err := t.checkConnection(s.Conn()); err != nil {
+		log.Warningln("Refusing transfer:", err, s.Conn().RemoteMultiaddr())
+		if eh, ok := t.th.(TransferErrorHandler); ok {
+			eh.HandleTransferError(err)
+		}
+		s.Reset()
+		return
+	}
 
-	// Read initialization vector from stream. This is sent first from our peer.
-	iv, err := t.node.ReadBytes(s)
-	if err != nil {
-		log.Warningln("Could not read stream initialization vector", err)
-		s.Reset() // Stream is probably broken anyways
+	// Wait until the buffers of other transfers were released.
+	bufSize := receiveBufferSize(t.ChunkSize())
+	if err := t.memory.Acquire(t.node.ServiceContext(), bufSize); err != nil {
+		log.Warningln("Could not receive transfer:", err)
+		s.Reset()
 		return
 	}
+	defer t.memory.Release(bufSize)
+
+	// Abort the transfer if the bytes stop flowing.
+	var src io.Reader = s
+	if t.timeout > 0 {
+		wd := newWatchdogReader(s, t.timeout)
+		defer func() {
+			if wd.Stalled() {
+				log.Warningln("No data received for", t.timeout)
+				if eh, ok := t.th.(TransferErrorHandler); ok {
+					eh.HandleTransferError(ErrTransferStalled)
+				}
+			}
+		}()
+		src = wd
+	}
 
 	t.lk.RLock()
 	defer func() {
-		if err = s.Close(); err != nil {
+		if err := s.Close(); err != nil {
 			log.Warningln(err)
 		}
 		t.lk.RUnlock()
 	}()
 
+	if err := ReceiveArchive(src, sKey, t.th); err != nil {
+		log.Warningln(err)
+		var aerr *archiveError
+		if errors.As(err, &aerr) && aerr.reset {
+			s.Reset() // Tell the sender that we've stopped reading
+		}
+	}
+}
+
+// ErrDataMismatch is passed to the TransferErrorHandler if the received
+// data doesn't match the hash the sender has appended to it.
+var ErrDataMismatch = errors.New("received data does not match the hash of the sender")
+
+// archiveError is returned by ReceiveArchive. If reset is set the
+// stream is broken or we've stopped reading and should be reset.
+type archiveError struct {
+	msg   string
+	err   error
+	reset bool
+}
+
+func (e *archiveError) Error() string {
+	return e.msg + ": " + e.err.Error()
+}
+
+func (e *archiveError) Unwrap() error {
+	return e.err
+}
+
+// ReceiveArchive reads an encrypted tar ball as written by SendArchive
+// from src and passes each entry to the given handler. It returns an
+// error if the archive is malformed, the handler has aborted the
+// transfer or the data doesn't match the hash the sender appended.
+// It doesn't call Done on the handler.
+func ReceiveArchive(src io.Reader, key []byte, th TransferHandler) error {
+	// Read initialization vector from stream. This is sent first from our peer.
+	iv, err := readBytes(src)
+	if err != nil {
+		return &archiveError{msg: "could not read stream initialization vector", err: err, reset: true}
+	}
+
 	// Decrypt the stream
-	sd, err := crypt.NewStreamDecrypter(sKey, iv, s)
+	sd, err := crypt.NewStreamDecrypter(key, iv, src)
 	if err != nil {
-		log.Warningln("Could not instantiate stream decrypter", err)
-		return
+		return &archiveError{msg: "could not instantiate stream decrypter", err: err}
 	}
 
 	// Drain tar archive
@@ -101,30 +268,62 @@ func (t *TransferProtocol) onTransfer(s network.Stream) {
 		if err == io.EOF {
 			break // End of archive
 		} else if err != nil {
-			log.Warningln("Error reading next tar element", err)
-			return
+			return &archiveError{msg: "error reading next tar element", err: err}
+		}
+		if err = th.HandleFile(hdr, tr); err != nil {
+			if eh, ok := th.(TransferErrorHandler); ok {
+				eh.HandleTransferError(err)
+			}
+			return &archiveError{msg: "aborting transfer", err: err, reset: true}
 		}
-		t.th.HandleFile(hdr, tr)
 	}
 
 	// Read file hash from the stream and check if it matches
-	hash, err := t.node.ReadBytes(s)
+	hash, err := readBytes(src)
 	if err != nil {
-		log.Warningln("Could not read hash", err)
-		return
+		return &archiveError{msg: "could not read hash", err: err}
 	}
 
 	// Check if hashes match
 	if err = sd.Authenticate(hash); err != nil {
-		log.Warningln("Could not authenticate received data", err)
-		return
+		if eh, ok := th.(TransferErrorHandler); ok {
+			eh.HandleTransferError(ErrDataMismatch)
+		}
+		return &archiveError{msg: "could not authenticate received data", err: err}
 	}
+
+	return nil
 }
 
 // Transfer can be called to transfer the given payload to the given peer. The PushRequest is used for displaying
 // the progress to the user. This function returns when the bytes where transmitted and we have received an
 // acknowledgment.
 func (t *TransferProtocol) Transfer(ctx context.Context, peerID peer.ID, basePath string) error {
+	return t.TransferFrom(ctx, peerID, basePath, 0, nil)
+}
+
+// TransferFrom is like Transfer but skips the first offset bytes of a single
+// file, because the receiver already has them from an interrupted transfer.
+// The tar entry of the file only holds the remaining bytes then. If sum
+// isn't nil, the whole file is written to it on the way.
+func (t *TransferProtocol) TransferFrom(ctx context.Context, peerID peer.ID, basePath string, offset int64, sum hash.Hash) error {
+	return t.transferTree(ctx, peerID, basePath, offset, nil, sum)
+}
+
+// TransferResume is like Transfer but leaves out the files of a directory
+// that the receiver already has from an interrupted transfer and resumes
+// the file it was interrupted in. Files whose content has changed since
+// are sent in full. A nil DirResume sends all files. If sum isn't nil,
+// the data is written to it on the way as Checksum hashes it.
+func (t *TransferProtocol) TransferResume(ctx context.Context, peerID peer.ID, basePath string, r *DirResume, sum hash.Hash) error {
+	return t.transferTree(ctx, peerID, basePath, 0, r, sum)
+}
+
+// transferTree sends the file or directory at basePath to the given peer.
+// It skips the first offset bytes of a single file or the files of a
+// directory the receiver already has according to r. The checksum is
+// written to sum if it isn't nil. Skipped contents are read for it.
+func (t *TransferProtocol) transferTree(ctx context.Context, peerID peer.ID, basePath string, offset int64, r *DirResume, sum hash.Hash) error {
 	// Open a new stream to our peer.
 	s, err := t.node.NewStream(ctx, peerID, ProtocolTransfer)
 	if err != nil {
@@ -134,87 +333,284 @@ func (t *TransferProtocol) Transfer(ctx context.Context, peerID peer.ID, basePat
 	defer s.Close()
 	defer t.node.ResetOnShutdown(s)()
 
+	if err = t.checkConnection(s.Conn()); err != nil {
+		s.Reset()
+		return err
+	}
+
 	base, err := os.Stat(basePath)
 	if err != nil {
 		return err
 	}
 
-	// Get PAKE session key for stream encryption
-	sKey, found := t.node.GetSessionKey(peerID)
-	if !found {
-		return fmt.Errorf("session key not found to encrypt data transfer")
+	if offset < 0 || offset > base.Size() || (offset > 0 && base.IsDir()) {
+		return fmt.Errorf("invalid resume offset %d for %s", offset, basePath)
 	}
 
-	// Initialize new stream encrypter
-	se, err := crypt.NewStreamEncrypter(sKey, s)
+	// Attributes that can't be read are only warned about once.
+	warnedXattrs := false
+
+	return t.writeArchive(ctx, s, peerID, func(tw *tar.Writer) error {
+		return t.exclude.Walk(basePath, func(path string, info os.FileInfo, err error) error {
+			log.Debugln("Preparing file for transmission:", path)
+			if err != nil {
+				log.Debugln("Error walking file:", err)
+				return err
+			}
+
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return errors.Wrapf(err, "error writing tar file info header %s: %s", path, err)
+			}
+
+			// To preserve directory structure in the tar ball.
+			hdr.Name, err = relPath(basePath, base.IsDir(), path)
+			if err != nil {
+				return errors.Wrapf(err, "error building relative path: %s (%v) %s", basePath, base.IsDir(), path)
+			}
+
+			if sum != nil && path != basePath {
+				rel, err := filepath.Rel(basePath, path)
+				if err != nil {
+					return err
+				}
+				WriteContentName(sum, rel)
+			}
+
+			// Only the offset of a single file can be non-zero.
+			offset := offset
+			skip := false
+			if r != nil && info.Mode().IsRegular() {
+				if offset, skip, err = r.resumeAt(hdr.Name, path, info.Size()); err != nil {
+					return err
+				}
+			}
+
+			if t.xattrs && (info.IsDir() || info.Mode().IsRegular()) {
+				if err = AddXattrs(hdr, path); err != nil && !warnedXattrs {
+					log.Warningln("Could not read extended attributes:", err)
+					warnedXattrs = true
+				}
+			}
+
+			// Tell the receiver that it already has (part of) the file.
+			switch {
+			case skip:
+				hdr.Size = 0
+				addPAXRecord(hdr, ResumeSkipRecord, "1")
+			case offset > 0:
+				hdr.Size -= offset
+				addPAXRecord(hdr, ResumeOffsetRecord, strconv.FormatInt(offset, 10))
+			}
+
+			if err = tw.WriteHeader(hdr); err != nil {
+				return errors.Wrap(err, "error writing tar header")
+			}
+
+			if skip {
+				log.Infoln(info.Name(), "(already received)")
+				return hashFile(sum, path, info.Size())
+			}
+
+			if err = hashFile(sum, path, offset); err != nil {
+				return err
+			}
+
+			// Continue as all information was written above with WriteHeader.
+			// This also applies to empty files as there is no content to copy.
+			if info.IsDir() || hdr.Size == 0 {
+				return nil
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return errors.Wrapf(err, "error opening file for taring at: %s", path)
+			}
+			defer f.Close()
+
+			if offset > 0 {
+				if _, err = f.Seek(offset, io.SeekStart); err != nil {
+					return errors.Wrapf(err, "error seeking to resume offset in %s", path)
+				}
+			}
+
+			bar := log.NewProgressBar(hdr.Size, info.Name())
+			dst := io.MultiWriter(tw, bar)
+			if sum != nil {
+				dst = io.MultiWriter(tw, bar, sum)
+			}
+			n, err := CopyChunks(dst, f, t.ChunkSize())
+			metrics.BytesTransferred.WithLabelValues(metrics.DirectionSent).Add(float64(n))
+			return err
+		})
+	})
+}
+
+// hashFile writes the first n bytes of the file at the given path to sum.
+// It's a no-op if sum is nil. The receiver already has these bytes, so
+// they aren't read while they're sent.
+func hashFile(sum hash.Hash, path string, n int64) error {
+	if sum == nil || n == 0 {
+		return nil
+	}
+
+	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	// Send the encryption initialization vector to our peer.
-	// Does not need to be encrypted, just unique.
-	// https://en.wikipedia.org/wiki/Block_cipher_mode_of_operation#Initialization_vector_.28IV.29
-	_, err = t.node.WriteBytes(s, se.InitializationVector())
+	if _, err = io.CopyN(sum, f, n); err != nil {
+		return errors.Wrapf(err, "error hashing %s", path)
+	}
+	return nil
+}
+
+// TransferReader sends size bytes from r to the given peer as a single
+// file with the given name. It's used for data that doesn't come from
+// the local disk. It fails if r ends before size bytes were read.
+func (t *TransferProtocol) TransferReader(ctx context.Context, peerID peer.ID, name string, size int64, modTime time.Time, r io.Reader) error {
+	s, err := t.node.NewStream(ctx, peerID, ProtocolTransfer)
 	if err != nil {
 		return err
 	}
 
-	tw := tar.NewWriter(se)
-	err = filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
-		log.Debugln("Preparing file for transmission:", path)
-		if err != nil {
-			log.Debugln("Error walking file:", err)
-			return err
-		}
+	defer s.Close()
+	defer t.node.ResetOnShutdown(s)()
 
-		hdr, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return errors.Wrapf(err, "error writing tar file info header %s: %s", path, err)
-		}
+	if err = t.checkConnection(s.Conn()); err != nil {
+		s.Reset()
+		return err
+	}
 
-		// To preserve directory structure in the tar ball.
-		hdr.Name, err = relPath(basePath, base.IsDir(), path)
-		if err != nil {
-			return errors.Wrapf(err, "error building relative path: %s (%v) %s", basePath, base.IsDir(), path)
+	err = t.writeArchive(ctx, s, peerID, func(tw *tar.Writer) error {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     size,
+			Mode:     0o644,
+			ModTime:  modTime,
 		}
-
-		if err = tw.WriteHeader(hdr); err != nil {
+		if err := tw.WriteHeader(hdr); err != nil {
 			return errors.Wrap(err, "error writing tar header")
 		}
 
-		// Continue as all information was written above with WriteHeader.
-		if info.IsDir() {
-			return nil
+		bar := log.NewProgressBar(size, name)
+		n, err := CopyChunks(io.MultiWriter(tw, bar), io.LimitReader(r, size), t.ChunkSize())
+		metrics.BytesTransferred.WithLabelValues(metrics.DirectionSent).Add(float64(n))
+		if err == nil && n < size {
+			err = fmt.Errorf("%s ended early after %s of %s", name, format.Bytes(n), format.Bytes(size))
 		}
+		return err
+	})
+	if err != nil {
+		// Don't let the receiver accept the incomplete file.
+		s.Reset()
+	}
+	return err
+}
 
-		f, err := os.Open(path)
-		if err != nil {
-			return errors.Wrapf(err, "error opening file for taring at: %s", path)
-		}
-		defer f.Close()
+// TransferBenchmark sends size generated bytes to the given peer instead of
+// reading files from disk. It returns the throughput the data was sent with.
+func (t *TransferProtocol) TransferBenchmark(ctx context.Context, peerID peer.ID, size int64) (RateSummary, error) {
+	s, err := t.node.NewStream(ctx, peerID, ProtocolTransfer)
+	if err != nil {
+		return RateSummary{}, err
+	}
 
-		bar := progress.DefaultBytes(info.Size(), info.Name())
-		if _, err = io.Copy(io.MultiWriter(tw, bar), f); err != nil {
-			return err
+	defer s.Close()
+	defer t.node.ResetOnShutdown(s)()
+
+	if err = t.checkConnection(s.Conn()); err != nil {
+		s.Reset()
+		return RateSummary{}, err
+	}
+
+	meter := NewRateMeter()
+	err = t.writeArchive(ctx, s, peerID, func(tw *tar.Writer) error {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     BenchmarkName,
+			Size:     size,
+			Mode:     0o644,
+			ModTime:  time.Now(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return errors.Wrap(err, "error writing tar header")
 		}
 
-		return nil
+		bar := log.NewProgressBar(size, BenchmarkName)
+		n, err := CopyChunks(io.MultiWriter(tw, bar, meter), BenchmarkReader(size), t.ChunkSize())
+		metrics.BytesTransferred.WithLabelValues(metrics.DirectionSent).Add(float64(n))
+		return err
 	})
+	return meter.Summary(), err
+}
+
+// writeArchive encrypts the given transfer stream and lets write add the
+// entries to the tar archive. It returns after the peer has acknowledged
+// that it received all data.
+func (t *TransferProtocol) writeArchive(ctx context.Context, s network.Stream, peerID peer.ID, write func(tw *tar.Writer) error) error {
+	// Wait until the buffers of other transfers were released.
+	bufSize := sendBufferSize(t.ChunkSize())
+	if err := t.memory.Acquire(ctx, bufSize); err != nil {
+		return err
+	}
+	defer t.memory.Release(bufSize)
+
+	// Get PAKE session key for stream encryption
+	sKey, found := t.node.GetSessionKey(peerID)
+	if !found {
+		return fmt.Errorf("session key not found to encrypt data transfer")
+	}
+
+	if err := SendArchive(s, sKey, write); err != nil {
+		return err
+	}
+
+	return t.node.WaitForEOF(s)
+}
+
+// SendArchive writes the tar ball that write produces encrypted with the
+// given key to dst. It's preceded by the initialization vector and followed
+// by the hash of all data, so that ReceiveArchive can authenticate it.
+func SendArchive(dst io.Writer, key []byte, write func(tw *tar.Writer) error) error {
+	// Initialize new stream encrypter
+	se, err := crypt.NewStreamEncrypter(key, dst)
 	if err != nil {
 		return err
 	}
 
+	// Send the encryption initialization vector to our peer.
+	// Does not need to be encrypted, just unique.
+	// https://en.wikipedia.org/wiki/Block_cipher_mode_of_operation#Initialization_vector_.28IV.29
+	if _, err = writeBytes(dst, se.InitializationVector()); err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(se)
+	if err = write(tw); err != nil {
+		return err
+	}
+
 	if err = tw.Close(); err != nil {
 		log.Debugln("Error closing tar ball", err)
 	}
 
 	// Send the hash of all sent data, so our recipient can check the data.
-	_, err = t.node.WriteBytes(s, se.Hash())
-	if err != nil {
+	if _, err = writeBytes(dst, se.Hash()); err != nil {
 		return errors.Wrap(err, "error writing final hash to stream")
 	}
 
-	return t.node.WaitForEOF(s)
+	return nil
+}
+
+// addPAXRecord adds the given record to the PAX records of the header.
+func addPAXRecord(hdr *tar.Header, key string, value string) {
+	if hdr.PAXRecords == nil {
+		hdr.PAXRecords = map[string]string{}
+	}
+	hdr.PAXRecords[key] = value
 }
 
 // relPath builds the path structure for the tar archive - this will be the structure as it is received.
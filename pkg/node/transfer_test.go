@@ -2,17 +2,22 @@ package node
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
+	"crypto/rand"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/dennis-tra/pcp/internal/format"
 	"github.com/dennis-tra/pcp/pkg/crypt"
 	"github.com/dennis-tra/pcp/pkg/service"
 )
@@ -23,7 +28,10 @@ type TestTransferHandler struct {
 	done    func()
 }
 
-func (tth *TestTransferHandler) HandleFile(hdr *tar.Header, r io.Reader) { tth.handler(hdr, r) }
+func (tth *TestTransferHandler) HandleFile(hdr *tar.Header, r io.Reader) error {
+	tth.handler(hdr, r)
+	return nil
+}
 
 func (tth *TestTransferHandler) Done() {
 	tth.done()
@@ -40,6 +48,7 @@ func TestTransferProtocol_onTransfer(t *testing.T) {
 		isDir   bool
 	}{
 		{testObj: "transfer_file/file", isDir: false},
+		{testObj: "transfer_file_empty/file", isDir: false},
 		{testObj: "transfer_dir_empty", isDir: true},
 		{testObj: "transfer_dir", isDir: true},
 		{testObj: "transfer_subdir", isDir: true},
@@ -282,3 +291,188 @@ func assertTmpIntegrity(t *testing.T, srcObj string, srcIsDir bool) {
 		assert.Equal(t, tmpWalk.info.IsDir(), testWalk.info.IsDir())
 	}
 }
+
+func TestValidateChunkSize(t *testing.T) {
+	assert.NoError(t, ValidateChunkSize(0))
+	assert.NoError(t, ValidateChunkSize(DefaultChunkSize))
+	assert.NoError(t, ValidateChunkSize(MinChunkSize))
+	assert.NoError(t, ValidateChunkSize(MaxChunkSize))
+	assert.Error(t, ValidateChunkSize(MinChunkSize-1))
+	assert.Error(t, ValidateChunkSize(MaxChunkSize+1))
+}
+
+func TestCopyChunks(t *testing.T) {
+	data := make([]byte, 100_000)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	n, err := CopyChunks(&buf, bytes.NewReader(data), MinChunkSize)
+	require.NoError(t, err)
+	assert.EqualValues(t, len(data), n)
+	assert.Equal(t, data, buf.Bytes())
+}
+
+func TestTransferProtocol_TransferReader(t *testing.T) {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	node1, _ := setupNode(t, net)
+	node2, _ := setupNode(t, net)
+	authNodes(t, node1, node2)
+
+	var received []byte
+	done := make(chan struct{}, 2)
+	node2.RegisterTransferHandler(&TestTransferHandler{
+		handler: func(hdr *tar.Header, r io.Reader) {
+			assert.Equal(t, "remote.txt", hdr.Name)
+			received, _ = ioutil.ReadAll(r)
+		},
+		done: func() { done <- struct{}{} },
+	})
+
+	require.NoError(t, net.LinkAll())
+
+	modTime := time.Now()
+	require.NoError(t, node1.TransferReader(ctx, node2.ID(), "remote.txt", 4, modTime, bytes.NewReader([]byte("data"))))
+	<-done
+	assert.Equal(t, []byte("data"), received)
+
+	// The reader ends before the announced size.
+	err := node1.TransferReader(ctx, node2.ID(), "remote.txt", 8, modTime, bytes.NewReader([]byte("data")))
+	assert.Error(t, err)
+}
+
+// archiveHandler collects the received files and
+// fails like a real handler if the data is cut off.
+type archiveHandler struct {
+	files map[string][]byte
+}
+
+func (h *archiveHandler) HandleFile(hdr *tar.Header, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	h.files[hdr.Name] = data
+	return nil
+}
+
+func (h *archiveHandler) Done() {}
+
+// interceptWriter passes the bytes written to it through mangle, which
+// may change or drop them, before they're written to w.
+type interceptWriter struct {
+	w       io.Writer
+	written int
+	mangle  func(offset int, p []byte) []byte
+}
+
+func (iw *interceptWriter) Write(p []byte) (int, error) {
+	offset := iw.written
+	iw.written += len(p)
+	_, err := iw.w.Write(iw.mangle(offset, append([]byte{}, p...)))
+	return len(p), err
+}
+
+// sendArchivePipe sends the given data as a single file with SendArchive
+// in the background. The written bytes are passed through mangle.
+func sendArchivePipe(t *testing.T, key []byte, data []byte, mangle func(offset int, p []byte) []byte) *io.PipeReader {
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { pr.Close() })
+
+	go func() {
+		err := SendArchive(&interceptWriter{w: pw, mangle: mangle}, key, func(tw *tar.Writer) error {
+			hdr := &tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(data))}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err := tw.Write(data)
+			return err
+		})
+		pw.CloseWithError(err)
+	}()
+
+	return pr
+}
+
+func TestReceiveArchive(t *testing.T) {
+	key, err := crypt.DeriveKey([]byte{}, []byte{})
+	require.NoError(t, err)
+
+	data := make([]byte, 1000)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+
+	unchanged := func(_ int, p []byte) []byte { return p }
+
+	t.Run("success", func(t *testing.T) {
+		th := &archiveHandler{files: map[string][]byte{}}
+		require.NoError(t, ReceiveArchive(sendArchivePipe(t, key, data, unchanged), key, th))
+		assert.Equal(t, data, th.files["file"])
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		// Everything after the first 100 bytes is lost.
+		truncate := func(offset int, p []byte) []byte {
+			if offset >= 100 {
+				return nil
+			} else if offset+len(p) > 100 {
+				return p[:100-offset]
+			}
+			return p
+		}
+
+		th := &archiveHandler{files: map[string][]byte{}}
+		err := ReceiveArchive(sendArchivePipe(t, key, data, truncate), key, th)
+		assert.Error(t, err)
+		assert.Empty(t, th.files)
+	})
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		// Flip a bit in the file content, which comes after
+		// the initialization vector and the tar header.
+		corrupt := func(offset int, p []byte) []byte {
+			if offset <= 700 && 700 < offset+len(p) {
+				p[700-offset] ^= 1
+			}
+			return p
+		}
+
+		th := &archiveHandler{files: map[string][]byte{}}
+		err := ReceiveArchive(sendArchivePipe(t, key, data, corrupt), key, th)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not authenticate received data")
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, err := crypt.DeriveKey([]byte("other"), []byte{})
+		require.NoError(t, err)
+
+		err = ReceiveArchive(sendArchivePipe(t, key, data, unchanged), other, &archiveHandler{files: map[string][]byte{}})
+		assert.Error(t, err)
+	})
+}
+
+// BenchmarkCopyChunks measures the throughput of copying data through
+// a pipe, which resembles a stream, with different chunk sizes.
+func BenchmarkCopyChunks(b *testing.B) {
+	const total = 16 << 20
+	data := make([]byte, total)
+
+	for _, size := range []int{MinChunkSize, DefaultChunkSize, 256 << 10, 1 << 20} {
+		b.Run(format.Bytes(int64(size)), func(b *testing.B) {
+			b.SetBytes(total)
+			for i := 0; i < b.N; i++ {
+				pr, pw := io.Pipe()
+				go func() {
+					_, err := CopyChunks(pw, bytes.NewReader(data), size)
+					pw.CloseWithError(err)
+				}()
+				if _, err := CopyChunks(ioutil.Discard, pr, size); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package node
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/network"
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// PortMappingTimeout is the time we wait for the gateway
+// to map our listen ports after it was found.
+var PortMappingTimeout = 10 * time.Second
+
+// portMapper requests UPnP or NAT-PMP port mappings for the listen ports
+// of the host. The mappings are removed when the host is closed.
+type portMapper struct {
+	mgr basichost.NATManager
+}
+
+// option returns the libp2p option that makes the host map its listen
+// ports and keeps a reference to the NAT manager to report the result.
+func (p *portMapper) option() libp2p.Option {
+	return libp2p.NATManager(func(n network.Network) basichost.NATManager {
+		p.mgr = basichost.NewNATManager(n)
+		return p.mgr
+	})
+}
+
+// report waits until the gateway was searched for and logs the mapped
+// addresses. Nothing but a debug message is logged if there is no
+// gateway, as we fall back to relays and hole punching anyway.
+func (p *portMapper) report(done <-chan struct{}) {
+	if p.mgr == nil {
+		return
+	}
+
+	select {
+	case <-p.mgr.Ready():
+	case <-done:
+		return
+	}
+
+	nat := p.mgr.NAT()
+	if nat == nil {
+		log.Debugln("UPnP - No gateway found that supports UPnP or NAT-PMP")
+		return
+	}
+
+	// The ports are mapped in the background after the gateway was found.
+	timeout := time.After(PortMappingTimeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		mapped := 0
+		for _, m := range nat.Mappings() {
+			if m.ExternalPort() == 0 {
+				continue
+			}
+			mapped++
+			if addr, err := m.ExternalAddr(); err == nil {
+				log.Infof("UPnP - Mapped %s port %d to %s\n", m.Protocol(), m.InternalPort(), addr)
+			}
+		}
+		if mapped > 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timeout:
+			log.Infoln("UPnP - Found a gateway but it didn't map any port")
+			return
+		case <-done:
+			return
+		}
+	}
+}
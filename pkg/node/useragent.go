@@ -0,0 +1,36 @@
+package node
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// BuildVersion is the version of the pcp binary. It's set by the
+// command line tool and ends up in the default user agent.
+var BuildVersion = "dev"
+
+// DefaultUserAgent returns the user agent that is announced to other
+// peers in the libp2p identify exchange if none was configured.
+func DefaultUserAgent() string {
+	return "pcp/" + BuildVersion
+}
+
+// AgentVersion returns the user agent the given peer has announced in
+// the identify exchange. It's empty if the exchange hasn't happened yet.
+func (n *Node) AgentVersion(peerID peer.ID) string {
+	av, err := n.Peerstore().Get(peerID, "AgentVersion")
+	if err != nil {
+		return ""
+	}
+	s, _ := av.(string)
+	return s
+}
+
+// logAgent logs the user agent of the given authenticated peer,
+// which helps to tell pcp versions apart when debugging.
+func (n *Node) logAgent(peerID peer.ID) {
+	if av := n.AgentVersion(peerID); av != "" {
+		log.Debugln("Peer", peerID, "uses", av)
+	}
+}
@@ -0,0 +1,44 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultUserAgent(t *testing.T) {
+	prev := BuildVersion
+	BuildVersion = "v1.2.3+abc"
+	defer func() { BuildVersion = prev }()
+
+	assert.Equal(t, "pcp/v1.2.3+abc", DefaultUserAgent())
+}
+
+func TestNode_AgentVersion(t *testing.T) {
+	ctx := context.Background()
+
+	newNode := func(userAgent string) *Node {
+		opts := DefaultOptions(nil)
+		opts.Homebrew = true
+		opts.UseDHT = false
+		opts.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		opts.UserAgent = userAgent
+		n, err := New(ctx, opts)
+		require.NoError(t, err)
+		t.Cleanup(n.Shutdown)
+		return n
+	}
+
+	node1 := newNode("")
+	node2 := newNode("custom/1.0")
+
+	require.NoError(t, node1.Connect(ctx, peer.AddrInfo{ID: node2.ID(), Addrs: node2.Addrs()}))
+
+	// The identify exchange runs in the background after connecting.
+	assert.Eventually(t, func() bool { return node1.AgentVersion(node2.ID()) == "custom/1.0" }, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return node2.AgentVersion(node1.ID()) == DefaultUserAgent() }, 5*time.Second, 10*time.Millisecond)
+}
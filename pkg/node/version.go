@@ -0,0 +1,43 @@
+package node
+
+import (
+	"fmt"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+// The version strings can be replaced at build time via e.g.:
+// -ldflags "-X github.com/dennis-tra/pcp/pkg/node.ProtocolVersion=${VERSION}"
+var (
+	// ProtocolVersion is sent in the header of every message. Peers
+	// with different protocol versions refuse to talk to each other.
+	ProtocolVersion = "1"
+
+	// DiscoveryVersion is the version of the scheme that
+	// derives the discovery ID from the words and the time.
+	DiscoveryVersion = "1"
+)
+
+// ErrIncompatibleVersion is returned if the peer uses another protocol version.
+type ErrIncompatibleVersion struct {
+	Local  string
+	Remote string
+}
+
+func (e ErrIncompatibleVersion) Error() string {
+	remote := e.Remote
+	if remote == "" {
+		remote = "unknown"
+	}
+	return fmt.Sprintf("incompatible versions: peer uses protocol version %s but we use %s - please make sure both sides run the same pcp version", remote, e.Local)
+}
+
+// checkVersion returns an error if the given message was
+// created by a node with another protocol version.
+func checkVersion(msg p2p.HeaderMessage) error {
+	remote := msg.GetHeader().GetProtocolVersion()
+	if remote != ProtocolVersion {
+		return ErrIncompatibleVersion{Local: ProtocolVersion, Remote: remote}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+func Test_checkVersion(t *testing.T) {
+	msg := p2p.NewPushResponse(true)
+	msg.SetHeader(&p2p.Header{ProtocolVersion: ProtocolVersion})
+	assert.NoError(t, checkVersion(msg))
+
+	msg.SetHeader(&p2p.Header{ProtocolVersion: "0"})
+	assert.Equal(t, ErrIncompatibleVersion{Local: ProtocolVersion, Remote: "0"}, checkVersion(msg))
+
+	msg.SetHeader(&p2p.Header{})
+	err := checkVersion(msg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown")
+}
@@ -0,0 +1,46 @@
+package node
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/pkg/errors"
+)
+
+// ErrTransferStalled is returned if no bytes have arrived
+// within the configured transfer timeout.
+var ErrTransferStalled = errors.New("transfer stalled")
+
+// watchdogReader resets the wrapped stream if a single read blocks
+// longer than the timeout. The timer only runs while we are waiting
+// for bytes, so pauses on our side (e.g. while prompting the user)
+// and slow but progressing transfers don't trigger it.
+type watchdogReader struct {
+	s       network.Stream
+	timeout time.Duration
+	stalled int32
+}
+
+func newWatchdogReader(s network.Stream, timeout time.Duration) *watchdogReader {
+	return &watchdogReader{s: s, timeout: timeout}
+}
+
+func (w *watchdogReader) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(w.timeout, func() {
+		atomic.StoreInt32(&w.stalled, 1)
+		w.s.Reset()
+	})
+	n, err := w.s.Read(p)
+	timer.Stop()
+
+	if err != nil && w.Stalled() {
+		return n, ErrTransferStalled
+	}
+	return n, err
+}
+
+// Stalled returns true if the stream was reset because no bytes arrived in time.
+func (w *watchdogReader) Stalled() bool {
+	return atomic.LoadInt32(&w.stalled) == 1
+}
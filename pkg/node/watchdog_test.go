@@ -0,0 +1,66 @@
+package node
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const watchdogTestProtocol = protocol.ID("/pcp/test/watchdog")
+
+func setupWatchdogStream(t *testing.T, write func(s network.Stream)) network.Stream {
+	ctx := context.Background()
+	net := mocknet.New(ctx)
+
+	h1, err := net.GenPeer()
+	require.NoError(t, err)
+	h2, err := net.GenPeer()
+	require.NoError(t, err)
+	require.NoError(t, net.LinkAll())
+
+	h2.SetStreamHandler(watchdogTestProtocol, write)
+
+	s, err := h1.NewStream(ctx, h2.ID(), watchdogTestProtocol)
+	require.NoError(t, err)
+
+	// Trigger the stream handler.
+	_, err = s.Write([]byte{0})
+	require.NoError(t, err)
+
+	return s
+}
+
+func TestWatchdogReader_stalled(t *testing.T) {
+	s := setupWatchdogStream(t, func(s network.Stream) {
+		time.Sleep(time.Second)
+		s.Close()
+	})
+
+	wd := newWatchdogReader(s, 50*time.Millisecond)
+	_, err := ioutil.ReadAll(wd)
+	assert.Equal(t, ErrTransferStalled, err)
+	assert.True(t, wd.Stalled())
+}
+
+func TestWatchdogReader_slowButProgressing(t *testing.T) {
+	s := setupWatchdogStream(t, func(s network.Stream) {
+		for i := 0; i < 5; i++ {
+			time.Sleep(20 * time.Millisecond)
+			_, _ = s.Write([]byte("data"))
+		}
+		s.Close()
+	})
+
+	wd := newWatchdogReader(s, 100*time.Millisecond)
+	data, err := ioutil.ReadAll(wd)
+	assert.NoError(t, err)
+	assert.Equal(t, "datadatadatadatadata", string(data))
+	assert.False(t, wd.Stalled())
+}
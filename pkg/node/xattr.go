@@ -0,0 +1,53 @@
+package node
+
+import (
+	"archive/tar"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// XattrPrefix is the prefix of the PAX records that carry the extended
+// attributes of a file in the tar ball. GNU tar and bsdtar use it as well.
+const XattrPrefix = "SCHILY.xattr."
+
+// ErrXattrUnsupported is returned if extended attributes can't be read
+// or written on this platform or file system.
+var ErrXattrUnsupported = errors.New("extended attributes are not supported")
+
+// AddXattrs adds the extended attributes of the file at the given path to
+// the tar header. On macOS this includes the resource fork, which is
+// exposed as the com.apple.ResourceFork attribute.
+func AddXattrs(hdr *tar.Header, path string) error {
+	attrs, err := ReadXattrs(path)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range attrs {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords[XattrPrefix+name] = string(value)
+	}
+
+	return nil
+}
+
+// HeaderXattrs returns the names of the extended attributes in the given
+// tar header in lexical order and their values.
+func HeaderXattrs(hdr *tar.Header) ([]string, map[string][]byte) {
+	var names []string
+	attrs := map[string][]byte{}
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, XattrPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, XattrPrefix)
+		names = append(names, name)
+		attrs[name] = []byte(value)
+	}
+	sort.Strings(names)
+	return names, attrs
+}
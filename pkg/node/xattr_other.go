@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package node
+
+// ReadXattrs returns ErrXattrUnsupported as extended
+// attributes are only supported on Linux and macOS.
+func ReadXattrs(path string) (map[string][]byte, error) {
+	return nil, ErrXattrUnsupported
+}
+
+// WriteXattr returns ErrXattrUnsupported as extended
+// attributes are only supported on Linux and macOS.
+func WriteXattr(path string, name string, value []byte) error {
+	return ErrXattrUnsupported
+}
@@ -0,0 +1,40 @@
+package node
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddXattrs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, ioutil.WriteFile(path, []byte("data"), 0o644))
+
+	if err := WriteXattr(path, "user.pcp", []byte("value\x00binary")); err != nil {
+		t.Skip("extended attributes are not supported:", err)
+	}
+
+	hdr := &tar.Header{Name: "file"}
+	require.NoError(t, AddXattrs(hdr, path))
+	assert.Equal(t, "value\x00binary", hdr.PAXRecords[XattrPrefix+"user.pcp"])
+
+	names, attrs := HeaderXattrs(hdr)
+	assert.Equal(t, []string{"user.pcp"}, names)
+	assert.Equal(t, []byte("value\x00binary"), attrs["user.pcp"])
+}
+
+func TestHeaderXattrs_otherRecords(t *testing.T) {
+	hdr := &tar.Header{PAXRecords: map[string]string{
+		"comment":              "ignored",
+		XattrPrefix + "user.b": "2",
+		XattrPrefix + "user.a": "1",
+	}}
+
+	names, attrs := HeaderXattrs(hdr)
+	assert.Equal(t, []string{"user.a", "user.b"}, names)
+	assert.Len(t, attrs, 2)
+}
@@ -0,0 +1,68 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package node
+
+import (
+	"bytes"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReadXattrs returns the extended attributes of the file at the given path.
+// File systems without extended attributes yield none.
+func ReadXattrs(path string) (map[string][]byte, error) {
+	buf, err := xattrBuffer(func(dest []byte) (int, error) { return unix.Listxattr(path, dest) })
+	if err == unix.ENOTSUP {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string][]byte{}
+	for _, name := range bytes.Split(buf, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := xattrBuffer(func(dest []byte) (int, error) { return unix.Getxattr(path, string(name), dest) })
+		if err != nil {
+			return nil, err
+		}
+		attrs[string(name)] = value
+	}
+
+	return attrs, nil
+}
+
+// WriteXattr sets the extended attribute of the file at the given path. It
+// returns ErrXattrUnsupported if the file system doesn't support it.
+func WriteXattr(path string, name string, value []byte) error {
+	err := unix.Setxattr(path, name, value, 0)
+	if err == unix.ENOTSUP {
+		return ErrXattrUnsupported
+	}
+	return err
+}
+
+// xattrBuffer calls get first to find out the required size of the buffer
+// and then to fill it. The size may have changed in between, in which case
+// we start over.
+func xattrBuffer(get func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := get(nil)
+		if err != nil {
+			return nil, err
+		} else if size == 0 {
+			return []byte{}, nil
+		}
+
+		buf := make([]byte, size)
+		size, err = get(buf)
+		if err == unix.ERANGE {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	}
+}
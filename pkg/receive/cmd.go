@@ -1,16 +1,20 @@
 package receive
 
 import (
-	"encoding/hex"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 
 	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/internal/tui"
 	"github.com/dennis-tra/pcp/pkg/config"
-	p2p "github.com/dennis-tra/pcp/pkg/pb"
+	"github.com/dennis-tra/pcp/pkg/dht"
+	"github.com/dennis-tra/pcp/pkg/mdns"
+	"github.com/dennis-tra/pcp/pkg/words"
 )
 
 // Command contains the receive sub-command configuration.
@@ -21,12 +25,233 @@ var Command = &cli.Command{
 	Action:    Action,
 	ArgsUsage: "[WORD-CODE]",
 	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:    "w",
+			Aliases: []string{"receive-word-count"},
+			Usage:   "the number of words the sender has generated (inferred from the given words if not set) - independent of the word count of pcp send",
+			EnvVars: []string{"PCP_RECEIVE_WORD_COUNT"},
+		},
 		&cli.BoolFlag{
 			Name:    "auto-accept",
 			Aliases: []string{"yes", "y"},
 			Usage:   "automatically accept the file transfer",
 			EnvVars: []string{"PCP_AUTO_ACCEPT"},
 		},
+		&cli.StringFlag{
+			Name:    "expect-peer",
+			Usage:   "only authenticate with the sender that has the given peer ID",
+			EnvVars: []string{"PCP_EXPECT_PEER"},
+		},
+		&cli.StringFlag{
+			Name:    "accept-from-file",
+			Usage:   "path to a YAML or JSON file with rules to accept or reject transfers without prompting",
+			EnvVars: []string{"PCP_ACCEPT_FROM_FILE"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "block-ext",
+			Usage:   "reject files with these extensions, e.g. exe,sh,bat (case-insensitive, can be repeated)",
+			EnvVars: []string{"PCP_BLOCK_EXT"},
+		},
+		&cli.BoolFlag{
+			Name:    "block-ext-prompt",
+			Usage:   "ask about files with blocked extensions instead of rejecting them, even with --auto-accept",
+			EnvVars: []string{"PCP_BLOCK_EXT_PROMPT"},
+		},
+		&cli.IntFlag{
+			Name:    "collision-threshold",
+			Usage:   "warn about a channel collision if more than this number of peers fail authentication (0 disables)",
+			EnvVars: []string{"PCP_COLLISION_THRESHOLD"},
+			Value:   2,
+		},
+		&cli.IntFlag{
+			Name:    "max-auth-failures",
+			Usage:   "give up after this number of peers failed authentication (0 means unlimited)",
+			EnvVars: []string{"PCP_MAX_AUTH_FAILURES"},
+		},
+		&cli.BoolFlag{
+			Name:    "keep-alive",
+			Usage:   "wait for the next sender after a transfer has finished instead of exiting",
+			EnvVars: []string{"PCP_KEEP_ALIVE"},
+		},
+		&cli.BoolFlag{
+			Name:    "force",
+			Aliases: []string{"f"},
+			Usage:   "overwrite existing files without asking",
+			EnvVars: []string{"PCP_FORCE"},
+		},
+		&cli.BoolFlag{
+			Name:    "skip-existing",
+			Usage:   "keep existing files and skip the received ones without asking",
+			EnvVars: []string{"PCP_SKIP_EXISTING"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "allow-subnet",
+			Usage:   "only connect to peers in the given subnet in CIDR notation (can be repeated)",
+			EnvVars: []string{"PCP_ALLOW_SUBNET"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "deny-subnet",
+			Usage:   "never connect to peers in the given subnet in CIDR notation, takes precedence over --allow-subnet (can be repeated)",
+			EnvVars: []string{"PCP_DENY_SUBNET"},
+		},
+		&cli.DurationFlag{
+			Name:    "dht-backoff-initial",
+			Usage:   "initial waiting time between repeated DHT lookups, doubled after each lookup without result (0 disables)",
+			EnvVars: []string{"PCP_DHT_BACKOFF_INITIAL"},
+			Value:   dht.RetryBackoffInitial,
+		},
+		&cli.DurationFlag{
+			Name:    "dht-backoff-max",
+			Usage:   "maximum waiting time between repeated DHT lookups",
+			EnvVars: []string{"PCP_DHT_BACKOFF_MAX"},
+			Value:   dht.RetryBackoffMax,
+		},
+		&cli.IntFlag{
+			Name:    "dht-provider-limit",
+			Usage:   "end a DHT lookup after this many providers were found (0 means unlimited)",
+			EnvVars: []string{"PCP_DHT_PROVIDER_LIMIT"},
+			Value:   dht.DefaultProviderLimit,
+		},
+		&cli.DurationFlag{
+			Name:    "dht-hint-after",
+			Usage:   "print a hint if the DHT is reachable but no sender was found after this time (0 disables it)",
+			EnvVars: []string{"PCP_DHT_HINT_AFTER"},
+			Value:   DefaultDHTHintAfter,
+		},
+		&cli.IntFlag{
+			Name:    "dht-concurrent-lookups",
+			Usage:   "how many DHT lookups of different time slots run at once, e.g. with --tolerant-clock - the current slot is never held back (0 means unlimited)",
+			EnvVars: []string{"PCP_DHT_CONCURRENT_LOOKUPS"},
+			Value:   dht.DefaultConcurrentLookups,
+		},
+		&cli.DurationFlag{
+			Name:    "mdns-dedup-ttl",
+			Usage:   "ignore repeated mDNS responses of a peer with unchanged addresses for this long (0 handles every response)",
+			EnvVars: []string{"PCP_MDNS_DEDUP_TTL"},
+			Value:   mdns.DefaultDedupTTL,
+		},
+		&cli.StringFlag{
+			Name:    "on-complete",
+			Usage:   "run this command after a successful receive, e.g. \"unzip {path}\" - supports {path}, {name} (the local name, ./ prefixed if it starts with a dash), {size} and {peer}",
+			EnvVars: []string{"PCP_ON_COMPLETE"},
+		},
+		&cli.BoolFlag{
+			Name:    "on-complete-strict",
+			Usage:   "exit with an error if the --on-complete command fails",
+			EnvVars: []string{"PCP_ON_COMPLETE_STRICT"},
+		},
+		&cli.IntFlag{
+			Name:    "concurrent-dials",
+			Usage:   "maximum number of discovered peers to connect to and authenticate at the same time (0 means unlimited)",
+			EnvVars: []string{"PCP_CONCURRENT_DIALS"},
+			Value:   8,
+		},
+		&cli.DurationFlag{
+			Name:    "connect-timeout",
+			Usage:   "give up connecting to a discovered peer after this time (0 disables)",
+			EnvVars: []string{"PCP_CONNECT_TIMEOUT"},
+			Value:   15 * time.Second,
+		},
+		&cli.DurationFlag{
+			Name:    "pake-timeout",
+			Usage:   "give up authenticating a peer that didn't complete the key exchange within this time (0 disables)",
+			EnvVars: []string{"PCP_PAKE_TIMEOUT"},
+			Value:   30 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:    "verify-key",
+			Usage:   "only accept transfers signed by the given hex encoded ed25519 public key",
+			EnvVars: []string{"PCP_VERIFY_KEY"},
+		},
+		&cli.DurationFlag{
+			Name:    "transfer-timeout",
+			Usage:   "abort the transfer if no data arrived for this long, e.g. 30s (0 disables)",
+			EnvVars: []string{"PCP_TRANSFER_TIMEOUT"},
+		},
+		&cli.BoolFlag{
+			Name:    "mdns-keep-local-addrs",
+			Usage:   "also dial loopback and link-local addresses of peers found via mDNS if LAN addresses are available",
+			EnvVars: []string{"PCP_MDNS_KEEP_LOCAL_ADDRS"},
+		},
+		&cli.BoolFlag{
+			Name:    "no-mdns-public-filter",
+			Usage:   "keep public addresses of peers found via mDNS, e.g. in LANs that use public address ranges",
+			EnvVars: []string{"PCP_NO_MDNS_PUBLIC_FILTER"},
+		},
+		&cli.BoolFlag{
+			Name:    "preserve",
+			Usage:   "restore the permissions, modification times and, where possible, ownership of the received files",
+			EnvVars: []string{"PCP_PRESERVE"},
+		},
+		&cli.BoolFlag{
+			Name:    "tolerant-clock",
+			Usage:   "also look in adjacent time slots in case the clocks of both machines differ by up to 10 minutes",
+			EnvVars: []string{"PCP_TOLERANT_CLOCK"},
+		},
+		&cli.BoolFlag{
+			Name:    "no-offset-discovery",
+			Usage:   "only look in the current time slot, e.g. if the sender was started right before (halves the discovery traffic) - can't be combined with --tolerant-clock",
+			EnvVars: []string{"PCP_NO_OFFSET_DISCOVERY"},
+		},
+		&cli.BoolFlag{
+			Name:    "mdns-fallback",
+			Usage:   "continue with mDNS only if the DHT is unreachable and fail once all discovery mechanisms have failed",
+			EnvVars: []string{"PCP_MDNS_FALLBACK"},
+		},
+		&cli.IntFlag{
+			Name:    "max-retries",
+			Usage:   "give up after each discovery mechanism has looked for the sender this many times without a result, e.g. in CI (0 retries forever)",
+			EnvVars: []string{"PCP_MAX_RETRIES"},
+		},
+		&cli.StringFlag{
+			Name:    "history-file",
+			Usage:   "append a JSON record of each completed or failed transfer to the given file",
+			EnvVars: []string{"PCP_HISTORY_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "resume-dir",
+			Usage:   "directory single files and the state of directories are kept in until they're complete, so that interrupted transfers can be resumed (empty disables resuming)",
+			EnvVars: []string{"PCP_RESUME_DIR"},
+			Value:   DefaultResumeDir(),
+		},
+		&cli.DurationFlag{
+			Name:    "resume-ttl",
+			Usage:   "discard partial transfers in the resume directory that weren't touched for this long (0 keeps them)",
+			EnvVars: []string{"PCP_RESUME_TTL"},
+			Value:   DefaultResumeTTL,
+		},
+		&cli.BoolFlag{
+			Name:    "pick",
+			Usage:   "choose the sender if multiple senders authenticate instead of using the first one",
+			EnvVars: []string{"PCP_PICK"},
+		},
+		&cli.DurationFlag{
+			Name:    "pick-window",
+			Usage:   "how long to wait for further senders after the first one with --pick",
+			EnvVars: []string{"PCP_PICK_WINDOW"},
+			Value:   5 * time.Second,
+		},
+		&cli.DurationFlag{
+			Name:    "pick-timeout",
+			Usage:   "how long to wait for your choice with --pick before the sender with the best connection is used",
+			EnvVars: []string{"PCP_PICK_TIMEOUT"},
+			Value:   30 * time.Second,
+		},
+		&cli.BoolFlag{
+			Name:    "manifest-only",
+			Usage:   "only write what the sender offers as JSON and decline the transfer",
+			EnvVars: []string{"PCP_MANIFEST_ONLY"},
+		},
+		&cli.StringFlag{
+			Name:    "manifest-out",
+			Usage:   "file the --manifest-only report is appended to (default: stdout)",
+			EnvVars: []string{"PCP_MANIFEST_OUT"},
+		},
+		&cli.BoolFlag{
+			Name:    "confirm-words",
+			Usage:   "show the parsed words and the channel they map to and ask for confirmation before searching",
+			EnvVars: []string{"PCP_CONFIRM_WORDS"},
+		},
 	},
 	Description: `The receive subcommand starts searching for peers in your local 
 network by sending out multicast DNS queries. These queries are
@@ -50,46 +275,103 @@ After the authentication was successful you need to confirm the
 file transfer. The confirmation dialog shows the name and size of
 the file.
 
-The file will be saved to your current working directory overwriting
-any files with the same name. If the transmission fails the file 
-will contain the partial written bytes.`,
+The file will be saved to your current working directory. If a file
+with the same name already exists you are asked whether it should
+be overwritten, skipped or saved under a new name. Use --force or
+--skip-existing to decide this upfront.
+
+Interrupted single files are kept in --resume-dir and resumed later.
+Interrupted directories are resumed from a hidden staging directory.
+
+With --keep-alive the receiver waits for the next sender after a
+transfer has finished. In this mode existing files are not over-
+written but the received data is saved under a new name.
+
+With --pick the receiver authenticates all senders that use the
+same words and lists those whose request arrived within the pick
+window. They are ordered by the quality of the connection: direct
+connections come first, then those with the lowest round trip time.
+You then choose the sender to receive from, or the first one is used
+if you don't choose in time. The remaining senders are rejected.
+
+With --manifest-only the receiver authenticates the sender, writes
+what it offers as a line of JSON to stdout or --manifest-out and
+declines the transfer. Nothing is written to disk otherwise. The
+report holds the name, size and, for directories, the number of
+files and the top-level entries. The sender exits without an error.
+
+With --confirm-words the receiver prints the words it parsed, their
+count and the identifiers the channel maps to and asks you to confirm
+them before it starts searching. A typo then doesn't go unnoticed
+during a long search. The sender prints the same identifiers with
+--show-channel. The question is skipped with --auto-accept or if
+stdin is no terminal.
+
+With --on-complete a command is run after a successful receive. It
+is not passed to a shell but split into arguments, in which these
+template variables are replaced:
+
+  {path}  absolute path of the received file or directory
+  {name}  name of the received file or directory on this machine,
+          prefixed with ./ if it starts with a dash
+  {size}  size of the transfer in bytes
+  {peer}  peer ID of the sender
+
+The exit status of the command is logged. Use --on-complete-strict
+to let pcp exit with an error if the command fails.`,
 }
 
 // Action is the function that is called when running pcp receive.
 func Action(c *cli.Context) error {
-	c, err := config.FillContext(c)
+	c, err := config.FillContext(c, c.String("config"))
 	if err != nil {
 		return errors.Wrap(err, "failed loading configuration")
 	}
 
-	words := strings.Split(c.Args().First(), "-") // transfer words
+	if c.Bool("print-config") {
+		return config.Print(os.Stdout, c)
+	}
+
+	if c.Bool("list-interfaces") {
+		return mdns.PrintInterfaces(os.Stdout)
+	}
 
-	local, err := InitNode(c, words)
+	// The words may be separated by spaces and thus span multiple arguments.
+	code := strings.Join(c.Args().Slice(), " ")
+	wrds := words.Split(code) // transfer words
+	if len(wrds) == 0 {
+		return fmt.Errorf("please specify the words you received from your peer")
+	}
+
+	local, err := New(c.Context, OptionsFromContext(c, wrds))
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("failed to initialize node"))
 	}
 
+	if err = local.ConfirmWords(); err != nil {
+		local.Shutdown()
+		return err
+	}
+
 	// Search for identifier
-	log.Infof("Looking for peer %s... \n", c.Args().First())
-	local.StartDiscovering(c)
+	log.Infof("Looking for peer %s... \n", code)
+	local.Start()
 
 	// Wait for the user to stop the tool or the transfer to finish.
-	select {
-	case <-c.Done():
-		local.Shutdown()
-		return nil
-	case <-local.SigDone():
-		return nil
+	err = local.Wait(c.Context)
+	if c.Bool("summary") {
+		tui.Show(sessionSummary(local.Summary(), err), tui.DefaultHold)
 	}
+	return err
 }
 
-func printInformation(data *p2p.PushRequest) {
-	log.Infoln("Sending request information:")
-	log.Infoln("\tPeer:\t", data.Header.NodeId)
-	log.Infoln("\tName:\t", data.Name)
-	log.Infoln("\tSize:\t", data.Size)
-	log.Infoln("\tSign:\t", hex.EncodeToString(data.Header.Signature))
-	log.Infoln("\tPubKey:\t", hex.EncodeToString(data.Header.GetNodePubKey()))
+// sessionSummary returns the summary of the last transfer. If there was
+// none, e.g. because no sender was found, it reports the error instead.
+func sessionSummary(s *tui.Summary, err error) *tui.Summary {
+	if s != nil || err == nil {
+		return s
+	}
+	return &tui.Summary{Outcome: "failed: " + err.Error()}
 }
 
 func help() {
@@ -0,0 +1,81 @@
+package receive
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/pkg/discovery"
+)
+
+// ErrWordsNotConfirmed is returned if the user didn't confirm
+// the words with --confirm-words.
+var ErrWordsNotConfirmed = errors.New("words not confirmed, please check them and try again")
+
+// ConfirmWords prints the given words, their count and the channel they
+// map to and asks the user to confirm them before we start searching for
+// the sender. It does nothing if --confirm-words wasn't given, the
+// transfer is accepted automatically or stdin is no terminal.
+func (n *Node) ConfirmWords() error {
+	if !n.confirmWords || n.autoAccept || !n.stdinTerminal {
+		return nil
+	}
+
+	log.Infof("Words (%d): %s\n", len(n.Words), strings.Join(n.Words, "-"))
+	log.Infof("Channel: %d (derived from %q)\n", n.ChanID, n.Words[0])
+	for _, line := range n.describeChannel() {
+		log.Infoln(line)
+	}
+
+	for {
+		log.Infof("Are these words correct? [Y/n] ")
+
+		var l stdinLine
+		select {
+		case l = <-n.stdin():
+		case <-n.SigShutdown():
+			fmt.Fprintln(log.Out)
+			return ErrPromptCancelled
+		}
+
+		if l.err != nil {
+			return l.err
+		} else if !l.ok {
+			fmt.Fprintln(log.Out)
+			return ErrWordsNotConfirmed
+		}
+
+		switch strings.ToLower(strings.TrimSpace(l.text)) {
+		case "", "y", "yes":
+			return nil
+		case "n", "no":
+			return ErrWordsNotConfirmed
+		}
+	}
+}
+
+// describeChannel returns the identifiers the discoverers of the enabled
+// mechanisms derive from our channel in the current time slot. The
+// sender logs the same identifiers with --show-channel.
+func (n *Node) describeChannel() []string {
+	var discoverers []discovery.Discoverer
+	if n.useDHT {
+		discoverers = append(discoverers, n.newDHTDiscoverer())
+	}
+	if n.useMDNS {
+		discoverers = append(discoverers, n.newMDNSDiscoverer())
+	}
+	if n.Rendezvous != nil {
+		discoverers = append(discoverers, n.newRendezvousDiscoverer())
+	}
+
+	var lines []string
+	for _, d := range discoverers {
+		if cd, ok := d.(discovery.ChannelDescriber); ok {
+			lines = append(lines, fmt.Sprintf("Channel %s: %s", d.Mechanism(), cd.DescribeChannel(n.ChanID)))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,44 @@
+package receive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+	"github.com/dennis-tra/pcp/pkg/service"
+)
+
+func TestNode_ConfirmWords(t *testing.T) {
+	tests := []struct {
+		answer string
+		ok     bool
+		want   error
+	}{
+		{answer: "", ok: true, want: nil},
+		{answer: "Y", ok: true, want: nil},
+		{answer: "no", ok: true, want: ErrWordsNotConfirmed},
+		{answer: "", ok: false, want: ErrWordsNotConfirmed},
+	}
+	for _, tt := range tests {
+		n := &Node{Node: &pcpnode.Node{Service: service.New("node"), Words: []string{"word1", "word2", "word3"}}, confirmWords: true, stdinTerminal: true}
+		n.stdinOnce.Do(func() {
+			n.stdinLines = make(chan stdinLine, 1)
+			n.stdinLines <- stdinLine{text: tt.answer, ok: tt.ok}
+		})
+		assert.Equal(t, tt.want, n.ConfirmWords(), tt.answer)
+	}
+}
+
+func TestNode_ConfirmWords_skipped(t *testing.T) {
+	// Nobody is asked, so reading stdin would block.
+	n := &Node{Node: &pcpnode.Node{Words: []string{"word1", "word2", "word3"}}}
+	assert.NoError(t, n.ConfirmWords())
+
+	n.confirmWords = true
+	assert.NoError(t, n.ConfirmWords())
+
+	n.stdinTerminal = true
+	n.autoAccept = true
+	assert.NoError(t, n.ConfirmWords())
+}
@@ -0,0 +1,100 @@
+package receive
+
+import (
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// ConflictAction describes how an incoming file is handled if
+// a file with the same name already exists.
+type ConflictAction int
+
+const (
+	// ConflictPrompt asks the user for every conflicting file.
+	ConflictPrompt ConflictAction = iota
+	// ConflictOverwrite replaces the existing file.
+	ConflictOverwrite
+	// ConflictSkip keeps the existing file and discards the received data.
+	ConflictSkip
+	// ConflictRename saves the received data under a new unique name.
+	ConflictRename
+)
+
+// conflictResolver decides what to do with an incoming file
+// that would replace the existing file at the given path.
+type conflictResolver func(path string) ConflictAction
+
+// conflictAction returns the action that was configured via command line
+// flags. If no flag was given the user is prompted for each conflict, unless
+// transfers are accepted automatically, in which case files are overwritten.
+func (n *Node) conflictAction() ConflictAction {
+	switch {
+	case n.force:
+		return ConflictOverwrite
+	case n.skipExisting:
+		return ConflictSkip
+	case n.autoAccept:
+		return ConflictOverwrite
+	default:
+		return ConflictPrompt
+	}
+}
+
+// promptConflict returns a resolver that asks the user how to handle each
+// conflicting file. Answering with an upper case letter applies the choice
+// to all remaining conflicts of the transfer.
+func (n *Node) promptConflict(peerID peer.ID) conflictResolver {
+	var all *ConflictAction
+	return func(path string) ConflictAction {
+		if all != nil {
+			return *all
+		}
+
+		for {
+			log.Infof("%s already exists. Overwrite, skip or rename? [o,s,r,O,S,R,?] ", path)
+			line, ok, err := n.readLine(peerID)
+			if err == ErrPromptCancelled {
+				return ConflictSkip
+			} else if !ok {
+				// Nobody can answer, so don't destroy the existing file.
+				log.Warningln("failed reading from stdin, skipping", path, err)
+				log.Warningln("pass --force or --skip-existing to decide without asking")
+				return ConflictSkip
+			}
+
+			input := strings.TrimSpace(line)
+			if input == "" {
+				continue
+			}
+
+			if input == "?" {
+				log.Infoln("o: overwrite the existing file")
+				log.Infoln("s: skip this file and keep the existing one")
+				log.Infoln("r: save the file under a new name")
+				log.Infoln("O, S, R: apply the choice to all remaining files")
+				continue
+			}
+
+			var action ConflictAction
+			switch strings.ToLower(input) {
+			case "o":
+				action = ConflictOverwrite
+			case "s":
+				action = ConflictSkip
+			case "r":
+				action = ConflictRename
+			default:
+				log.Infoln("Invalid input")
+				continue
+			}
+
+			if input != strings.ToLower(input) {
+				all = &action
+			}
+			return action
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package receive
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ErrConnection is returned if we couldn't connect to a discovered
+// peer or, with --only-direct, not without a relay.
+type ErrConnection struct {
+	PeerID peer.ID
+	Err    error
+}
+
+func (e ErrConnection) Error() string {
+	return "failed connecting: " + e.Err.Error()
+}
+
+func (e ErrConnection) Unwrap() error {
+	return e.Err
+}
+
+// ErrAuthentication is returned if a peer didn't pass the password
+// authenticated key exchange, e.g. because it used other words.
+type ErrAuthentication struct {
+	PeerID peer.ID
+	Err    error
+}
+
+func (e ErrAuthentication) Error() string {
+	return "failed authentication: " + e.Err.Error()
+}
+
+func (e ErrAuthentication) Unwrap() error {
+	return e.Err
+}
+
+// ErrTruncated is returned if the transfer ended before
+// all announced bytes were received.
+type ErrTruncated struct {
+	Received int64
+	Expected int64
+}
+
+func (e ErrTruncated) Error() string {
+	return fmt.Sprintf("only received %d of %d bytes", e.Received, e.Expected)
+}
+
+// ErrIntegrity is returned if the received data doesn't match the
+// hash of the sender, the signed content hash or, for resumed
+// transfers, the hash of the complete file.
+type ErrIntegrity struct {
+	Err error
+}
+
+func (e ErrIntegrity) Error() string {
+	return e.Err.Error()
+}
+
+func (e ErrIntegrity) Unwrap() error {
+	return e.Err
+}
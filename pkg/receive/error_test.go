@@ -0,0 +1,95 @@
+package receive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+func TestErrors_messages(t *testing.T) {
+	cause := fmt.Errorf("cause")
+
+	connErr := error(ErrConnection{Err: cause})
+	assert.Equal(t, "failed connecting: cause", connErr.Error())
+	assert.True(t, errors.Is(connErr, cause))
+
+	authErr := error(ErrAuthentication{Err: cause})
+	assert.Equal(t, "failed authentication: cause", authErr.Error())
+	assert.True(t, errors.Is(authErr, cause))
+
+	assert.Equal(t, "only received 4 of 8 bytes", ErrTruncated{Received: 4, Expected: 8}.Error())
+}
+
+func TestTransferHandler_check_dataMismatch(t *testing.T) {
+	th := &TransferHandler{}
+	th.HandleTransferError(pcpnode.ErrDataMismatch)
+
+	err := th.check(&p2p.PushRequest{})
+	var ierr ErrIntegrity
+	assert.True(t, errors.As(err, &ierr))
+	assert.True(t, errors.Is(err, pcpnode.ErrDataMismatch))
+}
+
+func TestTransferHandler_check_removesCorruptedFile(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	data := []byte("corrupted")
+	th, err := NewTransferHandler("file.txt", make(chan int64, 1))
+	require.NoError(t, err)
+	hdr := &tar.Header{Name: "file.txt", Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+	require.NoError(t, th.HandleFile(hdr, bytes.NewReader(data)))
+	require.FileExists(t, filepath.Join(dir, "file.txt"))
+
+	th.HandleTransferError(pcpnode.ErrDataMismatch)
+	var ierr ErrIntegrity
+	assert.True(t, errors.As(th.check(&p2p.PushRequest{}), &ierr))
+	assert.NoFileExists(t, filepath.Join(dir, "file.txt"))
+}
+
+func TestTransferHandler_check_contentHash(t *testing.T) {
+	th := &TransferHandler{contentHash: pcpnode.NewContentHash()}
+
+	err := th.check(&p2p.PushRequest{ContentHash: []byte("other")})
+	var ierr ErrIntegrity
+	assert.True(t, errors.As(err, &ierr))
+	assert.EqualError(t, err, "received data does not match the signed content hash")
+}
+
+func TestNode_TransferFinishHandler_truncated(t *testing.T) {
+	opts := DefaultOptions(nil)
+	opts.Homebrew = true
+	opts.UseDHT = false
+	opts.AutoAccept = true
+	opts.ResumeDir = t.TempDir()
+
+	n, err := New(context.Background(), opts)
+	require.NoError(t, err)
+
+	remote, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	done := make(chan int64, 1)
+	n.TransferFinishHandler(remote, &p2p.PushRequest{Name: "file.txt", Size: 8}, &TransferHandler{}, done)
+	done <- 4
+
+	err = n.Wait(context.Background())
+	var terr ErrTruncated
+	require.True(t, errors.As(err, &terr))
+	assert.Equal(t, ErrTruncated{Received: 4, Expected: 8}, terr)
+}
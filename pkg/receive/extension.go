@@ -0,0 +1,86 @@
+package receive
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+// ErrBlockedExtension is returned if a received file has
+// an extension the user has blocked with --block-ext.
+var ErrBlockedExtension = errors.New("blocked file extension")
+
+// extensionSet holds the lower case file extensions without leading dot.
+type extensionSet map[string]struct{}
+
+// parseExtensions builds the set of blocked extensions. Each value may
+// contain a comma separated list. A leading dot and the case are ignored,
+// so "EXE", ".exe" and "exe" are equivalent. It returns nil if no
+// extension is given.
+func parseExtensions(values []string) extensionSet {
+	var set extensionSet
+	for _, value := range values {
+		for _, ext := range strings.Split(value, ",") {
+			ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+			if ext == "" {
+				continue
+			}
+			if set == nil {
+				set = extensionSet{}
+			}
+			set[ext] = struct{}{}
+		}
+	}
+	return set
+}
+
+// blocks returns true if the given file name has a blocked extension.
+// Every suffix after a dot is considered, so that "tar.gz" and "gz" both
+// block "backup.tar.gz". Names without an extension and hidden files
+// like ".bashrc" only match if they contain a further dot.
+func (s extensionSet) blocks(name string) bool {
+	if len(s) == 0 {
+		return false
+	}
+
+	name = strings.ToLower(path.Base(strings.TrimSuffix(name, "/")))
+	name = strings.TrimLeft(name, ".")
+	for {
+		i := strings.Index(name, ".")
+		if i == -1 {
+			return false
+		}
+		name = name[i+1:]
+		if _, found := s[name]; found {
+			return true
+		}
+	}
+}
+
+// blockedNames returns the names of the push request that have a blocked
+// extension. For directories the top-level entries of the manifest are
+// checked, as files further down are only known once they arrive.
+func (s extensionSet) blockedNames(pr *p2p.PushRequest) []string {
+	if len(s) == 0 {
+		return nil
+	}
+
+	if !pr.IsDir {
+		if s.blocks(pr.Name) {
+			return []string{printable(pr.Name)}
+		}
+		return nil
+	}
+
+	var blocked []string
+	for _, entry := range pr.Entries {
+		// Directories carry a trailing slash in the manifest.
+		if !strings.HasSuffix(entry, "/") && s.blocks(entry) {
+			blocked = append(blocked, printable(entry))
+		}
+	}
+	return blocked
+}
@@ -0,0 +1,80 @@
+package receive
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+func TestParseExtensions(t *testing.T) {
+	assert.Nil(t, parseExtensions(nil))
+	assert.Nil(t, parseExtensions([]string{" , "}))
+	assert.Equal(t, extensionSet{"exe": {}, "sh": {}, "tar.gz": {}}, parseExtensions([]string{"EXE, .sh", "tar.gz"}))
+}
+
+func TestExtensionSet_blocks(t *testing.T) {
+	s := parseExtensions([]string{"exe,sh,tar.gz"})
+
+	tests := map[string]bool{
+		"setup.exe":        true,
+		"SETUP.EXE":        true,
+		"dir/run.sh":       true,
+		"backup.tar.gz":    true,
+		"notes.txt":        false,
+		"Makefile":         false,
+		"sh":               false,
+		".sh":              false,
+		".hidden.sh":       true,
+		"archive.gz":       false,
+		"exe/":             false,
+		"trailing.":        false,
+		"a.exe.txt":        false,
+		"program.exe.part": false,
+	}
+	for name, blocked := range tests {
+		assert.Equal(t, blocked, s.blocks(name), name)
+	}
+
+	var empty extensionSet
+	assert.False(t, empty.blocks("setup.exe"))
+}
+
+func TestExtensionSet_blockedNames(t *testing.T) {
+	s := parseExtensions([]string{"exe"})
+
+	assert.Equal(t, []string{"setup.exe"}, s.blockedNames(&p2p.PushRequest{Name: "setup.exe"}))
+	assert.Nil(t, s.blockedNames(&p2p.PushRequest{Name: "notes.txt"}))
+
+	pr := &p2p.PushRequest{Name: "dir", IsDir: true, Entries: []string{"a.exe", "b.txt", "c.exe/", "D.EXE"}}
+	assert.Equal(t, []string{"a.exe", "D.EXE"}, s.blockedNames(pr))
+}
+
+func TestTransferHandler_HandleFile_blockedExtension(t *testing.T) {
+	th := &TransferHandler{blockExt: parseExtensions([]string{"sh"})}
+	hdr := &tar.Header{Name: "dir/sub/run.sh", Typeflag: tar.TypeReg, Mode: 0o644, Size: 2}
+
+	err := th.HandleFile(hdr, bytes.NewReader([]byte("ls")))
+	assert.True(t, errors.Is(err, ErrBlockedExtension))
+}
+
+func TestContentExtension(t *testing.T) {
+	tests := []struct {
+		pr   *p2p.PushRequest
+		want string
+	}{
+		{&p2p.PushRequest{Name: "stdin", ContentType: "text/plain"}, ".txt"},
+		{&p2p.PushRequest{Name: "notes.md", ContentType: "text/plain"}, ""},
+		{&p2p.PushRequest{Name: "stdin"}, ""},
+		{&p2p.PushRequest{Name: "dir", IsDir: true, ContentType: "text/plain"}, ""},
+		{&p2p.PushRequest{Name: "bench", Benchmark: true, ContentType: "text/plain"}, ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, contentExtension(tt.pr), tt.pr.Name)
+	}
+}
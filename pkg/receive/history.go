@@ -0,0 +1,110 @@
+package receive
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// HistoryEntry describes a single completed or failed transfer.
+type HistoryEntry struct {
+	Time     time.Time `json:"time"`
+	PeerID   string    `json:"peer_id"`
+	Name     string    `json:"name,omitempty"`
+	Size     int64     `json:"size"`
+	Received int64     `json:"received"`
+	Success  bool      `json:"success"`
+	Error    string    `json:"error,omitempty"`
+	Source   string    `json:"source,omitempty"`
+	Checksum string    `json:"sha256,omitempty"`
+
+	// Duration is the time from accepting the transfer until it has
+	// finished in seconds. BytesPerSecond is the average throughput.
+	Duration       float64 `json:"duration_s"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
+
+// History appends transfer records as newline-delimited
+// JSON to a file. A nil History discards all records.
+type History struct {
+	lk sync.Mutex
+	f  *os.File
+}
+
+// OpenHistory opens the history file at the given path
+// for appending. The file is created if it doesn't exist.
+func OpenHistory(path string) (*History, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed opening history file")
+	}
+	return &History{f: f}, nil
+}
+
+// Record appends the given entry to the history file. Each record
+// is synced to disk, so that it survives an abrupt exit.
+func (h *History) Record(e HistoryEntry) {
+	if h == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Warningln("error encoding history entry:", err)
+		return
+	}
+
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	if h.f == nil {
+		return
+	}
+
+	if _, err = h.f.Write(append(data, '\n')); err != nil {
+		log.Warningln("error writing history entry:", err)
+		return
+	}
+
+	if err = h.f.Sync(); err != nil {
+		log.Warningln("error syncing history file:", err)
+	}
+}
+
+// Close closes the history file. Subsequent records are discarded.
+func (h *History) Close() {
+	if h == nil {
+		return
+	}
+
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	if h.f == nil {
+		return
+	}
+
+	if err := h.f.Close(); err != nil {
+		log.Warningln("error closing history file:", err)
+	}
+	h.f = nil
+}
+
+// recordFailure appends a failed connection or authentication attempt.
+func (h *History) recordFailure(peerID peer.ID, source string, err error) {
+	h.Record(HistoryEntry{
+		PeerID: peerID.String(),
+		Error:  err.Error(),
+		Source: source,
+	})
+}
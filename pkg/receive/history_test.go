@@ -0,0 +1,58 @@
+package receive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory_Record(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "history.jsonl")
+	h, err := OpenHistory(path)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			h.Record(HistoryEntry{PeerID: "peer", Name: fmt.Sprintf("file-%d", i), Size: 10, Received: 10, Success: true})
+		}(i)
+	}
+	wg.Wait()
+	h.Close()
+
+	// Records after closing are discarded.
+	h.Record(HistoryEntry{Name: "discarded"})
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 10)
+	for _, line := range lines {
+		var e HistoryEntry
+		require.NoError(t, json.Unmarshal([]byte(line), &e))
+		assert.Equal(t, "peer", e.PeerID)
+		assert.True(t, e.Success)
+		assert.False(t, e.Time.IsZero())
+	}
+}
+
+func TestHistory_nil(t *testing.T) {
+	var h *History
+	h.Record(HistoryEntry{Name: "file"})
+	h.Close()
+}
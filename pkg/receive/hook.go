@@ -0,0 +1,170 @@
+package receive
+
+import (
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// ErrHookFailed is returned if the on-complete hook exited unsuccessfully.
+var ErrHookFailed = errors.New("on-complete hook failed")
+
+// hookVarRegex matches the template variables in an on-complete hook.
+var hookVarRegex = regexp.MustCompile(`\{[a-z_]*\}`)
+
+// hookVars holds the values of the template variables of an on-complete hook.
+type hookVars struct {
+	Path string
+
+	// Name is the local name of the received file or directory, which
+	// may differ from the one the sender announced, e.g. after a conflict.
+	Name string
+
+	Size   int64
+	PeerID peer.ID
+}
+
+// values maps the template variables to their values. A name that starts
+// with a dash is prefixed with ./ so that the hook doesn't take it for an
+// option.
+func (v hookVars) values() map[string]string {
+	name := v.Name
+	if strings.HasPrefix(name, "-") {
+		name = "./" + name
+	}
+
+	return map[string]string{
+		"{path}": v.Path,
+		"{name}": name,
+		"{size}": strconv.FormatInt(v.Size, 10),
+		"{peer}": v.PeerID.String(),
+	}
+}
+
+// hook is a command that is run after a file was received successfully.
+// The command is not passed to a shell. Instead, it is split into
+// arguments and the template variables are substituted within each
+// argument. A file name with spaces or shell meta characters thus
+// always ends up as a single, literal argument.
+type hook struct {
+	args []string
+}
+
+// parseHook splits the given command into arguments. Single and double
+// quotes group arguments that contain spaces. Unknown template
+// variables are rejected to catch typos early.
+func parseHook(command string) (*hook, error) {
+	args, err := splitArgs(command)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return nil, errors.New("on-complete hook is empty")
+	}
+
+	known := hookVars{}.values()
+	for _, arg := range args {
+		for _, v := range hookVarRegex.FindAllString(arg, -1) {
+			if _, found := known[v]; !found {
+				return nil, errors.Errorf("unknown template variable %s in on-complete hook (use {path}, {name}, {size} or {peer})", v)
+			}
+		}
+	}
+
+	return &hook{args: args}, nil
+}
+
+// expand returns the arguments of the hook with the given
+// values substituted for the template variables.
+func (h *hook) expand(vars hookVars) []string {
+	values := vars.values()
+	expanded := make([]string, len(h.args))
+	for i, arg := range h.args {
+		expanded[i] = hookVarRegex.ReplaceAllStringFunc(arg, func(v string) string {
+			return values[v]
+		})
+	}
+	return expanded
+}
+
+// run executes the hook and waits for it to exit. Its output is
+// passed through to our own standard output and error.
+func (h *hook) run(vars hookVars) error {
+	args := h.expand(vars)
+	log.Infoln("Running on-complete hook:", strings.Join(args, " "))
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return errors.Wrapf(ErrHookFailed, "exit status %d", exitErr.ExitCode())
+		}
+		return errors.Wrap(ErrHookFailed, err.Error())
+	}
+
+	log.Infoln("On-complete hook finished successfully")
+	return nil
+}
+
+// splitArgs splits the given command line at spaces. Single and double
+// quotes group characters, a backslash escapes the next character
+// outside of single quotes.
+func splitArgs(command string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range command {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape in on-complete hook")
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
@@ -0,0 +1,81 @@
+package receive
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_splitArgs(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{command: "unzip {path}", want: []string{"unzip", "{path}"}},
+		{command: "  cp  {path}   /tmp ", want: []string{"cp", "{path}", "/tmp"}},
+		{command: `echo "received {name}" 'a b'`, want: []string{"echo", "received {name}", "a b"}},
+		{command: `echo a\ b ""`, want: []string{"echo", "a b", ""}},
+		{command: `echo 'unterminated`, wantErr: true},
+		{command: `echo trailing\`, wantErr: true},
+		{command: "", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got, err := splitArgs(tt.command)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_parseHook(t *testing.T) {
+	_, err := parseHook("")
+	assert.Error(t, err)
+
+	_, err = parseHook("unzip {file}")
+	assert.Error(t, err)
+
+	h, err := parseHook("process --name={name} {path} {size} {peer}")
+	require.NoError(t, err)
+
+	// Values with shell meta characters stay a single literal argument.
+	args := h.expand(hookVars{Path: "/tmp/a b; rm -rf ~", Name: "$(reboot)", Size: 42, PeerID: peer.ID("peer")})
+	assert.Equal(t, []string{"process", "--name=$(reboot)", "/tmp/a b; rm -rf ~", "42", peer.ID("peer").String()}, args)
+}
+
+func Test_hook_expand_dashName(t *testing.T) {
+	h, err := parseHook("process {name}")
+	require.NoError(t, err)
+
+	// A name that looks like an option stays a file name.
+	assert.Equal(t, []string{"process", "./-rf"}, h.expand(hookVars{Name: "-rf"}))
+	assert.Equal(t, []string{"process", "./--exec=reboot"}, h.expand(hookVars{Name: "--exec=reboot"}))
+	assert.Equal(t, []string{"process", "file-1.txt"}, h.expand(hookVars{Name: "file-1.txt"}))
+}
+
+func Test_hook_run(t *testing.T) {
+	if _, err := exec.LookPath("true"); err != nil {
+		t.Skip("true is not available")
+	}
+
+	h, err := parseHook("true {path}")
+	require.NoError(t, err)
+	assert.NoError(t, h.run(hookVars{Path: "file"}))
+
+	h, err = parseHook("false")
+	require.NoError(t, err)
+	assert.True(t, errors.Is(h.run(hookVars{}), ErrHookFailed))
+
+	h, err = parseHook("pcp-command-that-does-not-exist")
+	require.NoError(t, err)
+	assert.True(t, errors.Is(h.run(hookVars{}), ErrHookFailed))
+}
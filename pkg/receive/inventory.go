@@ -0,0 +1,108 @@
+package receive
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+// ManifestReport describes what a sender offered in --manifest-only mode.
+type ManifestReport struct {
+	Time        time.Time `json:"time"`
+	PeerID      string    `json:"peer_id"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	IsDir       bool      `json:"is_dir"`
+	Benchmark   bool      `json:"benchmark,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	FileHash    string    `json:"file_hash,omitempty"`
+	TotalFiles  int64     `json:"total_files,omitempty"`
+	EntryCount  int64     `json:"entry_count,omitempty"`
+
+	// Entries are the top-level entries of a directory. The sender
+	// only lists the first ones, EntryCount holds the total number.
+	Entries []string `json:"entries,omitempty"`
+
+	// Signed is true if the push request carries a signature. Verified
+	// is only set if a key to verify it with was given.
+	Signed   bool  `json:"signed"`
+	Verified *bool `json:"verified,omitempty"`
+}
+
+// newManifestReport describes the given push request of the given peer.
+func newManifestReport(peerID string, pr *p2p.PushRequest) ManifestReport {
+	r := ManifestReport{
+		Time:        time.Now(),
+		PeerID:      peerID,
+		Name:        pr.Name,
+		Size:        pr.Size,
+		IsDir:       pr.IsDir,
+		Benchmark:   pr.Benchmark,
+		ContentType: pr.ContentType,
+		TotalFiles:  pr.TotalFiles,
+		EntryCount:  pr.EntryCount,
+		Entries:     pr.Entries,
+		Signed:      len(pr.Signature) > 0,
+	}
+	if len(pr.FileHash) > 0 {
+		r.FileHash = hex.EncodeToString(pr.FileHash)
+	}
+	return r
+}
+
+// manifestWriter writes the manifest reports as newline-delimited
+// JSON to stdout or a file.
+type manifestWriter struct {
+	lk sync.Mutex
+	w  io.Writer
+	f  *os.File
+}
+
+// openManifestWriter opens the given file for appending, so that
+// the reports of several senders in --keep-alive mode are kept.
+// An empty path or "-" writes to stdout.
+func openManifestWriter(path string) (*manifestWriter, error) {
+	if path == "" || path == "-" {
+		return &manifestWriter{w: os.Stdout}, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed opening manifest file")
+	}
+	return &manifestWriter{w: f, f: f}, nil
+}
+
+// Write appends the given report.
+func (m *manifestWriter) Write(r ManifestReport) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if _, err = m.w.Write(append(data, '\n')); err != nil {
+		return errors.Wrap(err, "failed writing manifest")
+	}
+	return nil
+}
+
+// Close closes the manifest file. A nil manifestWriter is a no-op.
+func (m *manifestWriter) Close() {
+	if m == nil || m.f == nil {
+		return
+	}
+
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	_ = m.f.Close()
+}
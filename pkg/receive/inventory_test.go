@@ -0,0 +1,64 @@
+package receive
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+func TestNode_HandlePushRequest_manifestOnly(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "manifest.jsonl")
+
+	opts := DefaultOptions(nil)
+	opts.Homebrew = true
+	opts.UseDHT = false
+	opts.ResumeDir = t.TempDir()
+	opts.ManifestOnly = true
+	opts.ManifestOut = out
+
+	n, err := New(context.Background(), opts)
+	require.NoError(t, err)
+	assert.True(t, n.ManifestOnly())
+
+	remote, err := test.RandPeerID()
+	require.NoError(t, err)
+	pr := &p2p.PushRequest{
+		Header:     &p2p.Header{NodeId: remote.Pretty()},
+		Name:       "photos",
+		Size:       2048,
+		IsDir:      true,
+		TotalFiles: 3,
+		EntryCount: 2,
+		Entries:    []string{"a.jpg", "trip/"},
+	}
+
+	accept, err := n.HandlePushRequest(pr)
+	assert.False(t, accept)
+	assert.NoError(t, err)
+	assert.NoError(t, n.Wait(context.Background()))
+
+	data, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+
+	var report ManifestReport
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &report))
+	assert.Equal(t, remote.Pretty(), report.PeerID)
+	assert.Equal(t, "photos", report.Name)
+	assert.EqualValues(t, 2048, report.Size)
+	assert.True(t, report.IsDir)
+	assert.EqualValues(t, 3, report.TotalFiles)
+	assert.Equal(t, []string{"a.jpg", "trip/"}, report.Entries)
+	assert.False(t, report.Signed)
+	assert.Nil(t, report.Verified)
+}
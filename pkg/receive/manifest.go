@@ -0,0 +1,50 @@
+package receive
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+// manifestDisplayLimit is the maximum number of top-level entries
+// of a directory that we print before asking the user.
+const manifestDisplayLimit = 10
+
+// manifestLines returns the top-level entries of the directory in the
+// given push request. At most limit entries are listed, the remaining
+// ones are summarized in a final line.
+func manifestLines(pr *p2p.PushRequest, limit int) []string {
+	entries := pr.Entries
+	total := int(pr.EntryCount)
+	if total < len(entries) {
+		total = len(entries)
+	}
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	lines := make([]string, 0, len(entries)+1)
+	for _, entry := range entries {
+		lines = append(lines, printable(entry))
+	}
+
+	if more := total - len(entries); more > 0 {
+		lines = append(lines, fmt.Sprintf("... and %d more", more))
+	}
+
+	return lines
+}
+
+// printable replaces all characters of the given name that could mess
+// with the terminal. The entries are chosen by the sender after all.
+func printable(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsPrint(r) {
+			return r
+		}
+		return '?'
+	}, name)
+}
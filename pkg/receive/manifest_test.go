@@ -0,0 +1,26 @@
+package receive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+func TestManifestLines(t *testing.T) {
+	pr := &p2p.PushRequest{Entries: []string{"a", "b/", "c"}, EntryCount: 3}
+	assert.Equal(t, []string{"a", "b/", "c"}, manifestLines(pr, 10))
+	assert.Equal(t, []string{"a", "b/", "... and 1 more"}, manifestLines(pr, 2))
+
+	// The sender truncated the list of entries
+	pr = &p2p.PushRequest{Entries: []string{"a", "b/"}, EntryCount: 120}
+	assert.Equal(t, []string{"a", "b/", "... and 118 more"}, manifestLines(pr, 10))
+
+	assert.Empty(t, manifestLines(&p2p.PushRequest{}, 10))
+}
+
+func TestManifestLines_printable(t *testing.T) {
+	pr := &p2p.PushRequest{Entries: []string{"evil\x1b[2J"}, EntryCount: 1}
+	assert.Equal(t, []string{"evil?[2J"}, manifestLines(pr, 10))
+}
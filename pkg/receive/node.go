@@ -1,23 +1,44 @@
 package receive
 
 import (
-	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
-
-	"github.com/urfave/cli/v2"
+	"sync/atomic"
+	"time"
 
 	"github.com/dennis-tra/pcp/internal/format"
 	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/internal/tui"
 	"github.com/dennis-tra/pcp/pkg/dht"
+	"github.com/dennis-tra/pcp/pkg/discovery"
 	"github.com/dennis-tra/pcp/pkg/mdns"
+	"github.com/dennis-tra/pcp/pkg/metrics"
 	pcpnode "github.com/dennis-tra/pcp/pkg/node"
 	p2p "github.com/dennis-tra/pcp/pkg/pb"
+	"github.com/dennis-tra/pcp/pkg/rendezvous"
+	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/pkg/errors"
 )
 
+// discoveryHintAfter is the time after which we print hints
+// why the peer discovery might not be successful.
+var discoveryHintAfter = 2 * time.Minute
+
+// ClockSkewSlots is the number of adjacent time slots in each
+// direction we additionally look in with --tolerant-clock.
+const ClockSkewSlots = 2
+
+// cancelTimeout is the time we wait for the sender to
+// acknowledge that we have cancelled the transfer.
+var cancelTimeout = 3 * time.Second
+
 type PeerState uint8
 
 const (
@@ -26,71 +47,583 @@ const (
 	Connected
 	FailedConnecting
 	FailedAuthentication
+	Skipped
+	TimedOut
 )
 
+// peerInfo tracks the connection state of a discovered peer together
+// with the addresses we have used in our last connection attempt.
+type peerInfo struct {
+	state PeerState
+	addrs []ma.Multiaddr
+}
+
 type Node struct {
 	*pcpnode.Node
 
 	autoAccept  bool
-	discoverers []Discoverer
-	peerStates  *sync.Map // TODO: Use PeerStore?
-}
+	acceptRules []AcceptRule
+	discoverers []discovery.Discoverer
+
+	// blockExt holds the file extensions that are rejected. If
+	// blockExtPrompt is set, the user is asked instead, even
+	// if the transfer would be accepted automatically.
+	blockExt       extensionSet
+	blockExtPrompt bool
+
+	// Which discovery mechanisms should be used.
+	useDHT  bool
+	useMDNS bool
+
+	// keepAlive indicates whether the node should wait for the
+	// next sender after a transfer has finished.
+	keepAlive bool
+
+	// force and skipExisting determine how received files are
+	// handled that already exist. If none is set the user is asked.
+	force        bool
+	skipExisting bool
+
+	// expectedPeer is the peer ID the user expects the sender to
+	// have. If it's empty any peer is accepted.
+	expectedPeer peer.ID
+
+	// subnets restricts the addresses of discovered peers
+	// we attempt to connect to.
+	subnets *SubnetFilter
+
+	// mdnsKeepLocalAddrs keeps loopback and link-local
+	// addresses of peers found via mDNS.
+	mdnsKeepLocalAddrs bool
+
+	// mdnsNoPublicFilter keeps public addresses of peers found via mDNS.
+	mdnsNoPublicFilter bool
+
+	// preserve restores the modes, modification times
+	// and ownership of the received files.
+	preserve bool
+
+	// tolerantClock also looks in adjacent time slots in
+	// case the clocks of both peers differ.
+	tolerantClock bool
+
+	// noOffsetDiscovery only looks in the current time slot.
+	noOffsetDiscovery bool
+
+	// peersFound counts the peers the discoverers have found. If it stays
+	// zero, the clocks of both peers likely differ too much.
+	peersFound int32
+
+	// mdnsFallback continues with mDNS if the DHT is unavailable and
+	// fails the node once no discoverer is left.
+	mdnsFallback bool
+
+	// maxRetries is the number of rounds without a result
+	// after which each discoverer gives up.
+	maxRetries int
+
+	// dhtBackoffInitial and dhtBackoffMax configure the waiting
+	// time between repeated DHT provider lookups.
+	dhtBackoffInitial time.Duration
+	dhtBackoffMax     time.Duration
+
+	// dhtProviderLimit ends a DHT lookup after this many providers.
+	dhtProviderLimit int
+
+	// dhtHintAfter is the time without any provider after which
+	// the user gets a hint. Zero disables it.
+	dhtHintAfter time.Duration
+
+	// dhtLookups bounds the concurrent lookups of the DHT
+	// discoverers. It's nil if there is no limit.
+	dhtLookups *dht.LookupLimiter
+
+	// mdnsDedupTTL is the time repeated mDNS responses are ignored.
+	mdnsDedupTTL time.Duration
+
+	// onComplete is run after a file was received successfully. If
+	// onCompleteStrict is set, a failing hook fails the receive.
+	onComplete       *hook
+	onCompleteStrict bool
 
-type Discoverer interface {
-	Discover(chanID int, handler func(info peer.AddrInfo)) error
-	Shutdown()
+	// dialSem limits the number of concurrent connection and
+	// authentication attempts. It's nil if there is no limit.
+	dialSem chan struct{}
+
+	// connectTimeout is the time we try to connect to a
+	// discovered peer. Zero disables the timeout.
+	connectTimeout time.Duration
+
+	// keyExchangeTimeout is the time a peer has to complete
+	// the key exchange. Zero disables the timeout.
+	keyExchangeTimeout time.Duration
+
+	// peerStatesLk serializes the check and update of a peer's state,
+	// so that a peer that's found by two discoverers at the same
+	// time is only connected once.
+	peerStatesLk sync.Mutex
+	peerStates   *sync.Map // TODO: Use PeerStore?
+
+	// authFailures counts the distinct peers that failed authentication.
+	// If it exceeds collisionThreshold we warn about a channel collision.
+	authFailures       int32
+	collisionThreshold int32
+	collisionWarning   sync.Once
+
+	// maxAuthFailures shuts the node down after this many peers
+	// failed authentication. Zero means unlimited.
+	maxAuthFailures int32
+
+	// verifyKey is the public key push requests must be signed with.
+	// Requests are not verified if it's nil.
+	verifyKey crypto.PubKey
+
+	// err holds the reason why the node was shut down prematurely.
+	errLk sync.Mutex
+	err   error
+
+	// history records completed and failed transfers if it's set.
+	history *History
+
+	// resume keeps partially received files to resume interrupted
+	// transfers. It's nil if resuming is disabled.
+	resume *resumeStore
+
+	// peerSource holds the name of the discovery mechanism
+	// that has found the peer we're ultimately connected to.
+	peerSource string
+
+	// picker lets the user choose between multiple authenticated
+	// senders. It's nil if the first sender should be used.
+	picker *peerPicker
+
+	// manifest receives the reports in --manifest-only mode. All
+	// transfers are declined if it's set.
+	manifest *manifestWriter
+
+	// transferPeer is the sender of the running transfer. It's
+	// notified if the user cancels the transfer. The partially
+	// received data of transfer is removed on shutdown.
+	transferLk   sync.Mutex
+	transferPeer peer.ID
+	transfer     *TransferHandler
+
+	// summary describes the outcome of the last transfer.
+	summaryLk sync.Mutex
+	summary   *tui.Summary
+
+	// stdinLines receives the lines read from stdin.
+	stdinOnce  sync.Once
+	stdinLines chan stdinLine
+
+	// stdinTerminal is true if stdin was a terminal at startup. Otherwise
+	// answers can only be piped in and EOF means there is nobody to ask.
+	stdinTerminal bool
+
+	// confirmWords asks the user to confirm the
+	// words before discovery starts.
+	confirmWords bool
 }
 
-func InitNode(c *cli.Context, words []string) (*Node, error) {
-	h, err := pcpnode.New(c, words)
+// ErrTooManyAuthFailures is returned if more peers failed
+// authentication than allowed with --max-auth-failures.
+var ErrTooManyAuthFailures = errors.New("too many authentication failures")
+
+// ErrNoDiscovery is returned with --mdns-fallback or --max-retries
+// if all discovery mechanisms have failed or given up.
+var ErrNoDiscovery = errors.New("all discovery mechanisms failed")
+
+// New initializes a receiving node with the given options. Call
+// Start to search for the sender and Wait to block until the
+// transfer has finished.
+func New(ctx context.Context, opts Options) (*Node, error) {
+	if err := validateWordCount(opts.Words, opts.WordCount, opts.Homebrew); err != nil {
+		return nil, err
+	}
+
+	var expectedPeer peer.ID
+	if opts.ExpectPeer != "" {
+		pid, err := peer.Decode(opts.ExpectPeer)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid expected peer ID")
+		}
+		expectedPeer = pid
+	}
+
+	if opts.Force && opts.SkipExisting {
+		return nil, errors.New("force and skip-existing are mutually exclusive")
+	}
+
+	if opts.TransferTimeout < 0 {
+		return nil, errors.New("the transfer timeout must not be negative")
+	}
+
+	if opts.DHTBackoffInitial < 0 || opts.DHTBackoffMax < opts.DHTBackoffInitial {
+		return nil, errors.New("the DHT backoff must not be negative and the maximum not less than the initial value")
+	}
+
+	var onComplete *hook
+	if opts.OnComplete != "" {
+		h, err := parseHook(opts.OnComplete)
+		if err != nil {
+			return nil, err
+		}
+		onComplete = h
+	}
+
+	if opts.MaxRetries < 0 {
+		return nil, errors.New("the maximum number of discovery retries must not be negative")
+	}
+
+	if opts.ConcurrentDials < 0 {
+		return nil, errors.New("the number of concurrent dials must not be negative")
+	}
+
+	if opts.ConnectTimeout < 0 {
+		return nil, errors.New("the connect timeout must not be negative")
+	}
+
+	if opts.DHTProviderLimit < 0 {
+		return nil, errors.New("the DHT provider limit must not be negative")
+	}
+
+	if opts.DHTHintAfter < 0 {
+		return nil, errors.New("the DHT hint delay must not be negative")
+	}
+
+	if opts.NoOffsetDiscovery && opts.TolerantClock {
+		return nil, errors.New("--no-offset-discovery can't be combined with --tolerant-clock")
+	}
+
+	if opts.DHTConcurrentLookups < 0 {
+		return nil, errors.New("the number of concurrent DHT lookups must not be negative")
+	}
+
+	if opts.ResumeTTL < 0 {
+		return nil, errors.New("the resume TTL must not be negative")
+	}
+
+	var resume *resumeStore
+	if opts.ResumeDir != "" {
+		resume = newResumeStore(opts.ResumeDir, opts.ResumeTTL)
+	}
+
+	var verifyKey crypto.PubKey
+	if opts.VerifyKey != "" {
+		key, err := pcpnode.ParseVerifyKey(opts.VerifyKey)
+		if err != nil {
+			return nil, err
+		}
+		verifyKey = key
+	}
+
+	subnets, err := ParseSubnetFilter(opts.AllowSubnets, opts.DenySubnets)
+	if err != nil {
+		return nil, err
+	}
+
+	var acceptRules []AcceptRule
+	if opts.AcceptFromFile != "" {
+		rules, err := LoadAcceptRules(opts.AcceptFromFile)
+		if err != nil {
+			return nil, err
+		}
+		acceptRules = rules
+	}
+
+	var history *History
+	if opts.HistoryFile != "" {
+		history, err = OpenHistory(opts.HistoryFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var manifest *manifestWriter
+	if opts.ManifestOnly {
+		if manifest, err = openManifestWriter(opts.ManifestOut); err != nil {
+			history.Close()
+			return nil, err
+		}
+	}
+
+	h, err := pcpnode.New(ctx, opts.Options)
 	if err != nil {
+		history.Close()
+		manifest.Close()
 		return nil, err
 	}
 
 	n := &Node{
-		Node:        h,
-		autoAccept:  c.Bool("auto-accept"),
-		peerStates:  &sync.Map{},
-		discoverers: []Discoverer{},
+		Node:         h,
+		autoAccept:   opts.AutoAccept,
+		acceptRules:  acceptRules,
+		useDHT:       opts.UseDHT,
+		useMDNS:      opts.UseMDNS,
+		keepAlive:    opts.KeepAlive,
+		force:        opts.Force,
+		skipExisting: opts.SkipExisting,
+		expectedPeer: expectedPeer,
+		subnets:      subnets,
+		verifyKey:    verifyKey,
+		history:      history,
+		resume:       resume,
+		manifest:     manifest,
+		peerStates:   &sync.Map{},
+		discoverers:  []discovery.Discoverer{},
+
+		blockExt:       parseExtensions(opts.BlockExt),
+		blockExtPrompt: opts.BlockExtPrompt,
+
+		collisionThreshold: int32(opts.CollisionThreshold),
+		maxAuthFailures:    int32(opts.MaxAuthFailures),
+		dhtBackoffInitial:  opts.DHTBackoffInitial,
+		dhtBackoffMax:      opts.DHTBackoffMax,
+		dhtProviderLimit:   opts.DHTProviderLimit,
+		dhtHintAfter:       opts.DHTHintAfter,
+		dhtLookups:         dht.NewLookupLimiter(opts.DHTConcurrentLookups),
+		connectTimeout:     opts.ConnectTimeout,
+		dialSem:            newDialSem(opts.ConcurrentDials),
+		onComplete:         onComplete,
+		onCompleteStrict:   opts.OnCompleteStrict,
+		keyExchangeTimeout: opts.KeyExchangeTimeout,
+		mdnsKeepLocalAddrs: opts.MDNSKeepLocalAddrs,
+		mdnsNoPublicFilter: opts.MDNSNoPublicFilter,
+		mdnsDedupTTL:       opts.MDNSDedupTTL,
+		mdnsFallback:       opts.MDNSFallback,
+		maxRetries:         opts.MaxRetries,
+		preserve:           opts.Preserve,
+		tolerantClock:      opts.TolerantClock,
+		noOffsetDiscovery:  opts.NoOffsetDiscovery,
+
+		stdinTerminal: stdinIsTerminal(),
+		confirmWords:  opts.ConfirmWords,
 	}
 
+	if !n.stdinTerminal && !n.autoAccept && n.acceptRules == nil && n.manifest == nil {
+		log.Warningln("stdin is not a terminal, so transfers can only be confirmed with answers piped to stdin. Pass --auto-accept to accept them without asking.")
+	}
+
+	if opts.Pick {
+		n.picker = newPeerPicker(opts.PickWindow, opts.PickTimeout)
+	}
+
+	n.SetTransferTimeout(opts.TransferTimeout)
 	n.RegisterPushRequestHandler(n)
 
 	return n, nil
 }
 
+// validateWordCount checks that exactly the expected number of words
+// was given, so that we don't search for a peer on the wrong channel.
+// A word count of zero means that any number of words is accepted.
+func validateWordCount(words []string, count int, homebrew bool) error {
+	if count == 0 || homebrew {
+		return nil
+	}
+
+	if count < 3 {
+		return fmt.Errorf("the number of words must not be less than 3")
+	}
+
+	if len(words) != count {
+		return fmt.Errorf("expected %d words but got %d", count, len(words))
+	}
+
+	return nil
+}
+
 func (n *Node) Shutdown() {
+	n.cancelTransfer()
+
+	// Interrupt a running transfer and pending prompts before waiting
+	// for the transfer handler, which only returns after the transfer.
+	n.SignalShutdown()
+
 	n.StopDiscovering()
 	n.UnregisterPushRequestHandler()
 	n.UnregisterTransferHandler()
+	n.discardTransfer()
 	n.Node.Shutdown()
+	n.history.Close()
+	n.manifest.Close()
+}
+
+// Start searches for the sender in the background.
+func (n *Node) Start() {
+	n.StartDiscovering()
+}
+
+// Wait blocks until the transfer has finished or the given context
+// is cancelled, in which case the node is shut down. If we haven't
+// found the sender for a while, possible reasons are printed. It
+// returns an error if the transfer was rejected as untrusted.
+func (n *Node) Wait(ctx context.Context) error {
+	hint := time.After(discoveryHintAfter)
+	for {
+		select {
+		case <-ctx.Done():
+			n.Shutdown()
+			return nil
+		case <-n.SigDone():
+			n.errLk.Lock()
+			defer n.errLk.Unlock()
+			return n.err
+		case <-hint:
+			if n.GetState() == pcpnode.Discovering {
+				n.printDiscoveryHint()
+			}
+		}
+	}
+}
+
+// printDiscoveryHint prints possible reasons why we haven't found our peer yet.
+func (n *Node) printDiscoveryHint() {
+	log.Infoln("Still looking for your peer...")
+	if n.Namespace == "" {
+		log.Infoln("If your peer uses a namespace you need to pass the same --namespace value.")
+	} else {
+		log.Infof("Make sure your peer uses the identical namespace %q.\n", n.Namespace)
+	}
+
+	// Tell "found a peer but couldn't reach it" apart from "found nobody".
+	unreachable := n.unreachablePeers()
+	if len(unreachable) > 0 {
+		log.Infof("Found peers that didn't advertise an address we can dial (%s).\n", strings.Join(unreachable, ", "))
+		log.Infoln("The DHT only uses public and mDNS only private addresses (see --no-mdns-public-filter).")
+	}
+
+	// If we haven't found any peer at all, we're likely looking
+	// in the wrong time slot because the clocks differ.
+	if atomic.LoadInt32(&n.peersFound) > 0 || len(unreachable) > 0 || !n.TimeSlot.IsZero() {
+		return
+	}
+
+	log.Infoln("No peer was found at all. Where we look is derived from the words and the current time,")
+	log.Infoln("so make sure the clocks of both machines are in sync, e.g. via NTP.")
+	if n.tolerantClock {
+		log.Infof("We already tolerate a clock difference of up to %s.\n", time.Duration(ClockSkewSlots)*dht.TruncateDuration)
+	} else if n.noOffsetDiscovery {
+		log.Infoln("With --no-offset-discovery we only look in the current time slot, so the sender must have started in it.")
+	} else {
+		log.Infoln("Pass --tolerant-clock to also look in adjacent time slots.")
+	}
 }
 
-func (n *Node) StartDiscovering(c *cli.Context) {
+// unreachablePeers describes how many peers the current discoverers have
+// found without a dialable address per mechanism, e.g. "2 via DHT".
+func (n *Node) unreachablePeers() []string {
+	counts := map[string]int{}
+	var mechanisms []string
+	for _, d := range n.discoverers {
+		uc, ok := d.(discovery.UnreachableCounter)
+		if !ok || uc.Unreachable() == 0 {
+			continue
+		}
+		if _, found := counts[d.Mechanism()]; !found {
+			mechanisms = append(mechanisms, d.Mechanism())
+		}
+		counts[d.Mechanism()] += uc.Unreachable()
+	}
+
+	var descriptions []string
+	for _, mechanism := range mechanisms {
+		descriptions = append(descriptions, fmt.Sprintf("%d via %s", counts[mechanism], mechanism))
+	}
+	return descriptions
+}
+
+func (n *Node) StartDiscovering() {
 	n.SetState(pcpnode.Discovering)
+	n.discover(n.newDiscoverers())
+}
+
+// newDiscoverers returns the discoverers of all enabled mechanisms.
+// Each mechanism gets a discoverer per time slot we look in.
+func (n *Node) newDiscoverers() []discovery.Discoverer {
+	tl := pcpnode.NewTimeline()
+	offsets := n.slotOffsets()
+	discoverers := []discovery.Discoverer{}
+	if n.useDHT {
+		hint := &sync.Once{}
+		for _, offset := range offsets {
+			label := slotLabel("DHT", offset)
+			d := n.newDHTDiscoverer().SetOffset(offset)
+			if n.dhtHintAfter > 0 {
+				d.SetNoProviderHandler(n.dhtHintAfter, func() { hint.Do(n.noProviderHint) })
+			}
+			n.ReportChannel(label, d)
+			discoverers = append(discoverers, d.SetStageHandler(func(s dht.Stage) { tl.Enter(label, string(s)) }))
+		}
+	}
 
-	if c.Bool("mdns") == c.Bool("dht") {
-		n.discoverers = []Discoverer{
-			dht.NewDiscoverer(n, n.DHT),
-			dht.NewDiscoverer(n, n.DHT).SetOffset(-dht.TruncateDuration),
-			mdns.NewDiscoverer(n.Node),
-			mdns.NewDiscoverer(n.Node).SetOffset(-dht.TruncateDuration),
+	if n.useMDNS {
+		if n.mdnsNoPublicFilter {
+			log.Infoln("mDNS - Keeping public addresses of discovered peers (--no-mdns-public-filter)")
 		}
-	} else if c.Bool("mdns") {
-		n.discoverers = []Discoverer{
-			mdns.NewDiscoverer(n.Node),
-			mdns.NewDiscoverer(n.Node).SetOffset(-dht.TruncateDuration),
+		for _, offset := range offsets {
+			label := slotLabel("mDNS", offset)
+			d := n.newMDNSDiscoverer().SetOffset(offset)
+			n.ReportChannel(label, d)
+			discoverers = append(discoverers, d.SetStageHandler(func(s mdns.Stage) { tl.Enter(label, string(s)) }))
 		}
-	} else if c.Bool("dht") {
-		n.discoverers = []Discoverer{
-			dht.NewDiscoverer(n, n.DHT),
-			dht.NewDiscoverer(n, n.DHT).SetOffset(-dht.TruncateDuration),
+	}
+
+	if n.Rendezvous != nil {
+		for _, offset := range offsets {
+			label := slotLabel("rendezvous", offset)
+			d := n.newRendezvousDiscoverer().SetOffset(offset)
+			n.ReportChannel(label, d)
+			discoverers = append(discoverers, d.SetStageHandler(func(s rendezvous.Stage) { tl.Enter(label, string(s)) }))
 		}
 	}
 
+	return discoverers
+}
+
+// slotOffsets returns the offsets of the time slots we look in. Besides
+// the current slot we look in the previous one for senders that have
+// started before the slot changed. It's not needed if the time slot is
+// pinned by a channel file and skipped with --no-offset-discovery. With
+// --tolerant-clock we also look in up to ClockSkewSlots adjacent slots
+// in each direction in case the clocks of both peers differ.
+func (n *Node) slotOffsets() []time.Duration {
+	if !n.TimeSlot.IsZero() || n.noOffsetDiscovery {
+		return []time.Duration{0}
+	}
+
+	offsets := []time.Duration{0, -dht.TruncateDuration}
+	if n.tolerantClock {
+		for i := 1; i <= ClockSkewSlots; i++ {
+			offsets = append(offsets,
+				time.Duration(i)*dht.TruncateDuration,
+				-time.Duration(i+1)*dht.TruncateDuration)
+		}
+	}
+	return offsets
+}
+
+// slotLabel returns the name of a discoverer in the timeline.
+func slotLabel(mechanism string, offset time.Duration) string {
+	switch slots := int(offset / dht.TruncateDuration); slots {
+	case 0:
+		return mechanism
+	case -1:
+		return mechanism + " (previous slot)"
+	default:
+		return fmt.Sprintf("%s (slot %+d)", mechanism, slots)
+	}
+}
+
+// discover runs the given discoverers in the background until
+// StopDiscovering is called. Found peers are passed to HandlePeer.
+func (n *Node) discover(discoverers []discovery.Discoverer) {
+	n.discoverers = discoverers
+	round := newDiscoveryRound(len(n.discoverers))
 	for _, discoverer := range n.discoverers {
-		go func(d Discoverer) {
-			err := d.Discover(n.ChanID, n.HandlePeer)
+		go func(d discovery.Discoverer) {
+			src := d.Mechanism()
+			err := d.Discover(n.ChanID, func(pi peer.AddrInfo) { n.HandlePeer(pi, src) })
 			if err == nil {
 				return
 			}
@@ -101,21 +634,64 @@ func (n *Node) StartDiscovering(c *cli.Context) {
 				return
 			}
 
-			switch e := err.(type) {
-			case dht.ErrConnThresholdNotReached:
-				e.Log()
-			default:
-				log.Warningln(err)
-			}
+			n.handleDiscoverError(round, src, err)
 		}(discoverer)
 	}
 }
 
+// discoveryRound keeps track of the discoverers
+// of one StartDiscovering call that are still running.
+type discoveryRound struct {
+	running    int32
+	dhtWarning sync.Once
+}
+
+func newDiscoveryRound(discoverers int) *discoveryRound {
+	return &discoveryRound{running: int32(discoverers)}
+}
+
+// giveUp registers a discoverer that has returned with an
+// error. It returns true if it was the last one running.
+func (r *discoveryRound) giveUp() bool {
+	return atomic.AddInt32(&r.running, -1) == 0
+}
+
+// handleDiscoverError logs the error of a discoverer that has given up. With
+// --mdns-fallback or --max-retries we continue as long as another discoverer
+// is running, e.g. mDNS in a network that blocks the DHT, and fail otherwise.
+func (n *Node) handleDiscoverError(round *discoveryRound, src string, err error) {
+	exhausted := false
+	switch e := err.(type) {
+	case dht.ErrConnThresholdNotReached:
+		e.Log()
+	case discovery.ErrRetriesExhausted:
+		log.Infoln(e)
+		exhausted = true
+	default:
+		log.Warningln(err)
+	}
+
+	if !n.mdnsFallback && n.maxRetries == 0 {
+		return
+	}
+
+	if round.giveUp() {
+		go n.fail(fmt.Errorf("%w: %v", ErrNoDiscovery, err))
+		return
+	}
+
+	if src == "DHT" && !exhausted {
+		round.dhtWarning.Do(func() {
+			log.Warningln("DHT is unavailable, continuing with mDNS only")
+		})
+	}
+}
+
 func (n *Node) StopDiscovering() {
 	var wg sync.WaitGroup
 	for _, discoverer := range n.discoverers {
 		wg.Add(1)
-		go func(d Discoverer) {
+		go func(d discovery.Discoverer) {
 			d.Shutdown()
 			wg.Done()
 		}(discoverer)
@@ -123,43 +699,158 @@ func (n *Node) StopDiscovering() {
 	wg.Wait()
 }
 
+// noProviderHint tells the user why the DHT doesn't find the sender
+// although it's reachable. We keep searching afterwards.
+func (n *Node) noProviderHint() {
+	log.Warningf("No sender found in the DHT after %s although it's reachable. Make sure the sender is running and uses the same words - compare the identifiers of both ends with --show-channel. Still searching...\n", n.dhtHintAfter)
+}
+
+// newDHTDiscoverer returns a DHT discoverer that is configured by the user's options.
+func (n *Node) newDHTDiscoverer() *dht.Discoverer {
+	return dht.NewDiscoverer(n, n.DHT).SetNamespace(n.Namespace).SetTimeSlot(n.TimeSlot).
+		SetBackoff(n.dhtBackoffInitial, n.dhtBackoffMax).SetProviderLimit(n.dhtProviderLimit).
+		SetLookupLimiter(n.dhtLookups).SetBootstrapRetries(n.DHTBootstrapRounds, n.DHTBootstrapBackoff).
+		SetMaxRetries(n.maxRetries)
+}
+
+// newRendezvousDiscoverer returns a discoverer that queries the user's rendezvous server.
+func (n *Node) newRendezvousDiscoverer() *rendezvous.Discoverer {
+	return rendezvous.NewDiscoverer(n, *n.Rendezvous).SetNamespace(n.Namespace).SetTimeSlot(n.TimeSlot).
+		SetMaxRetries(n.maxRetries)
+}
+
+// newMDNSDiscoverer returns an mDNS discoverer that is configured by the user's options.
+func (n *Node) newMDNSDiscoverer() *mdns.Discoverer {
+	return mdns.NewDiscoverer(n.Node).SetNamespace(n.Namespace).SetServiceTag(n.MDNSServiceTag).SetInterval(n.MDNSInterval).SetTimeSlot(n.TimeSlot).
+		SetKeepLocalAddrs(n.mdnsKeepLocalAddrs).SetKeepPublicAddrs(n.mdnsNoPublicFilter).SetDedupTTL(n.mdnsDedupTTL).
+		SetInterfaces(n.MDNSInterfaces).SetMaxRetries(n.maxRetries)
+}
+
 // HandlePeer is called async from the discoverers. It's okay to have long running tasks here.
-func (n *Node) HandlePeer(pi peer.AddrInfo) {
+// The source indicates which discovery mechanism has found the peer.
+func (n *Node) HandlePeer(pi peer.AddrInfo, source string) {
 	if n.GetState() != pcpnode.Discovering {
 		log.Debugln("Received a peer from the discoverer although we're not discovering")
 		return
 	}
 
+	// The DHT may return our own provider record if we're advertising on the same channel.
+	if pi.ID == n.ID() {
+		log.Warningln("Discovered ourselves - are you sending and receiving on the same machine with the same words?")
+		return
+	}
+
+	atomic.AddInt32(&n.peersFound, 1)
+
+	// Only consider addresses in the subnets the user has allowed.
+	if !n.subnets.IsEmpty() {
+		pi.Addrs = n.subnets.Filter(pi.Addrs)
+		if len(pi.Addrs) == 0 {
+			log.Debugln("Skipping peer as none of its addresses is allowed", pi.ID)
+			return
+		}
+	}
+
+	// Relay addresses are of no use if we must connect directly.
+	if n.OnlyDirect() {
+		pi.Addrs = pcpnode.DirectAddrs(pi.Addrs)
+		if len(pi.Addrs) == 0 {
+			log.Debugln("Skipping peer as it's only reachable through a relay", pi.ID)
+			return
+		}
+	}
+
+	// Addresses outside the local network would be refused after connecting.
+	if n.LANOnly() {
+		pi.Addrs = pcpnode.LANAddrs(pi.Addrs)
+		if len(pi.Addrs) == 0 {
+			log.Infoln("Skipping peer as it has no private address in the local network (--lan-only):", pi.ID)
+			return
+		}
+	}
+
 	// Check if we have already seen the peer and exit early to not connect again.
-	peerState, _ := n.peerStates.LoadOrStore(pi.ID, NotConnected)
-	switch peerState.(PeerState) {
-	case NotConnected:
-	case Connecting:
-		log.Debugln("Skipping node as we're already trying to connect", pi.ID)
+	// The peer stays in the connecting state while it waits for a free
+	// dial slot, so that it's not queued again if it's found again.
+	if !n.claimPeer(pi) {
 		return
-	case FailedConnecting:
-		// TODO: Check if multiaddrs have changed and only connect if that's the case
-		log.Debugln("We tried to connect previously but couldn't establish a connection, try again", pi.ID)
-	case FailedAuthentication:
-		log.Debugln("We tried to connect previously but the node didn't pass authentication  -> skipping", pi.ID)
+	}
+
+	if !n.acquireDial() {
+		return
+	}
+	defer n.releaseDial()
+
+	// We may have found our peer while we were waiting.
+	if n.GetState() != pcpnode.Discovering {
+		n.setPeerState(pi, NotConnected)
 		return
 	}
 
 	log.Debugln("Connecting to peer:", pi.ID)
-	n.peerStates.Store(pi.ID, Connecting)
-	if err := n.Connect(n.ServiceContext(), pi); err != nil {
+	metrics.ConnectionAttempts.Inc()
+	if err := n.connect(pi); err != nil {
 		log.Debugln("Error connecting to peer:", pi.ID, err)
-		n.peerStates.Store(pi.ID, FailedConnecting)
+		n.history.recordFailure(pi.ID, source, ErrConnection{PeerID: pi.ID, Err: err})
+		n.setPeerState(pi, FailedConnecting)
+		metrics.ConnectionFailures.Inc()
+		return
+	}
+
+	// Verify the connection itself, the peer may have been
+	// reached through another route than its addresses suggest.
+	if n.LANOnly() {
+		if err := n.EnsureLANConn(pi.ID); err != nil {
+			log.Warningln("Refusing peer:", err)
+			n.setPeerState(pi, FailedConnecting)
+			n.history.recordFailure(pi.ID, source, ErrConnection{PeerID: pi.ID, Err: err})
+			return
+		}
+	}
+
+	// Only authenticate the peer the user has pinned.
+	if n.expectedPeer != "" && pi.ID != n.expectedPeer {
+		log.Debugln("Skipping peer as it doesn't match the expected peer ID", pi.ID)
+		n.setPeerState(pi, Skipped)
 		return
 	}
 
 	// Negotiate PAKE
-	if _, err := n.StartKeyExchange(n.ServiceContext(), pi.ID); err != nil {
+	if err := n.keyExchange(pi.ID); err == context.DeadlineExceeded {
+		log.Warningln("Peer didn't complete authentication within", n.keyExchangeTimeout, pi.ID)
+		n.setPeerState(pi, TimedOut)
+		n.history.recordFailure(pi.ID, source, errors.Wrap(err, "authentication timed out"))
+		return
+	} else if err != nil {
 		log.Errorln("Peer didn't pass authentication:", err)
-		n.peerStates.Store(pi.ID, FailedAuthentication)
+		n.setPeerState(pi, FailedAuthentication)
+		n.history.recordFailure(pi.ID, source, ErrAuthentication{PeerID: pi.ID, Err: err})
+		n.registerAuthFailure()
+		if n.exceededAuthFailures() {
+			go n.fail(errors.Wrapf(ErrTooManyAuthFailures, "%d peers failed authentication", n.maxAuthFailures))
+		}
+		return
+	}
+
+	if n.OnlyDirect() {
+		if err := n.EnsureDirectConn(n.ServiceContext(), pi.ID); err != nil {
+			log.Errorln("Could not connect directly to the sender:", err)
+			n.setPeerState(pi, FailedConnecting)
+			err = ErrConnection{PeerID: pi.ID, Err: err}
+			n.history.recordFailure(pi.ID, source, err)
+			go n.fail(err)
+			return
+		}
+	}
+	n.setPeerState(pi, Connected)
+
+	// Let the user choose between all senders that authenticate
+	// until the first push request has arrived.
+	if n.picker != nil {
+		n.picker.addSource(pi.ID, source)
+		log.Infoln("Authenticated sender", pi.ID)
 		return
 	}
-	n.peerStates.Store(pi.ID, Connected)
 
 	// We're authenticated so can initiate a transfer
 	if n.GetState() == pcpnode.Connected {
@@ -167,30 +858,276 @@ func (n *Node) HandlePeer(pi peer.AddrInfo) {
 		return
 	}
 	n.SetState(pcpnode.Connected)
+	n.peerSource = source
+	log.Debugln("Found peer", pi.ID, "via", source)
 
 	// Stop the discovering process as we have found the valid peer
 	n.StopDiscovering()
 }
 
+// newDialSem returns a semaphore that allows the given number of
+// concurrent dials. Zero means unlimited and returns nil.
+func newDialSem(limit int) chan struct{} {
+	if limit == 0 {
+		return nil
+	}
+	return make(chan struct{}, limit)
+}
+
+// acquireDial blocks until less than the maximum number of connection
+// and authentication attempts are running. It returns false if the
+// node was shut down in the meantime.
+func (n *Node) acquireDial() bool {
+	if n.dialSem == nil {
+		return true
+	}
+
+	select {
+	case n.dialSem <- struct{}{}:
+		return true
+	case <-n.SigShutdown():
+		return false
+	}
+}
+
+// releaseDial frees the dial slot of a finished attempt.
+func (n *Node) releaseDial() {
+	if n.dialSem != nil {
+		<-n.dialSem
+	}
+}
+
+// connect establishes a connection to the given peer. It gives up
+// after the configured connect timeout, so that a peer with many
+// unreachable addresses doesn't keep us busy.
+func (n *Node) connect(pi peer.AddrInfo) error {
+	ctx := n.ServiceContext()
+	if n.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.connectTimeout)
+		defer cancel()
+	}
+	return n.Connect(ctx, pi)
+}
+
+// keyExchange authenticates the given peer. It returns
+// context.DeadlineExceeded if the peer didn't complete the
+// key exchange within the configured timeout.
+func (n *Node) keyExchange(peerID peer.ID) error {
+	ctx := n.ServiceContext()
+	if n.keyExchangeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.keyExchangeTimeout)
+		defer cancel()
+	}
+
+	_, err := n.StartKeyExchange(ctx, peerID)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// shouldConnect checks the previous connection state of the given peer
+// and decides whether we should (re-)attempt a connection. Peers that we
+// couldn't connect to are only retried if their addresses have changed
+// to avoid a tight reconnect loop.
+func (n *Node) shouldConnect(pi peer.AddrInfo) bool {
+	val, _ := n.peerStates.LoadOrStore(pi.ID, peerInfo{state: NotConnected})
+	prev := val.(peerInfo)
+	switch prev.state {
+	case NotConnected:
+		return true
+	case Connecting:
+		log.Debugln("Skipping node as we're already trying to connect", pi.ID)
+		return false
+	case FailedConnecting:
+		if sameAddrs(prev.addrs, pi.Addrs) {
+			log.Debugln("We tried to connect previously but couldn't establish a connection and addresses didn't change -> skipping", pi.ID)
+			return false
+		}
+		log.Debugln("We tried to connect previously but couldn't establish a connection, addresses changed -> try again", pi.ID)
+		return true
+	case FailedAuthentication:
+		log.Debugln("We tried to connect previously but the node didn't pass authentication  -> skipping", pi.ID)
+		return false
+	case Skipped:
+		log.Debugln("Peer doesn't match the expected peer ID -> skipping", pi.ID)
+		return false
+	case TimedOut:
+		if sameAddrs(prev.addrs, pi.Addrs) {
+			log.Debugln("We tried to authenticate previously but the node didn't respond in time and addresses didn't change -> skipping", pi.ID)
+			return false
+		}
+		log.Debugln("We tried to authenticate previously but the node didn't respond in time, addresses changed -> try again", pi.ID)
+		return true
+	default:
+		return false
+	}
+}
+
+// claimPeer atomically checks whether we should connect to the given peer
+// and marks it as connecting if so. The primary and offset discoverers
+// may report the same peer at the same time and only one of them must
+// win. It returns true for the caller that should connect.
+func (n *Node) claimPeer(pi peer.AddrInfo) bool {
+	n.peerStatesLk.Lock()
+	defer n.peerStatesLk.Unlock()
+
+	if !n.shouldConnect(pi) {
+		return false
+	}
+
+	n.peerStates.Store(pi.ID, peerInfo{state: Connecting, addrs: pi.Addrs})
+	return true
+}
+
+// registerAuthFailure counts a peer that didn't pass authentication. If
+// too many distinct peers fail on the same channel, it's likely that
+// someone else is using the same channel at the same time. The user is
+// warned once in this case. It returns true if the warning was printed.
+func (n *Node) registerAuthFailure() bool {
+	failures := atomic.AddInt32(&n.authFailures, 1)
+	if n.collisionThreshold <= 0 || failures <= n.collisionThreshold {
+		return false
+	}
+
+	warned := false
+	n.collisionWarning.Do(func() {
+		log.Warningf("%d different peers failed authentication. Another pcp user may be using the same channel.\n", failures)
+		log.Warningf("Consider asking the sender to generate a new code with more words than %d (pcp send -w %d).\n", len(n.Words), len(n.Words)+2)
+		warned = true
+	})
+
+	return warned
+}
+
+// exceededAuthFailures returns true if the maximum number
+// of peers that may fail authentication was reached.
+func (n *Node) exceededAuthFailures() bool {
+	return n.maxAuthFailures > 0 && atomic.LoadInt32(&n.authFailures) >= n.maxAuthFailures
+}
+
+// setPeerState stores the given state for the peer alongside the
+// addresses that were used for the connection attempt.
+func (n *Node) setPeerState(pi peer.AddrInfo, state PeerState) {
+	n.peerStatesLk.Lock()
+	defer n.peerStatesLk.Unlock()
+	n.peerStates.Store(pi.ID, peerInfo{state: state, addrs: pi.Addrs})
+}
+
+// sameAddrs returns true if both slices contain the same set of multi addresses.
+func sameAddrs(a []ma.Multiaddr, b []ma.Multiaddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	set := map[string]struct{}{}
+	for _, addr := range a {
+		set[string(addr.Bytes())] = struct{}{}
+	}
+
+	for _, addr := range b {
+		if _, found := set[string(addr.Bytes())]; !found {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (n *Node) HandlePushRequest(pr *p2p.PushRequest) (bool, error) {
-	if n.autoAccept {
+	if n.manifest != nil {
+		return false, n.reportManifest(pr)
+	}
+
+	// Reject the transfer right away if it's not signed by the trusted key.
+	if n.verifyKey != nil {
+		if err := pcpnode.VerifyPushRequest(n.verifyKey, pr); err != nil {
+			log.Errorln("Rejecting", pr.Name+":", err)
+			go n.fail(err)
+			return false, nil
+		}
+		log.Infoln("Verified the signature of", pr.Name)
+	}
+
+	peerID, err := pr.PeerID()
+	if err != nil {
+		return false, err
+	}
+
+	// Don't even ask the user about names that would be saved outside the current directory.
+	if _, err := sanitizeName(pr.Name); err != nil {
+		log.Errorln("Rejecting transfer:", err)
+		go n.finish(peerID)
+		return false, nil
+	}
+
+	// Blocked extensions are either rejected or always need the user's consent.
+	forcePrompt := false
+	if blocked := n.blockExt.blockedNames(pr); len(blocked) > 0 {
+		if !n.blockExtPrompt {
+			log.Errorln("Rejecting transfer: blocked file extension:", strings.Join(blocked, ", "))
+			go n.finish(peerID)
+			return false, nil
+		}
+		log.Warningln("The transfer contains files with blocked extensions:", strings.Join(blocked, ", "))
+		forcePrompt = true
+	}
+
+	if n.picker != nil && !n.pick(peerID, pr) {
+		return false, nil
+	}
+
+	if n.autoAccept && !forcePrompt {
 		return n.handleAccept(pr)
 	}
 
+	if accept, matched := evaluateRules(n.acceptRules, pr); matched && !forcePrompt {
+		if accept {
+			log.Infoln("Accepting", pr.Name, "based on accept rules")
+			return n.handleAccept(pr)
+		}
+		log.Infoln("Rejecting", pr.Name, "based on accept rules")
+		go n.finish(peerID)
+		return false, nil
+	}
+
 	obj := "File"
 	if pr.IsDir {
 		obj = "Directory"
+	} else if pr.Benchmark {
+		obj = "Benchmark"
+	}
+	if pr.Benchmark {
+		log.Infof("%s: %s of generated data that is discarded and not written to disk\n", obj, format.Bytes(pr.Size))
+	} else if pr.IsDir && pr.TotalFiles > 0 {
+		log.Infof("%s: %s (%s, %d files)\n", obj, pr.Name, format.Bytes(pr.Size), pr.TotalFiles)
+	} else if ext := contentExtension(pr); ext != "" {
+		log.Infof("%s: %s (%s, %s, saved as %s)\n", obj, pr.Name, format.Bytes(pr.Size), pr.ContentType, pr.Name+ext)
+	} else {
+		log.Infof("%s: %s (%s)\n", obj, pr.Name, format.Bytes(pr.Size))
+	}
+	for _, line := range manifestLines(pr, manifestDisplayLimit) {
+		log.Infoln("\t" + line)
 	}
-	log.Infof("%s: %s (%s)\n", obj, pr.Name, format.Bytes(pr.Size))
 	for {
 		log.Infof("Do you want to receive this %s? [y,n,i,?] ", strings.ToLower(obj))
-		scanner := bufio.NewScanner(os.Stdin)
-		if !scanner.Scan() {
-			return true, errors.Wrap(scanner.Err(), "failed reading from stdin")
+		line, ok, err := n.readLine(peerID)
+		if err == ErrPromptCancelled {
+			go n.Shutdown()
+			return false, err
+		} else if !ok && err == nil && !n.stdinTerminal {
+			// Nobody can answer, so waiting for the next sender is pointless.
+			fmt.Fprintln(log.Out)
+			go n.fail(ErrNoTerminal)
+			return false, nil
+		} else if !ok {
+			return true, errors.Wrap(err, "failed reading from stdin")
 		}
 
 		// sanitize user input
-		input := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		input := strings.ToLower(strings.TrimSpace(line))
 
 		// Empty input, user just pressed enter => do nothing and prompt again
 		if input == "" {
@@ -205,7 +1142,7 @@ func (n *Node) HandlePushRequest(pr *p2p.PushRequest) (bool, error) {
 
 		// Print information about the send request
 		if input == "i" {
-			printInformation(pr)
+			n.printInformation(pr)
 			continue
 		}
 
@@ -216,7 +1153,7 @@ func (n *Node) HandlePushRequest(pr *p2p.PushRequest) (bool, error) {
 
 		// Reject the file transfer
 		if input == "n" {
-			go n.Shutdown()
+			go n.finish(peerID)
 			return false, nil
 		}
 
@@ -224,20 +1161,245 @@ func (n *Node) HandlePushRequest(pr *p2p.PushRequest) (bool, error) {
 	}
 }
 
+// printInformation prints the details of the push request together
+// with the connections to the authenticated sender.
+func (n *Node) printInformation(pr *p2p.PushRequest) {
+	log.Infoln("Sending request information:")
+	log.Infoln("\tPeer:\t", pr.Header.NodeId)
+	if peerID, err := pr.PeerID(); err == nil {
+		for _, conn := range n.Network().ConnsToPeer(peerID) {
+			log.Infof("\tConn:\t %s (%s)\n", conn.RemoteMultiaddr(), pcpnode.ConnectionType(conn))
+		}
+	}
+	log.Infoln("\tName:\t", pr.Name)
+	log.Infoln("\tSize:\t", pr.Size)
+	if pr.IsDir {
+		log.Infoln("\tFiles:\t", pr.TotalFiles)
+		for _, line := range manifestLines(pr, manifestDisplayLimit) {
+			log.Infoln("\t\t", line)
+		}
+	}
+	if n.verifyKey != nil {
+		log.Infoln("\tSigned:\t verified with trusted key")
+	} else if len(pr.Signature) > 0 {
+		log.Infoln("\tSigned:\t yes, but not verified (see --verify-key)")
+	}
+	log.Infoln("\tSign:\t", hex.EncodeToString(pr.Header.Signature))
+	log.Infoln("\tPubKey:\t", hex.EncodeToString(pr.Header.GetNodePubKey()))
+}
+
+// contentExtension returns the extension a single file is saved with
+// because the sender announced its content type, but its name has no
+// extension, e.g. if it was read from a stream. It returns an empty
+// string if the name should be kept.
+func contentExtension(pr *p2p.PushRequest) string {
+	if pr.IsDir || pr.Benchmark || pr.ContentType == "" || filepath.Ext(pr.Name) != "" {
+		return ""
+	}
+	return pcpnode.ExtensionByType(pr.ContentType)
+}
+
+// HandlePushRequestError is called if the push request of our peer was
+// refused, e.g. because of incompatible versions. We cannot receive the
+// file anymore, so we shut down.
+func (n *Node) HandlePushRequestError(err error) {
+	log.Errorln(err)
+	go n.fail(err)
+}
+
+// ManifestOnly is true if we decline all transfers, because we only
+// report what is offered. The sender doesn't treat it as an error then.
+func (n *Node) ManifestOnly() bool {
+	return n.manifest != nil
+}
+
+// reportManifest writes what the sender offers with the given push
+// request and declines the transfer.
+func (n *Node) reportManifest(pr *p2p.PushRequest) error {
+	peerID, err := pr.PeerID()
+	if err != nil {
+		return err
+	}
+
+	report := newManifestReport(peerID.String(), pr)
+	if n.verifyKey != nil {
+		verified := pcpnode.VerifyPushRequest(n.verifyKey, pr) == nil
+		report.Verified = &verified
+	}
+
+	if err = n.manifest.Write(report); err != nil {
+		go n.fail(err)
+		return nil
+	}
+
+	log.Infoln("Wrote the manifest of", pr.Name, "and declined the transfer")
+	go n.finish(peerID)
+	return nil
+}
+
 // handleAccept handles the case when the user accepted the transfer or provided
 // the corresponding command line flag.
 func (n *Node) handleAccept(pr *p2p.PushRequest) (bool, error) {
-	done := n.TransferFinishHandler(pr.Size)
+	peerID, err := pr.PeerID()
+	if err != nil {
+		return true, err
+	}
+
+	// Buffered, as nobody receives from it anymore after a shutdown.
+	done := make(chan int64, 1)
 	th, err := NewTransferHandler(pr.Name, done)
 	if err != nil {
 		return true, err
 	}
+	if n.verifyKey != nil {
+		th.contentHash = pcpnode.NewContentHash()
+	}
+	th.uniqueNames = n.keepAlive
+	th.chunkSize = n.ChunkSize()
+	th.onConflict = n.conflictAction()
+	th.resolveConflict = n.promptConflict(peerID)
+	th.cancelled = n.SigShutdown()
+	if n.preserve {
+		th.preserve = &preserver{}
+	}
+	th.atomicDir = pr.IsDir
+	if !n.blockExtPrompt {
+		th.blockExt = n.blockExt
+	}
+	if pr.Benchmark {
+		th.benchmark = pcpnode.NewRateMeter()
+	}
+	th.extension = contentExtension(pr)
+	if n.resume != nil && resumable(pr) {
+		if th.resume, err = n.resume.lookup(pr); err != nil {
+			log.Warningln("Could not prepare resuming the transfer:", err)
+		} else if th.resume.offset > 0 {
+			log.Infof("Found %s of %s from an interrupted transfer\n", format.Bytes(th.resume.offset), format.Bytes(pr.Size))
+		}
+	}
+	if n.resume != nil && resumableDir(pr) && !n.keepAlive {
+		th.dirResume, err = n.lookupDir(pr)
+		if err != nil {
+			log.Warningln("Could not prepare resuming the transfer:", err)
+		} else if th.dirResume != nil && (len(th.dirResume.state.Files) > 0 || th.dirResume.offset > 0) {
+			log.Infof("Found %d files of %s from an interrupted transfer\n", len(th.dirResume.state.Files), pr.Name)
+		}
+	}
+	n.TransferFinishHandler(peerID, pr, th, done)
 	n.RegisterTransferHandler(th)
+
+	n.transferLk.Lock()
+	n.transferPeer = peerID
+	n.transferLk.Unlock()
+	log.Infoln("Press Ctrl+C to cancel the transfer")
+
 	return true, nil
 }
 
-func (n *Node) TransferFinishHandler(size int64) chan int64 {
-	done := make(chan int64)
+// ResumeOffset returns the number of bytes of the accepted file we
+// already have from an interrupted transfer and their hash, so that
+// the sender can check that its file still starts with them.
+func (n *Node) ResumeOffset(pr *p2p.PushRequest) (int64, []byte) {
+	n.transferLk.Lock()
+	defer n.transferLk.Unlock()
+
+	if n.transfer == nil || n.transfer.resume == nil {
+		return 0, nil
+	}
+	return n.transfer.resume.offset, n.transfer.resume.offsetHash
+}
+
+// ResumeDir returns the files of the accepted directory we already
+// have from an interrupted transfer. The sender skips them.
+func (n *Node) ResumeDir(pr *p2p.PushRequest) *pcpnode.DirResume {
+	n.transferLk.Lock()
+	defer n.transferLk.Unlock()
+
+	if n.transfer == nil || n.transfer.dirResume == nil {
+		return nil
+	}
+	return n.transfer.dirResume.response()
+}
+
+// lookupDir returns the partial transfer of the directory of the given
+// push request. Directories that already exist are received in place
+// and can't be resumed, so it returns nil for them.
+func (n *Node) lookupDir(pr *p2p.PushRequest) (*partialDir, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := sanitizeName(pr.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = os.Lstat(filepath.Join(cwd, name)); err == nil {
+		return nil, nil
+	}
+
+	return n.resume.lookupDir(pr, cwd)
+}
+
+// cancelTransfer tells the sender that we have cancelled a
+// running transfer, so that it can exit cleanly.
+func (n *Node) cancelTransfer() {
+	n.transferLk.Lock()
+	peerID := n.transferPeer
+	n.transferPeer = ""
+	n.transferLk.Unlock()
+
+	if peerID == "" {
+		return
+	}
+
+	log.Infoln("Cancelling the transfer...")
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	defer cancel()
+	if err := n.SendCancel(ctx, peerID); err != nil {
+		log.Debugln("Could not notify the sender about the cancellation:", err)
+	}
+}
+
+// discardTransfer removes a partially received directory. It's called on
+// shutdown after the transfer handler was unregistered, so that
+// nothing is written into the directory anymore.
+func (n *Node) discardTransfer() {
+	n.transferLk.Lock()
+	th := n.transfer
+	n.transfer = nil
+	n.transferLk.Unlock()
+
+	if th != nil {
+		th.discard()
+	}
+}
+
+// TransferFinishHandler waits until the given transfer handler reports
+// the received bytes on done and reports the result. Failed transfers
+// are detected and the received data is verified before a received
+// directory is moved into place.
+func (n *Node) TransferFinishHandler(peerID peer.ID, pr *p2p.PushRequest, th *TransferHandler, done <-chan int64) {
+	n.transferLk.Lock()
+	n.transfer = th
+	n.transferLk.Unlock()
+
+	start := time.Now()
+	conn := n.PeerConnectionType(peerID)
+	checksum := ""
+	summarize := func(outcome string) {
+		n.setSummary(&tui.Summary{
+			Outcome:    outcome,
+			Peer:       peerID.String(),
+			File:       pr.Name,
+			Size:       pr.Size,
+			Duration:   time.Since(start),
+			Connection: conn,
+			Checksum:   checksum,
+		})
+	}
+
 	go func() {
 		var received int64
 		select {
@@ -246,13 +1408,140 @@ func (n *Node) TransferFinishHandler(size int64) chan int64 {
 		case received = <-done:
 		}
 
-		if received == size {
-			log.Infoln("Successfully received file/directory!")
+		n.transferLk.Lock()
+		n.transferPeer = ""
+		n.transfer = nil
+		n.transferLk.Unlock()
+
+		// Nothing was written to disk, so there is nothing to verify or record.
+		if th.benchmark != nil {
+			if received != pr.Size {
+				log.Warningf("WARNING: Only received %d of %d bytes!\n", received, pr.Size)
+			}
+			log.Infoln("Benchmark:", th.benchmark.Summary())
+			summarize("benchmark finished: " + th.benchmark.Summary().String())
+			n.finish(peerID)
+			return
+		}
+
+		elapsed := time.Since(start)
+		entry := HistoryEntry{
+			PeerID:         peerID.String(),
+			Name:           pr.Name,
+			Size:           pr.Size,
+			Received:       received,
+			Source:         n.peerSource,
+			Duration:       elapsed.Seconds(),
+			BytesPerSecond: format.BytesPerSecond(received, elapsed),
+		}
+
+		err := th.check(pr)
+		if err == nil && received == pr.Size {
+			err = th.commit()
+		}
+
+		if err != nil {
+			th.discard()
+			log.Errorln(err)
+			entry.Error = err.Error()
+			n.history.Record(entry)
+			summarize("failed: " + err.Error())
+			n.fail(err)
+			return
+		}
+
+		if received == pr.Size {
+			log.Infof("Successfully received file/directory! (peer found via %s)\n", n.peerSource)
+			log.Infoln("Received", format.TransferSummary(received, elapsed))
+			checksum = n.checksum(th.path)
+			entry.Checksum = checksum
+			entry.Success = true
+			summarize("received")
 		} else {
-			log.Infof("WARNING: Only received %d of %d bytes!\n", received, size)
+			th.discard()
+			log.Warningf("WARNING: Only received %d of %d bytes!\n", received, pr.Size)
+			entry.Error = "incomplete transfer"
+			summarize(fmt.Sprintf("incomplete: received %s of %s", format.Bytes(received), format.Bytes(pr.Size)))
+			n.history.Record(entry)
+			n.fail(ErrTruncated{Received: received, Expected: pr.Size})
+			return
 		}
+		n.history.Record(entry)
 
-		n.Shutdown()
+		if entry.Success && n.onComplete != nil {
+			vars := hookVars{Path: th.path, Name: filepath.Base(th.path), Size: pr.Size, PeerID: peerID}
+			if err := n.onComplete.run(vars); err != nil {
+				log.Errorln(err)
+				if n.onCompleteStrict {
+					n.fail(err)
+					return
+				}
+			}
+		}
+
+		n.finish(peerID)
 	}()
-	return done
+}
+
+// checksum calculates and prints the checksum of the received file or
+// directory, so that it can be compared with the one of the sender.
+// It's empty if it's disabled or couldn't be calculated.
+func (n *Node) checksum(path string) string {
+	if !n.ChecksumEnabled() {
+		return ""
+	}
+
+	sum, err := pcpnode.Checksum(path, nil)
+	if err != nil {
+		log.Warningln("Could not calculate the checksum:", err)
+		return ""
+	}
+	log.Infoln("SHA-256:", sum)
+	return sum
+}
+
+// setSummary records the outcome of the last transfer.
+func (n *Node) setSummary(s *tui.Summary) {
+	n.summaryLk.Lock()
+	defer n.summaryLk.Unlock()
+	n.summary = s
+}
+
+// Summary describes the outcome of the last transfer. It's nil
+// if no transfer has been accepted.
+func (n *Node) Summary() *tui.Summary {
+	n.summaryLk.Lock()
+	defer n.summaryLk.Unlock()
+	return n.summary
+}
+
+// fail shuts down the node and lets Wait return the given error.
+func (n *Node) fail(err error) {
+	n.errLk.Lock()
+	if n.err == nil {
+		n.err = err
+	}
+	n.errLk.Unlock()
+	n.Shutdown()
+}
+
+// finish is called after the conversation with the given peer has ended.
+// It shuts down the node or, in keep-alive mode, starts discovering
+// again to wait for the next sender.
+func (n *Node) finish(peerID peer.ID) {
+	if !n.keepAlive {
+		n.Shutdown()
+		return
+	}
+
+	n.UnregisterTransferHandler()
+
+	// Forget the peer, so that it can send again.
+	n.peerStatesLk.Lock()
+	n.peerStates.Delete(peerID)
+	n.peerStatesLk.Unlock()
+	n.picker.reset()
+
+	log.Infof("Looking for the next peer %s...\n", strings.Join(n.Words, "-"))
+	n.StartDiscovering()
 }
@@ -0,0 +1,518 @@
+package receive
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennis-tra/pcp/internal/tui"
+	"github.com/dennis-tra/pcp/pkg/dht"
+	"github.com/dennis-tra/pcp/pkg/discovery"
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+	"github.com/dennis-tra/pcp/pkg/service"
+)
+
+func mustAddrs(t *testing.T, addrs ...string) []ma.Multiaddr {
+	var maddrs []ma.Multiaddr
+	for _, addr := range addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		require.NoError(t, err)
+		maddrs = append(maddrs, maddr)
+	}
+	return maddrs
+}
+
+func TestNode_shouldConnect(t *testing.T) {
+	pid := peer.ID("some-peer")
+	addrs := mustAddrs(t, "/ip4/192.168.0.1/tcp/1234")
+
+	tests := []struct {
+		name     string
+		prev     *peerInfo
+		addrs    []ma.Multiaddr
+		expected bool
+	}{
+		{name: "unknown peer", prev: nil, addrs: addrs, expected: true},
+		{name: "not connected", prev: &peerInfo{state: NotConnected}, addrs: addrs, expected: true},
+		{name: "connecting", prev: &peerInfo{state: Connecting, addrs: addrs}, addrs: addrs, expected: false},
+		{name: "connected", prev: &peerInfo{state: Connected, addrs: addrs}, addrs: addrs, expected: false},
+		{name: "failed authentication", prev: &peerInfo{state: FailedAuthentication, addrs: addrs}, addrs: addrs, expected: false},
+		{name: "skipped", prev: &peerInfo{state: Skipped, addrs: addrs}, addrs: addrs, expected: false},
+		{
+			name:     "failed connecting with unchanged addrs",
+			prev:     &peerInfo{state: FailedConnecting, addrs: addrs},
+			addrs:    mustAddrs(t, "/ip4/192.168.0.1/tcp/1234"),
+			expected: false,
+		},
+		{
+			name:     "timed out with unchanged addrs",
+			prev:     &peerInfo{state: TimedOut, addrs: addrs},
+			addrs:    mustAddrs(t, "/ip4/192.168.0.1/tcp/1234"),
+			expected: false,
+		},
+		{
+			name:     "timed out with changed addrs",
+			prev:     &peerInfo{state: TimedOut, addrs: addrs},
+			addrs:    mustAddrs(t, "/ip4/192.168.0.2/tcp/1234"),
+			expected: true,
+		},
+		{
+			name:     "failed connecting with changed addrs",
+			prev:     &peerInfo{state: FailedConnecting, addrs: addrs},
+			addrs:    mustAddrs(t, "/ip4/192.168.0.2/tcp/1234"),
+			expected: true,
+		},
+		{
+			name:     "failed connecting with additional addrs",
+			prev:     &peerInfo{state: FailedConnecting, addrs: addrs},
+			addrs:    mustAddrs(t, "/ip4/192.168.0.1/tcp/1234", "/ip4/192.168.0.1/udp/1234/quic"),
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &Node{peerStates: &sync.Map{}}
+			if tt.prev != nil {
+				n.peerStates.Store(pid, *tt.prev)
+			}
+			assert.Equal(t, tt.expected, n.shouldConnect(peer.AddrInfo{ID: pid, Addrs: tt.addrs}))
+		})
+	}
+}
+
+func TestNode_claimPeer_concurrent(t *testing.T) {
+	// The primary and offset discoverers report the same peer at the same time.
+	n := &Node{peerStates: &sync.Map{}}
+	pi := peer.AddrInfo{ID: peer.ID("some-peer"), Addrs: mustAddrs(t, "/ip4/192.168.0.1/tcp/1234")}
+
+	var wg sync.WaitGroup
+	var claims int32
+	start := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if n.claimPeer(pi) {
+				atomic.AddInt32(&claims, 1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, claims)
+	val, found := n.peerStates.Load(pi.ID)
+	require.True(t, found)
+	assert.Equal(t, Connecting, val.(peerInfo).state)
+}
+
+func TestDiscoveryRound_giveUp(t *testing.T) {
+	r := newDiscoveryRound(3)
+	assert.False(t, r.giveUp())
+	assert.False(t, r.giveUp())
+	assert.True(t, r.giveUp())
+}
+
+// testDiscoverer doesn't find any peer and
+// blocks until it's shut down.
+type testDiscoverer struct {
+	started chan struct{}
+	stop    chan struct{}
+}
+
+func newTestDiscoverer() *testDiscoverer {
+	return &testDiscoverer{started: make(chan struct{}), stop: make(chan struct{})}
+}
+
+func (d *testDiscoverer) Discover(chanID int, handler func(info peer.AddrInfo)) error {
+	close(d.started)
+	<-d.stop
+	return nil
+}
+
+func (d *testDiscoverer) Shutdown() {
+	close(d.stop)
+}
+
+func (d *testDiscoverer) Mechanism() string {
+	return "test"
+}
+
+// unreachableDiscoverer reports a fixed number of unreachable peers.
+type unreachableDiscoverer struct {
+	*testDiscoverer
+	mechanism   string
+	unreachable int
+}
+
+func (d *unreachableDiscoverer) Mechanism() string {
+	return d.mechanism
+}
+
+func (d *unreachableDiscoverer) Unreachable() int {
+	return d.unreachable
+}
+
+func TestNode_unreachablePeers(t *testing.T) {
+	n := &Node{Node: &pcpnode.Node{}}
+	assert.Empty(t, n.unreachablePeers())
+
+	n.discoverers = []discovery.Discoverer{
+		newTestDiscoverer(),
+		&unreachableDiscoverer{testDiscoverer: newTestDiscoverer(), mechanism: "DHT", unreachable: 1},
+		&unreachableDiscoverer{testDiscoverer: newTestDiscoverer(), mechanism: "mDNS"},
+		&unreachableDiscoverer{testDiscoverer: newTestDiscoverer(), mechanism: "DHT", unreachable: 2},
+	}
+	assert.Equal(t, []string{"3 via DHT"}, n.unreachablePeers())
+}
+
+func TestNode_discover(t *testing.T) {
+	d1, d2 := newTestDiscoverer(), newTestDiscoverer()
+
+	n := &Node{Node: &pcpnode.Node{}}
+	n.discover([]discovery.Discoverer{d1, d2})
+	<-d1.started
+	<-d2.started
+
+	n.StopDiscovering()
+	for _, d := range []*testDiscoverer{d1, d2} {
+		select {
+		case <-d.stop:
+		default:
+			t.Fatal("discoverer wasn't shut down")
+		}
+	}
+}
+
+func TestNode_newDiscoverers(t *testing.T) {
+	h, err := libp2p.New(context.Background(), libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	defer h.Close()
+
+	n := &Node{Node: &pcpnode.Node{Host: h}, useDHT: true, useMDNS: true}
+
+	mechanisms := func() []string {
+		var names []string
+		for _, d := range n.newDiscoverers() {
+			names = append(names, d.Mechanism())
+		}
+		return names
+	}
+
+	// Each mechanism is paired with a discoverer for the previous time slot.
+	assert.Equal(t, []string{"DHT", "DHT", "mDNS", "mDNS"}, mechanisms())
+
+	n.Rendezvous = &peer.AddrInfo{ID: h.ID()}
+	assert.Equal(t, []string{"DHT", "DHT", "mDNS", "mDNS", "rendezvous", "rendezvous"}, mechanisms())
+
+	// Without offset discovery only the primary discoverers remain, so
+	// the round gives up once each mechanism has given up once.
+	n.noOffsetDiscovery = true
+	assert.Equal(t, []string{"DHT", "mDNS", "rendezvous"}, mechanisms())
+	n.noOffsetDiscovery = false
+
+	// A pinned time slot doesn't need them.
+	n.TimeSlot = time.Now()
+	assert.Equal(t, []string{"DHT", "mDNS", "rendezvous"}, mechanisms())
+}
+
+func TestNode_slotOffsets(t *testing.T) {
+	T := dht.TruncateDuration
+
+	n := &Node{Node: &pcpnode.Node{}}
+	assert.Equal(t, []time.Duration{0, -T}, n.slotOffsets())
+
+	n.noOffsetDiscovery = true
+	assert.Equal(t, []time.Duration{0}, n.slotOffsets())
+
+	n.noOffsetDiscovery = false
+	n.tolerantClock = true
+	assert.Equal(t, []time.Duration{0, -T, T, -2 * T, 2 * T, -3 * T}, n.slotOffsets())
+
+	n.TimeSlot = time.Now()
+	assert.Equal(t, []time.Duration{0}, n.slotOffsets())
+}
+
+func TestNew_noOffsetDiscoveryWithTolerantClock(t *testing.T) {
+	opts := DefaultOptions(nil)
+	opts.Homebrew = true
+	opts.UseDHT = false
+	opts.ResumeDir = ""
+	opts.NoOffsetDiscovery = true
+	opts.TolerantClock = true
+
+	// Only the current slot would be searched, so the clock tolerance would be a lie.
+	_, err := New(context.Background(), opts)
+	assert.EqualError(t, err, "--no-offset-discovery can't be combined with --tolerant-clock")
+}
+
+func Test_slotLabel(t *testing.T) {
+	T := dht.TruncateDuration
+	assert.Equal(t, "DHT", slotLabel("DHT", 0))
+	assert.Equal(t, "DHT (previous slot)", slotLabel("DHT", -T))
+	assert.Equal(t, "DHT (slot +1)", slotLabel("DHT", T))
+	assert.Equal(t, "mDNS (slot -2)", slotLabel("mDNS", -2*T))
+}
+
+func TestSameAddrs_ignoresOrder(t *testing.T) {
+	a := mustAddrs(t, "/ip4/192.168.0.1/tcp/1234", "/ip4/10.0.0.1/tcp/1234")
+	b := mustAddrs(t, "/ip4/10.0.0.1/tcp/1234", "/ip4/192.168.0.1/tcp/1234")
+	assert.True(t, sameAddrs(a, b))
+}
+
+func TestNode_registerAuthFailure(t *testing.T) {
+	n := &Node{Node: &pcpnode.Node{Words: []string{"one", "two", "three", "four"}}, collisionThreshold: 2}
+	assert.False(t, n.registerAuthFailure())
+	assert.False(t, n.registerAuthFailure())
+	assert.True(t, n.registerAuthFailure())
+	assert.False(t, n.registerAuthFailure()) // only warn once
+}
+
+func TestNode_registerAuthFailure_disabled(t *testing.T) {
+	n := &Node{collisionThreshold: 0}
+	for i := 0; i < 10; i++ {
+		assert.False(t, n.registerAuthFailure())
+	}
+}
+
+func Test_validateWordCount(t *testing.T) {
+	wrds := []string{"one", "two", "three", "four"}
+
+	assert.NoError(t, validateWordCount(wrds, 0, false))
+	assert.NoError(t, validateWordCount(wrds, 4, false))
+	assert.NoError(t, validateWordCount(wrds, 5, true))
+	assert.Error(t, validateWordCount(wrds, 5, false))
+	assert.Error(t, validateWordCount(wrds[:2], 2, false))
+}
+
+func TestNode_exceededAuthFailures(t *testing.T) {
+	n := &Node{maxAuthFailures: 2}
+	n.registerAuthFailure()
+	assert.False(t, n.exceededAuthFailures())
+	n.registerAuthFailure()
+	assert.True(t, n.exceededAuthFailures())
+
+	n = &Node{}
+	for i := 0; i < 10; i++ {
+		n.registerAuthFailure()
+	}
+	assert.False(t, n.exceededAuthFailures())
+}
+
+func TestNode_connect_timeout(t *testing.T) {
+	// The listener accepts connections but never completes the handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	h, err := libp2p.New(context.Background(), libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	defer h.Close()
+
+	remote, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	addr, err := manet.FromNetAddr(l.Addr())
+	require.NoError(t, err)
+
+	n := &Node{
+		Node:           &pcpnode.Node{Host: h, Service: service.New("node")},
+		connectTimeout: 100 * time.Millisecond,
+	}
+
+	start := time.Now()
+	err = n.connect(peer.AddrInfo{ID: remote, Addrs: []ma.Multiaddr{addr}})
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
+
+func TestNode_acquireDial(t *testing.T) {
+	n := &Node{
+		Node:    &pcpnode.Node{Service: service.New("node")},
+		dialSem: newDialSem(1),
+	}
+	require.NoError(t, n.ServiceStarted())
+
+	require.True(t, n.acquireDial())
+
+	acquired := make(chan bool)
+	go func() { acquired <- n.acquireDial() }()
+
+	select {
+	case <-acquired:
+		t.Fatal("second dial should wait for a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	n.releaseDial()
+	assert.True(t, <-acquired)
+
+	// Waiting dials give up on shutdown.
+	go func() { acquired <- n.acquireDial() }()
+	go n.Service.Shutdown()
+	assert.False(t, <-acquired)
+	n.ServiceStopped()
+}
+
+func TestNode_acquireDial_unlimited(t *testing.T) {
+	n := &Node{dialSem: newDialSem(0)}
+	for i := 0; i < 100; i++ {
+		assert.True(t, n.acquireDial())
+	}
+	n.releaseDial()
+}
+
+func Test_sessionSummary(t *testing.T) {
+	assert.Nil(t, sessionSummary(nil, nil))
+
+	s := &tui.Summary{Outcome: "received"}
+	assert.Equal(t, s, sessionSummary(s, errors.New("later failure")))
+
+	s = sessionSummary(nil, errors.New("no sender found"))
+	assert.Equal(t, "failed: no sender found", s.Outcome)
+}
+
+// receiveBlocked lets a sender push the given request to a new receiver
+// that accepts it. The sender writes the archive with the given function
+// and then keeps the transfer running until the test ends.
+func receiveBlocked(t *testing.T, pr *p2p.PushRequest, write func(tw *tar.Writer) error) *Node {
+	ctx := context.Background()
+
+	opts := DefaultOptions(nil)
+	opts.Homebrew = true
+	opts.UseDHT = false
+	opts.AutoAccept = true
+	opts.ResumeDir = ""
+
+	n, err := New(ctx, opts)
+	require.NoError(t, err)
+
+	sender, err := libp2p.New(ctx, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { sender.Close() })
+	require.NoError(t, sender.Connect(ctx, peer.AddrInfo{ID: n.ID(), Addrs: n.Addrs()}))
+
+	key := make([]byte, 32)
+	n.AddAuthenticatedPeer(sender.ID(), key)
+
+	pr.Header = &p2p.Header{NodeId: sender.ID().Pretty()}
+	accept, err := n.HandlePushRequest(pr)
+	require.NoError(t, err)
+	require.True(t, accept)
+
+	s, err := sender.NewStream(ctx, n.ID(), pcpnode.ProtocolTransfer)
+	require.NoError(t, err)
+
+	block := make(chan struct{})
+	t.Cleanup(func() { close(block) })
+	go pcpnode.SendArchive(s, key, func(tw *tar.Writer) error {
+		if err := write(tw); err != nil {
+			return err
+		}
+		<-block
+		return nil
+	})
+
+	return n
+}
+
+// shutdownWithin fails the test if the node doesn't shut down in time.
+func shutdownWithin(t *testing.T, n *Node, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		n.Shutdown()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		t.Fatal("shutdown waited for the running transfer")
+	}
+}
+
+func TestNode_Shutdown_cancelsRunningTransfer(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	pr := &p2p.PushRequest{Name: "file.txt", Size: 1024}
+	n := receiveBlocked(t, pr, func(tw *tar.Writer) error {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: "file.txt", Size: 1024, Mode: 0o644}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(make([]byte, 512))
+		return err
+	})
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "file.txt"))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	shutdownWithin(t, n, 5*time.Second)
+	assert.NoFileExists(t, filepath.Join(dir, "file.txt"), "partial file")
+}
+
+func TestNode_Shutdown_removesStagingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	pr := &p2p.PushRequest{Name: "dir", Size: 1024, IsDir: true}
+	n := receiveBlocked(t, pr, func(tw *tar.Writer) error {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "dir", Mode: 0o755}); err != nil {
+			return err
+		}
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: "dir/file.txt", Size: 1024, Mode: 0o644}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(make([]byte, 512))
+		return err
+	})
+
+	require.Eventually(t, func() bool {
+		entries, err := ioutil.ReadDir(dir)
+		return err == nil && len(entries) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	shutdownWithin(t, n, 5*time.Second)
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "staging directory")
+}
@@ -0,0 +1,251 @@
+package receive
+
+import (
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/dennis-tra/pcp/pkg/dht"
+	"github.com/dennis-tra/pcp/pkg/mdns"
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+)
+
+// Options configures a receiving node.
+type Options struct {
+	pcpnode.Options
+
+	// WordCount is the number of words the sender has generated. If
+	// it's set, the given words must have exactly this length. It is
+	// inferred from the given words if it's zero.
+	WordCount int
+
+	// AutoAccept accepts every transfer without prompting.
+	AutoAccept bool
+
+	// ExpectPeer is the peer ID the sender must have. Any
+	// peer is authenticated if it's empty.
+	ExpectPeer string
+
+	// AcceptFromFile is the path to a YAML or JSON file with rules
+	// to accept or reject transfers without prompting.
+	AcceptFromFile string
+
+	// BlockExt lists file extensions, e.g. exe or sh, that are
+	// rejected. Each value may be a comma separated list.
+	BlockExt []string
+
+	// BlockExtPrompt asks the user about blocked extensions instead
+	// of rejecting them, even if AutoAccept is set.
+	BlockExtPrompt bool
+
+	// CollisionThreshold is the number of peers that may fail
+	// authentication before we warn about a channel collision.
+	// Zero disables the warning.
+	CollisionThreshold int
+
+	// MaxAuthFailures is the number of peers that may fail authentication
+	// before the node gives up with ErrTooManyAuthFailures. Zero means unlimited.
+	MaxAuthFailures int
+
+	// KeepAlive waits for the next sender after a transfer has finished.
+	KeepAlive bool
+
+	// Force overwrites and SkipExisting keeps existing files. If
+	// none is set the user is asked for each conflicting file.
+	Force        bool
+	SkipExisting bool
+
+	// AllowSubnets and DenySubnets restrict the addresses of discovered
+	// peers we attempt to connect to in CIDR notation.
+	AllowSubnets []string
+	DenySubnets  []string
+
+	// DHTBackoffInitial and DHTBackoffMax configure the exponential
+	// backoff between repeated DHT provider lookups.
+	DHTBackoffInitial time.Duration
+	DHTBackoffMax     time.Duration
+
+	// DHTProviderLimit ends a DHT lookup after this many providers
+	// were found. Zero lets the lookup run until it times out.
+	DHTProviderLimit int
+
+	// DHTHintAfter is the time after which the user is told how to
+	// proceed if the DHT is reachable but no sender has provided the
+	// channel. The search goes on. Zero disables the hint.
+	DHTHintAfter time.Duration
+
+	// DHTConcurrentLookups bounds the DHT provider lookups that the
+	// discoverers of the different time slots run at the same time.
+	// The lookup in the current time slot never waits. Zero means
+	// unlimited.
+	DHTConcurrentLookups int
+
+	// MDNSDedupTTL is the time during which repeated mDNS responses of
+	// a sender with unchanged addresses are ignored. Zero handles
+	// every response.
+	MDNSDedupTTL time.Duration
+
+	// OnComplete is a command that is run after a file was received
+	// successfully. The template variables {path}, {name}, {size} and
+	// {peer} are substituted. If OnCompleteStrict is set, a failing
+	// command makes the receive fail.
+	OnComplete       string
+	OnCompleteStrict bool
+
+	// ConcurrentDials limits the number of discovered peers we connect
+	// to and authenticate at the same time. Zero means unlimited.
+	ConcurrentDials int
+
+	// ConnectTimeout is the time we try to connect to a discovered
+	// peer before we give up on it. Zero disables it.
+	ConnectTimeout time.Duration
+
+	// KeyExchangeTimeout is the time a discovered peer has to complete
+	// the password authenticated key exchange. Zero disables it.
+	KeyExchangeTimeout time.Duration
+
+	// VerifyKey is the hex encoded ed25519 public key push requests
+	// must be signed with. Requests are not verified if it's empty.
+	VerifyKey string
+
+	// TransferTimeout aborts a transfer if no bytes have arrived
+	// for this long. Zero disables the timeout.
+	TransferTimeout time.Duration
+
+	// MDNSKeepLocalAddrs keeps loopback and link-local addresses of
+	// peers found via mDNS even if LAN addresses are available.
+	MDNSKeepLocalAddrs bool
+
+	// MDNSNoPublicFilter keeps public addresses of peers found via
+	// mDNS. They are dropped by default as LAN peers should have
+	// private addresses.
+	MDNSNoPublicFilter bool
+
+	// Preserve restores the exact permission bits, modification times
+	// and, where possible, ownership of the received files.
+	Preserve bool
+
+	// TolerantClock also looks in up to ClockSkewSlots adjacent
+	// time slots in each direction in case the clocks differ.
+	TolerantClock bool
+
+	// NoOffsetDiscovery only looks in the current time slot and not in the
+	// previous one. It saves half of the discovery traffic if both peers
+	// are started in the same slot, e.g. by a script.
+	NoOffsetDiscovery bool
+
+	// MDNSFallback continues with mDNS if the DHT is unavailable, e.g.
+	// because the bootstrap peers can't be reached. The node only fails
+	// if all discovery mechanisms have failed.
+	MDNSFallback bool
+
+	// MaxRetries is the number of rounds without a result after which
+	// each discoverer gives up. The node fails once all of them have
+	// given up. Zero retries forever.
+	MaxRetries int
+
+	// HistoryFile is the path to a file that completed and failed
+	// transfers are appended to as newline-delimited JSON.
+	HistoryFile string
+
+	// ResumeDir is the directory single files are received in until
+	// they're complete. An interrupted transfer of the same file is
+	// resumed from there. Partial transfers that weren't touched for
+	// ResumeTTL are discarded. Resuming is disabled if it's empty.
+	ResumeDir string
+	ResumeTTL time.Duration
+
+	// Pick lets the user choose between all senders that authenticate
+	// within PickWindow after the first push request. The first
+	// sender is picked if the user didn't choose within PickTimeout.
+	Pick        bool
+	PickWindow  time.Duration
+	PickTimeout time.Duration
+
+	// ManifestOnly writes what the sender offers to ManifestOut and
+	// declines the transfer. An empty ManifestOut writes to stdout.
+	ManifestOnly bool
+	ManifestOut  string
+
+	// ConfirmWords shows the parsed words and the channel they map to
+	// and asks the user to confirm them before discovery starts. It's
+	// skipped with AutoAccept or if stdin is no terminal.
+	ConfirmWords bool
+}
+
+// DefaultDHTHintAfter is the time without a provider in the
+// DHT after which the user gets a hint.
+const DefaultDHTHintAfter = 2 * time.Minute
+
+// DefaultOptions returns the options the receive command uses if no
+// flags are given.
+func DefaultOptions(words []string) Options {
+	return Options{
+		Options:            pcpnode.DefaultOptions(words),
+		CollisionThreshold: 2,
+		DHTBackoffInitial:  dht.RetryBackoffInitial,
+		DHTBackoffMax:      dht.RetryBackoffMax,
+		DHTProviderLimit:   dht.DefaultProviderLimit,
+		DHTHintAfter:       DefaultDHTHintAfter,
+		ConcurrentDials:    8,
+		ConnectTimeout:     15 * time.Second,
+		KeyExchangeTimeout: 30 * time.Second,
+		PickWindow:         5 * time.Second,
+		PickTimeout:        30 * time.Second,
+		ResumeDir:          DefaultResumeDir(),
+		ResumeTTL:          DefaultResumeTTL,
+
+		DHTConcurrentLookups: dht.DefaultConcurrentLookups,
+		MDNSDedupTTL:         mdns.DefaultDedupTTL,
+	}
+}
+
+// OptionsFromContext builds the options from the command line flags.
+func OptionsFromContext(c *cli.Context, words []string) Options {
+	return Options{
+		Options:            pcpnode.OptionsFromContext(c, words),
+		WordCount:          c.Int("w"),
+		AutoAccept:         c.Bool("auto-accept"),
+		ExpectPeer:         c.String("expect-peer"),
+		AcceptFromFile:     c.String("accept-from-file"),
+		BlockExt:           c.StringSlice("block-ext"),
+		BlockExtPrompt:     c.Bool("block-ext-prompt"),
+		CollisionThreshold: c.Int("collision-threshold"),
+		MaxAuthFailures:    c.Int("max-auth-failures"),
+		KeepAlive:          c.Bool("keep-alive"),
+		Force:              c.Bool("force"),
+		SkipExisting:       c.Bool("skip-existing"),
+		AllowSubnets:       c.StringSlice("allow-subnet"),
+		DenySubnets:        c.StringSlice("deny-subnet"),
+		DHTBackoffInitial:  c.Duration("dht-backoff-initial"),
+		DHTBackoffMax:      c.Duration("dht-backoff-max"),
+		DHTProviderLimit:   c.Int("dht-provider-limit"),
+		DHTHintAfter:       c.Duration("dht-hint-after"),
+		ConcurrentDials:    c.Int("concurrent-dials"),
+		ConnectTimeout:     c.Duration("connect-timeout"),
+		OnComplete:         c.String("on-complete"),
+		OnCompleteStrict:   c.Bool("on-complete-strict"),
+		KeyExchangeTimeout: c.Duration("pake-timeout"),
+		VerifyKey:          c.String("verify-key"),
+		TransferTimeout:    c.Duration("transfer-timeout"),
+		MDNSKeepLocalAddrs: c.Bool("mdns-keep-local-addrs"),
+		MDNSNoPublicFilter: c.Bool("no-mdns-public-filter"),
+		MDNSFallback:       c.Bool("mdns-fallback"),
+		MaxRetries:         c.Int("max-retries"),
+		Preserve:           c.Bool("preserve"),
+		TolerantClock:      c.Bool("tolerant-clock"),
+		NoOffsetDiscovery:  c.Bool("no-offset-discovery"),
+		HistoryFile:        c.String("history-file"),
+		ResumeDir:          c.String("resume-dir"),
+		ResumeTTL:          c.Duration("resume-ttl"),
+		Pick:               c.Bool("pick"),
+		PickWindow:         c.Duration("pick-window"),
+		PickTimeout:        c.Duration("pick-timeout"),
+		ManifestOnly:       c.Bool("manifest-only"),
+		ManifestOut:        c.String("manifest-out"),
+		ConfirmWords:       c.Bool("confirm-words"),
+
+		DHTConcurrentLookups: c.Int("dht-concurrent-lookups"),
+		MDNSDedupTTL:         c.Duration("mdns-dedup-ttl"),
+	}
+}
@@ -0,0 +1,235 @@
+package receive
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/dennis-tra/pcp/internal/format"
+	"github.com/dennis-tra/pcp/internal/log"
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+// candidate is an authenticated sender whose push request
+// waits for the user to pick a sender.
+type candidate struct {
+	peerID peer.ID
+	pr     *p2p.PushRequest
+	picked chan bool
+
+	// direct is true if we're connected to the sender without a relay.
+	direct bool
+
+	// rtt is the measured round trip time. It's zero if it's unknown.
+	rtt time.Duration
+}
+
+// quality describes the connection to the candidate for the user.
+func (c *candidate) quality() string {
+	connType := "relayed"
+	if c.direct {
+		connType = "direct"
+	}
+	if c.rtt == 0 {
+		return connType
+	}
+	return connType + ", " + c.rtt.Round(time.Millisecond).String()
+}
+
+// rankCandidates orders the candidates by the quality of the connection.
+// Direct connections come first, ties are broken by the round trip time.
+// Candidates without a measurement go last within their group.
+func rankCandidates(candidates []*candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ci, cj := candidates[i], candidates[j]
+		if ci.direct != cj.direct {
+			return ci.direct
+		}
+		if ci.rtt == 0 || cj.rtt == 0 {
+			return ci.rtt != 0 && cj.rtt == 0
+		}
+		return ci.rtt < cj.rtt
+	})
+}
+
+// peerPicker buffers the push requests of all authenticated senders
+// that arrive within a time window after the first one. Afterwards
+// the user chooses from which sender to receive. All other
+// senders are rejected.
+type peerPicker struct {
+	lk         sync.Mutex
+	candidates []*candidate
+	sources    map[peer.ID]string
+	decided    bool
+
+	// window is the time we wait for further push requests after the first one.
+	window time.Duration
+
+	// timeout is the time the user has to choose. The first sender is
+	// picked if no choice was made in time.
+	timeout time.Duration
+}
+
+func newPeerPicker(window time.Duration, timeout time.Duration) *peerPicker {
+	return &peerPicker{
+		sources: map[peer.ID]string{},
+		window:  window,
+		timeout: timeout,
+	}
+}
+
+// addSource remembers the discovery mechanism that has found the given peer.
+func (p *peerPicker) addSource(peerID peer.ID, source string) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.sources[peerID] = source
+}
+
+// add buffers the push request of the given peer. It returns nil if the
+// choice was already made. The first candidate starts the selection.
+func (p *peerPicker) add(peerID peer.ID, pr *p2p.PushRequest) (c *candidate, first bool) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	if p.decided {
+		return nil, false
+	}
+
+	c = &candidate{peerID: peerID, pr: pr, picked: make(chan bool, 1)}
+	p.candidates = append(p.candidates, c)
+
+	return c, len(p.candidates) == 1
+}
+
+// close stops accepting candidates and returns the buffered ones.
+func (p *peerPicker) close() []*candidate {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.decided = true
+	return p.candidates
+}
+
+// reset prepares the picker for the next round of senders.
+func (p *peerPicker) reset() {
+	if p == nil {
+		return
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.candidates = nil
+	p.sources = map[peer.ID]string{}
+	p.decided = false
+}
+
+// pick blocks until the user has chosen a sender and returns
+// true if it's the sender of the given push request.
+func (n *Node) pick(peerID peer.ID, pr *p2p.PushRequest) bool {
+	c, first := n.picker.add(peerID, pr)
+	if c == nil {
+		log.Infoln("Rejecting", pr.Name, "from", peerID, "as another sender was picked")
+		return false
+	}
+
+	if first {
+		go n.runPicker()
+	}
+
+	select {
+	case picked := <-c.picked:
+		return picked
+	case <-n.SigShutdown():
+		return false
+	}
+}
+
+// runPicker waits for further candidates and lets the user choose one.
+func (n *Node) runPicker() {
+	log.Infof("Waiting %s for other senders...\n", n.picker.window)
+	select {
+	case <-time.After(n.picker.window):
+	case <-n.SigShutdown():
+		return
+	}
+
+	candidates := n.picker.close()
+
+	idx := 0
+	if len(candidates) > 1 {
+		n.measureCandidates(candidates)
+		rankCandidates(candidates)
+		idx = n.promptPick(candidates)
+	}
+
+	picked := candidates[idx]
+	n.picker.lk.Lock()
+	n.peerSource = n.picker.sources[picked.peerID]
+	n.picker.lk.Unlock()
+
+	// We're done searching as we have picked our peer.
+	n.SetState(pcpnode.Connected)
+	n.StopDiscovering()
+
+	for i, c := range candidates {
+		c.picked <- i == idx
+	}
+}
+
+// measureCandidates determines concurrently whether the connection to each
+// candidate is direct and how long a round trip takes. It's only done if
+// there is a choice, so a single sender isn't delayed.
+func (n *Node) measureCandidates(candidates []*candidate) {
+	var wg sync.WaitGroup
+	for _, c := range candidates {
+		wg.Add(1)
+		go func(c *candidate) {
+			defer wg.Done()
+			c.direct = n.HasDirectConn(c.peerID)
+			rtt, err := n.RTT(n.ServiceContext(), c.peerID)
+			if err != nil {
+				log.Debugln("Could not measure round trip time to", c.peerID, err)
+				return
+			}
+			c.rtt = rtt
+		}(c)
+	}
+	wg.Wait()
+}
+
+// promptPick lists the given candidates and asks the user to choose
+// one. It returns the index of the first candidate, which has the
+// best connection, if the user didn't choose in time.
+func (n *Node) promptPick(candidates []*candidate) int {
+	log.Infoln("Found multiple senders:")
+	for i, c := range candidates {
+		log.Infof("\t[%d] %s - %s (%s) [%s]\n", i+1, c.peerID, c.pr.Name, format.Bytes(c.pr.Size), c.quality())
+	}
+
+	deadline := time.Now().Add(n.picker.timeout)
+	for {
+		log.Infof("From which sender do you want to receive? [1-%d] ", len(candidates))
+		line, ok, err := n.readLineTimeout(time.Until(deadline))
+		if err != nil || !ok {
+			log.Infoln("No sender picked, using the one with the best connection")
+			return 0
+		}
+
+		input := strings.TrimSpace(line)
+		if input == "" {
+			continue
+		}
+
+		choice, err := strconv.Atoi(input)
+		if err != nil || choice < 1 || choice > len(candidates) {
+			log.Infoln("Invalid input")
+			continue
+		}
+
+		return choice - 1
+	}
+}
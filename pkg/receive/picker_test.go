@@ -0,0 +1,50 @@
+package receive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+func TestPeerPicker(t *testing.T) {
+	p := newPeerPicker(time.Second, time.Second)
+
+	c1, first := p.add("peer-1", &p2p.PushRequest{Name: "a"})
+	require.NotNil(t, c1)
+	assert.True(t, first)
+
+	c2, first := p.add("peer-2", &p2p.PushRequest{Name: "b"})
+	require.NotNil(t, c2)
+	assert.False(t, first)
+
+	assert.Equal(t, []*candidate{c1, c2}, p.close())
+
+	// No candidates are accepted after the choice was made.
+	c3, first := p.add("peer-3", &p2p.PushRequest{Name: "c"})
+	assert.Nil(t, c3)
+	assert.False(t, first)
+
+	p.reset()
+	c3, first = p.add("peer-3", &p2p.PushRequest{Name: "c"})
+	require.NotNil(t, c3)
+	assert.True(t, first)
+}
+
+func TestRankCandidates(t *testing.T) {
+	relayedFast := &candidate{peerID: "relayed-fast", rtt: time.Millisecond}
+	directSlow := &candidate{peerID: "direct-slow", direct: true, rtt: 50 * time.Millisecond}
+	directFast := &candidate{peerID: "direct-fast", direct: true, rtt: 5 * time.Millisecond}
+	directUnknown := &candidate{peerID: "direct-unknown", direct: true}
+
+	candidates := []*candidate{relayedFast, directUnknown, directSlow, directFast}
+	rankCandidates(candidates)
+	assert.Equal(t, []*candidate{directFast, directSlow, directUnknown, relayedFast}, candidates)
+
+	assert.Equal(t, "direct, 5ms", directFast.quality())
+	assert.Equal(t, "direct", directUnknown.quality())
+	assert.Equal(t, "relayed, 1ms", relayedFast.quality())
+}
@@ -0,0 +1,74 @@
+package receive
+
+import (
+	"archive/tar"
+	"os"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// preserver restores the permission bits, modification times and
+// ownership of received files as they were on the sender's side. The
+// permission bits are otherwise subject to the umask and existing
+// files keep their mode. Failures are only warned about once per
+// kind, e.g. if we're not allowed to change the owner.
+type preserver struct {
+	dirs []preservedDir
+
+	warnedChmod   bool
+	warnedChtimes bool
+	warnedChown   bool
+}
+
+// preservedDir is a received directory whose modification time is
+// restored after its content was written as that changes it.
+type preservedDir struct {
+	path string
+	hdr  *tar.Header
+}
+
+// file applies the metadata of the given tar entry to the received file.
+func (p *preserver) file(path string, hdr *tar.Header) {
+	if p == nil {
+		return
+	}
+	p.apply(path, hdr)
+}
+
+// dir remembers the received directory until finish is called.
+func (p *preserver) dir(path string, hdr *tar.Header) {
+	if p == nil {
+		return
+	}
+	p.dirs = append(p.dirs, preservedDir{path: path, hdr: hdr})
+}
+
+// finish applies the metadata of all received directories. Nested
+// directories come last in the tar ball, so we restore them first.
+func (p *preserver) finish() {
+	if p == nil {
+		return
+	}
+
+	for i := len(p.dirs) - 1; i >= 0; i-- {
+		p.apply(p.dirs[i].path, p.dirs[i].hdr)
+	}
+	p.dirs = nil
+}
+
+func (p *preserver) apply(path string, hdr *tar.Header) {
+	if err := os.Chmod(path, hdr.FileInfo().Mode().Perm()); err != nil && !p.warnedChmod {
+		log.Warningln("Could not preserve permissions:", err)
+		p.warnedChmod = true
+	}
+
+	if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil && !p.warnedChown {
+		log.Warningln("Could not preserve ownership:", err)
+		p.warnedChown = true
+	}
+
+	if err := os.Chtimes(path, hdr.ModTime, hdr.ModTime); err != nil && !p.warnedChtimes {
+		log.Warningln("Could not preserve modification time:", err)
+		p.warnedChtimes = true
+	}
+}
@@ -0,0 +1,57 @@
+package receive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferHandler_HandleFile_preserve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	data := []byte("#!/bin/sh")
+	hdrs := []*tar.Header{
+		{Name: "dir", Mode: 0o750, ModTime: mtime, Typeflag: tar.TypeDir, Uid: os.Getuid(), Gid: os.Getgid()},
+		{Name: "dir/run.sh", Mode: 0o751, ModTime: mtime, Size: int64(len(data)), Typeflag: tar.TypeReg, Uid: os.Getuid(), Gid: os.Getgid()},
+	}
+
+	done := make(chan int64, 1)
+	th := &TransferHandler{done: done, onConflict: ConflictOverwrite, preserve: &preserver{}}
+	for _, hdr := range hdrs {
+		require.NoError(t, th.HandleFile(hdr, bytes.NewReader(data[:hdr.Size])))
+	}
+	th.Done()
+
+	info, err := os.Stat(filepath.Join(dir, "dir", "run.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o751), info.Mode().Perm())
+	assert.True(t, info.ModTime().Equal(mtime))
+
+	// The directory is restored after its content was written.
+	info, err = os.Stat(filepath.Join(dir, "dir"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o750), info.Mode().Perm())
+	assert.True(t, info.ModTime().Equal(mtime))
+}
+
+func TestPreserver_nil(t *testing.T) {
+	var p *preserver
+	p.file("does-not-exist", &tar.Header{})
+	p.dir("does-not-exist", &tar.Header{})
+	p.finish()
+}
@@ -0,0 +1,123 @@
+package receive
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// ErrPromptCancelled is returned if we stopped waiting for user input
+// because the node is shutting down or the peer has disconnected.
+var ErrPromptCancelled = errors.New("prompt cancelled")
+
+// ErrNoTerminal is returned if a transfer needs to be confirmed, but
+// stdin is no terminal and didn't provide an answer either.
+var ErrNoTerminal = errors.New("no terminal available to confirm the transfer; pass --auto-accept")
+
+// stdinIsTerminal reports whether stdin is connected to a terminal.
+// It's a variable for testing purposes.
+var stdinIsTerminal = func() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// stdinLine is the result of scanning a single line from stdin.
+type stdinLine struct {
+	text string
+	ok   bool
+	err  error
+}
+
+// stdin returns the channel that receives the lines read from stdin.
+// Scanning stdin cannot be interrupted, so it is done in a single go
+// routine for the lifetime of the node. A line is only consumed if
+// someone is reading from the channel, so no input is lost if
+// a prompt was cancelled.
+func (n *Node) stdin() <-chan stdinLine {
+	n.stdinOnce.Do(func() {
+		n.stdinLines = make(chan stdinLine)
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for {
+				ok := scanner.Scan()
+				n.stdinLines <- stdinLine{text: scanner.Text(), ok: ok, err: scanner.Err()}
+			}
+		}()
+	})
+	return n.stdinLines
+}
+
+// readLine reads a single line from stdin while we're listening for
+// a shutdown signal or the disconnection of the given peer.
+func (n *Node) readLine(peerID peer.ID) (string, bool, error) {
+	disconnected := n.notifyDisconnect(peerID)
+	defer disconnected.stop()
+
+	select {
+	case l := <-n.stdin():
+		return l.text, l.ok, l.err
+	case <-n.SigShutdown():
+		// Terminate the pending prompt line.
+		fmt.Fprintln(log.Out)
+		return "", false, ErrPromptCancelled
+	case <-disconnected.C:
+		fmt.Fprintln(log.Out)
+		log.Infoln("Peer disconnected")
+		return "", false, ErrPromptCancelled
+	}
+}
+
+// readLineTimeout reads a single line from stdin. It returns
+// ErrPromptCancelled if no line was entered in time.
+func (n *Node) readLineTimeout(timeout time.Duration) (string, bool, error) {
+	select {
+	case l := <-n.stdin():
+		return l.text, l.ok, l.err
+	case <-n.SigShutdown():
+		fmt.Fprintln(log.Out)
+		return "", false, ErrPromptCancelled
+	case <-time.After(timeout):
+		fmt.Fprintln(log.Out)
+		return "", false, ErrPromptCancelled
+	}
+}
+
+// disconnectNotifier closes its channel as soon as there is
+// no connection left to the observed peer.
+type disconnectNotifier struct {
+	C    chan struct{}
+	once sync.Once
+	stop func()
+}
+
+// notifyDisconnect returns a notifier for the disconnection of the
+// given peer. The notifier must be stopped after it is not needed anymore.
+func (n *Node) notifyDisconnect(peerID peer.ID) *disconnectNotifier {
+	dn := &disconnectNotifier{C: make(chan struct{})}
+	nb := &network.NotifyBundle{
+		DisconnectedF: func(net network.Network, conn network.Conn) {
+			if conn.RemotePeer() != peerID || net.Connectedness(peerID) == network.Connected {
+				return
+			}
+			dn.once.Do(func() { close(dn.C) })
+		},
+	}
+
+	n.Network().Notify(nb)
+	dn.stop = func() { n.Network().StopNotify(nb) }
+
+	// The peer may have disconnected before we started listening.
+	if n.Network().Connectedness(peerID) != network.Connected {
+		dn.once.Do(func() { close(dn.C) })
+	}
+
+	return dn
+}
@@ -0,0 +1,83 @@
+package receive
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+func TestNode_HandlePushRequest_noTerminal(t *testing.T) {
+	// Stdin is closed and nobody can answer.
+	n, remote := promptNode(t, stdinLine{})
+	n.stdinTerminal = false
+
+	pr := &p2p.PushRequest{Header: &p2p.Header{NodeId: remote.Pretty()}, Name: "file.txt", Size: 4}
+
+	accept, err := n.HandlePushRequest(pr)
+	assert.False(t, accept)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrNoTerminal, n.Wait(context.Background()))
+}
+
+// promptNode returns a receiving node whose stdin yields the given lines
+// and the ID of a peer that is connected to it.
+func promptNode(t *testing.T, lines ...stdinLine) (*Node, peer.ID) {
+	ctx := context.Background()
+
+	opts := DefaultOptions(nil)
+	opts.Homebrew = true
+	opts.UseDHT = false
+	opts.ResumeDir = t.TempDir()
+
+	n, err := New(ctx, opts)
+	require.NoError(t, err)
+	t.Cleanup(n.Shutdown)
+
+	n.stdinOnce.Do(func() {
+		n.stdinLines = make(chan stdinLine, len(lines))
+		for _, l := range lines {
+			n.stdinLines <- l
+		}
+	})
+
+	remote, err := libp2p.New(ctx, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { remote.Close() })
+	require.NoError(t, remote.Connect(ctx, peer.AddrInfo{ID: n.ID(), Addrs: n.Addrs()}))
+
+	return n, remote.ID()
+}
+
+func TestNode_promptConflict_noStdin(t *testing.T) {
+	// Stdin is closed and nobody can answer.
+	n, remote := promptNode(t, stdinLine{})
+	assert.Equal(t, ConflictSkip, n.promptConflict(remote)("file.txt"))
+}
+
+func TestNode_readLine(t *testing.T) {
+	n, remote := promptNode(t, stdinLine{text: "y", ok: true})
+
+	line, ok, err := n.readLine(remote)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "y", line)
+}
+
+func TestNode_readLine_alreadyDisconnected(t *testing.T) {
+	// Nothing is ever entered, so only the disconnection ends the prompt.
+	n, _ := promptNode(t)
+
+	gone, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	_, ok, err := n.readLine(gone)
+	assert.False(t, ok)
+	assert.Equal(t, ErrPromptCancelled, err)
+}
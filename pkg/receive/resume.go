@@ -0,0 +1,671 @@
+package receive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/internal/format"
+	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/pkg/metrics"
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+// DefaultResumeTTL is the time after which an untouched
+// partial transfer in the resume directory is discarded.
+const DefaultResumeTTL = 7 * 24 * time.Hour
+
+// DefaultResumeDir returns the directory partial transfers are kept in. It
+// lies in the user's cache directory or, if there is none, in the
+// temporary directory.
+func DefaultResumeDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "pcp", "resume")
+	}
+	return filepath.Join(os.TempDir(), "pcp-resume")
+}
+
+// resumeMeta is stored next to a partial file and describes the
+// file it belongs to. The size of the partial file is the offset
+// the transfer is resumed at.
+type resumeMeta struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+
+	// Staging is set for directories. It's the directory the partial
+	// directory is kept in. Files maps the names of the completely
+	// received files to the hex encoded hashes of their content and
+	// Partial is the name of the file the transfer was interrupted in.
+	Staging string            `json:"staging,omitempty"`
+	Files   map[string]string `json:"files,omitempty"`
+	Partial string            `json:"partial,omitempty"`
+}
+
+// resumeStore keeps partially received files in a directory outside of
+// the current working directory. A file is received into the store and
+// only moved into place after it was verified. If the transfer is
+// interrupted, the next transfer of the same file continues where it
+// has stopped.
+type resumeStore struct {
+	dir string
+
+	// ttl is the time after which untouched partial transfers
+	// are discarded. Zero keeps them forever.
+	ttl time.Duration
+}
+
+func newResumeStore(dir string, ttl time.Duration) *resumeStore {
+	return &resumeStore{dir: dir, ttl: ttl}
+}
+
+// resumable returns true if the given push request can be received
+// into the resume store. Directories are received in place.
+func resumable(pr *p2p.PushRequest) bool {
+	return !pr.IsDir && !pr.Benchmark && pr.Size > 0
+}
+
+// fileKey identifies the partial transfer of the file with the given
+// name and size. The sender checks that the bytes we already have match
+// the beginning of its file, so other files with the same name and size
+// are sent in full.
+func fileKey(name string, size int64) string {
+	hash := sha256.Sum256([]byte("file\x00" + name + "\x00" + strconv.FormatInt(size, 10)))
+	return hex.EncodeToString(hash[:])
+}
+
+// lookup returns the partial transfer for the given push request. Stale
+// entries are discarded before. If there is no partial transfer of
+// the file yet, a new one at offset zero is prepared.
+func (s *resumeStore) lookup(pr *p2p.PushRequest) (*partial, error) {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "failed creating resume directory")
+	}
+
+	key := fileKey(pr.Name, pr.Size)
+	s.prune(pr.Name, key)
+
+	p := &partial{
+		path: filepath.Join(s.dir, key+".part"),
+		meta: filepath.Join(s.dir, key+".json"),
+	}
+
+	meta, err := readResumeMeta(p.meta)
+	if err == nil && meta.Size == pr.Size {
+		if info, err := os.Stat(p.path); err == nil && info.Size() <= pr.Size {
+			if p.offsetHash, err = pcpnode.PrefixHash(p.path, info.Size()); err == nil {
+				p.offset = info.Size()
+				return p, nil
+			}
+			log.Debugln("error hashing partial file:", err)
+		}
+	}
+
+	p.remove()
+	data, err := json.Marshal(resumeMeta{Name: pr.Name, Size: pr.Size, Hash: key})
+	if err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(p.meta, data, 0o600); err != nil {
+		return nil, errors.Wrap(err, "failed writing resume metadata")
+	}
+
+	return p, nil
+}
+
+// prune discards partial transfers that haven't been touched for longer
+// than the TTL, that belong to another version of the file with the
+// given name, or whose metadata is broken.
+func (s *resumeStore) prune(name string, key string) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		log.Debugln("error reading resume directory:", err)
+		return
+	}
+
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), ".json") {
+			continue
+		}
+
+		p := &partial{meta: filepath.Join(s.dir, info.Name())}
+		p.path = strings.TrimSuffix(p.meta, ".json") + ".part"
+
+		touched := info.ModTime()
+		if part, err := os.Stat(p.path); err == nil && part.ModTime().After(touched) {
+			touched = part.ModTime()
+		}
+
+		meta, err := readResumeMeta(p.meta)
+		switch {
+		case err != nil:
+			log.Debugln("Discarding partial transfer with broken metadata:", p.meta, err)
+		case s.ttl > 0 && time.Since(touched) > s.ttl:
+			log.Debugln("Discarding expired partial transfer of", meta.Name)
+		case meta.Name == name && meta.Hash != key && meta.Staging == "":
+			log.Debugln("Discarding partial transfer of a different version of", meta.Name)
+		default:
+			continue
+		}
+		p.remove()
+		if meta != nil && meta.Staging != "" {
+			removeStaging(meta.Staging)
+		}
+	}
+}
+
+func readResumeMeta(path string) (*resumeMeta, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &resumeMeta{}
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// partial is a partially received file in the resume store.
+type partial struct {
+	path string
+	meta string
+
+	// offset is the number of bytes we already had when the
+	// transfer was accepted and offsetHash is their hash.
+	offset     int64
+	offsetHash []byte
+
+	// hdr is the tar entry of the file. It's set once the
+	// file has arrived.
+	hdr *tar.Header
+}
+
+// remove discards the partial file together with its metadata.
+func (p *partial) remove() {
+	if p == nil {
+		return
+	}
+	for _, path := range []string{p.path, p.meta} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Warningln("error removing partial transfer:", path, err)
+		}
+	}
+}
+
+// receivePartial appends the received content to the partial file in
+// the resume store. The partial file is kept if the transfer is
+// interrupted or cancelled, so that it can be resumed later.
+func (th *TransferHandler) receivePartial(hdr *tar.Header, src io.Reader) error {
+	p := th.resume
+
+	// The sender sends the file in full if it has changed since.
+	if record, found := hdr.PAXRecords[pcpnode.ResumeOffsetRecord]; !found {
+		p.offset = 0
+	} else if offset, err := strconv.ParseInt(record, 10, 64); err != nil || offset != p.offset {
+		return fmt.Errorf("sender resumed %s at an unexpected offset", printable(hdr.Name))
+	}
+
+	f, err := os.OpenFile(p.path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return writeError(err, "error opening partial file %s", p.path)
+	}
+	defer f.Close()
+
+	// Drop anything beyond the offset we told the sender.
+	if err = f.Truncate(p.offset); err != nil {
+		return writeError(err, "error truncating partial file %s", p.path)
+	}
+
+	// The content hash covers the whole file, so feed it the bytes we already have.
+	if th.contentHash != nil && p.offset > 0 {
+		if _, err = io.Copy(th.contentHash, io.NewSectionReader(f, 0, p.offset)); err != nil {
+			return errors.Wrapf(err, "error reading partial file %s", p.path)
+		}
+	}
+
+	if _, err = f.Seek(p.offset, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "error seeking in partial file %s", p.path)
+	}
+
+	if p.offset > 0 {
+		log.Infof("Resuming %s after %s\n", filepath.Base(hdr.Name), format.Bytes(p.offset))
+	}
+
+	p.hdr = hdr
+	th.received += p.offset
+
+	bar := log.NewProgressBar(hdr.Size, filepath.Base(hdr.Name))
+	n, err := pcpnode.CopyChunks(io.MultiWriter(f, bar), src, th.chunkSize)
+	th.received += n
+	metrics.BytesTransferred.WithLabelValues(metrics.DirectionReceived).Add(float64(n))
+	if err == nil {
+		return nil
+	}
+
+	select {
+	case <-th.cancelled:
+		log.Infoln("Kept the partial file to resume the transfer later")
+		return ErrTransferCancelled
+	default:
+	}
+
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) {
+		// Reading from the stream failed. Keep the partial file, the
+		// transfer protocol reports the broken stream.
+		log.Warningln("error receiving file content:", th.path, err)
+		return nil
+	}
+
+	// We couldn't write to disk, so a later resume would fail as well.
+	f.Close()
+	p.remove()
+
+	return writeError(err, "error writing partial file %s", p.path)
+}
+
+// commitPartial moves the completely received file from the resume store
+// into place. The sender has checked the bytes we already had against
+// its file and the rest was authenticated while it was received.
+func (th *TransferHandler) commitPartial() error {
+	p := th.resume
+
+	if err := moveFile(p.path, th.path); err != nil {
+		return errors.Wrapf(err, "could not move the received file into place, it was kept at %s", p.path)
+	}
+	th.resume = nil
+	p.remove()
+
+	th.xattrs.restore(th.path, p.hdr)
+	if err := os.Chmod(th.path, createPerm(p.hdr)); err != nil {
+		log.Warningln("error setting file permissions:", th.path, err)
+	}
+	th.preserve.file(th.path, p.hdr)
+
+	return nil
+}
+
+// createPerm returns the permission bits of the given tar entry masked
+// with the umask, like os.OpenFile applies them to files it creates.
+// Partial files are created private and get these bits once complete.
+func createPerm(hdr *tar.Header) os.FileMode {
+	return hdr.FileInfo().Mode().Perm() &^ umask
+}
+
+// moveFile renames src to dst. If both are on different file systems
+// the file is copied instead.
+func moveFile(src string, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return writeError(err, "error copying %s", src)
+	}
+
+	if err = out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// resumableDir returns true if the given push request is for a directory
+// that can be received into a staging directory that is kept if the
+// transfer is interrupted.
+func resumableDir(pr *p2p.PushRequest) bool {
+	return pr.IsDir && !pr.Benchmark
+}
+
+// dirKey identifies the partial transfer of the directory
+// with the given name into the given directory.
+func dirKey(cwd string, name string) string {
+	hash := sha256.Sum256([]byte("dir\x00" + cwd + "\x00" + name))
+	return hex.EncodeToString(hash[:])
+}
+
+// lookupDir returns the partial transfer of the directory of the given
+// push request into the given directory. If there is none yet, a new one
+// is prepared. The files are received in a staging directory next to the
+// final directory, so that it can be moved into place atomically.
+func (s *resumeStore) lookupDir(pr *p2p.PushRequest, cwd string) (*partialDir, error) {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "failed creating resume directory")
+	}
+
+	key := dirKey(cwd, pr.Name)
+	s.prune(pr.Name, key)
+
+	p := &partialDir{
+		meta: filepath.Join(s.dir, key+".json"),
+		seen: map[string]bool{},
+	}
+
+	meta, err := readResumeMeta(p.meta)
+	if err == nil && meta.Staging != "" {
+		if info, err := os.Stat(meta.Staging); err == nil && info.IsDir() {
+			p.state = *meta
+			p.state.Size = pr.Size
+			if p.state.Files == nil {
+				p.state.Files = map[string]string{}
+			}
+			p.findOffset()
+			return p, nil
+		}
+	}
+
+	if err == nil {
+		(&partial{meta: p.meta}).remove()
+	}
+
+	p.state = resumeMeta{
+		Name:    pr.Name,
+		Size:    pr.Size,
+		Hash:    key,
+		Staging: filepath.Join(cwd, ".pcp-resume-"+key[:16]),
+		Files:   map[string]string{},
+	}
+	if err = p.save(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// partialDir is a partially received directory. The received files are
+// kept in a staging directory and the state is kept in the resume store.
+type partialDir struct {
+	meta  string
+	state resumeMeta
+
+	// offset is the number of bytes of the partial file we already have
+	// and offsetHash is their hash.
+	offset     int64
+	offsetHash []byte
+
+	// seen holds the files that were received
+	// or confirmed by the sender in this transfer.
+	seen map[string]bool
+}
+
+// findOffset determines how much of the file the transfer
+// was interrupted in we already have.
+func (p *partialDir) findOffset() {
+	if p.state.Partial == "" {
+		return
+	}
+
+	path, err := p.path(p.state.Partial)
+	if err != nil {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		return
+	}
+
+	hash, err := pcpnode.PrefixHash(path, info.Size())
+	if err != nil {
+		log.Debugln("error hashing partial file:", err)
+		return
+	}
+
+	p.offset = info.Size()
+	p.offsetHash = hash
+}
+
+// path returns where the file with the given tar name is kept.
+func (p *partialDir) path(name string) (string, error) {
+	clean, err := sanitizeName(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(p.state.Staging, clean), nil
+}
+
+// response returns the files we already have to tell the sender.
+func (p *partialDir) response() *pcpnode.DirResume {
+	if p == nil {
+		return nil
+	}
+
+	r := &pcpnode.DirResume{Done: map[string][]byte{}}
+	for name, key := range p.state.Files {
+		hash, err := hex.DecodeString(key)
+		if err != nil {
+			continue
+		}
+		r.Done[name] = hash
+	}
+	if p.offset > 0 {
+		r.Partial = p.state.Partial
+		r.PartialHash = p.offsetHash
+		r.Offset = p.offset
+	}
+	return r
+}
+
+// save writes the state of the partial directory to the resume store.
+func (p *partialDir) save() error {
+	data, err := json.Marshal(p.state)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(p.meta, data, 0o600); err != nil {
+		return errors.Wrap(err, "failed writing resume metadata")
+	}
+	return nil
+}
+
+// begin records that the file with the given name is being received.
+func (p *partialDir) begin(name string) {
+	p.seen[name] = true
+	delete(p.state.Files, name)
+	p.state.Partial = name
+	if err := p.save(); err != nil {
+		log.Warningln(err)
+	}
+}
+
+// complete records that the file with the given name was received completely.
+func (p *partialDir) complete(name string, path string) {
+	hash, err := pcpnode.FileHash(path)
+	if err != nil {
+		log.Warningln("Could not record received file to resume later:", err)
+		return
+	}
+
+	p.seen[name] = true
+	p.state.Files[name] = hex.EncodeToString(hash)
+	if p.state.Partial == name {
+		p.state.Partial = ""
+	}
+	if err = p.save(); err != nil {
+		log.Warningln(err)
+	}
+}
+
+// prune removes the files of an earlier transfer from the staging
+// directory that the sender hasn't sent or confirmed in this one,
+// e.g. because they were deleted on the sender side.
+func (p *partialDir) prune() {
+	names := []string{p.state.Partial}
+	for name := range p.state.Files {
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		if name == "" || p.seen[name] {
+			continue
+		}
+		path, err := p.path(name)
+		if err != nil {
+			continue
+		}
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Warningln("error removing stale file:", path, err)
+		}
+	}
+}
+
+// remove discards the state of the partial directory. If staging is
+// set the staging directory with the received files is removed as well.
+func (p *partialDir) remove(staging bool) {
+	if p == nil {
+		return
+	}
+	if err := os.Remove(p.meta); err != nil && !os.IsNotExist(err) {
+		log.Warningln("error removing partial transfer:", p.meta, err)
+	}
+	if staging {
+		removeStaging(p.state.Staging)
+	}
+}
+
+// removeStaging removes the staging directory of a partial directory.
+func removeStaging(staging string) {
+	if err := os.RemoveAll(staging); err != nil {
+		log.Warningln("error removing partial directory:", staging, err)
+	}
+}
+
+// receiveResumed handles an entry of a resumed directory transfer that
+// the sender has marked with a resume record. A skipped file is taken
+// from the staging directory and a resumed file is appended to.
+func (th *TransferHandler) receiveResumed(hdr *tar.Header, joined string, src io.Reader) error {
+	p := th.dirResume
+	if p == nil {
+		return fmt.Errorf("unexpected resumed entry %s", printable(hdr.Name))
+	}
+
+	if _, skip := hdr.PAXRecords[pcpnode.ResumeSkipRecord]; skip {
+		if _, found := p.state.Files[hdr.Name]; !found {
+			return fmt.Errorf("sender skipped %s that we don't have", printable(hdr.Name))
+		}
+
+		f, err := os.Open(joined)
+		if err != nil {
+			return errors.Wrapf(err, "error opening received file %s", joined)
+		}
+		defer f.Close()
+
+		// The content hash covers the skipped files as well.
+		var n int64
+		if th.contentHash != nil {
+			n, err = io.Copy(th.contentHash, f)
+		} else {
+			var info os.FileInfo
+			info, err = f.Stat()
+			if info != nil {
+				n = info.Size()
+			}
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error reading received file %s", joined)
+		}
+
+		p.seen[hdr.Name] = true
+		th.received += n
+		log.Infoln(filepath.Base(hdr.Name), "(already received)")
+		th.xattrs.restore(joined, hdr)
+		th.preserve.file(joined, hdr)
+		return nil
+	}
+
+	offset, err := strconv.ParseInt(hdr.PAXRecords[pcpnode.ResumeOffsetRecord], 10, 64)
+	if err != nil || hdr.Name != p.state.Partial || offset != p.offset {
+		return fmt.Errorf("sender resumed %s at an unexpected offset", printable(hdr.Name))
+	}
+
+	f, err := os.OpenFile(joined, os.O_RDWR, 0o600)
+	if err != nil {
+		return writeError(err, "error opening partial file %s", joined)
+	}
+	defer f.Close()
+
+	if err = f.Truncate(offset); err != nil {
+		return writeError(err, "error truncating partial file %s", joined)
+	}
+
+	if th.contentHash != nil {
+		if _, err = io.Copy(th.contentHash, io.NewSectionReader(f, 0, offset)); err != nil {
+			return errors.Wrapf(err, "error reading partial file %s", joined)
+		}
+	}
+
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "error seeking in partial file %s", joined)
+	}
+
+	log.Infof("Resuming %s after %s\n", filepath.Base(hdr.Name), format.Bytes(offset))
+	p.seen[hdr.Name] = true
+	th.received += offset
+
+	bar := log.NewProgressBar(hdr.Size, filepath.Base(hdr.Name))
+	n, err := pcpnode.CopyChunks(io.MultiWriter(f, bar), src, th.chunkSize)
+	th.received += n
+	metrics.BytesTransferred.WithLabelValues(metrics.DirectionReceived).Add(float64(n))
+	if err != nil {
+		return th.keepPartialDir(joined, err)
+	}
+
+	f.Close()
+	th.xattrs.restore(joined, hdr)
+	if err = os.Chmod(joined, createPerm(hdr)); err != nil {
+		log.Warningln("error setting file permissions:", joined, err)
+	}
+	th.preserve.file(joined, hdr)
+	p.complete(hdr.Name, joined)
+
+	return nil
+}
+
+// keepPartialDir handles an error while a file of a resumable directory
+// was received. The partial file is kept unless it couldn't be written.
+func (th *TransferHandler) keepPartialDir(joined string, err error) error {
+	select {
+	case <-th.cancelled:
+		log.Infoln("Kept the partial directory to resume the transfer later")
+		return ErrTransferCancelled
+	default:
+	}
+
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) {
+		// Reading from the stream failed. Keep the partial file, the
+		// transfer protocol reports the broken stream.
+		log.Warningln("error receiving file content:", joined, err)
+		return nil
+	}
+
+	return writeError(err, "error writing file %s", joined)
+}
@@ -0,0 +1,291 @@
+package receive
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+func resumeRequest(name string, content []byte) *p2p.PushRequest {
+	return p2p.NewPushRequest(name, int64(len(content)), false)
+}
+
+func TestResumable(t *testing.T) {
+	pr := resumeRequest("file", []byte("data"))
+	assert.True(t, resumable(pr))
+
+	assert.False(t, resumable(resumeRequest("file", nil)), "empty file")
+
+	dir := resumeRequest("dir", []byte("data"))
+	dir.IsDir = true
+	assert.False(t, resumable(dir))
+}
+
+func TestResumeStore_lookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := newResumeStore(filepath.Join(dir, "resume"), time.Hour)
+	pr := resumeRequest("file.txt", []byte("some data"))
+
+	p, err := s.lookup(pr)
+	require.NoError(t, err)
+	assert.Zero(t, p.offset)
+	assert.FileExists(t, p.meta)
+
+	require.NoError(t, ioutil.WriteFile(p.path, []byte("some"), 0o600))
+	p, err = s.lookup(pr)
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, p.offset)
+	hash := sha256.Sum256([]byte("some"))
+	assert.Equal(t, hash[:], p.offsetHash)
+
+	// A partial file that's larger than the file can't be resumed.
+	require.NoError(t, ioutil.WriteFile(p.path, []byte("some data and more"), 0o600))
+	p, err = s.lookup(pr)
+	require.NoError(t, err)
+	assert.Zero(t, p.offset)
+	assert.NoFileExists(t, p.path)
+}
+
+func TestResumeStore_prune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := newResumeStore(dir, time.Hour)
+
+	old, err := s.lookup(resumeRequest("file.txt", []byte("old version")))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(old.path, []byte("old"), 0o600))
+
+	expired, err := s.lookup(resumeRequest("other.txt", []byte("expired")))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(expired.path, []byte("exp"), 0o600))
+	past := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(expired.path, past, past))
+	require.NoError(t, os.Chtimes(expired.meta, past, past))
+
+	kept, err := s.lookup(resumeRequest("kept.txt", []byte("kept")))
+	require.NoError(t, err)
+
+	broken := filepath.Join(dir, "broken.json")
+	require.NoError(t, ioutil.WriteFile(broken, []byte("{"), 0o600))
+
+	// A new version of file.txt discards the old one.
+	_, err = s.lookup(resumeRequest("file.txt", []byte("newer version")))
+	require.NoError(t, err)
+
+	assert.NoFileExists(t, old.path)
+	assert.NoFileExists(t, old.meta)
+	assert.NoFileExists(t, expired.path)
+	assert.NoFileExists(t, expired.meta)
+	assert.NoFileExists(t, broken)
+	assert.FileExists(t, kept.meta)
+}
+
+func TestTransferHandler_resume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	content := []byte("some data that is resumed")
+	pr := resumeRequest("file.txt", content)
+	s := newResumeStore(filepath.Join(dir, "resume"), 0)
+
+	// The first transfer breaks after a few bytes.
+	p, err := s.lookup(pr)
+	require.NoError(t, err)
+	th := &TransferHandler{resume: p}
+	hdr := &tar.Header{Name: "file.txt", Mode: 0o666, Size: int64(len(content)), Typeflag: tar.TypeReg}
+	require.NoError(t, th.HandleFile(hdr, iotest.TimeoutReader(iotest.OneByteReader(bytes.NewReader(content)))))
+	assert.NoFileExists(t, filepath.Join(dir, "file.txt"), "partial file in working directory")
+
+	// The second transfer only sends the remaining bytes.
+	p, err = s.lookup(pr)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, p.offset)
+
+	th = &TransferHandler{resume: p}
+	hdr.Size = int64(len(content)) - p.offset
+	hdr.PAXRecords = map[string]string{pcpnode.ResumeOffsetRecord: "1"}
+	require.NoError(t, th.HandleFile(hdr, bytes.NewReader(content[p.offset:])))
+	assert.EqualValues(t, len(content), th.received)
+	require.NoError(t, th.commit())
+
+	received, err := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, content, received)
+	assert.NoFileExists(t, p.path)
+	assert.NoFileExists(t, p.meta)
+
+	info, err := os.Stat(filepath.Join(dir, "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o666)&^umask, info.Mode().Perm(), "umask not applied")
+}
+
+func TestTransferHandler_resume_changed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	content := []byte("some data")
+	pr := resumeRequest("file.txt", content)
+	s := newResumeStore(filepath.Join(dir, "resume"), 0)
+
+	p, err := s.lookup(pr)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(p.path, []byte("other"), 0o600))
+	p, err = s.lookup(pr)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, p.offset)
+
+	// The sender's file starts differently, so it sends it in full.
+	th := &TransferHandler{resume: p}
+	hdr := &tar.Header{Name: "file.txt", Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+	require.NoError(t, th.HandleFile(hdr, bytes.NewReader(content)))
+	assert.EqualValues(t, len(content), th.received)
+	require.NoError(t, th.commit())
+
+	received, err := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, content, received)
+}
+
+func TestTransferHandler_resume_unexpectedOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	content := []byte("some data")
+	s := newResumeStore(filepath.Join(dir, "resume"), 0)
+	p, err := s.lookup(resumeRequest("file.txt", content))
+	require.NoError(t, err)
+
+	th := &TransferHandler{resume: p}
+	hdr := &tar.Header{Name: "file.txt", Mode: 0o644, Size: 4, Typeflag: tar.TypeReg}
+	hdr.PAXRecords = map[string]string{pcpnode.ResumeOffsetRecord: "5"}
+	assert.Error(t, th.receivePartial(hdr, bytes.NewReader(content[5:])))
+}
+
+func Test_moveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	require.NoError(t, ioutil.WriteFile(src, []byte("data"), 0o600))
+
+	require.NoError(t, moveFile(src, dst))
+	assert.NoFileExists(t, src)
+	data, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+}
+
+func TestTransferHandler_resumeDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+
+	pr := p2p.NewPushRequest("dir", 13, true)
+	s := newResumeStore(filepath.Join(dir, "resume"), 0)
+	dirHdr := &tar.Header{Name: "dir", Mode: 0o755, Typeflag: tar.TypeDir}
+	fileHdr := func(name string, size int64) *tar.Header {
+		return &tar.Header{Name: filepath.Join("dir", name), Mode: 0o644, Size: size, Typeflag: tar.TypeReg}
+	}
+
+	// The first transfer breaks in the middle of b.
+	p, err := s.lookupDir(pr, cwd)
+	require.NoError(t, err)
+	th := &TransferHandler{atomicDir: true, dirResume: p}
+	require.NoError(t, th.HandleFile(dirHdr, bytes.NewReader(nil)))
+	require.NoError(t, th.HandleFile(fileHdr("a", 4), bytes.NewReader([]byte("aaaa"))))
+	require.NoError(t, th.HandleFile(fileHdr("stale", 5), bytes.NewReader([]byte("stale"))))
+	require.NoError(t, th.HandleFile(fileHdr("b", 5), iotest.TimeoutReader(iotest.OneByteReader(bytes.NewReader([]byte("bbbbb"))))))
+	th.discard()
+	assert.NoDirExists(t, filepath.Join(dir, "dir"), "partial directory in working directory")
+
+	// The second transfer tells the sender what we have.
+	p, err = s.lookupDir(pr, cwd)
+	require.NoError(t, err)
+	r := p.response()
+	aHash := sha256.Sum256([]byte("aaaa"))
+	assert.Equal(t, aHash[:], r.Done[filepath.Join("dir", "a")])
+	assert.Equal(t, filepath.Join("dir", "b"), r.Partial)
+	assert.EqualValues(t, 1, r.Offset)
+
+	skipped := fileHdr("a", 0)
+	skipped.PAXRecords = map[string]string{pcpnode.ResumeSkipRecord: "1"}
+	resumed := fileHdr("b", 4)
+	resumed.PAXRecords = map[string]string{pcpnode.ResumeOffsetRecord: "1"}
+
+	th = &TransferHandler{atomicDir: true, dirResume: p}
+	require.NoError(t, th.HandleFile(dirHdr, bytes.NewReader(nil)))
+	require.NoError(t, th.HandleFile(skipped, bytes.NewReader(nil)))
+	require.NoError(t, th.HandleFile(resumed, bytes.NewReader([]byte("bbbb"))))
+	require.NoError(t, th.HandleFile(fileHdr("c", 4), bytes.NewReader([]byte("cccc"))))
+	assert.EqualValues(t, pr.Size, th.received)
+	require.NoError(t, th.commit())
+
+	for name, content := range map[string]string{"a": "aaaa", "b": "bbbbb", "c": "cccc"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "dir", name))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	}
+
+	// The file that the sender doesn't have anymore is gone.
+	assert.NoFileExists(t, filepath.Join(dir, "dir", "stale"))
+	assert.NoFileExists(t, p.meta)
+	assert.NoDirExists(t, p.state.Staging)
+}
+
+func TestTransferHandler_resumeDir_unexpectedEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	// Without a partial directory the sender must not skip files.
+	hdr := &tar.Header{Name: "file", Mode: 0o644, Typeflag: tar.TypeReg}
+	hdr.PAXRecords = map[string]string{pcpnode.ResumeSkipRecord: "1"}
+	th := &TransferHandler{}
+	assert.Error(t, th.HandleFile(hdr, bytes.NewReader(nil)))
+	assert.NoFileExists(t, filepath.Join(dir, "file"))
+}
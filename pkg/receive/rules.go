@@ -0,0 +1,107 @@
+package receive
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+// Rule actions that decide what happens with a matching push request.
+const (
+	ActionAccept = "accept"
+	ActionReject = "reject"
+)
+
+// AcceptRule describes which push requests should be accepted or rejected
+// without asking the user. All given conditions must match.
+type AcceptRule struct {
+	// Name is a glob pattern that is matched against the name of the
+	// file or directory. An empty pattern matches every name.
+	Name string `yaml:"name" json:"name"`
+
+	// MinSize is the minimum size in bytes (inclusive).
+	MinSize int64 `yaml:"min_size" json:"min_size"`
+
+	// MaxSize is the maximum size in bytes (inclusive). Zero means unbounded.
+	MaxSize int64 `yaml:"max_size" json:"max_size"`
+
+	// Action is either "accept" or "reject".
+	Action string `yaml:"action" json:"action"`
+}
+
+// LoadAcceptRules reads and validates the list of rules from the file at
+// the given path. As JSON is a subset of YAML both formats are supported.
+func LoadAcceptRules(path string) ([]AcceptRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []AcceptRule
+	if err = yaml.UnmarshalStrict(data, &rules); err != nil {
+		return nil, errors.Wrap(err, "failed parsing accept rules")
+	}
+
+	for i, rule := range rules {
+		if err = rule.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid accept rule %d", i+1)
+		}
+	}
+
+	return rules, nil
+}
+
+func (r AcceptRule) validate() error {
+	if r.Action != ActionAccept && r.Action != ActionReject {
+		return fmt.Errorf("action must be %q or %q, got %q", ActionAccept, ActionReject, r.Action)
+	}
+
+	if _, err := filepath.Match(r.Name, ""); err != nil {
+		return errors.Wrap(err, "malformed name pattern")
+	}
+
+	if r.MinSize < 0 || r.MaxSize < 0 {
+		return fmt.Errorf("sizes must not be negative")
+	}
+
+	if r.MaxSize != 0 && r.MinSize > r.MaxSize {
+		return fmt.Errorf("min_size must not be greater than max_size")
+	}
+
+	return nil
+}
+
+// Matches returns true if the given push request satisfies all conditions of the rule.
+func (r AcceptRule) Matches(pr *p2p.PushRequest) bool {
+	if r.Name != "" {
+		if matched, _ := filepath.Match(r.Name, pr.Name); !matched {
+			return false
+		}
+	}
+
+	if pr.Size < r.MinSize {
+		return false
+	}
+
+	if r.MaxSize != 0 && pr.Size > r.MaxSize {
+		return false
+	}
+
+	return true
+}
+
+// evaluateRules returns the decision of the first rule that matches the
+// push request. The second return value is false if no rule matched.
+func evaluateRules(rules []AcceptRule, pr *p2p.PushRequest) (bool, bool) {
+	for _, rule := range rules {
+		if rule.Matches(pr) {
+			return rule.Action == ActionAccept, true
+		}
+	}
+	return false, false
+}
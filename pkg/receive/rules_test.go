@@ -0,0 +1,91 @@
+package receive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+func writeRules(t *testing.T, filename string, content string) string {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, filename)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadAcceptRules_yaml(t *testing.T) {
+	path := writeRules(t, "rules.yaml", `
+- name: "*.pdf"
+  max_size: 1000
+  action: accept
+- action: reject
+`)
+	rules, err := LoadAcceptRules(path)
+	require.NoError(t, err)
+	assert.Equal(t, []AcceptRule{
+		{Name: "*.pdf", MaxSize: 1000, Action: ActionAccept},
+		{Action: ActionReject},
+	}, rules)
+}
+
+func TestLoadAcceptRules_json(t *testing.T) {
+	path := writeRules(t, "rules.json", `[{"name": "*.txt", "min_size": 10, "action": "reject"}]`)
+	rules, err := LoadAcceptRules(path)
+	require.NoError(t, err)
+	assert.Equal(t, []AcceptRule{{Name: "*.txt", MinSize: 10, Action: ActionReject}}, rules)
+}
+
+func TestLoadAcceptRules_invalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown action":  `[{"action": "maybe"}]`,
+		"missing action":  `[{"name": "*"}]`,
+		"bad pattern":     `[{"name": "[", "action": "accept"}]`,
+		"negative size":   `[{"min_size": -1, "action": "accept"}]`,
+		"inverted bounds": `[{"min_size": 10, "max_size": 5, "action": "accept"}]`,
+		"unknown field":   `[{"size": 10, "action": "accept"}]`,
+		"not a list":      `{"action": "accept"}`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadAcceptRules(writeRules(t, "rules.json", content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEvaluateRules_firstMatchWins(t *testing.T) {
+	rules := []AcceptRule{
+		{Name: "*.pdf", MaxSize: 100, Action: ActionAccept},
+		{Name: "*.pdf", Action: ActionReject},
+		{MinSize: 1000, Action: ActionReject},
+	}
+
+	tests := []struct {
+		name    string
+		size    int64
+		accept  bool
+		matched bool
+	}{
+		{name: "small.pdf", size: 10, accept: true, matched: true},
+		{name: "large.pdf", size: 1000, accept: false, matched: true},
+		{name: "large.txt", size: 1000, accept: false, matched: true},
+		{name: "small.txt", size: 10, accept: false, matched: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accept, matched := evaluateRules(rules, &p2p.PushRequest{Name: tt.name, Size: tt.size})
+			assert.Equal(t, tt.accept, accept)
+			assert.Equal(t, tt.matched, matched)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package receive
+
+import (
+	"net"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// SubnetFilter restricts the addresses of discovered peers we
+// attempt to connect to. Denied subnets take precedence over allowed
+// ones. If no subnet is allowed explicitly, all addresses are allowed
+// that are not denied.
+type SubnetFilter struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// ParseSubnetFilter parses the given IPv4 or IPv6 CIDR notations.
+func ParseSubnetFilter(allow []string, deny []string) (*SubnetFilter, error) {
+	allowNets, err := parseCIDRs(allow)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid allowed subnet")
+	}
+
+	denyNets, err := parseCIDRs(deny)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid denied subnet")
+	}
+
+	return &SubnetFilter{Allow: allowNets, Deny: denyNets}, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// IsEmpty returns true if the filter doesn't restrict any address.
+func (f *SubnetFilter) IsEmpty() bool {
+	return f == nil || (len(f.Allow) == 0 && len(f.Deny) == 0)
+}
+
+// Allowed returns true if the given address passes the filter. Addresses
+// without an IP component (e.g. relay addresses) are only allowed if
+// no subnet was allowed explicitly.
+func (f *SubnetFilter) Allowed(addr ma.Multiaddr) bool {
+	if f.IsEmpty() {
+		return true
+	}
+
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return len(f.Allow) == 0
+	}
+
+	if containsIP(f.Deny, ip) {
+		return false
+	}
+
+	return len(f.Allow) == 0 || containsIP(f.Allow, ip)
+}
+
+// Filter returns only the addresses that pass the filter.
+func (f *SubnetFilter) Filter(addrs []ma.Multiaddr) []ma.Multiaddr {
+	if f.IsEmpty() {
+		return addrs
+	}
+
+	allowed := []ma.Multiaddr{}
+	for _, addr := range addrs {
+		if f.Allowed(addr) {
+			allowed = append(allowed, addr)
+			log.Debugf("\tallowed - %s\n", addr.String())
+		} else {
+			log.Debugf("\tdenied - %s\n", addr.String())
+		}
+	}
+	return allowed
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package receive
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSubnetFilter_invalid(t *testing.T) {
+	_, err := ParseSubnetFilter([]string{"192.168.0.1"}, nil)
+	assert.Error(t, err)
+
+	_, err = ParseSubnetFilter(nil, []string{"fe80::/129"})
+	assert.Error(t, err)
+}
+
+func TestSubnetFilter_Allowed(t *testing.T) {
+	f, err := ParseSubnetFilter([]string{"192.168.0.0/16", "fd00::/8"}, []string{"192.168.1.0/24"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"/ip4/192.168.0.10/tcp/4001", true},
+		{"/ip4/192.168.1.10/tcp/4001", false},
+		{"/ip4/10.0.0.1/tcp/4001", false},
+		{"/ip6/fd00::1/tcp/4001", true},
+		{"/ip6/2001:db8::1/tcp/4001", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, f.Allowed(ma.StringCast(tt.addr)))
+		})
+	}
+}
+
+func TestSubnetFilter_denyOnly(t *testing.T) {
+	f, err := ParseSubnetFilter(nil, []string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/ip4/10.0.0.1/tcp/4001"),
+		ma.StringCast("/ip4/192.168.0.1/tcp/4001"),
+	}
+	assert.Equal(t, addrs[1:], f.Filter(addrs))
+}
+
+func TestSubnetFilter_empty(t *testing.T) {
+	var f *SubnetFilter
+	assert.True(t, f.IsEmpty())
+	assert.True(t, f.Allowed(ma.StringCast("/ip4/10.0.0.1/tcp/4001")))
+}
@@ -2,56 +2,600 @@ package receive
 
 import (
 	"archive/tar"
+	"bytes"
+	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 
-	progress "github.com/schollz/progressbar/v3"
+	"github.com/pkg/errors"
 
 	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/pkg/metrics"
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
 )
 
+// ErrDiskFull is returned if the received data could not be
+// written because the destination disk ran out of space.
+var ErrDiskFull = errors.New("ran out of disk space")
+
+// ErrTransferCancelled is returned if the user has cancelled
+// the transfer while a file was being received.
+var ErrTransferCancelled = errors.New("transfer cancelled")
+
+// ErrInvalidName is returned if the sender has sent a file name
+// that would be saved outside of the current working directory.
+var ErrInvalidName = errors.New("invalid file name")
+
 type TransferHandler struct {
 	filename string
 	received int64
 	done     chan int64
+
+	// uniqueNames indicates whether existing files or directories
+	// should be preserved by saving the received data under a new name.
+	uniqueNames bool
+
+	// root holds the top-level name under which the received
+	// data is saved if it differs from the name of the sender.
+	root string
+
+	// onConflict determines how existing files are handled. If it's
+	// ConflictPrompt the resolveConflict function is asked per file.
+	onConflict      ConflictAction
+	resolveConflict conflictResolver
+
+	// contentHash is calculated over the names and contents of
+	// all received files if the push request was signed.
+	contentHash hash.Hash
+
+	// extension is appended to the name of a single file that was
+	// sent without one. It's derived from the announced content type.
+	extension string
+
+	// err holds the reason why the transfer was aborted.
+	err error
+
+	// chunkSize is the size of the buffer the received data is copied with.
+	chunkSize int
+
+	// cancelled is closed if the user has cancelled the transfer.
+	cancelled <-chan struct{}
+
+	// path is the absolute path of the received file or top-level directory.
+	path string
+
+	// preserve restores the modes, modification times and ownership
+	// of the received files. It's nil if they shouldn't be preserved.
+	preserve *preserver
+
+	// xattrs restores the extended attributes the sender has
+	// added to the tar entries.
+	xattrs xattrRestorer
+
+	// atomicDir receives a directory in a staging directory that is
+	// moved into place once the transfer was verified. A failed
+	// transfer doesn't leave a partial directory behind then.
+	atomicDir bool
+
+	// staging is the temporary directory the top-level directory is
+	// received in. It's created in the current working directory to be
+	// on the same file system, so that the final rename is atomic.
+	stagingLk sync.Mutex
+	staging   string
+
+	// blockExt aborts the transfer if a file with one of the
+	// extensions arrives that wasn't listed in the manifest.
+	blockExt extensionSet
+
+	// benchmark discards the received data instead of writing it to
+	// disk and measures the throughput. It's nil for regular transfers.
+	benchmark *pcpnode.RateMeter
+
+	// resume receives a single file into the resume store, from where
+	// it's moved into place once it was verified. It's nil if the
+	// transfer can't be resumed.
+	resume *partial
+
+	// unverified are the files that were written directly to their
+	// final path instead of a staging directory. They are removed if
+	// the received data turns out to be corrupted.
+	unverified []string
+
+	// dirResume keeps a directory in a staging directory that outlives
+	// an interrupted transfer, together with the files that were
+	// received completely. It's nil if the transfer can't be resumed.
+	dirResume *partialDir
 }
 
 func NewTransferHandler(filename string, done chan int64) (*TransferHandler, error) {
-	return &TransferHandler{filename: filename, done: done}, nil
+	name, err := sanitizeName(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &TransferHandler{filename: filepath.Base(name), done: done, chunkSize: pcpnode.DefaultChunkSize}, nil
 }
 
 func (th *TransferHandler) Done() {
+	th.preserve.finish()
 	th.done <- th.received
 	close(th.done)
 }
 
-func (th *TransferHandler) HandleFile(hdr *tar.Header, src io.Reader) {
+// HandleFile saves the given tar entry to the current working directory.
+// It returns an error if the data could not be written, e.g. because
+// the disk is full. A partially written file is removed in that case.
+func (th *TransferHandler) HandleFile(hdr *tar.Header, src io.Reader) error {
+	if th.benchmark != nil {
+		n, err := pcpnode.CopyChunks(th.benchmark, src, th.chunkSize)
+		th.received += n
+		metrics.BytesTransferred.WithLabelValues(metrics.DirectionReceived).Add(float64(n))
+		return err
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		log.Warningln("error determining current working directory:", err)
 		cwd = "."
 	}
 
+	// The sender controls the name, so make sure it stays in our directory.
+	name, err := sanitizeName(hdr.Name)
+	if err != nil {
+		return err
+	}
+
+	// All entries must be below the announced file or directory.
+	if root := strings.SplitN(filepath.ToSlash(name), "/", 2)[0]; th.filename != "" && root != th.filename {
+		return errors.Wrapf(ErrInvalidName, "%q is not part of the announced %q", hdr.Name, th.filename)
+	}
+
+	if th.extension != "" && hdr.FileInfo().Mode().IsRegular() {
+		name += th.extension
+	}
+
+	// The manifest only lists the top-level entries, so check the nested files as they arrive.
+	if hdr.FileInfo().Mode().IsRegular() && th.blockExt.blocks(name) {
+		return errors.Wrap(ErrBlockedExtension, printable(name))
+	}
+
+	if th.contentHash != nil {
+		pcpnode.WriteContentName(th.contentHash, hdr.Name)
+		src = io.TeeReader(src, th.contentHash)
+	}
+
 	finfo := hdr.FileInfo()
-	joined := filepath.Join(cwd, hdr.Name)
+	target := th.targetName(cwd, name)
+	_, skipped := hdr.PAXRecords[pcpnode.ResumeSkipRecord]
+	_, resumed := hdr.PAXRecords[pcpnode.ResumeOffsetRecord]
+	resumed = resumed && th.resume == nil
+	if th.path == "" && th.atomicDir && finfo.IsDir() {
+		if err := th.stage(cwd, target); err != nil {
+			return err
+		}
+	}
+
+	base := th.baseDir(cwd)
+	joined := filepath.Join(base, target)
+	if err := checkParents(base, joined); err != nil {
+		return err
+	}
+
 	if finfo.IsDir() {
-		err := os.MkdirAll(joined, finfo.Mode())
-		if err != nil {
-			log.Warningln("error creating directory:", joined, err)
+		if err := os.MkdirAll(joined, finfo.Mode()); err != nil {
+			return writeError(err, "error creating directory %s", joined)
 		}
 	}
+
+	// Files in the staging directory of a resumed directory are our own.
+	if !finfo.IsDir() && th.dirResume == nil && th.exists(joined) {
+		switch th.conflictAction(joined) {
+		case ConflictSkip:
+			// Drain the skipped file so that the transfer can proceed. The
+			// bytes still count as received for the final size comparison.
+			n, err := io.Copy(ioutil.Discard, src)
+			th.received += n
+			metrics.BytesTransferred.WithLabelValues(metrics.DirectionReceived).Add(float64(n))
+			if err != nil {
+				log.Warningln("error skipping file content:", joined, err)
+			}
+			log.Infoln("Skipped existing file", joined)
+			return nil
+		case ConflictRename:
+			dir := filepath.Dir(joined)
+			joined = filepath.Join(dir, uniqueName(dir, filepath.Base(joined)))
+			log.Infoln("Saving file as", joined)
+		}
+	}
+
+	// The first entry is the received file or the top-level directory.
+	if th.path == "" {
+		th.path = joined
+	}
+
+	if finfo.IsDir() {
+		th.xattrs.restore(joined, hdr)
+		th.preserve.dir(joined, hdr)
+		return nil
+	}
+
+	if th.resume != nil && finfo.Mode().IsRegular() {
+		return th.receivePartial(hdr, src)
+	}
+
+	if skipped || resumed {
+		return th.receiveResumed(hdr, joined, src)
+	}
+
+	if th.dirResume != nil && finfo.Mode().IsRegular() {
+		th.dirResume.begin(hdr.Name)
+	}
+
 	newFile, err := os.OpenFile(joined, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, finfo.Mode().Perm())
 	if err != nil {
-		log.Warningln("error creating file:", joined, err)
-		return
+		return writeError(err, "error creating file %s", joined)
+	}
+	defer newFile.Close()
+	if base == cwd {
+		th.unverified = append(th.unverified, joined)
+	}
+
+	// Creating an empty file is enough. There are no bytes
+	// to copy and no progress to show.
+	if hdr.Size == 0 {
+		log.Infoln(filepath.Base(hdr.Name), "(empty file)")
+		th.xattrs.restore(joined, hdr)
+		th.preserve.file(joined, hdr)
+		th.completed(hdr, joined)
+		return nil
 	}
 
-	bar := progress.DefaultBytes(hdr.Size, filepath.Base(hdr.Name))
-	n, err := io.Copy(io.MultiWriter(newFile, bar), src)
+	bar := log.NewProgressBar(hdr.Size, filepath.Base(hdr.Name))
+	n, err := pcpnode.CopyChunks(io.MultiWriter(newFile, bar), src, th.chunkSize)
 	th.received += n
-	if err != nil {
-		log.Warningln("error writing file content:", joined, err)
+	metrics.BytesTransferred.WithLabelValues(metrics.DirectionReceived).Add(float64(n))
+	if err == nil {
+		th.xattrs.restore(joined, hdr)
+		th.preserve.file(joined, hdr)
+		th.completed(hdr, joined)
+		return nil
+	}
+
+	if th.dirResume != nil {
+		return th.keepPartialDir(joined, err)
+	}
+
+	// The user has cancelled the transfer, so the partial file is useless.
+	select {
+	case <-th.cancelled:
+		newFile.Close()
+		if rerr := os.Remove(joined); rerr != nil {
+			log.Warningln("error removing partial file:", joined, rerr)
+		} else {
+			log.Infoln("Removed partial file", joined)
+		}
+		return ErrTransferCancelled
+	default:
+	}
+
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) {
+		// Reading from the stream failed. Keep the partial file, the
+		// transfer protocol reports the broken stream.
+		log.Warningln("error receiving file content:", joined, err)
+		return nil
+	}
+
+	// We couldn't write to disk, so remove the partial file as it's useless.
+	newFile.Close()
+	if rerr := os.Remove(joined); rerr != nil {
+		log.Warningln("error removing partial file:", joined, rerr)
+	}
+
+	return writeError(err, "error writing file %s", joined)
+}
+
+// completed records a regular file of a resumable directory as received.
+func (th *TransferHandler) completed(hdr *tar.Header, joined string) {
+	if th.dirResume != nil && hdr.FileInfo().Mode().IsRegular() {
+		th.dirResume.complete(hdr.Name, joined)
+	}
+}
+
+// sanitizeName checks a file name that was sent by our peer and returns it
+// as a clean relative path. Absolute paths, names that contain null bytes
+// and names that would escape the current working directory are
+// rejected. Backslashes are treated as separators on every platform.
+func sanitizeName(name string) (string, error) {
+	if strings.ContainsRune(name, 0) {
+		return "", errors.Wrapf(ErrInvalidName, "%q contains a null byte", name)
+	}
+
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(slashed, "/") || hasDriveLetter(slashed) {
+		return "", errors.Wrapf(ErrInvalidName, "%q is an absolute path", name)
+	}
+
+	cleaned := path.Clean(slashed)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", errors.Wrapf(ErrInvalidName, "%q points outside the current directory", name)
+	}
+
+	return filepath.FromSlash(cleaned), nil
+}
+
+// checkParents returns an error if one of the existing directories
+// between base and the given path is a symbolic link. Creating files
+// below it could write outside of the current working directory.
+func checkParents(base string, path string) error {
+	rel, err := filepath.Rel(base, filepath.Dir(path))
+	if err != nil || rel == "." {
+		return err
+	}
+
+	dir := base
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return errors.Wrapf(ErrInvalidName, "%s is a symbolic link", dir)
+		}
+	}
+	return nil
+}
+
+// hasDriveLetter returns true if the given name starts with
+// a Windows drive letter like C: (with or without separator).
+func hasDriveLetter(name string) bool {
+	if len(name) < 2 || name[1] != ':' {
+		return false
+	}
+	c := name[0]
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// writeError wraps the given error with a message. If the disk is
+// full it is replaced by ErrDiskFull to tell the user the cause.
+func writeError(err error, format string, args ...interface{}) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return errors.Wrapf(ErrDiskFull, format, args...)
+	}
+	return errors.Wrapf(err, format, args...)
+}
+
+// stage creates the staging directory for the given top-level directory.
+// If the directory already exists we receive into it directly, so that
+// the existing files are handled like in a file transfer.
+func (th *TransferHandler) stage(cwd string, target string) error {
+	final := filepath.Join(cwd, target)
+	if th.exists(final) {
+		log.Debugln("Receiving into existing directory", final)
+		th.dropDirResume()
+		return nil
+	}
+
+	var staging string
+	if th.dirResume != nil {
+		// The staging directory is kept to resume an interrupted transfer.
+		staging = th.dirResume.state.Staging
+		if err := os.MkdirAll(staging, 0o700); err != nil {
+			return writeError(err, "error creating staging directory %s", staging)
+		}
+	} else {
+		var err error
+		if staging, err = ioutil.TempDir(cwd, ".pcp-"); err != nil {
+			return writeError(err, "error creating staging directory in %s", cwd)
+		}
+	}
+
+	th.stagingLk.Lock()
+	th.staging = staging
+	th.stagingLk.Unlock()
+	th.path = final
+
+	return nil
+}
+
+// baseDir returns the directory the received entries are saved in.
+func (th *TransferHandler) baseDir(cwd string) string {
+	th.stagingLk.Lock()
+	defer th.stagingLk.Unlock()
+
+	if th.staging != "" {
+		return th.staging
+	}
+	return cwd
+}
+
+// commit moves the received directory from the staging
+// directory or the received file from the resume store into
+// place. If that fails the received data is kept there.
+func (th *TransferHandler) commit() error {
+	if th.resume != nil && th.resume.hdr != nil {
+		return th.commitPartial()
+	}
+
+	th.stagingLk.Lock()
+	defer th.stagingLk.Unlock()
+
+	if th.staging == "" {
+		return nil
+	}
+
+	if th.dirResume != nil {
+		th.dirResume.prune()
+	}
+
+	staged := filepath.Join(th.staging, filepath.Base(th.path))
+	if err := os.Rename(staged, th.path); err != nil {
+		return errors.Wrapf(err, "could not move the received directory into place, it was kept at %s", staged)
+	}
+
+	if err := os.Remove(th.staging); err != nil {
+		log.Warningln("error removing staging directory:", th.staging, err)
+	}
+	th.staging = ""
+	th.dirResume.remove(false)
+	th.dirResume = nil
+
+	return nil
+}
+
+// discard removes the staging directory together with
+// the partially received directory.
+func (th *TransferHandler) discard() {
+	th.stagingLk.Lock()
+	defer th.stagingLk.Unlock()
+
+	if th.staging == "" {
 		return
 	}
+
+	// Keep the received files to resume the transfer later.
+	if th.dirResume != nil {
+		log.Infoln("Kept the partial directory to resume the transfer later")
+		th.staging = ""
+		return
+	}
+
+	if err := os.RemoveAll(th.staging); err != nil {
+		log.Warningln("error removing partial directory:", th.staging, err)
+	} else {
+		log.Infoln("Removed partial directory", th.path)
+	}
+	th.staging = ""
+}
+
+// HandleTransferError is called if the transfer was aborted.
+func (th *TransferHandler) HandleTransferError(err error) {
+	if err == pcpnode.ErrDataMismatch {
+		err = ErrIntegrity{Err: err}
+	}
+	th.err = err
+}
+
+// check returns an error if the transfer was aborted or the received
+// data doesn't match the content hash of a signed push request.
+func (th *TransferHandler) check(pr *p2p.PushRequest) error {
+	if th.err != nil {
+		// Corrupted files must not be resumed from.
+		var ierr ErrIntegrity
+		if errors.As(th.err, &ierr) {
+			th.dropDirResume()
+			th.removeUnverified()
+		}
+		return th.err
+	}
+
+	if th.contentHash != nil {
+		err := th.verifyContentHash(pr.ContentHash)
+		if err != nil {
+			// The partial file may be the culprit, so don't resume from it.
+			th.resume.remove()
+			th.dropDirResume()
+			th.removeUnverified()
+		}
+		return err
+	}
+
+	return nil
+}
+
+// removeUnverified removes the files that were written directly to
+// their final path, so that corrupted data isn't left behind under
+// the real name.
+func (th *TransferHandler) removeUnverified() {
+	for _, path := range th.unverified {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Warningln("error removing corrupted file:", path, err)
+		} else if err == nil {
+			log.Infoln("Removed corrupted file", path)
+		}
+	}
+	th.unverified = nil
+}
+
+// dropDirResume discards the state of a resumable directory, so that
+// the staging directory is removed like for other transfers.
+func (th *TransferHandler) dropDirResume() {
+	th.stagingLk.Lock()
+	defer th.stagingLk.Unlock()
+
+	if th.dirResume == nil {
+		return
+	}
+	th.dirResume.remove(th.staging == "")
+	th.dirResume = nil
+}
+
+// verifyContentHash checks that the received data matches the signed content hash.
+func (th *TransferHandler) verifyContentHash(expected []byte) error {
+	if th.contentHash == nil || !bytes.Equal(th.contentHash.Sum(nil), expected) {
+		return ErrIntegrity{Err: fmt.Errorf("received data does not match the signed content hash")}
+	}
+	return nil
+}
+
+// exists returns true if there is a file or directory at the given path.
+func (th *TransferHandler) exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// conflictAction returns how the existing file at the given path
+// should be handled.
+func (th *TransferHandler) conflictAction(path string) ConflictAction {
+	if th.onConflict != ConflictPrompt {
+		return th.onConflict
+	}
+
+	if th.resolveConflict == nil {
+		return ConflictOverwrite
+	}
+
+	return th.resolveConflict(path)
+}
+
+// targetName returns the relative path the given tar entry should be saved
+// to. If unique names are requested and the top-level file or directory
+// already exists, the top-level component is replaced by a unique one.
+func (th *TransferHandler) targetName(cwd string, name string) string {
+	if !th.uniqueNames {
+		return name
+	}
+
+	parts := strings.SplitN(filepath.ToSlash(name), "/", 2)
+	if th.root == "" {
+		th.root = uniqueName(cwd, parts[0])
+	}
+	parts[0] = th.root
+
+	return filepath.FromSlash(strings.Join(parts, "/"))
+}
+
+// uniqueName appends an increasing counter to the given name until
+// no file or directory with that name exists in the given directory.
+func uniqueName(dir string, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := name
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(filepath.Join(dir, candidate)); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
 }
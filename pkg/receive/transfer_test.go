@@ -0,0 +1,301 @@
+package receive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferHandler_targetName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte{}, 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file (1).txt"), []byte{}, 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "dir"), 0o755))
+
+	th := &TransferHandler{}
+	assert.Equal(t, "file.txt", th.targetName(dir, "file.txt"))
+
+	th = &TransferHandler{uniqueNames: true}
+	assert.Equal(t, "file (2).txt", th.targetName(dir, "file.txt"))
+
+	th = &TransferHandler{uniqueNames: true}
+	assert.Equal(t, "dir (1)", th.targetName(dir, "dir"))
+	assert.Equal(t, filepath.Join("dir (1)", "sub", "file"), th.targetName(dir, "dir/sub/file"))
+
+	th = &TransferHandler{uniqueNames: true}
+	assert.Equal(t, "new", th.targetName(dir, "new"))
+}
+
+func TestTransferHandler_conflictAction(t *testing.T) {
+	th := &TransferHandler{onConflict: ConflictSkip}
+	assert.Equal(t, ConflictSkip, th.conflictAction("file"))
+
+	th = &TransferHandler{}
+	assert.Equal(t, ConflictOverwrite, th.conflictAction("file"))
+
+	var asked string
+	th = &TransferHandler{resolveConflict: func(path string) ConflictAction {
+		asked = path
+		return ConflictRename
+	}}
+	assert.Equal(t, ConflictRename, th.conflictAction("file"))
+	assert.Equal(t, "file", asked)
+}
+
+func TestTransferHandler_HandleFile_diskFull(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full is not available")
+	}
+
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	// Every write to /dev/full fails with ENOSPC.
+	require.NoError(t, os.Symlink("/dev/full", filepath.Join(dir, "file.txt")))
+
+	data := []byte("some data")
+	hdr := &tar.Header{Name: "file.txt", Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+
+	th := &TransferHandler{onConflict: ConflictOverwrite}
+	err = th.HandleFile(hdr, bytes.NewReader(data))
+	assert.True(t, errors.Is(err, ErrDiskFull))
+
+	_, err = os.Lstat(filepath.Join(dir, "file.txt"))
+	assert.True(t, os.IsNotExist(err), "partial file should be removed")
+}
+
+func Test_writeError(t *testing.T) {
+	err := writeError(&os.PathError{Op: "write", Path: "file", Err: syscall.ENOSPC}, "error writing %s", "file")
+	assert.True(t, errors.Is(err, ErrDiskFull))
+
+	err = writeError(&os.PathError{Op: "write", Path: "file", Err: syscall.EACCES}, "error writing %s", "file")
+	assert.False(t, errors.Is(err, ErrDiskFull))
+}
+
+func Test_sanitizeName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "file.txt", want: "file.txt"},
+		{name: "dir/sub/file.txt", want: filepath.Join("dir", "sub", "file.txt")},
+		{name: `dir\sub\file.txt`, want: filepath.Join("dir", "sub", "file.txt")},
+		{name: "dir/../file.txt", want: "file.txt"},
+		{name: "./file.txt", want: "file.txt"},
+		{name: "..", wantErr: true},
+		{name: "../file.txt", wantErr: true},
+		{name: "../../etc/passwd", wantErr: true},
+		{name: "dir/../../file.txt", wantErr: true},
+		{name: `..\..\file.txt`, wantErr: true},
+		{name: "/etc/passwd", wantErr: true},
+		{name: `\\server\share\file.txt`, wantErr: true},
+		{name: `C:\Windows\file.txt`, wantErr: true},
+		{name: "C:/Windows/file.txt", wantErr: true},
+		{name: "c:file.txt", wantErr: true},
+		{name: "file\x00.txt", wantErr: true},
+		{name: "", wantErr: true},
+		{name: ".", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeName(tt.name)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidName))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTransferHandler_HandleFile_pathTraversal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cwd := filepath.Join(dir, "cwd")
+	require.NoError(t, os.Mkdir(cwd, 0o755))
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(cwd))
+	defer os.Chdir(wd)
+
+	data := []byte("malicious")
+	hdr := &tar.Header{Name: "../escaped.txt", Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+
+	th := &TransferHandler{}
+	err = th.HandleFile(hdr, bytes.NewReader(data))
+	assert.True(t, errors.Is(err, ErrInvalidName))
+
+	_, err = os.Lstat(filepath.Join(dir, "escaped.txt"))
+	assert.True(t, os.IsNotExist(err), "file must not be written outside of the working directory")
+}
+
+func TestTransferHandler_HandleFile_outsideAnnouncedRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	data := []byte("unexpected")
+	hdr := &tar.Header{Name: "other/file.txt", Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+
+	th, err := NewTransferHandler("dir", make(chan int64, 1))
+	require.NoError(t, err)
+	err = th.HandleFile(hdr, bytes.NewReader(data))
+	assert.True(t, errors.Is(err, ErrInvalidName))
+
+	_, err = os.Lstat(filepath.Join(dir, "other"))
+	assert.True(t, os.IsNotExist(err), "entries outside of the announced directory must not be written")
+}
+
+func TestTransferHandler_HandleFile_symlinkedParent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cwd := filepath.Join(dir, "cwd")
+	outside := filepath.Join(dir, "outside")
+	require.NoError(t, os.Mkdir(cwd, 0o755))
+	require.NoError(t, os.Mkdir(outside, 0o755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(cwd, "dir")))
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(cwd))
+	defer os.Chdir(wd)
+
+	data := []byte("malicious")
+	hdrs := []*tar.Header{
+		{Name: "dir/file.txt", Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg},
+		{Name: "dir/sub", Mode: 0o755, Typeflag: tar.TypeDir},
+	}
+	for _, hdr := range hdrs {
+		th, err := NewTransferHandler("dir", make(chan int64, 1))
+		require.NoError(t, err)
+		th.onConflict = ConflictOverwrite
+		err = th.HandleFile(hdr, bytes.NewReader(data[:hdr.Size]))
+		assert.True(t, errors.Is(err, ErrInvalidName), hdr.Name)
+	}
+
+	entries, err := ioutil.ReadDir(outside)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "nothing must be written through the symbolic link")
+}
+
+func TestNewTransferHandler_invalidName(t *testing.T) {
+	_, err := NewTransferHandler("/etc/passwd", make(chan int64))
+	assert.True(t, errors.Is(err, ErrInvalidName))
+
+	th, err := NewTransferHandler("dir/file.txt", make(chan int64))
+	require.NoError(t, err)
+	assert.Equal(t, "file.txt", th.filename)
+}
+
+func receiveDir(t *testing.T, th *TransferHandler) {
+	data := []byte("content")
+	hdrs := []*tar.Header{
+		{Name: "dir", Mode: 0o755, Typeflag: tar.TypeDir},
+		{Name: "dir/file.txt", Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg},
+	}
+	for _, hdr := range hdrs {
+		require.NoError(t, th.HandleFile(hdr, bytes.NewReader(data[:hdr.Size])))
+	}
+}
+
+func TestTransferHandler_atomicDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	th := &TransferHandler{onConflict: ConflictOverwrite, atomicDir: true}
+	receiveDir(t, th)
+
+	// Nothing is in place before the transfer was verified.
+	_, err = os.Stat(filepath.Join(dir, "dir"))
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, th.commit())
+	data, err := ioutil.ReadFile(filepath.Join(dir, "dir", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+	assert.Equal(t, filepath.Join(dir, "dir"), th.path)
+
+	// The staging directory is gone.
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, infos, 1)
+}
+
+func TestTransferHandler_atomicDir_discard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	th := &TransferHandler{onConflict: ConflictOverwrite, atomicDir: true}
+	receiveDir(t, th)
+	th.discard()
+
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, infos)
+
+	// Committing afterwards is a no-op.
+	assert.NoError(t, th.commit())
+}
+
+func TestTransferHandler_atomicDir_existing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	// An existing directory is received into directly.
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "dir"), 0o755))
+	th := &TransferHandler{onConflict: ConflictOverwrite, atomicDir: true}
+	receiveDir(t, th)
+
+	_, err = os.Stat(filepath.Join(dir, "dir", "file.txt"))
+	assert.NoError(t, err)
+	th.discard()
+	_, err = os.Stat(filepath.Join(dir, "dir", "file.txt"))
+	assert.NoError(t, err)
+}
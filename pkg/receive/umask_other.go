@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package receive
+
+import "os"
+
+// umask doesn't mask any permission bits as the
+// mask is only read on Linux and macOS.
+var umask os.FileMode
@@ -0,0 +1,20 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package receive
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// umask is the file mode creation mask of this process. It's read once
+// at start up, because reading it means setting it for a moment.
+var umask = readUmask()
+
+func readUmask() os.FileMode {
+	mask := unix.Umask(0)
+	unix.Umask(mask)
+	return os.FileMode(mask)
+}
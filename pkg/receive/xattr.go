@@ -0,0 +1,54 @@
+package receive
+
+import (
+	"archive/tar"
+	"strings"
+
+	"github.com/dennis-tra/pcp/internal/log"
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+)
+
+// restrictedXattrs are the namespaces of extended attributes on Linux
+// that grant privileges or hold access control lists. We don't let the
+// sender set them, e.g. file capabilities if we run as root.
+var restrictedXattrs = []string{"security.", "system.", "trusted."}
+
+// xattrRestorer sets the extended attributes the sender has added to
+// the tar entries. Attributes our file system doesn't support are
+// skipped and only warned about once.
+type xattrRestorer struct {
+	warned bool
+}
+
+// restore sets the extended attributes of the given tar entry on the
+// received file.
+func (x *xattrRestorer) restore(path string, hdr *tar.Header) {
+	names, attrs := pcpnode.HeaderXattrs(hdr)
+	for _, name := range names {
+		if isRestrictedXattr(name) {
+			log.Debugln("Skipping restricted extended attribute", name, "of", path)
+			continue
+		}
+
+		err := pcpnode.WriteXattr(path, name, attrs[name])
+		if err == nil || x.warned {
+			continue
+		}
+
+		if err == pcpnode.ErrXattrUnsupported {
+			log.Warningln("Skipping extended attributes as they're not supported here")
+		} else {
+			log.Warningln("Could not restore extended attribute", name, "of", path+":", err)
+		}
+		x.warned = true
+	}
+}
+
+func isRestrictedXattr(name string) bool {
+	for _, prefix := range restrictedXattrs {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
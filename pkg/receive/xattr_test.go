@@ -0,0 +1,44 @@
+package receive
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+)
+
+func TestXattrRestorer_restore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, ioutil.WriteFile(path, []byte("data"), 0o644))
+
+	if err := pcpnode.WriteXattr(path, "user.probe", nil); err != nil {
+		t.Skip("extended attributes are not supported:", err)
+	}
+
+	hdr := &tar.Header{PAXRecords: map[string]string{
+		pcpnode.XattrPrefix + "user.pcp":            "value",
+		pcpnode.XattrPrefix + "security.capability": "granted",
+	}}
+
+	x := &xattrRestorer{}
+	x.restore(path, hdr)
+	assert.False(t, x.warned)
+
+	attrs, err := pcpnode.ReadXattrs(path)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), attrs["user.pcp"])
+	assert.NotContains(t, attrs, "security.capability")
+}
+
+func TestIsRestrictedXattr(t *testing.T) {
+	assert.True(t, isRestrictedXattr("security.capability"))
+	assert.True(t, isRestrictedXattr("system.posix_acl_access"))
+	assert.True(t, isRestrictedXattr("trusted.overlay.opaque"))
+	assert.False(t, isRestrictedXattr("user.pcp"))
+	assert.False(t, isRestrictedXattr("com.apple.ResourceFork"))
+}
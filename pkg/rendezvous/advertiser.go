@@ -0,0 +1,140 @@
+package rendezvous
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/internal/log"
+)
+
+// unregisterTimeout is the time we wait for the server to remove
+// our registrations on shutdown. They expire anyway.
+var unregisterTimeout = 2 * time.Second
+
+// Advertiser registers us at the rendezvous server
+// under the discovery identifier of the channel.
+type Advertiser struct {
+	*protocol
+
+	// registered holds the namespaces we have registered under.
+	registered []string
+}
+
+// NewAdvertiser creates a new Advertiser that uses the given server.
+func NewAdvertiser(h host.Host, server peer.AddrInfo) *Advertiser {
+	return &Advertiser{protocol: newProtocol(h, server)}
+}
+
+// Advertise registers us under the discovery identifier of the current
+// time slot until Shutdown is called. We register again when the time
+// slot changes. The previous registration is kept for receivers that
+// are still looking in the previous time slot. If the server is
+// unavailable we keep retrying.
+func (a *Advertiser) Advertise(chanID int) error {
+	if err := a.ServiceStarted(); err != nil {
+		return err
+	}
+	defer a.ServiceStopped()
+	defer a.unregisterAll()
+
+	for {
+		did := a.DiscoveryID(chanID)
+		log.Debugln("rendezvous - Registering", did)
+		a.setStage(StageRegistering)
+		err := a.register(did)
+		if a.ServiceContext().Err() != nil {
+			return nil
+		}
+
+		a.reachable(err)
+		if err != nil {
+			a.setStage(StageRetrying)
+			if !a.wait(RetryInterval) {
+				return nil
+			}
+			continue
+		}
+		a.setStage(StageRegistered)
+
+		if !a.wait(a.untilRenewal()) {
+			return nil
+		}
+	}
+}
+
+// register adds us to the given namespace at the rendezvous server.
+func (a *Advertiser) register(ns string) error {
+	key := a.Peerstore().PrivKey(a.ID())
+	if key == nil {
+		return errors.New("private key for rendezvous registration not found")
+	}
+
+	spr, err := sealPeerRecord(peer.AddrInfo{ID: a.ID(), Addrs: a.Addrs()}, key)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.request(a.ServiceContext(), registerMsg(ns, spr, int64(RegistrationTTL.Seconds())))
+	if err != nil {
+		return err
+	} else if err = resp.err(); err != nil {
+		return err
+	}
+
+	for _, registered := range a.registered {
+		if registered == ns {
+			return nil
+		}
+	}
+	a.registered = append(a.registered, ns)
+	return nil
+}
+
+// unregisterAll removes all our registrations from the rendezvous server.
+func (a *Advertiser) unregisterAll() {
+	ctx, cancel := context.WithTimeout(context.Background(), unregisterTimeout)
+	defer cancel()
+
+	for _, ns := range a.registered {
+		if _, err := a.request(ctx, unregisterMsg(ns, a.ID())); err != nil {
+			log.Debugln("rendezvous - Could not unregister", ns, err)
+		}
+	}
+	a.registered = nil
+}
+
+// untilRenewal returns the time until we need to register again. That's
+// when the time slot changes or, if it's pinned, before the
+// registration expires.
+func (a *Advertiser) untilRenewal() time.Duration {
+	if !a.timeSlot.IsZero() {
+		return RegistrationTTL / 2
+	}
+	return a.TimeSlotStart().Add(TruncateDuration).Sub(a.refTime())
+}
+
+func (a *Advertiser) SetNamespace(namespace string) *Advertiser {
+	a.namespace = namespace
+	return a
+}
+
+// SetTimeSlot pins the time slot the discovery identifier is derived
+// from. The zero time derives it from the current time again.
+func (a *Advertiser) SetTimeSlot(slot time.Time) *Advertiser {
+	a.timeSlot = slot
+	return a
+}
+
+// SetStageHandler registers a function that is called whenever the stage changes.
+func (a *Advertiser) SetStageHandler(handler func(Stage)) *Advertiser {
+	a.onStage = handler
+	return a
+}
+
+func (a *Advertiser) Shutdown() {
+	a.Service.Shutdown()
+}
@@ -0,0 +1,114 @@
+package rendezvous
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/pkg/discovery"
+)
+
+// PollInterval is the time between two queries of the rendezvous server.
+var PollInterval = 5 * time.Second
+
+// Discoverer asks the rendezvous server for peers that
+// registered under the discovery identifier of the channel.
+type Discoverer struct {
+	*protocol
+
+	// retries counts the queries without a result.
+	retries discovery.Retries
+}
+
+// NewDiscoverer creates a new Discoverer that uses the given server.
+func NewDiscoverer(h host.Host, server peer.AddrInfo) *Discoverer {
+	return &Discoverer{protocol: newProtocol(h, server)}
+}
+
+// Discover queries the rendezvous server repeatedly until Shutdown is
+// called and passes all registered peers to the given handler. If the
+// server is unavailable we keep retrying.
+func (d *Discoverer) Discover(chanID int, handler func(info peer.AddrInfo)) error {
+	if err := d.ServiceStarted(); err != nil {
+		return err
+	}
+	defer d.ServiceStopped()
+
+	for {
+		did := d.DiscoveryID(chanID)
+		log.Debugln("rendezvous - Discovering", did)
+		d.setStage(StageQuerying)
+		peers, err := d.discover(did)
+		if d.ServiceContext().Err() != nil {
+			return nil
+		}
+
+		d.reachable(err)
+		for _, pi := range peers {
+			log.Debugln("rendezvous - Found peer", pi.ID)
+			go handler(pi)
+		}
+
+		if d.retries.Exhausted(len(peers) > 0) {
+			d.setStage(StageGaveUp)
+			return discovery.ErrRetriesExhausted{Mechanism: d.Mechanism(), Rounds: d.retries.Max}
+		}
+
+		interval := PollInterval
+		if err != nil {
+			d.setStage(StageRetrying)
+			interval = RetryInterval
+		}
+
+		if !d.wait(interval) {
+			return nil
+		}
+	}
+}
+
+// discover returns the peers that are registered under the given namespace.
+func (d *Discoverer) discover(ns string) ([]peer.AddrInfo, error) {
+	resp, err := d.request(d.ServiceContext(), discoverMsg(ns))
+	if err != nil {
+		return nil, err
+	} else if err = resp.err(); err != nil {
+		return nil, err
+	}
+	return resp.registrations, nil
+}
+
+// SetMaxRetries makes Discover give up after the given number of queries
+// without a result. Zero retries forever.
+func (d *Discoverer) SetMaxRetries(max int) *Discoverer {
+	d.retries = discovery.Retries{Max: max}
+	return d
+}
+
+func (d *Discoverer) SetOffset(offset time.Duration) *Discoverer {
+	d.offset = offset
+	return d
+}
+
+func (d *Discoverer) SetNamespace(namespace string) *Discoverer {
+	d.namespace = namespace
+	return d
+}
+
+// SetTimeSlot pins the time slot the discovery identifier is derived
+// from. The zero time derives it from the current time again.
+func (d *Discoverer) SetTimeSlot(slot time.Time) *Discoverer {
+	d.timeSlot = slot
+	return d
+}
+
+// SetStageHandler registers a function that is called whenever the stage changes.
+func (d *Discoverer) SetStageHandler(handler func(Stage)) *Discoverer {
+	d.onStage = handler
+	return d
+}
+
+func (d *Discoverer) Shutdown() {
+	d.Service.Shutdown()
+}
@@ -0,0 +1,186 @@
+package rendezvous
+
+import (
+	"bufio"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennis-tra/pcp/pkg/discovery"
+)
+
+// testServer is a minimal rendezvous server that keeps
+// the signed peer records of the registrations in memory.
+type testServer struct {
+	host.Host
+	t *testing.T
+
+	lk            sync.Mutex
+	registrations map[string][][]byte
+}
+
+func newTestServer(t *testing.T, net mocknet.Mocknet) *testServer {
+	h, err := net.GenPeer()
+	require.NoError(t, err)
+
+	s := &testServer{Host: h, t: t, registrations: map[string][][]byte{}}
+	h.SetStreamHandler(ProtocolID, s.handle)
+	return s
+}
+
+func (s *testServer) info() peer.AddrInfo {
+	return peer.AddrInfo{ID: s.ID(), Addrs: s.Addrs()}
+}
+
+func (s *testServer) handle(stream network.Stream) {
+	defer stream.Close()
+
+	msg, err := readMsg(bufio.NewReader(stream))
+	if err != nil {
+		return
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	req := parseRequest(s.t, msg)
+	switch req.typ {
+	case typeRegister:
+		s.registrations[req.ns] = append(s.registrations[req.ns], req.spr)
+		_ = writeMsg(stream, statusResponseMsg(statusOK, ""))
+	case typeUnregister:
+		delete(s.registrations, req.ns)
+		_ = writeMsg(stream, statusResponseMsg(statusOK, ""))
+	case typeDiscover:
+		_ = writeMsg(stream, discoverResponseMsg(req.ns, s.registrations[req.ns]...))
+	}
+}
+
+func (s *testServer) namespaces() int {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return len(s.registrations)
+}
+
+func setupPeers(t *testing.T) (*testServer, host.Host, host.Host) {
+	net := mocknet.New(context.Background())
+	server := newTestServer(t, net)
+
+	// The sender needs a real key to sign its peer record.
+	key, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+	require.NoError(t, err)
+	sender, err := net.AddPeer(key, addr)
+	require.NoError(t, err)
+	receiver, err := net.GenPeer()
+	require.NoError(t, err)
+	require.NoError(t, net.LinkAll())
+	return server, sender, receiver
+}
+
+func TestDiscoverer_Discover(t *testing.T) {
+	server, sender, receiver := setupPeers(t)
+
+	a := NewAdvertiser(sender, server.info()).SetNamespace("test")
+	d := NewDiscoverer(receiver, server.info()).SetNamespace("test")
+
+	registered := make(chan Stage, 10)
+	a.SetStageHandler(func(s Stage) { registered <- s })
+	go func() { assert.NoError(t, a.Advertise(1)) }()
+	for s := range registered {
+		if s == StageRegistered {
+			break
+		}
+	}
+
+	found := make(chan peer.ID, 10)
+	go func() { assert.NoError(t, d.Discover(1, func(pi peer.AddrInfo) { found <- pi.ID })) }()
+
+	select {
+	case id := <-found:
+		assert.Equal(t, sender.ID(), id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("sender wasn't discovered")
+	}
+
+	d.Shutdown()
+	a.Shutdown()
+
+	// The advertiser removes its registration on shutdown.
+	assert.Equal(t, 0, server.namespaces())
+}
+
+func TestDiscoverer_Discover_otherNamespace(t *testing.T) {
+	server, sender, receiver := setupPeers(t)
+
+	a := NewAdvertiser(sender, server.info()).SetNamespace("test")
+	require.NoError(t, a.register(a.DiscoveryID(1)))
+
+	d := NewDiscoverer(receiver, server.info()).SetNamespace("other")
+	require.NoError(t, d.ServiceStarted())
+	defer d.ServiceStopped()
+
+	peers, err := d.discover(d.DiscoveryID(1))
+	require.NoError(t, err)
+	assert.Empty(t, peers)
+}
+
+func TestDiscoverer_Discover_unavailable(t *testing.T) {
+	net := mocknet.New(context.Background())
+	receiver, err := net.GenPeer()
+	require.NoError(t, err)
+
+	// The server isn't linked, so it can't be reached.
+	server, err := net.GenPeer()
+	require.NoError(t, err)
+
+	prevRetry := RetryInterval
+	RetryInterval = 10 * time.Millisecond
+	defer func() { RetryInterval = prevRetry }()
+
+	retrying := make(chan struct{})
+	var once sync.Once
+	d := NewDiscoverer(receiver, peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}).
+		SetStageHandler(func(s Stage) {
+			if s == StageRetrying {
+				once.Do(func() { close(retrying) })
+			}
+		})
+
+	errs := make(chan error)
+	go func() { errs <- d.Discover(1, func(pi peer.AddrInfo) {}) }()
+
+	<-retrying
+	d.Shutdown()
+	assert.NoError(t, <-errs)
+}
+
+func TestDiscoverer_Discover_maxRetries(t *testing.T) {
+	net := mocknet.New(context.Background())
+	receiver, err := net.GenPeer()
+	require.NoError(t, err)
+
+	server, err := net.GenPeer()
+	require.NoError(t, err)
+
+	prevRetry := RetryInterval
+	RetryInterval = 10 * time.Millisecond
+	defer func() { RetryInterval = prevRetry }()
+
+	d := NewDiscoverer(receiver, peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}).SetMaxRetries(2)
+
+	err = d.Discover(1, func(pi peer.AddrInfo) {})
+	assert.Equal(t, discovery.ErrRetriesExhausted{Mechanism: "rendezvous", Rounds: 2}, err)
+}
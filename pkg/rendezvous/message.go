@@ -0,0 +1,236 @@
+package rendezvous
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The message types and field numbers of the libp2p rendezvous
+// protocol. The messages are encoded by hand to not depend on
+// a rendezvous implementation for this small subset.
+const (
+	typeRegister         = 0
+	typeRegisterResponse = 1
+	typeUnregister       = 2
+	typeDiscover         = 3
+	typeDiscoverResponse = 4
+)
+
+// statusOK is the response status of a successful request.
+const statusOK = 0
+
+// maxMessageSize is the maximum size of a message we accept from the server.
+const maxMessageSize = 1 << 20
+
+// response holds the fields of a register or discover response that we care about.
+type response struct {
+	typ           uint64
+	status        uint64
+	statusText    string
+	registrations []peer.AddrInfo
+}
+
+// err returns an error if the server didn't accept the request.
+func (r *response) err() error {
+	if r.status == statusOK {
+		return nil
+	}
+	return fmt.Errorf("rendezvous server responded with status %d: %s", r.status, r.statusText)
+}
+
+// registerMsg asks the server to list us under the given namespace for ttl
+// seconds. The spec expects our addresses as a signed peer record envelope.
+func registerMsg(ns string, signedPeerRecord []byte, ttl int64) []byte {
+	var reg []byte
+	reg = protowire.AppendTag(reg, 1, protowire.BytesType)
+	reg = protowire.AppendString(reg, ns)
+	reg = protowire.AppendTag(reg, 2, protowire.BytesType)
+	reg = protowire.AppendBytes(reg, signedPeerRecord)
+	reg = protowire.AppendTag(reg, 3, protowire.VarintType)
+	reg = protowire.AppendVarint(reg, uint64(ttl))
+
+	return message(typeRegister, 2, reg)
+}
+
+// sealPeerRecord signs the given peer info with the private key of the
+// peer and returns the marshalled envelope to put into a registration.
+func sealPeerRecord(pi peer.AddrInfo, key crypto.PrivKey) ([]byte, error) {
+	envelope, err := record.Seal(peer.PeerRecordFromAddrInfo(pi), key)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not sign peer record")
+	}
+	return envelope.Marshal()
+}
+
+// unregisterMsg asks the server to remove us from the given namespace.
+func unregisterMsg(ns string, id peer.ID) []byte {
+	var unreg []byte
+	unreg = protowire.AppendTag(unreg, 1, protowire.BytesType)
+	unreg = protowire.AppendString(unreg, ns)
+	unreg = protowire.AppendTag(unreg, 2, protowire.BytesType)
+	unreg = protowire.AppendBytes(unreg, []byte(id))
+
+	return message(typeUnregister, 4, unreg)
+}
+
+// discoverMsg asks the server for the peers registered under the given namespace.
+func discoverMsg(ns string) []byte {
+	var disc []byte
+	disc = protowire.AppendTag(disc, 1, protowire.BytesType)
+	disc = protowire.AppendString(disc, ns)
+
+	return message(typeDiscover, 5, disc)
+}
+
+// message wraps the given sub message in the top-level message.
+func message(typ uint64, field protowire.Number, sub []byte) []byte {
+	var msg []byte
+	msg = protowire.AppendTag(msg, 1, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, typ)
+	msg = protowire.AppendTag(msg, field, protowire.BytesType)
+	msg = protowire.AppendBytes(msg, sub)
+	return msg
+}
+
+// parseResponse decodes a register or discover response.
+func parseResponse(b []byte) (*response, error) {
+	resp := &response{}
+	err := walk(b, func(num protowire.Number, typ protowire.Type, v uint64, sub []byte) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			resp.typ = v
+		case num == 3 && typ == protowire.BytesType:
+			return parseStatus(sub, 1, 2, resp)
+		case num == 6 && typ == protowire.BytesType:
+			return parseDiscoverResponse(sub, resp)
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// parseStatus reads the status and status text fields with the given numbers.
+func parseStatus(b []byte, statusNum protowire.Number, textNum protowire.Number, resp *response) error {
+	return walk(b, func(num protowire.Number, typ protowire.Type, v uint64, sub []byte) error {
+		switch {
+		case num == statusNum && typ == protowire.VarintType:
+			resp.status = v
+		case num == textNum && typ == protowire.BytesType:
+			resp.statusText = string(sub)
+		}
+		return nil
+	})
+}
+
+func parseDiscoverResponse(b []byte, resp *response) error {
+	if err := parseStatus(b, 3, 4, resp); err != nil {
+		return err
+	}
+
+	return walk(b, func(num protowire.Number, typ protowire.Type, v uint64, sub []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+
+		// The registration holds the signed peer record in field 2.
+		return walk(sub, func(num protowire.Number, typ protowire.Type, v uint64, sub []byte) error {
+			if num != 2 || typ != protowire.BytesType {
+				return nil
+			}
+			// Skip registrations we can't verify instead of failing the whole response.
+			if pi, err := openPeerRecord(sub); err == nil {
+				resp.registrations = append(resp.registrations, pi)
+			}
+			return nil
+		})
+	})
+}
+
+// openPeerRecord verifies the given signed peer record envelope and returns
+// the peer info it contains. The record must be signed by the peer itself.
+func openPeerRecord(b []byte) (peer.AddrInfo, error) {
+	envelope, rec, err := record.ConsumeEnvelope(b, peer.PeerRecordEnvelopeDomain)
+	if err != nil {
+		return peer.AddrInfo{}, errors.Wrap(err, "invalid signed peer record")
+	}
+
+	pr, ok := rec.(*peer.PeerRecord)
+	if !ok {
+		return peer.AddrInfo{}, errors.New("unexpected record type")
+	}
+
+	signer, err := peer.IDFromPublicKey(envelope.PublicKey)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	} else if signer != pr.PeerID {
+		return peer.AddrInfo{}, errors.New("peer record isn't signed by its peer")
+	}
+
+	return peer.AddrInfo{ID: pr.PeerID, Addrs: pr.Addrs}, nil
+}
+
+// walk calls fn for each field of the given message. Varint fields
+// pass their value in v, length delimited fields their content in sub.
+func walk(b []byte, fn func(num protowire.Number, typ protowire.Type, v uint64, sub []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v uint64
+		var sub []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			sub, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(num, typ, v, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMsg writes the given message prefixed with its varint encoded length.
+func writeMsg(w io.Writer, msg []byte) error {
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(msg))
+	n := binary.PutUvarint(buf, uint64(len(msg)))
+	_, err := w.Write(append(buf[:n], msg...))
+	return err
+}
+
+// readMsg reads a message that is prefixed with its varint encoded length.
+func readMsg(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > maxMessageSize {
+		return nil, fmt.Errorf("rendezvous message too large: %d bytes", size)
+	}
+
+	msg := make([]byte, size)
+	if _, err = io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
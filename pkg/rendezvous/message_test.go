@@ -0,0 +1,177 @@
+package rendezvous
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// request holds the fields of a request the test server cares about.
+type request struct {
+	typ uint64
+	ns  string
+	pi  peer.AddrInfo
+	spr []byte
+	ttl uint64
+}
+
+// parseRequest decodes a register, unregister or discover request.
+func parseRequest(t *testing.T, b []byte) request {
+	req := request{}
+	err := walk(b, func(num protowire.Number, typ protowire.Type, v uint64, sub []byte) error {
+		switch num {
+		case 1:
+			req.typ = v
+		case 2, 4, 5:
+			return walk(sub, func(num protowire.Number, typ protowire.Type, v uint64, sub []byte) error {
+				switch {
+				case num == 1:
+					req.ns = string(sub)
+				case num == 2 && req.typ == typeRegister:
+					pi, err := openPeerRecord(sub)
+					req.pi, req.spr = pi, sub
+					return err
+				case num == 2 && req.typ == typeUnregister:
+					req.pi.ID = peer.ID(sub)
+				case num == 3:
+					req.ttl = v
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return req
+}
+
+// discoverResponseMsg encodes a discover response with the given signed peer records.
+func discoverResponseMsg(ns string, sprs ...[]byte) []byte {
+	var resp []byte
+	for _, spr := range sprs {
+		reg := registerMsg(ns, spr, 0)
+		// Strip the top-level message to get the registration.
+		_, _, n := protowire.ConsumeField(reg)
+		reg = reg[n:]
+		_, _, n = protowire.ConsumeTag(reg)
+		sub, _ := protowire.ConsumeBytes(reg[n:])
+
+		resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+		resp = protowire.AppendBytes(resp, sub)
+	}
+	resp = protowire.AppendTag(resp, 3, protowire.VarintType)
+	resp = protowire.AppendVarint(resp, statusOK)
+	return message(typeDiscoverResponse, 6, resp)
+}
+
+// statusResponseMsg encodes a register response with the given status.
+func statusResponseMsg(status uint64, text string) []byte {
+	var resp []byte
+	resp = protowire.AppendTag(resp, 1, protowire.VarintType)
+	resp = protowire.AppendVarint(resp, status)
+	resp = protowire.AppendTag(resp, 2, protowire.BytesType)
+	resp = protowire.AppendString(resp, text)
+	return message(typeRegisterResponse, 3, resp)
+}
+
+// testPeerRecord returns a peer with the given addresses and its signed peer record.
+func testPeerRecord(t *testing.T, addrs ...ma.Multiaddr) (peer.ID, []byte) {
+	key, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+
+	spr, err := sealPeerRecord(peer.AddrInfo{ID: id, Addrs: addrs}, key)
+	require.NoError(t, err)
+	return id, spr
+}
+
+func TestRegisterMsg(t *testing.T) {
+	addr, err := ma.NewMultiaddr("/ip4/1.2.3.4/tcp/4001")
+	require.NoError(t, err)
+	id, spr := testPeerRecord(t, addr)
+
+	req := parseRequest(t, registerMsg("/pcp/123/1", spr, 7200))
+	assert.EqualValues(t, typeRegister, req.typ)
+	assert.Equal(t, "/pcp/123/1", req.ns)
+	assert.Equal(t, id, req.pi.ID)
+	require.Len(t, req.pi.Addrs, 1)
+	assert.True(t, addr.Equal(req.pi.Addrs[0]))
+	assert.EqualValues(t, 7200, req.ttl)
+}
+
+func TestOpenPeerRecord_foreignSigner(t *testing.T) {
+	key, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	id, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	spr, err := sealPeerRecord(peer.AddrInfo{ID: id}, key)
+	require.NoError(t, err)
+
+	_, err = openPeerRecord(spr)
+	assert.Error(t, err)
+
+	_, err = openPeerRecord([]byte("not an envelope"))
+	assert.Error(t, err)
+}
+
+func TestUnregisterAndDiscoverMsg(t *testing.T) {
+	id, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	req := parseRequest(t, unregisterMsg("/pcp/123/1", id))
+	assert.EqualValues(t, typeUnregister, req.typ)
+	assert.Equal(t, "/pcp/123/1", req.ns)
+	assert.Equal(t, id, req.pi.ID)
+
+	req = parseRequest(t, discoverMsg("/pcp/123/1"))
+	assert.EqualValues(t, typeDiscover, req.typ)
+	assert.Equal(t, "/pcp/123/1", req.ns)
+}
+
+func TestParseResponse_discover(t *testing.T) {
+	addr, err := ma.NewMultiaddr("/ip4/1.2.3.4/tcp/4001")
+	require.NoError(t, err)
+	id1, spr1 := testPeerRecord(t, addr)
+	id2, spr2 := testPeerRecord(t)
+
+	resp, err := parseResponse(discoverResponseMsg("ns", spr1, []byte("invalid"), spr2))
+	require.NoError(t, err)
+	assert.NoError(t, resp.err())
+	assert.EqualValues(t, typeDiscoverResponse, resp.typ)
+	require.Len(t, resp.registrations, 2)
+	assert.Equal(t, id1, resp.registrations[0].ID)
+	assert.Len(t, resp.registrations[0].Addrs, 1)
+	assert.Equal(t, id2, resp.registrations[1].ID)
+}
+
+func TestParseResponse_status(t *testing.T) {
+	resp, err := parseResponse(statusResponseMsg(100, "invalid namespace"))
+	require.NoError(t, err)
+	assert.EqualError(t, resp.err(), "rendezvous server responded with status 100: invalid namespace")
+
+	_, err = parseResponse([]byte{0xff})
+	assert.Error(t, err)
+}
+
+func TestWriteReadMsg(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeMsg(&buf, []byte("hello")))
+	msg, err := readMsg(bufio.NewReader(&buf))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), msg)
+
+	buf.Reset()
+	require.NoError(t, writeMsg(&buf, make([]byte, maxMessageSize+1)))
+	_, err = readMsg(bufio.NewReader(&buf))
+	assert.Error(t, err)
+}
@@ -0,0 +1,209 @@
+// Package rendezvous discovers peers through a libp2p rendezvous server.
+// The sender registers itself under the discovery identifier of the
+// channel and the receiver asks the server for the registered peers.
+// Both peers must use the same server. This is a reliable meeting
+// place if the DHT is slow and the peers are in different networks.
+package rendezvous
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/internal/wrap"
+	"github.com/dennis-tra/pcp/pkg/discovery"
+	"github.com/dennis-tra/pcp/pkg/service"
+)
+
+// ProtocolID is the protocol of the libp2p rendezvous server.
+const ProtocolID = "/rendezvous/1.0.0"
+
+// Both the discoverer and advertiser can be used generically.
+var (
+	_ discovery.Discoverer = (*Discoverer)(nil)
+	_ discovery.Advertiser = (*Advertiser)(nil)
+
+	_ discovery.ChannelDescriber = (*Discoverer)(nil)
+	_ discovery.ChannelDescriber = (*Advertiser)(nil)
+)
+
+// These wrapped top level functions are here for testing purposes.
+var wraptime wrap.Timer = wrap.Time{}
+
+var (
+	// TruncateDuration represents the time slot to which the current time is truncated.
+	TruncateDuration = 5 * time.Minute
+
+	// RequestTimeout is the time a request to the rendezvous server may take.
+	RequestTimeout = 30 * time.Second
+
+	// RetryInterval is the time we wait after the server was unavailable.
+	RetryInterval = 10 * time.Second
+
+	// RegistrationTTL is the time the server keeps our registration.
+	RegistrationTTL = 2 * time.Hour
+)
+
+// Stage describes what the discoverer or advertiser is currently doing.
+type Stage string
+
+const (
+	StageRegistering Stage = "registering"
+	StageRegistered  Stage = "registered"
+	StageQuerying    Stage = "querying"
+	StageRetrying    Stage = "waiting before retrying"
+	StageGaveUp      Stage = "gave up"
+)
+
+// protocol encapsulates the logic for discovering
+// peers through a rendezvous server.
+type protocol struct {
+	host.Host
+	*service.Service
+
+	// server is the rendezvous server both peers use.
+	server peer.AddrInfo
+
+	offset time.Duration
+
+	// timeSlot pins the time slot instead of deriving it from the
+	// current time. The discovery identifier doesn't rotate then.
+	timeSlot time.Time
+
+	// namespace isolates pcp deployments from each other by
+	// being mixed into the discovery identifier.
+	namespace string
+
+	// unavailable is set while the server can't be reached,
+	// so that we only warn once per outage.
+	unavailable bool
+
+	// onStage is called whenever the stage changes.
+	onStage func(Stage)
+}
+
+func newProtocol(h host.Host, server peer.AddrInfo) *protocol {
+	return &protocol{Host: h, server: server, Service: service.New("rendezvous")}
+}
+
+// ParseServer parses the multi address of a rendezvous server. It must
+// contain the peer ID of the server, e.g. /ip4/1.2.3.4/tcp/4001/p2p/Qm...
+func ParseServer(addr string) (*peer.AddrInfo, error) {
+	maddr, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid rendezvous address")
+	}
+
+	pi, err := peer.AddrInfoFromP2pAddr(maddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "rendezvous address must contain the peer ID of the server")
+	}
+
+	return pi, nil
+}
+
+// Mechanism returns the name of the discovery mechanism.
+func (p *protocol) Mechanism() string {
+	return "rendezvous"
+}
+
+// setStage reports the given stage to the stage handler if there is one.
+func (p *protocol) setStage(stage Stage) {
+	log.Debugln("rendezvous - Stage", stage)
+	if p.onStage != nil {
+		p.onStage(stage)
+	}
+}
+
+// TimeSlotStart returns the time when the current time slot started.
+func (p *protocol) TimeSlotStart() time.Time {
+	if !p.timeSlot.IsZero() {
+		return p.timeSlot.Add(p.offset)
+	}
+	return p.refTime().Truncate(TruncateDuration)
+}
+
+// refTime returns the reference time to calculate the time slot from.
+func (p *protocol) refTime() time.Time {
+	return wraptime.Now().Add(p.offset)
+}
+
+// DiscoveryID returns the string that we use as the rendezvous namespace.
+// It's identical to the one of the DHT and mDNS.
+func (p *protocol) DiscoveryID(chanID int) string {
+	if p.namespace == "" {
+		return fmt.Sprintf("/pcp/%d/%d", p.TimeSlotStart().UnixNano(), chanID)
+	}
+	return fmt.Sprintf("/pcp/%s/%d/%d", p.namespace, p.TimeSlotStart().UnixNano(), chanID)
+}
+
+// DescribeChannel returns the rendezvous namespace for the given channel.
+func (p *protocol) DescribeChannel(chanID int) string {
+	return p.DiscoveryID(chanID)
+}
+
+// request sends the given message to the rendezvous server and returns its response.
+func (p *protocol) request(ctx context.Context, msg []byte) (*response, error) {
+	ctx, cancel := context.WithTimeout(ctx, RequestTimeout)
+	defer cancel()
+
+	if err := p.Connect(ctx, p.server); err != nil {
+		return nil, errors.Wrap(err, "could not connect to rendezvous server")
+	}
+
+	s, err := p.NewStream(ctx, p.server.ID, ProtocolID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open stream to rendezvous server")
+	}
+	defer s.Close()
+
+	// Unblock reading if the context is done.
+	go func() {
+		<-ctx.Done()
+		_ = s.Reset()
+	}()
+
+	if err = writeMsg(s, msg); err != nil {
+		return nil, errors.Wrap(err, "could not send rendezvous request")
+	}
+
+	data, err := readMsg(bufio.NewReader(s))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read rendezvous response")
+	}
+
+	return parseResponse(data)
+}
+
+// reachable is called after each request to the server. It warns
+// once if the server isn't reachable and once it's back again.
+func (p *protocol) reachable(err error) {
+	if err != nil && !p.unavailable {
+		log.Warningln("rendezvous server unavailable, retrying in the background:", err)
+		p.unavailable = true
+	} else if err == nil && p.unavailable {
+		log.Infoln("rendezvous server is reachable again")
+		p.unavailable = false
+	}
+}
+
+// wait blocks for the given duration. It returns
+// false if the service was shut down in the meantime.
+func (p *protocol) wait(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-p.SigShutdown():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -2,15 +2,22 @@ package send
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/dennis-tra/pcp/pkg/words"
 
+	"github.com/atotto/clipboard"
+	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 
+	"github.com/dennis-tra/pcp/internal/format"
 	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/internal/tui"
 	"github.com/dennis-tra/pcp/pkg/config"
+	"github.com/dennis-tra/pcp/pkg/mdns"
 )
 
 // Command holds the `send` subcommand configuration.
@@ -27,14 +34,109 @@ var Command = &cli.Command{
 			EnvVars: []string{"PCP_WORD_COUNT"},
 			Value:   4,
 		},
+		&cli.StringFlag{
+			Name:    "word-separator",
+			Usage:   "how to separate the printed words (dash, space, dot)",
+			EnvVars: []string{"PCP_WORD_SEPARATOR"},
+			Value:   "dash",
+		},
+		&cli.BoolFlag{
+			Name:    "uppercase",
+			Usage:   "print the words in upper case",
+			EnvVars: []string{"PCP_UPPERCASE"},
+		},
+		&cli.BoolFlag{
+			Name:    "copy",
+			Usage:   "copy the receive command to the clipboard",
+			EnvVars: []string{"PCP_COPY"},
+		},
+		&cli.StringFlag{
+			Name:    "words-file",
+			Usage:   "write the space separated words to this file before advertising, e.g. for scripts (overwritten if it exists)",
+			EnvVars: []string{"PCP_WORDS_FILE"},
+		},
+		&cli.BoolFlag{
+			Name:    "words-file-command",
+			Usage:   "write the full receive command instead of just the words to --words-file",
+			EnvVars: []string{"PCP_WORDS_FILE_COMMAND"},
+		},
+		&cli.StringFlag{
+			Name:    "seed",
+			Usage:   "INSECURE: derive the words and the peer identity from this seed to get the same ones in every run - for tests and demos only",
+			EnvVars: []string{"PCP_SEED"},
+		},
+		&cli.StringFlag{
+			Name:    "sign-key",
+			Usage:   "path to an ed25519 key file to sign the transfer with (created if missing)",
+			EnvVars: []string{"PCP_SIGN_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "relay",
+			Usage:   "multiaddress including the peer ID of a relay to be reachable through",
+			EnvVars: []string{"PCP_RELAY"},
+		},
+		&cli.BoolFlag{
+			Name:    "relay-fallback",
+			Usage:   "continue without the relay if it cannot be reached",
+			EnvVars: []string{"PCP_RELAY_FALLBACK"},
+		},
+		&cli.IntFlag{
+			Name:    "peers",
+			Usage:   "send the file to this many receivers concurrently before exiting",
+			EnvVars: []string{"PCP_PEERS"},
+			Value:   1,
+		},
+		&cli.DurationFlag{
+			Name:    "peers-timeout",
+			Usage:   "stop waiting for more receivers after this time with --peers (0 waits forever)",
+			EnvVars: []string{"PCP_PEERS_TIMEOUT"},
+		},
+		&cli.IntFlag{
+			Name:    "collision-threshold",
+			Usage:   "suggest more words if more than this number of peers fail authentication (0 disables)",
+			EnvVars: []string{"PCP_COLLISION_THRESHOLD"},
+			Value:   2,
+		},
+		&cli.BoolFlag{
+			Name:    "benchmark",
+			Usage:   "send generated data instead of a file to measure the throughput without disk I/O",
+			EnvVars: []string{"PCP_BENCHMARK"},
+		},
+		&cli.GenericFlag{
+			Name:    "size",
+			Usage:   "number of bytes to send with --benchmark, e.g. 500MB or 2GiB",
+			EnvVars: []string{"PCP_SIZE"},
+			Value:   newByteSize(DefaultBenchmarkSize),
+		},
+		&cli.StringSliceFlag{
+			Name:    "exclude",
+			Usage:   "leave entries of a sent directory out that match this glob pattern, e.g. '*.log' or 'build/*' (repeatable)",
+			EnvVars: []string{"PCP_EXCLUDE"},
+		},
+		&cli.BoolFlag{
+			Name:    "no-hidden",
+			Usage:   "leave entries of a sent directory out whose name starts with a dot",
+			EnvVars: []string{"PCP_NO_HIDDEN"},
+		},
+		&cli.BoolFlag{
+			Name:    "gitignore",
+			Usage:   "match --exclude like .gitignore lines and also apply the .gitignore file of the sent directory",
+			EnvVars: []string{"PCP_GITIGNORE"},
+		},
+		&cli.BoolFlag{
+			Name:    "xattrs",
+			Usage:   "send the extended attributes of the files, e.g. to keep macOS resource forks",
+			EnvVars: []string{"PCP_XATTRS"},
+		},
 	},
-	ArgsUsage: `FILE`,
+	ArgsUsage: `FILE|URL`,
 	Description: `
-The send subcommand generates four random words based on the first
-bytes of a newly generated peer identity. The first word and the
-current time are used to generate an identifier that is broadcasted
-in your local network via mDNS and provided through the distributed
-hash table of the IPFS network.
+The send subcommand generates four random words. They are chosen
+independently of the peer identity, so a fixed identity (--identity)
+does not lead to predictable words. The first word and the current
+time are used to generate an identifier that is broadcasted in your
+local network via mDNS and provided through the distributed hash
+table of the IPFS network.
 
 After a peer attempts to connect it starts a password authen-
 ticated key exchange (PAKE) with the remaining three words to
@@ -44,6 +146,53 @@ currently connected one could fail the authentication procedure.
 
 After the authentication was successful and the peer confirmed
 the file transfer the transmission is started.
+
+While waiting, the sender lists every receiver that has started the
+key exchange with its abbreviated peer ID and whether it's connecting,
+authenticating, authenticated or has failed. The list is printed again
+whenever a receiver makes progress, so you can check that the right
+peer has connected before the transfer starts.
+
+Once the transfer has started, the sender stops advertising and rejects
+further receivers, including the ones that are still authenticating.
+The running transfer is not affected. With --peers it keeps advertising
+until enough receivers have connected.
+
+If more different receivers fail the authentication than allowed by
+--collision-threshold, another pcp user may be using the same channel
+or someone is guessing the words. The sender then suggests to start
+over with more words.
+
+Use --relay to connect through a relay you trust if both peers are behind a NAT.
+
+To distribute a file to several machines, pass --peers with the number
+of receivers. The sender keeps advertising and transfers the file to
+each authenticated receiver separately until that many have connected
+or --peers-timeout has passed. It then prints the result per receiver.
+
+To find out whether a slow transfer is caused by the network or the
+disk, pass --benchmark instead of a file. The sender then transfers
+--size generated bytes that the receiver discards, and both report
+the minimum, average and maximum rate.
+
+FILE can also be an http(s) URL. The file is then streamed from
+the server to the receiver without storing it locally. Its name is
+taken from the Content-Disposition header or the URL. If the server
+doesn't announce the size, the file is downloaded to a temporary
+file first, because the size must be known before the transfer.
+
+Use --exclude, --no-hidden or --gitignore to leave parts of a directory out.
+
+With --xattrs the extended attributes of the sent files and directories
+are added to the transfer, including resource forks on macOS. The
+receiver restores them where its file system supports them and only
+warns about the rest. Security related namespaces on Linux, e.g.
+security.capability, are never restored.
+
+For reproducible tests and demos, --seed derives the words and the
+peer identity from the given seed instead of a secure random source.
+The same seed yields the same words, channel and peer ID in every run,
+so the channel is predictable. Never use it for real transfers.
 `,
 }
 
@@ -51,53 +200,133 @@ the file transfer the transmission is started.
 // mainly responsible for input parsing and service initialisation.
 func Action(c *cli.Context) error {
 	// Read config file and fill context with it.
-	c, err := config.FillContext(c)
+	c, err := config.FillContext(c, c.String("config"))
 	if err != nil {
 		return err
 	}
 
-	// Try to open the file to check if we have access and fail early.
-	filepath := c.Args().First()
-	if err = validateFile(filepath); err != nil {
-		return err
+	if c.Bool("print-config") {
+		return config.Print(os.Stdout, c)
 	}
 
-	log.Debugln("Validating given word count:", c.Int("w"))
-	if c.Int("w") < 3 && !c.Bool("homebrew") {
-		return fmt.Errorf("the number of words must not be less than 3")
+	if c.Bool("list-interfaces") {
+		return mdns.PrintInterfaces(os.Stdout)
 	}
 
-	// Generate the random words
-	_, wrds, err := words.Random("english", c.Int("w"))
+	// Initialize node
+	local, err := New(c.Context, OptionsFromContext(c))
 	if err != nil {
 		return err
 	}
 
-	// If homebrew flag is set, overwrite generated words with well known list
-	if c.Bool("homebrew") {
-		wrds = words.HomebrewList()
-	}
-
-	// Initialize node
-	local, err := InitNode(c, filepath, wrds)
+	code, err := words.Join(local.Words, c.String("word-separator"), c.Bool("uppercase"))
 	if err != nil {
+		local.Shutdown()
 		return err
 	}
 
+	printEntropy(local.Words, c.Bool("homebrew"), c.String("seed") != "")
+
 	// Broadcast the code to be found by peers.
-	log.Infoln("Code is: ", strings.Join(local.Words, "-"))
-	log.Infoln("On the other machine run:\n\tpcp receive", strings.Join(local.Words, "-"))
+	log.Infoln("Code is: ", code)
+	log.Infoln("On the other machine run:\n\tpcp receive", code)
 
-	local.StartAdvertising(c)
+	if c.Bool("copy") {
+		copyToClipboard("pcp receive " + code)
+	}
+
+	// Write the words before advertising, so that a watching
+	// process can start receiving right away.
+	if path := c.String("words-file"); path != "" {
+		text := strings.Join(local.Words, " ")
+		if c.Bool("words-file-command") {
+			text = "pcp receive " + code
+		}
+		if err = writeWordsFile(path, text); err != nil {
+			local.Shutdown()
+			return err
+		}
+	}
+
+	local.Start()
 
 	// Wait for the user to stop the tool or the transfer to finish.
-	select {
-	case <-c.Done():
-		local.Shutdown()
-		return nil
-	case <-local.SigDone():
-		return nil
+	err = local.Wait(c.Context)
+	if c.Bool("summary") {
+		tui.Show(local.Summary(), tui.DefaultHold)
 	}
+	return err
+}
+
+// printEntropy shows the approximate brute-force resistance of the
+// words. The hard coded homebrew words are publicly known and seeded
+// words are predictable, so we warn loudly that anyone can join the
+// channel.
+func printEntropy(wrds []string, homebrew bool, seeded bool) {
+	if homebrew {
+		log.Warningln(strings.Repeat("!", 64))
+		log.Warningln("!! --homebrew uses a hard coded, publicly known word sequence.")
+		log.Warningln("!! ANYONE can receive this file. Only use it for testing.")
+		log.Warningln(strings.Repeat("!", 64))
+		return
+	}
+
+	if seeded {
+		log.Warningln(strings.Repeat("!", 64))
+		log.Warningln("!! --seed generates the same words and peer ID in every run.")
+		log.Warningln("!! ANYONE who knows or guesses the seed can receive this file.")
+		log.Warningln("!! Only use it for tests and demos.")
+		log.Warningln(strings.Repeat("!", 64))
+		return
+	}
+
+	bits, err := words.Entropy(string(words.English), len(wrds))
+	if err != nil {
+		log.Warningln("Could not estimate the entropy of the words:", err)
+		return
+	}
+
+	log.Infof("The secret words have an entropy of approximately %.0f bits\n", bits)
+	if bits < words.MinEntropyBits {
+		log.Warningf("This is less than the recommended %d bits. Consider using more words (-w).\n", words.MinEntropyBits)
+	}
+}
+
+// copyToClipboard copies the given text to the system clipboard. If there
+// is no clipboard available (e.g. in a headless SSH session) it just warns.
+func copyToClipboard(text string) {
+	if clipboard.Unsupported {
+		log.Warningln("Could not copy to clipboard: no clipboard available")
+		return
+	}
+
+	if err := clipboard.WriteAll(text); err != nil {
+		log.Warningln("Could not copy to clipboard:", err)
+		return
+	}
+
+	log.Infoln("Copied the receive command to your clipboard")
+}
+
+// newByteSize returns a flag value with the given default size.
+func newByteSize(size int64) *format.ByteSize {
+	b := format.ByteSize(size)
+	return &b
+}
+
+// writeWordsFile writes the given text to the file at the given path and
+// creates missing parent directories. An existing file is overwritten.
+// Only the user may read it as the words give access to the file.
+func writeWordsFile(path string, text string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.Wrapf(err, "could not create directory for words file %s", path)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(text+"\n"), 0o600); err != nil {
+		return errors.Wrapf(err, "could not write words file %s", path)
+	}
+
+	return nil
 }
 
 // validateFile tries to open the file at the given path to check
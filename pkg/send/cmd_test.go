@@ -0,0 +1,48 @@
+package send
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteWordsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Missing parent directories are created.
+	path := filepath.Join(dir, "a", "b", "words")
+	require.NoError(t, writeWordsFile(path, "some long words list"))
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "some long words list\n", string(data))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// An existing file is overwritten.
+	require.NoError(t, writeWordsFile(path, "short"))
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "short\n", string(data))
+}
+
+func TestWriteWordsFile_notWritable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A file can't be the parent directory.
+	parent := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(parent, nil, 0o644))
+
+	err = writeWordsFile(filepath.Join(parent, "words"), "words")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "words file")
+}
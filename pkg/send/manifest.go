@@ -0,0 +1,72 @@
+package send
+
+import (
+	"io/ioutil"
+	"os"
+
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+// maxManifestEntries is the maximum number of top-level entries
+// that are sent to the receiver as part of the push request.
+const maxManifestEntries = 100
+
+// manifest describes a directory before it's transferred, so that the
+// receiver can see what it would get before accepting.
+type manifest struct {
+	files      int64
+	entries    []string
+	entryCount int64
+}
+
+// buildManifest counts all regular files below the given directory and
+// lists its top-level entries in lexical order. Directories carry
+// a trailing slash. Excluded entries are left out.
+func buildManifest(root string, exclude *pcpnode.Exclude) (*manifest, error) {
+	all, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []os.FileInfo
+	for _, info := range all {
+		if !exclude.Match(info.Name(), info.IsDir()) {
+			infos = append(infos, info)
+		}
+	}
+
+	m := &manifest{entryCount: int64(len(infos))}
+	for _, info := range infos {
+		if len(m.entries) == maxManifestEntries {
+			break
+		}
+		name := info.Name()
+		if info.IsDir() {
+			name += "/"
+		}
+		m.entries = append(m.entries, name)
+	}
+
+	err = exclude.Walk(root, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			m.files++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// apply adds the manifest to the given push request.
+func (m *manifest) apply(req *p2p.PushRequest) {
+	req.TotalFiles = m.files
+	req.Entries = m.entries
+	req.EntryCount = m.entryCount
+}
@@ -0,0 +1,72 @@
+package send
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+)
+
+func TestBuildManifest(t *testing.T) {
+	root, err := ioutil.TempDir("", "pcp-manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "sub", "deep"), 0o755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "b.txt"), []byte("b"), 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "sub", "c.txt"), []byte("c"), 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "sub", "deep", "d.txt"), []byte("d"), 0o644))
+
+	m, err := buildManifest(root, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, m.files)
+	assert.EqualValues(t, 3, m.entryCount)
+	assert.Equal(t, []string{"a.txt", "b.txt", "sub/"}, m.entries)
+}
+
+func TestBuildManifest_truncated(t *testing.T) {
+	root, err := ioutil.TempDir("", "pcp-manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	for i := 0; i < maxManifestEntries+5; i++ {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, fmt.Sprintf("%03d", i)), nil, 0o644))
+	}
+
+	m, err := buildManifest(root, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, maxManifestEntries+5, m.files)
+	assert.EqualValues(t, maxManifestEntries+5, m.entryCount)
+	assert.Len(t, m.entries, maxManifestEntries)
+}
+
+func TestBuildManifest_exclude(t *testing.T) {
+	root, err := ioutil.TempDir("", "pcp-manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, ".git"), 0o755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, ".git", "HEAD"), []byte("ref"), 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "a.log"), []byte("log"), 0o644))
+
+	exclude, err := pcpnode.NewExclude([]string{"*.log"}, true, false)
+	require.NoError(t, err)
+
+	m, err := buildManifest(root, exclude)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, m.files)
+	assert.EqualValues(t, 1, m.entryCount)
+	assert.Equal(t, []string{"a.txt"}, m.entries)
+
+	size, err := totalSize(root, exclude)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, size)
+}
@@ -1,21 +1,35 @@
 package send
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/pkg/errors"
-	"github.com/urfave/cli/v2"
 
+	"github.com/dennis-tra/pcp/internal/format"
 	"github.com/dennis-tra/pcp/internal/log"
+	"github.com/dennis-tra/pcp/internal/tui"
 	"github.com/dennis-tra/pcp/pkg/dht"
+	"github.com/dennis-tra/pcp/pkg/discovery"
 	"github.com/dennis-tra/pcp/pkg/mdns"
 	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+	"github.com/dennis-tra/pcp/pkg/rendezvous"
+	"github.com/dennis-tra/pcp/pkg/words"
 )
 
 // Node encapsulates the logic of advertising and transmitting
@@ -23,65 +37,294 @@ import (
 type Node struct {
 	*pcpnode.Node
 
-	advertisers []Advertiser
+	advertisers []discovery.Advertiser
+
+	// cancelled is set to 1 if the receiver has cancelled the transfer.
+	cancelled int32
+
+	// transferring is set to 1 once the first receiver was authenticated
+	// and the transfer to it has started.
+	transferring int32
 
 	authPeers *sync.Map
 	filepath  string
-}
 
-type Advertiser interface {
-	Advertise(chanID int) error
-	Shutdown()
+	// Which discovery mechanisms should be used.
+	useDHT  bool
+	useMDNS bool
+
+	// signKey is used to sign the push request if it's set.
+	signKey crypto.PrivKey
+
+	// benchmarkSize is the number of generated bytes that are
+	// sent instead of a file. It's zero for regular transfers.
+	benchmarkSize int64
+
+	// receivers tracks the transfers if the file is sent to several
+	// receivers. It's nil if we stop after the first receiver.
+	receivers *receivers
+
+	// peersTimeout is the time after which no new receivers are
+	// accepted if the file is sent to several receivers.
+	peersTimeout time.Duration
+
+	// summary describes the outcome of the transfer to a single receiver.
+	summaryLk sync.Mutex
+	summary   *tui.Summary
+
+	// err is returned from Wait. It's set if the transfer must not
+	// be treated as a mere failed attempt, e.g. in --only-direct mode.
+	errLk sync.Mutex
+	err   error
+
+	// remote is the file we stream from an HTTP server. It's nil
+	// if the file or directory is read from the local disk.
+	remote *remoteFile
+
+	// exclude leaves entries of the sent directory out. It's nil
+	// if all entries are sent.
+	exclude *pcpnode.Exclude
+
+	// checksum is the SHA-256 checksum of the sent data. It's
+	// calculated once before the first transfer.
+	checksumLk sync.Mutex
+	checksum   string
+
+	// waitingRoom lists the receivers that have started
+	// a key exchange with us and their progress.
+	waitingRoom *waitingRoom
+
+	// collisionThreshold is the number of distinct peers that may
+	// fail authentication before we suggest more words.
+	collisionThreshold int
+	collisionWarning   sync.Once
 }
 
-// InitNode returns a fully configured node ready to start
-// advertising that we want to send a specific file.
-func InitNode(c *cli.Context, filepath string, words []string) (*Node, error) {
-	h, err := pcpnode.New(c, words, libp2p.EnableAutoRelay())
+// New returns a fully configured node ready to start advertising
+// that we want to send a specific file. If no words are given,
+// random ones are generated. Call Start to begin advertising
+// and Wait to block until the transfer has finished.
+func New(ctx context.Context, opts Options) (*Node, error) {
+	if opts.Peers < 1 {
+		return nil, fmt.Errorf("the number of receivers must be at least 1")
+	}
+
+	if opts.CollisionThreshold < 0 {
+		return nil, fmt.Errorf("the collision threshold must not be negative")
+	}
+
+	var remote *remoteFile
+	if opts.Benchmark {
+		if opts.Filepath != "" {
+			return nil, fmt.Errorf("--benchmark sends generated data and doesn't take a file")
+		}
+		if opts.BenchmarkSize <= 0 {
+			return nil, fmt.Errorf("the benchmark size must be positive")
+		}
+		if opts.SignKey != "" {
+			return nil, fmt.Errorf("generated benchmark data cannot be signed")
+		}
+	} else if isURL(opts.Filepath) {
+		if opts.SignKey != "" {
+			return nil, fmt.Errorf("a remote file cannot be signed without downloading it first")
+		}
+		var err error
+		if remote, err = statURL(ctx, http.DefaultClient, opts.Filepath); err != nil {
+			return nil, err
+		}
+		log.Debugln("Sending remote file", remote.name, "from", remote.url)
+	} else if err := validateFile(opts.Filepath); err != nil {
+		// Try to open the file to check if we have access and fail early.
+		return nil, err
+	}
+
+	exclude, err := newExclude(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Xattrs && (opts.Benchmark || isURL(opts.Filepath)) {
+		return nil, fmt.Errorf("--xattrs only applies to local files")
+	}
+
+	if opts.Seed != "" && opts.Homebrew {
+		return nil, fmt.Errorf("--seed can't be combined with the homebrew words")
+	}
+
+	if len(opts.Words) == 0 && !opts.Homebrew {
+		log.Debugln("Validating given word count:", opts.WordCount)
+		if opts.WordCount < 3 {
+			return nil, fmt.Errorf("the number of words must not be less than 3")
+		}
+
+		// Generate the random words. A seed makes them reproducible.
+		src := rand.Reader
+		if opts.Seed != "" {
+			src = words.NewSeededReader("words:" + opts.Seed)
+		}
+		_, wrds, err := words.RandomFrom(src, "english", opts.WordCount)
+		if err != nil {
+			return nil, err
+		}
+		opts.Words = wrds
+	}
+
+	var signKey crypto.PrivKey
+	if opts.SignKey != "" {
+		key, err := pcpnode.LoadSignKey(opts.SignKey)
+		if err != nil {
+			return nil, err
+		}
+
+		verifyKey, err := pcpnode.FormatVerifyKey(key)
+		if err != nil {
+			return nil, err
+		}
+		log.Infoln("Signing the transfer. The receiver can verify it with: --verify-key", verifyKey)
+		signKey = key
+	}
+
+	// Relay addresses would only be advertised in vain if we must
+	// connect directly.
+	var p2pOpts []libp2p.Option
+	if !opts.OnlyDirect && !opts.LANOnly {
+		p2pOpts = append(p2pOpts, libp2p.EnableAutoRelay())
+	}
+
+	var relay *peer.AddrInfo
+	if opts.Relay != "" {
+		if opts.OnlyDirect {
+			return nil, fmt.Errorf("--relay can't be combined with --only-direct")
+		}
+		if opts.LANOnly {
+			return nil, fmt.Errorf("--relay can't be combined with --lan-only")
+		}
+
+		pi, err := parseRelay(opts.Relay)
+		if err != nil {
+			return nil, err
+		}
+		relay = pi
+		p2pOpts = append(p2pOpts, relayOptions(relay)...)
+	}
+
+	h, err := pcpnode.New(ctx, opts.Options, p2pOpts...)
 	if err != nil {
 		return nil, err
 	}
 
+	if relay != nil {
+		if err = connectRelay(ctx, h, relay, opts.RelayFallback); err != nil {
+			h.Shutdown()
+			return nil, err
+		}
+	}
+
 	node := &Node{
 		Node:        h,
-		advertisers: []Advertiser{},
+		advertisers: []discovery.Advertiser{},
 		authPeers:   &sync.Map{},
-		filepath:    filepath,
+		filepath:    opts.Filepath,
+		useDHT:      opts.UseDHT,
+		useMDNS:     opts.UseMDNS,
+		signKey:     signKey,
+		remote:      remote,
+		exclude:     exclude,
+		waitingRoom: newWaitingRoom(),
+
+		collisionThreshold: opts.CollisionThreshold,
+	}
+	if opts.Benchmark {
+		node.benchmarkSize = opts.BenchmarkSize
+	}
+	if opts.Peers > 1 {
+		node.receivers = newReceivers(opts.Peers)
+		node.peersTimeout = opts.PeersTimeout
 	}
 
+	node.SetExclude(exclude)
+	node.SetXattrs(opts.Xattrs)
 	node.RegisterKeyExchangeHandler(node)
 
 	return node, nil
 }
 
+// Start advertises the file in the background.
+func (n *Node) Start() {
+	if n.receivers != nil {
+		log.Infof("Waiting for %d receivers...\n", n.receivers.limit)
+		n.RegisterCancelHandler(n.receivers.cancel)
+		if n.peersTimeout > 0 {
+			go n.waitForReceivers()
+		}
+	}
+	n.StartAdvertising()
+}
+
+// Wait blocks until the transfer has finished or the given
+// context is cancelled, in which case the node is shut down. It
+// returns an error if no direct connection could be established
+// in --only-direct mode.
+func (n *Node) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		if n.receivers != nil {
+			n.printSummary()
+		}
+		n.Shutdown()
+		return nil
+	case <-n.SigDone():
+		n.errLk.Lock()
+		defer n.errLk.Unlock()
+		return n.err
+	}
+}
+
+// setErr records the error that Wait returns. Only the first one is kept.
+func (n *Node) setErr(err error) {
+	n.errLk.Lock()
+	defer n.errLk.Unlock()
+	if n.err == nil {
+		n.err = err
+	}
+}
+
 func (n *Node) Shutdown() {
 	n.StopAdvertising()
 	n.UnregisterKeyExchangeHandler()
+	n.UnregisterCancelHandler()
 	n.Node.Shutdown()
 }
 
 // StartAdvertising asynchronously advertises the given code through the means of all
-// registered advertisers. Currently these are multicast DNS and DHT.
-func (n *Node) StartAdvertising(c *cli.Context) {
+// registered advertisers. These are multicast DNS, the DHT and optionally a rendezvous server.
+func (n *Node) StartAdvertising() {
 	n.SetState(pcpnode.Advertising)
 
-	if c.Bool("mdns") == c.Bool("dht") {
-		n.advertisers = []Advertiser{
-			dht.NewAdvertiser(n, n.DHT),
-			mdns.NewAdvertiser(n.Node),
-		}
-	} else if c.Bool("mdns") {
-		n.advertisers = []Advertiser{
-			mdns.NewAdvertiser(n.Node),
-		}
-	} else if c.Bool("dht") {
-		n.advertisers = []Advertiser{
-			dht.NewAdvertiser(n, n.DHT),
-		}
+	tl := pcpnode.NewTimeline()
+	n.advertisers = []discovery.Advertiser{}
+	if n.useDHT {
+		n.advertisers = append(n.advertisers, dht.NewAdvertiser(n, n.DHT).SetNamespace(n.Namespace).SetTimeSlot(n.TimeSlot).
+			SetBootstrapRetries(n.DHTBootstrapRounds, n.DHTBootstrapBackoff).
+			SetStageHandler(func(s dht.Stage) { tl.Enter("DHT", string(s)) }))
+	}
+
+	if n.useMDNS {
+		n.advertisers = append(n.advertisers, mdns.NewAdvertiser(n.Node).SetNamespace(n.Namespace).SetServiceTag(n.MDNSServiceTag).SetInterval(n.MDNSInterval).SetTimeSlot(n.TimeSlot).
+			SetInterfaces(n.MDNSInterfaces).SetStageHandler(func(s mdns.Stage) { tl.Enter("mDNS", string(s)) }))
+	}
+
+	if n.Rendezvous != nil {
+		n.advertisers = append(n.advertisers, rendezvous.NewAdvertiser(n, *n.Rendezvous).SetNamespace(n.Namespace).SetTimeSlot(n.TimeSlot).
+			SetStageHandler(func(s rendezvous.Stage) { tl.Enter("rendezvous", string(s)) }))
+	}
+
+	for _, advertiser := range n.advertisers {
+		n.ReportChannel(advertiser.Mechanism(), advertiser)
 	}
 
 	for _, advertiser := range n.advertisers {
-		go func(a Advertiser) {
+		go func(a discovery.Advertiser) {
 			err := a.Advertise(n.ChanID)
 			if err == nil {
 				return
@@ -107,7 +350,7 @@ func (n *Node) StopAdvertising() {
 	var wg sync.WaitGroup
 	for _, advertiser := range n.advertisers {
 		wg.Add(1)
-		go func(a Advertiser) {
+		go func(a discovery.Advertiser) {
 			a.Shutdown()
 			wg.Done()
 		}(advertiser)
@@ -116,55 +359,329 @@ func (n *Node) StopAdvertising() {
 }
 
 func (n *Node) HandleSuccessfulKeyExchange(peerID peer.ID) {
+	if n.receivers != nil {
+		n.handleReceiver(peerID)
+		return
+	}
+
 	// We're authenticated so can initiate a transfer
-	if n.GetState() == pcpnode.Connected {
+	if !atomic.CompareAndSwapInt32(&n.transferring, 0, 1) {
 		log.Debugln("already connected and authenticated with another node")
 		return
 	}
 	n.SetState(pcpnode.Connected)
+	n.stopAdvertising()
 
-	n.UnregisterKeyExchangeHandler()
-	go n.StopAdvertising()
+	n.RegisterCancelHandler(func(peer.ID) {
+		atomic.StoreInt32(&n.cancelled, 1)
+	})
 
+	start := time.Now()
+	conn := n.PeerConnectionType(peerID)
 	err := n.Transfer(peerID)
-	if err != nil {
+
+	outcome := "sent"
+	if errors.Is(err, ErrManifestOnly) {
+		outcome = "manifest fetched by the receiver"
+	} else if err != nil && atomic.LoadInt32(&n.cancelled) == 1 {
+		log.Infoln("The receiver has cancelled the transfer")
+		outcome = "cancelled by the receiver"
+	} else if err != nil {
 		log.Warningln("Error transferring file:", err)
+		outcome = "failed: " + err.Error()
+	}
+
+	if errors.Is(err, pcpnode.ErrNoDirectConnection) || errors.Is(err, pcpnode.ErrRelayedConnection) {
+		n.setErr(err)
 	}
 
+	name, size := n.payload()
+	n.checksumLk.Lock()
+	checksum := n.checksum
+	n.checksumLk.Unlock()
+
+	n.summaryLk.Lock()
+	n.summary = &tui.Summary{
+		Outcome:    outcome,
+		Peer:       peerID.String(),
+		File:       name,
+		Size:       size,
+		Duration:   time.Since(start),
+		Connection: conn,
+		Checksum:   checksum,
+	}
+	n.summaryLk.Unlock()
+
 	n.Shutdown()
 }
 
+// Summary describes the outcome of the transfer. It's nil if no
+// receiver was found or the file was sent to several receivers.
+func (n *Node) Summary() *tui.Summary {
+	n.summaryLk.Lock()
+	defer n.summaryLk.Unlock()
+	return n.summary
+}
+
+// payload returns the name and size of the data we send.
+func (n *Node) payload() (string, int64) {
+	if n.benchmarkSize > 0 {
+		return pcpnode.BenchmarkName, n.benchmarkSize
+	}
+
+	if n.remote != nil {
+		return n.remote.name, n.remote.size
+	}
+
+	size, err := totalSize(n.filepath, n.exclude)
+	if err != nil {
+		log.Debugln("error determining the size of", n.filepath, err)
+	}
+	return path.Base(n.filepath), size
+}
+
+// handleReceiver transfers the file to one of several receivers. The
+// node keeps advertising until enough receivers have connected and
+// shuts down once all transfers have finished.
+func (n *Node) handleReceiver(peerID peer.ID) {
+	added, full := n.receivers.add(peerID)
+	if !added {
+		log.Debugln("Not accepting receiver", peerID)
+		return
+	}
+	if full {
+		n.stopAccepting()
+	}
+
+	log.Infoln("Sending to receiver", peerID)
+	err := n.Transfer(peerID)
+	if errors.Is(err, ErrManifestOnly) {
+		log.Infoln("Receiver", peerID, "has only fetched the manifest")
+	} else if err != nil && n.receivers.isCancelled(peerID) {
+		log.Infoln("Receiver", peerID, "has cancelled the transfer")
+	} else if err != nil {
+		log.Warningf("Error transferring file to %s: %s\n", peerID, err)
+	}
+
+	if n.receivers.finish(peerID, err) {
+		n.printSummary()
+		n.Shutdown()
+	}
+}
+
+// waitForReceivers stops accepting new receivers after the timeout.
+func (n *Node) waitForReceivers() {
+	t := time.NewTimer(n.peersTimeout)
+	defer t.Stop()
+
+	select {
+	case <-n.SigShutdown():
+		return
+	case <-t.C:
+	}
+
+	log.Infof("Stopped waiting for receivers after %s\n", n.peersTimeout)
+	if idle := n.stopAccepting(); idle {
+		n.printSummary()
+		n.Shutdown()
+	}
+}
+
+// stopAccepting stops advertising and authenticating new receivers.
+// It returns true if no transfer is running anymore.
+func (n *Node) stopAccepting() bool {
+	idle := n.receivers.close()
+	n.SetState(pcpnode.Connected)
+	n.stopAdvertising()
+	return idle
+}
+
+// stopAdvertising stops advertising and rejects new receivers, including
+// the ones that are still authenticating, once we're transferring. The
+// running transfers go on. The advertisers are shut down in the
+// background, so that the transfer doesn't wait for them.
+func (n *Node) stopAdvertising() {
+	n.UnregisterKeyExchangeHandler()
+	go func() {
+		n.StopAdvertising()
+		log.Debugln("Stopped advertising")
+	}()
+}
+
+// printSummary reports the outcome of the transfer to each receiver.
+func (n *Node) printSummary() {
+	for _, line := range n.receivers.summary() {
+		log.Infoln(line)
+	}
+}
+
 func (n *Node) Transfer(peerID peer.ID) error {
+	if n.OnlyDirect() {
+		if err := n.EnsureDirectConn(n.ServiceContext(), peerID); err != nil {
+			return err
+		}
+	}
+
+	if n.LANOnly() {
+		if err := n.EnsureLANConn(peerID); err != nil {
+			return err
+		}
+	}
+
+	if n.benchmarkSize > 0 {
+		return n.transferBenchmark(peerID)
+	}
+
+	if n.remote != nil {
+		return n.transferRemote(peerID)
+	}
+
 	filename := path.Base(n.filepath)
-	size, err := totalSize(n.filepath)
+	size, err := totalSize(n.filepath, n.exclude)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(n.filepath)
 	if err != nil {
 		return err
 	}
 
+	req := p2p.NewPushRequest(filename, size, info.IsDir())
+	if info.IsDir() {
+		m, err := buildManifest(n.filepath, n.exclude)
+		if err != nil {
+			return errors.Wrap(err, "could not build manifest")
+		}
+		m.apply(req)
+	} else {
+		// Let the receiver pick an extension if the name has none.
+		if req.ContentType, err = pcpnode.FileContentType(n.filepath); err != nil {
+			return err
+		}
+	}
+
+	if n.signKey != nil {
+		hash, err := pcpnode.ContentHash(n.filepath, n.exclude)
+		if err != nil {
+			return err
+		}
+
+		if err = pcpnode.SignPushRequest(n.signKey, req, hash); err != nil {
+			return err
+		}
+	}
+
 	log.Infof("Asking for confirmation... ")
-	accepted, err := n.SendPushRequest(n.ServiceContext(), peerID, filename, size, false)
+	resp, err := n.RequestPush(n.ServiceContext(), peerID, req)
 	if err != nil {
 		return err
 	}
 
-	if !accepted {
-		log.Infoln("Rejected!")
-		return fmt.Errorf("rejected file transfer")
+	if !resp.Accept {
+		return rejected(resp)
 	}
 	log.Infoln("Accepted!")
 
-	if err = n.Node.Transfer(n.ServiceContext(), peerID, n.filepath); err != nil {
+	// Calculate the checksum while the data is read for the transfer.
+	var sum hash.Hash
+	if n.ChecksumEnabled() {
+		sum = sha256.New()
+	}
+
+	if info.IsDir() {
+		resume := pcpnode.DirResumeFromResponse(resp)
+		if resume != nil {
+			log.Infof("Resuming the transfer, the receiver already has %d files\n", len(resume.Done))
+		}
+		err = n.Node.TransferResume(n.ServiceContext(), peerID, n.filepath, resume, sum)
+	} else {
+		var offset int64
+		if offset, err = pcpnode.VerifyOffset(n.filepath, size, resp.Offset, resp.PartialHash); err != nil {
+			return err
+		}
+		if offset > 0 {
+			log.Infof("Resuming the transfer after %s of %s\n", format.Bytes(offset), format.Bytes(size))
+		}
+		err = n.Node.TransferFrom(n.ServiceContext(), peerID, n.filepath, offset, sum)
+	}
+	if err != nil {
 		return errors.Wrap(err, "could not transfer file to peer")
 	}
 
+	if sum != nil {
+		n.sourceChecksum(sum.Sum(nil))
+	}
+
 	log.Infoln("Successfully sent file/directory!")
 	return nil
 }
 
-func totalSize(path string) (int64, error) {
-	// TODO: Add file count
+// sourceChecksum prints the given checksum of the sent data once.
+func (n *Node) sourceChecksum(digest []byte) {
+	if !n.ChecksumEnabled() {
+		return
+	}
+
+	n.checksumLk.Lock()
+	defer n.checksumLk.Unlock()
+
+	if n.checksum != "" {
+		return
+	}
+
+	n.checksum = hex.EncodeToString(digest)
+	log.Infoln("SHA-256:", n.checksum)
+}
+
+// ErrManifestOnly is returned from Transfer if the receiver has only
+// fetched the manifest and declined the transfer on purpose.
+var ErrManifestOnly = errors.New("the receiver has only fetched the manifest")
+
+// rejected returns the error for the given declining push response.
+func rejected(resp *p2p.PushResponse) error {
+	if resp.ManifestOnly {
+		log.Infoln("The receiver has only fetched the manifest")
+		return ErrManifestOnly
+	}
+	log.Infoln("Rejected!")
+	return fmt.Errorf("rejected file transfer")
+}
+
+// transferBenchmark sends generated data that the receiver discards
+// and reports the throughput.
+func (n *Node) transferBenchmark(peerID peer.ID) error {
+	req := p2p.NewPushRequest(pcpnode.BenchmarkName, n.benchmarkSize, false)
+	req.Benchmark = true
+
+	log.Infof("Asking for confirmation... ")
+	resp, err := n.RequestPush(n.ServiceContext(), peerID, req)
+	if err != nil {
+		return err
+	}
+
+	if !resp.Accept && resp.ManifestOnly {
+		return rejected(resp)
+	} else if !resp.Accept {
+		log.Infoln("Rejected!")
+		return fmt.Errorf("rejected benchmark")
+	}
+	log.Infoln("Accepted!")
+
+	summary, err := n.Node.TransferBenchmark(n.ServiceContext(), peerID, n.benchmarkSize)
+	if err != nil {
+		return errors.Wrap(err, "could not send benchmark data to peer")
+	}
+
+	log.Infoln("Benchmark:", summary)
+	return nil
+}
+
+// totalSize returns the number of bytes of all files below the given
+// path that aren't excluded.
+func totalSize(path string, exclude *pcpnode.Exclude) (int64, error) {
 	var size int64
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+	err := exclude.Walk(path, func(_ string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -176,3 +693,42 @@ func totalSize(path string) (int64, error) {
 	})
 	return size, err
 }
+
+// newExclude builds the filter of directory entries from the given options.
+// It returns nil if all entries are sent and logs how many are left out.
+func newExclude(opts Options) (*pcpnode.Exclude, error) {
+	if len(opts.Exclude) == 0 && !opts.NoHidden && !opts.Gitignore {
+		return nil, nil
+	}
+
+	if opts.Benchmark || isURL(opts.Filepath) {
+		return nil, fmt.Errorf("--exclude, --no-hidden and --gitignore only apply to local directories")
+	}
+
+	exclude, err := pcpnode.NewExclude(opts.Exclude, opts.NoHidden, opts.Gitignore)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(opts.Filepath)
+	if err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		log.Debugln("Ignoring the exclude options as", opts.Filepath, "is no directory")
+		return nil, nil
+	}
+
+	if opts.Gitignore {
+		if err = exclude.AddGitignore(filepath.Join(opts.Filepath, ".gitignore")); err != nil {
+			return nil, err
+		}
+	}
+
+	count, err := exclude.Count(opts.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Excluding %d entries of %s\n", count, opts.Filepath)
+
+	return exclude, nil
+}
@@ -0,0 +1,115 @@
+package send
+
+import (
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/dennis-tra/pcp/internal/format"
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+)
+
+// Options configures a sending node.
+type Options struct {
+	pcpnode.Options
+
+	// Filepath is the file or directory that should be sent.
+	Filepath string
+
+	// WordCount is the number of random words that are generated
+	// if no words were given explicitly. It must not be less than 3.
+	WordCount int
+
+	// SignKey is the path to an ed25519 private key file that is used
+	// to sign the push request. The request is unsigned if it's empty.
+	SignKey string
+
+	// Relay is the multiaddress including the peer ID of a circuit relay
+	// the sender connects to and advertises a relayed address for.
+	Relay string
+
+	// RelayFallback continues without the relay if it's unreachable.
+	RelayFallback bool
+
+	// Peers is the number of receivers the file is sent to. The node
+	// keeps advertising until that many receivers have connected.
+	Peers int
+
+	// PeersTimeout is the time after which no new receivers are
+	// accepted if Peers is greater than one. Zero waits forever.
+	PeersTimeout time.Duration
+
+	// CollisionThreshold is the number of distinct peers that may fail
+	// authentication before we suggest more words. Zero disables it.
+	CollisionThreshold int
+
+	// Benchmark sends BenchmarkSize generated bytes instead of
+	// a file to measure the throughput to the receiver.
+	Benchmark     bool
+	BenchmarkSize int64
+
+	// Exclude are glob patterns of directory entries that are not sent.
+	Exclude []string
+
+	// NoHidden doesn't send directory entries whose name starts with a dot.
+	NoHidden bool
+
+	// Gitignore interprets Exclude like the lines of a .gitignore file
+	// and applies the .gitignore file of the sent directory.
+	Gitignore bool
+
+	// Xattrs sends the extended attributes of the files along with
+	// their content. The receiver restores the ones it supports.
+	Xattrs bool
+}
+
+// DefaultBenchmarkSize is the number of bytes sent with --benchmark if no size is given.
+const DefaultBenchmarkSize = 1 << 30
+
+// DefaultOptions returns the options the send command uses
+// if no flags are given.
+func DefaultOptions(filepath string) Options {
+	return Options{
+		Options:   pcpnode.DefaultOptions(nil),
+		Filepath:  filepath,
+		WordCount: 4,
+		Peers:     1,
+
+		BenchmarkSize:      DefaultBenchmarkSize,
+		CollisionThreshold: 2,
+	}
+}
+
+// OptionsFromContext builds the options from the command line flags.
+func OptionsFromContext(c *cli.Context) Options {
+	o := Options{
+		Options:   pcpnode.OptionsFromContext(c, nil),
+		Filepath:  c.Args().First(),
+		WordCount: c.Int("w"),
+		SignKey:   c.String("sign-key"),
+		Relay:     c.String("relay"),
+
+		RelayFallback: c.Bool("relay-fallback"),
+		Peers:         c.Int("peers"),
+		PeersTimeout:  c.Duration("peers-timeout"),
+		Benchmark:     c.Bool("benchmark"),
+		BenchmarkSize: benchmarkSize(c),
+
+		CollisionThreshold: c.Int("collision-threshold"),
+
+		Exclude:   c.StringSlice("exclude"),
+		NoHidden:  c.Bool("no-hidden"),
+		Gitignore: c.Bool("gitignore"),
+		Xattrs:    c.Bool("xattrs"),
+	}
+	o.Seed = c.String("seed")
+	return o
+}
+
+// benchmarkSize returns the value of the size flag.
+func benchmarkSize(c *cli.Context) int64 {
+	if b, ok := c.Generic("size").(*format.ByteSize); ok && b != nil {
+		return int64(*b)
+	}
+	return DefaultBenchmarkSize
+}
@@ -0,0 +1,148 @@
+package send
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+)
+
+// receiverResult is the outcome of the transfer to a single receiver.
+type receiverResult struct {
+	peerID   peer.ID
+	start    time.Time
+	duration time.Duration
+	finished bool
+	err      error
+
+	// cancelled is set if the receiver told us that it
+	// has cancelled the transfer on purpose.
+	cancelled bool
+}
+
+// status describes the outcome of the transfer for the summary.
+func (r *receiverResult) status() string {
+	switch {
+	case !r.finished:
+		return "interrupted"
+	case r.cancelled:
+		return "cancelled by the receiver"
+	case errors.Is(r.err, ErrManifestOnly):
+		return "manifest fetched by the receiver"
+	case r.err != nil:
+		return "failed: " + r.err.Error()
+	default:
+		return fmt.Sprintf("done in %s", r.duration.Round(time.Millisecond))
+	}
+}
+
+// receivers tracks the transfers if the file is sent to several
+// receivers. Each authenticated receiver gets its own transfer
+// until the limit is reached or no new ones are accepted anymore.
+type receivers struct {
+	lk      sync.Mutex
+	limit   int
+	closed  bool
+	running int
+	results []*receiverResult
+	byPeer  map[peer.ID]*receiverResult
+}
+
+func newReceivers(limit int) *receivers {
+	return &receivers{limit: limit, byPeer: map[peer.ID]*receiverResult{}}
+}
+
+// add registers a transfer to the given receiver. It returns false if
+// we already send to the receiver or don't accept new ones anymore. The
+// second return value is true if this receiver has reached the limit,
+// so that no new receivers are accepted from now on.
+func (r *receivers) add(peerID peer.ID) (bool, bool) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if _, found := r.byPeer[peerID]; found || r.closed {
+		return false, false
+	}
+
+	res := &receiverResult{peerID: peerID, start: time.Now()}
+	r.results = append(r.results, res)
+	r.byPeer[peerID] = res
+	r.running++
+
+	if len(r.results) >= r.limit {
+		r.closed = true
+		return true, true
+	}
+	return true, false
+}
+
+// cancel marks the transfer to the given receiver as cancelled by the receiver.
+func (r *receivers) cancel(peerID peer.ID) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if res, found := r.byPeer[peerID]; found {
+		res.cancelled = true
+	}
+}
+
+// isCancelled returns true if the given receiver has cancelled the transfer.
+func (r *receivers) isCancelled(peerID peer.ID) bool {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	res, found := r.byPeer[peerID]
+	return found && res.cancelled
+}
+
+// finish records the result of the transfer to the given receiver. It
+// returns true if this was the last running transfer and no new
+// receivers are accepted anymore.
+func (r *receivers) finish(peerID peer.ID, err error) bool {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	res, found := r.byPeer[peerID]
+	if !found || res.finished {
+		return false
+	}
+	res.finished = true
+	res.duration = time.Since(res.start)
+	res.err = err
+	r.running--
+
+	return r.closed && r.running == 0
+}
+
+// close stops accepting new receivers. It returns true if this closed
+// the receivers and no transfer is running anymore.
+func (r *receivers) close() bool {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if r.closed {
+		return false
+	}
+	r.closed = true
+	return r.running == 0
+}
+
+// summary returns a headline and one line per receiver in the order
+// in which they have connected.
+func (r *receivers) summary() []string {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	succeeded := 0
+	lines := []string{""}
+	for _, res := range r.results {
+		if res.finished && res.err == nil {
+			succeeded++
+		}
+		lines = append(lines, fmt.Sprintf("\t%s %s", res.peerID, res.status()))
+	}
+	lines[0] = fmt.Sprintf("Sent to %d of %d receivers:", succeeded, r.limit)
+	return lines
+}
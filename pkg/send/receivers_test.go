@@ -0,0 +1,83 @@
+package send
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceivers_add(t *testing.T) {
+	r := newReceivers(2)
+
+	added, full := r.add("a")
+	assert.True(t, added)
+	assert.False(t, full)
+
+	// The same receiver doesn't get a second transfer.
+	added, _ = r.add("a")
+	assert.False(t, added)
+
+	added, full = r.add("b")
+	assert.True(t, added)
+	assert.True(t, full)
+
+	added, _ = r.add("c")
+	assert.False(t, added)
+}
+
+func TestReceivers_finish(t *testing.T) {
+	r := newReceivers(2)
+	r.add("a")
+	r.add("b")
+
+	assert.False(t, r.finish("a", nil))
+	assert.False(t, r.finish("a", nil), "finished twice")
+	assert.True(t, r.finish("b", fmt.Errorf("rejected file transfer")))
+
+	lines := r.summary()
+	assert.Equal(t, "Sent to 1 of 2 receivers:", lines[0])
+	assert.Contains(t, lines[1], "done in")
+	assert.Contains(t, lines[2], "failed: rejected file transfer")
+}
+
+func TestReceivers_close(t *testing.T) {
+	r := newReceivers(3)
+	assert.True(t, r.close(), "no transfer running")
+	assert.False(t, r.close(), "already closed")
+
+	added, _ := r.add("a")
+	assert.False(t, added)
+
+	r = newReceivers(3)
+	r.add("a")
+	assert.False(t, r.close())
+	assert.True(t, r.finish("a", nil))
+}
+
+func TestReceivers_cancel(t *testing.T) {
+	r := newReceivers(2)
+	r.add("a")
+	r.add("b")
+
+	r.cancel("a")
+	r.cancel(peer.ID("unknown"))
+	assert.True(t, r.isCancelled("a"))
+	assert.False(t, r.isCancelled("b"))
+
+	r.finish("a", fmt.Errorf("stream reset"))
+	lines := r.summary()
+	assert.Contains(t, lines[1], "cancelled by the receiver")
+	assert.Contains(t, lines[2], "interrupted")
+}
+
+func TestReceivers_finish_manifestOnly(t *testing.T) {
+	r := newReceivers(1)
+	r.add("a")
+
+	assert.True(t, r.finish("a", ErrManifestOnly))
+	lines := r.summary()
+	assert.Equal(t, "Sent to 0 of 1 receivers:", lines[0])
+	assert.Contains(t, lines[1], "manifest fetched by the receiver")
+}
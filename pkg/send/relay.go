@@ -0,0 +1,69 @@
+package send
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/internal/log"
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+)
+
+// relayConnectTimeout is the time we wait for the connection to the
+// user-specified relay to be established.
+var relayConnectTimeout = 30 * time.Second
+
+// parseRelay parses the given relay multiaddress. It must contain
+// the peer ID of the relay, e.g. /ip4/1.2.3.4/tcp/4001/p2p/Qm...
+func parseRelay(addr string) (*peer.AddrInfo, error) {
+	maddr, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid relay address")
+	}
+
+	pi, err := peer.AddrInfoFromP2pAddr(maddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "relay address must contain the peer ID of the relay")
+	}
+
+	return pi, nil
+}
+
+// relayOptions returns the libp2p options that make the node use the
+// given relay and advertise the relayed address right away instead of
+// waiting for AutoNAT to determine that we're not publicly reachable.
+func relayOptions(relay *peer.AddrInfo) []libp2p.Option {
+	return []libp2p.Option{
+		libp2p.EnableRelay(),
+		libp2p.StaticRelays([]peer.AddrInfo{*relay}),
+		libp2p.ForceReachabilityPrivate(),
+	}
+}
+
+// connectRelay establishes a connection to the user-specified relay. If that
+// fails the error is returned unless fallback is set. Then we only warn
+// and continue with the remaining (direct or auto relay) addresses.
+func connectRelay(ctx context.Context, n *pcpnode.Node, relay *peer.AddrInfo, fallback bool) error {
+	log.Infoln("Connecting to relay", relay.ID)
+
+	cctx, cancel := context.WithTimeout(ctx, relayConnectTimeout)
+	defer cancel()
+
+	err := n.Connect(cctx, *relay)
+	if err == nil {
+		return nil
+	}
+
+	err = errors.Wrapf(err, "could not connect to relay %s", relay.ID)
+	if !fallback {
+		return err
+	}
+
+	log.Warningln(err)
+	log.Warningln("Falling back to direct connections")
+	return nil
+}
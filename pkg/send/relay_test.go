@@ -0,0 +1,21 @@
+package send
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRelay(t *testing.T) {
+	pi, err := parseRelay("/ip4/1.2.3.4/tcp/4001/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt")
+	require.NoError(t, err)
+	assert.Equal(t, "QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt", pi.ID.Pretty())
+	assert.Len(t, pi.Addrs, 1)
+
+	_, err = parseRelay("/ip4/1.2.3.4/tcp/4001")
+	assert.Error(t, err)
+
+	_, err = parseRelay("not-an-address")
+	assert.Error(t, err)
+}
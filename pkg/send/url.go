@@ -0,0 +1,251 @@
+package send
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+
+	"github.com/dennis-tra/pcp/internal/format"
+	"github.com/dennis-tra/pcp/internal/log"
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+	p2p "github.com/dennis-tra/pcp/pkg/pb"
+)
+
+// remoteFallbackName is the name of a remote file if
+// neither the server nor the URL tell us its name.
+const remoteFallbackName = "download"
+
+// isURL returns true if the given file argument is an http(s) URL
+// instead of a local path.
+func isURL(arg string) bool {
+	u, err := url.Parse(arg)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// remoteFile is a file that is streamed from an HTTP server to
+// the receiver without storing it locally first.
+type remoteFile struct {
+	client *http.Client
+
+	// url is the final URL after all redirects.
+	url string
+
+	name    string
+	modTime time.Time
+
+	// contentType is the MIME type the server has announced or
+	// that was derived from the name. It may be empty.
+	contentType string
+
+	// size is the announced size of the file. It's
+	// negative if the server didn't announce it.
+	size int64
+}
+
+// statURL asks the server for the name and size of the resource with
+// a HEAD request. Redirects are followed.
+func statURL(ctx context.Context, client *http.Client, rawURL string) (*remoteFile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid URL %s", rawURL)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not reach the server")
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server responded to %s with %s", rawURL, resp.Status)
+	}
+
+	rf := &remoteFile{
+		client:  client,
+		url:     resp.Request.URL.String(),
+		name:    remoteName(resp),
+		size:    resp.ContentLength,
+		modTime: time.Now(),
+	}
+
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		rf.modTime = lm
+	}
+
+	// The announced type is more accurate than a guess from the name.
+	if ct, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && ct != "application/octet-stream" {
+		rf.contentType = ct
+	} else {
+		rf.contentType = pcpnode.DetectContentType(rf.name, nil)
+	}
+
+	return rf, nil
+}
+
+// remoteName returns the file name from the Content-Disposition header
+// or the last segment of the final URL path.
+func remoteName(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		// Never let the server choose a path, only a name.
+		if name := filepath.Base(params["filename"]); params["filename"] != "" && name != "." && name != "/" {
+			return name
+		}
+	}
+
+	name := path.Base(resp.Request.URL.Path)
+	if name == "." || name == "/" {
+		return remoteFallbackName
+	}
+	return name
+}
+
+// open starts downloading the file. The size of the response must match
+// the size the HEAD request has announced, otherwise the file has changed
+// in between.
+func (rf *remoteFile) open(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rf.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The size must be the one of the file and not the one of a compressed response.
+	req.Header.Set("Accept-Encoding", "identity")
+
+	resp, err := rf.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not download the file")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server responded to %s with %s", rf.url, resp.Status)
+	}
+
+	if rf.size >= 0 && resp.ContentLength >= 0 && resp.ContentLength != rf.size {
+		resp.Body.Close()
+		return nil, fmt.Errorf("remote file has changed its size from %s to %s", format.Bytes(rf.size), format.Bytes(resp.ContentLength))
+	}
+
+	return resp.Body, nil
+}
+
+// spool downloads the file into a temporary file because the server
+// hasn't announced its size, which the transfer needs up front. The
+// caller must remove the returned file.
+func (rf *remoteFile) spool(body io.Reader) (*os.File, int64, error) {
+	log.Infoln("The server didn't announce the size of the file, downloading it first...")
+
+	f, err := ioutil.TempFile("", "pcp-download-")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size, err := io.Copy(f, body)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, errors.Wrap(err, "could not download the file")
+	}
+
+	return f, size, nil
+}
+
+// transferRemote streams the remote file to the given peer. The data
+// flows from the server through us to the peer without being stored
+// locally, unless the server didn't announce the size.
+func (n *Node) transferRemote(peerID peer.ID) error {
+	ctx := n.ServiceContext()
+	rf := n.remote
+
+	var src io.Reader
+	size := rf.size
+	contentType := rf.contentType
+	if size < 0 {
+		body, err := rf.open(ctx)
+		if err != nil {
+			return err
+		}
+		f, spooled, err := rf.spool(body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		src, size = f, spooled
+
+		// We have the whole file anyway, so sniff its type.
+		if contentType == "" {
+			if contentType, err = pcpnode.FileContentType(f.Name()); err != nil {
+				return err
+			}
+		}
+	}
+
+	req := p2p.NewPushRequest(rf.name, size, false)
+	req.ContentType = contentType
+
+	log.Infof("Asking for confirmation... ")
+	resp, err := n.RequestPush(ctx, peerID, req)
+	if err != nil {
+		return err
+	}
+
+	if !resp.Accept {
+		return rejected(resp)
+	}
+	log.Infoln("Accepted!")
+
+	// Only start downloading after the peer has accepted,
+	// so that the server doesn't wait for us in between.
+	if src == nil {
+		body, err := rf.open(ctx)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		src = body
+	}
+
+	// The file isn't stored locally, so calculate its checksum on the way.
+	var checksum hash.Hash
+	if n.ChecksumEnabled() {
+		checksum = sha256.New()
+		src = io.TeeReader(src, checksum)
+	}
+
+	if err = n.Node.TransferReader(ctx, peerID, rf.name, size, rf.modTime, src); err != nil {
+		return errors.Wrap(err, "could not transfer file to peer")
+	}
+
+	if checksum != nil {
+		sum := hex.EncodeToString(checksum.Sum(nil))
+		log.Infoln("SHA-256:", sum)
+		n.checksumLk.Lock()
+		n.checksum = sum
+		n.checksumLk.Unlock()
+	}
+
+	log.Infoln("Successfully sent file!")
+	return nil
+}
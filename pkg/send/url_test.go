@@ -0,0 +1,108 @@
+package send
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsURL(t *testing.T) {
+	assert.True(t, isURL("http://example.com/file.txt"))
+	assert.True(t, isURL("https://example.com"))
+	assert.False(t, isURL("file.txt"))
+	assert.False(t, isURL("/tmp/file.txt"))
+	assert.False(t, isURL("ftp://example.com/file.txt"))
+	assert.False(t, isURL("http:file.txt"))
+}
+
+func remoteServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("some data"))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/file.txt", http.StatusFound)
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="../report.pdf"`)
+		w.Write([]byte("pdf"))
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		// Flushing before writing forces chunked encoding without a length.
+		w.(http.Flusher).Flush()
+		w.Write([]byte("streamed data"))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestStatURL(t *testing.T) {
+	srv := remoteServer()
+	defer srv.Close()
+	ctx := context.Background()
+
+	rf, err := statURL(ctx, srv.Client(), srv.URL+"/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "file.txt", rf.name)
+	assert.EqualValues(t, 9, rf.size)
+
+	rf, err = statURL(ctx, srv.Client(), srv.URL+"/redirect")
+	require.NoError(t, err)
+	assert.Equal(t, "file.txt", rf.name)
+	assert.Equal(t, srv.URL+"/file.txt", rf.url)
+
+	rf, err = statURL(ctx, srv.Client(), srv.URL+"/download")
+	require.NoError(t, err)
+	assert.Equal(t, "report.pdf", rf.name)
+
+	rf, err = statURL(ctx, srv.Client(), srv.URL+"/stream")
+	require.NoError(t, err)
+	assert.EqualValues(t, -1, rf.size)
+
+	_, err = statURL(ctx, srv.Client(), srv.URL+"/missing")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+
+	_, err = statURL(ctx, srv.Client(), "http://127.0.0.1:0/file.txt")
+	assert.Error(t, err)
+}
+
+func TestRemoteFile_open(t *testing.T) {
+	srv := remoteServer()
+	defer srv.Close()
+	ctx := context.Background()
+
+	rf, err := statURL(ctx, srv.Client(), srv.URL+"/file.txt")
+	require.NoError(t, err)
+
+	body, err := rf.open(ctx)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "some data", string(data))
+
+	// The file has changed since the HEAD request.
+	rf.size = 4
+	_, err = rf.open(ctx)
+	assert.Error(t, err)
+}
+
+func TestRemoteFile_spool(t *testing.T) {
+	rf := &remoteFile{size: -1}
+	f, size, err := rf.spool(strings.NewReader("streamed data"))
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	assert.EqualValues(t, 13, size)
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "streamed data", string(data))
+}
@@ -0,0 +1,101 @@
+package send
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/dennis-tra/pcp/internal/log"
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+)
+
+// waitingRoom tracks the receivers that have started a key exchange
+// with us, so that the user sees who is trying to connect before the
+// transfer starts. Receivers are listed in the order they arrived.
+type waitingRoom struct {
+	lk     sync.Mutex
+	peers  []peer.ID
+	states map[peer.ID]pcpnode.KeyExchangeState
+
+	// failed holds the distinct peers that have failed authentication.
+	failed map[peer.ID]struct{}
+}
+
+func newWaitingRoom() *waitingRoom {
+	return &waitingRoom{
+		states: map[peer.ID]pcpnode.KeyExchangeState{},
+		failed: map[peer.ID]struct{}{},
+	}
+}
+
+// set stores the state of the given receiver and returns the
+// list of all receivers if the state has changed.
+func (w *waitingRoom) set(peerID peer.ID, state pcpnode.KeyExchangeState) (string, bool) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	prev, found := w.states[peerID]
+	if found && prev == state {
+		return "", false
+	} else if !found {
+		w.peers = append(w.peers, peerID)
+	}
+	w.states[peerID] = state
+	if state == pcpnode.KeyExchangeFailed {
+		w.failed[peerID] = struct{}{}
+	}
+
+	entries := make([]string, len(w.peers))
+	for i, p := range w.peers {
+		entries[i] = shortID(p) + " " + w.states[p].String()
+	}
+	return strings.Join(entries, ", "), true
+}
+
+// HandleKeyExchangeState is called by the key exchange protocol whenever
+// a receiver has made progress. It prints the updated waiting room.
+func (n *Node) HandleKeyExchangeState(peerID peer.ID, state pcpnode.KeyExchangeState) {
+	if list, changed := n.waitingRoom.set(peerID, state); changed {
+		log.Infoln("Receivers:", list)
+	}
+
+	if state == pcpnode.KeyExchangeFailed {
+		n.checkCollision()
+	}
+}
+
+// failures returns the number of distinct peers that have failed authentication.
+func (w *waitingRoom) failures() int {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	return len(w.failed)
+}
+
+// checkCollision suggests more words once more distinct peers have failed
+// authentication than the collision threshold allows. Either another pcp
+// user shares our channel or someone is guessing the words. It returns
+// true if the warning was printed.
+func (n *Node) checkCollision() bool {
+	failures := n.waitingRoom.failures()
+	if n.collisionThreshold <= 0 || failures <= n.collisionThreshold {
+		return false
+	}
+
+	warned := false
+	n.collisionWarning.Do(func() {
+		log.Warningf("%d different peers failed authentication. Another pcp user may be using the same channel or someone is guessing the words.\n", failures)
+		log.Warningf("Stop this transfer and send again with more words (pcp send -w %d) to make the channel harder to guess.\n", len(n.Words)+2)
+		warned = true
+	})
+	return warned
+}
+
+// shortID abbreviates the given peer ID for the waiting room.
+func shortID(peerID peer.ID) string {
+	s := peerID.String()
+	if len(s) > 16 {
+		return s[:16]
+	}
+	return s
+}
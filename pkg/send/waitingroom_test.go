@@ -0,0 +1,85 @@
+package send
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pcpnode "github.com/dennis-tra/pcp/pkg/node"
+)
+
+func TestWaitingRoom_set(t *testing.T) {
+	peer1, err := test.RandPeerID()
+	require.NoError(t, err)
+	peer2, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	w := newWaitingRoom()
+
+	list, changed := w.set(peer1, pcpnode.KeyExchangeConnecting)
+	assert.True(t, changed)
+	assert.Equal(t, peer1.String()[:16]+" connecting", list)
+
+	list, changed = w.set(peer2, pcpnode.KeyExchangeFailed)
+	assert.True(t, changed)
+	assert.Equal(t, peer1.String()[:16]+" connecting, "+peer2.String()[:16]+" failed", list)
+
+	// The order of arrival is kept.
+	list, changed = w.set(peer1, pcpnode.KeyExchangeAuthenticated)
+	assert.True(t, changed)
+	assert.Equal(t, peer1.String()[:16]+" authenticated, "+peer2.String()[:16]+" failed", list)
+
+	_, changed = w.set(peer1, pcpnode.KeyExchangeAuthenticated)
+	assert.False(t, changed)
+}
+
+func TestWaitingRoom_failures(t *testing.T) {
+	peer1, err := test.RandPeerID()
+	require.NoError(t, err)
+	peer2, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	w := newWaitingRoom()
+
+	// A peer that fails repeatedly is counted once.
+	w.set(peer1, pcpnode.KeyExchangeFailed)
+	w.set(peer1, pcpnode.KeyExchangeConnecting)
+	w.set(peer1, pcpnode.KeyExchangeFailed)
+	assert.Equal(t, 1, w.failures())
+
+	w.set(peer2, pcpnode.KeyExchangeFailed)
+	assert.Equal(t, 2, w.failures())
+}
+
+func TestNode_checkCollision(t *testing.T) {
+	n := &Node{
+		Node:               &pcpnode.Node{Words: []string{"one", "two", "three", "four"}},
+		waitingRoom:        newWaitingRoom(),
+		collisionThreshold: 2,
+	}
+
+	for i := 0; i < 2; i++ {
+		peerID, err := test.RandPeerID()
+		require.NoError(t, err)
+		n.waitingRoom.set(peerID, pcpnode.KeyExchangeFailed)
+		assert.False(t, n.checkCollision())
+	}
+
+	peerID, err := test.RandPeerID()
+	require.NoError(t, err)
+	n.waitingRoom.set(peerID, pcpnode.KeyExchangeFailed)
+	assert.True(t, n.checkCollision())
+	assert.False(t, n.checkCollision()) // only warn once
+}
+
+func TestNode_checkCollision_disabled(t *testing.T) {
+	n := &Node{waitingRoom: newWaitingRoom()}
+	for i := 0; i < 5; i++ {
+		peerID, err := test.RandPeerID()
+		require.NoError(t, err)
+		n.waitingRoom.set(peerID, pcpnode.KeyExchangeFailed)
+	}
+	assert.False(t, n.checkCollision())
+}
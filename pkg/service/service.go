@@ -134,17 +134,28 @@ func (s *Service) ServiceContext() context.Context {
 // This function blocks until the done channel was closed
 // which happens when ServiceStopped is called.
 func (s *Service) Shutdown() {
+	if !s.SignalShutdown() {
+		return
+	}
+	<-s.done
+	log.Debugln(s.name, "- Service was shut down")
+}
+
+// SignalShutdown instructs the service to gracefully shut down without
+// waiting for it. Everything listening on SigShutdown stops, which is
+// needed if the service stops itself and has to interrupt running
+// operations first. It returns false if the service wasn't running.
+func (s *Service) SignalShutdown() bool {
 	log.Debugln(s.name, "- Service shutting down...")
 
 	s.lk.Lock()
 	if s.state != Started {
 		s.lk.Unlock()
-		return
+		return false
 	}
 	s.state = Stopping
 	s.lk.Unlock()
 
 	close(s.shutdown)
-	<-s.done
-	log.Debugln(s.name, "- Service was shut down")
+	return true
 }
@@ -109,3 +109,21 @@ func TestService_SigShutdown(t *testing.T) {
 	go s.Shutdown()
 	<-s.SigShutdown()
 }
+
+func TestNewService_signalShutdown(t *testing.T) {
+	s := New("test")
+	assert.False(t, s.SignalShutdown())
+
+	err := s.ServiceStarted()
+	require.NoError(t, err)
+
+	assert.True(t, s.SignalShutdown())
+	<-s.SigShutdown()
+	<-s.ServiceContext().Done()
+	assert.False(t, s.SignalShutdown())
+
+	// Shutdown doesn't wait a second time.
+	s.Shutdown()
+	s.ServiceStopped()
+	<-s.SigDone()
+}
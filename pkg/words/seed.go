@@ -0,0 +1,42 @@
+package words
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+// seededReader is a deterministic stream of bytes. Each block is the
+// SHA-256 hash of the seed and a counter.
+type seededReader struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+// NewSeededReader returns a reader that yields the same bytes for the
+// same seed. It replaces the cryptographically secure random source for
+// reproducible tests and demos. Anyone who knows the seed can derive
+// the same bytes, so it must never be used to protect real transfers.
+func NewSeededReader(seed string) io.Reader {
+	return &seededReader{seed: []byte(seed)}
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			block := make([]byte, len(r.seed)+8)
+			copy(block, r.seed)
+			binary.BigEndian.PutUint64(block[len(r.seed):], r.counter)
+			r.counter++
+
+			sum := sha256.Sum256(block)
+			r.buf = sum[:]
+		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
@@ -3,7 +3,10 @@ package words
 import (
 	"crypto/rand"
 	"fmt"
+	"io"
+	"math"
 	"math/big"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/tyler-smith/go-bip39/wordlists"
@@ -37,9 +40,60 @@ var Lists = map[Language][]string{
 
 var ErrUnsupportedLanguage = errors.New("unsupported language")
 
+// Separators maps the names of the supported separators
+// between the words of a code to their string value.
+var Separators = map[string]string{
+	"dash":  "-",
+	"space": " ",
+	"dot":   ".",
+}
+
+// Join concatenates the given words with the separator of the
+// given name. If upper is true the words are printed in upper case.
+func Join(words []string, separator string, upper bool) (string, error) {
+	sep, found := Separators[separator]
+	if !found {
+		return "", fmt.Errorf("unsupported word separator %q", separator)
+	}
+
+	code := strings.Join(words, sep)
+	if upper {
+		code = strings.ToUpper(code)
+	}
+
+	return code, nil
+}
+
+// Split splits the given code at any of the supported separators
+// and normalizes the words to lower case, so that the result is
+// independent of how the code was shared.
+func Split(code string) []string {
+	fields := strings.FieldsFunc(code, func(r rune) bool {
+		for _, sep := range Separators {
+			if strings.ContainsRune(sep, r) {
+				return true
+			}
+		}
+		return false
+	})
+
+	words := make([]string, len(fields))
+	for i, field := range fields {
+		words[i] = strings.ToLower(field)
+	}
+
+	return words
+}
+
 // Random returns a slice of random words and their respective
 // integer values from the BIP39 wordlist of that given language.
 func Random(lang string, count int) ([]int, []string, error) {
+	return RandomFrom(rand.Reader, lang, count)
+}
+
+// RandomFrom is like Random but draws the words from the given source
+// of randomness, e.g. a reader from NewSeededReader.
+func RandomFrom(src io.Reader, lang string, count int) ([]int, []string, error) {
 	wordList, err := wordsForLang(lang)
 	if err != nil {
 		return nil, nil, err
@@ -47,7 +101,7 @@ func Random(lang string, count int) ([]int, []string, error) {
 	words := make([]string, count)
 	ints := make([]int, count)
 	for i := 0; i < count; i++ {
-		rint, err := rand.Int(rand.Reader, big.NewInt(int64(len(wordList))))
+		rint, err := rand.Int(src, big.NewInt(int64(len(wordList))))
 		if err != nil {
 			return nil, nil, err
 		}
@@ -57,6 +111,25 @@ func Random(lang string, count int) ([]int, []string, error) {
 	return ints, words, nil
 }
 
+// MinEntropyBits is the entropy below which we warn the user that
+// the words might be guessed by an active attacker.
+const MinEntropyBits = 40
+
+// Entropy returns the approximate entropy in bits of count words that
+// were chosen uniformly at random from the wordlist of the given language.
+// The first word isn't counted as it's the public channel ID, which is
+// announced in the clear. Only the remaining words are secret.
+func Entropy(lang string, count int) (float64, error) {
+	wordList, err := wordsForLang(lang)
+	if err != nil {
+		return 0, err
+	}
+	if count <= 1 {
+		return 0, nil
+	}
+	return float64(count-1) * math.Log2(float64(len(wordList))), nil
+}
+
 func ToInts(words []string) ([]int, error) {
 	var ints []int
 ListLoop:
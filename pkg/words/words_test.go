@@ -59,8 +59,92 @@ func TestRandomToInts(t *testing.T) {
 	}
 }
 
+func TestRandomFrom_seeded(t *testing.T) {
+	_, words1, err := RandomFrom(NewSeededReader("seed"), string(English), 4)
+	require.NoError(t, err)
+	_, words2, err := RandomFrom(NewSeededReader("seed"), string(English), 4)
+	require.NoError(t, err)
+	assert.Equal(t, words1, words2)
+
+	_, words3, err := RandomFrom(NewSeededReader("other seed"), string(English), 4)
+	require.NoError(t, err)
+	assert.NotEqual(t, words1, words3)
+}
+
 func TestRandom_UnsupportedLanguage(t *testing.T) {
 	_, _, err := Random("unsupported", 5)
 	require.Error(t, err)
 	assert.Equal(t, ErrUnsupportedLanguage, err)
 }
+
+func TestSplit(t *testing.T) {
+	testData := []struct {
+		code     string
+		expected []string
+	}{
+		{code: "", expected: []string{}},
+		{code: "apple-banana-cherry", expected: []string{"apple", "banana", "cherry"}},
+		{code: "apple banana cherry", expected: []string{"apple", "banana", "cherry"}},
+		{code: "apple.banana.cherry", expected: []string{"apple", "banana", "cherry"}},
+		{code: "Apple-BANANA cherry", expected: []string{"apple", "banana", "cherry"}},
+		{code: " apple--banana ", expected: []string{"apple", "banana"}},
+	}
+
+	for _, tt := range testData {
+		t.Run(tt.code, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Split(tt.code))
+		})
+	}
+}
+
+func TestJoin(t *testing.T) {
+	wrds := []string{"apple", "banana", "cherry"}
+
+	code, err := Join(wrds, "dot", false)
+	require.NoError(t, err)
+	assert.Equal(t, "apple.banana.cherry", code)
+
+	code, err = Join(wrds, "space", true)
+	require.NoError(t, err)
+	assert.Equal(t, "APPLE BANANA CHERRY", code)
+
+	_, err = Join(wrds, "comma", false)
+	assert.Error(t, err)
+}
+
+func TestSplit_isInverseOfJoin(t *testing.T) {
+	_, wrds, err := Random(string(English), 4)
+	require.NoError(t, err)
+
+	for sep := range Separators {
+		code, err := Join(wrds, sep, true)
+		require.NoError(t, err)
+
+		ints, err := ToInts(Split(code))
+		require.NoError(t, err)
+
+		expected, err := ToInts(wrds)
+		require.NoError(t, err)
+
+		assert.Equal(t, expected, ints)
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	// The first word is the public channel ID.
+	bits, err := Entropy(string(English), 5)
+	require.NoError(t, err)
+	assert.Equal(t, 44.0, bits)
+
+	bits, err = Entropy(string(English), 4)
+	require.NoError(t, err)
+	assert.Equal(t, 33.0, bits)
+	assert.Less(t, bits, float64(MinEntropyBits))
+
+	bits, err = Entropy(string(English), 1)
+	require.NoError(t, err)
+	assert.Zero(t, bits)
+
+	_, err = Entropy("klingon", 4)
+	assert.Error(t, err)
+}